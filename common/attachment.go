@@ -0,0 +1,309 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	// gif is only decoded, first frame makes the thumbnail
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxAttachmentSize  = 5 << 20
+	MaxPostAttachments = 4
+	// decoding a 1x1 compressed bomb would still allocate this much
+	MaxImagePixels = 4096 * 4096
+	// longer side of a thumbnail
+	ThumbnailSize     = 200
+	maxFileNameLength = 120
+	thumbnailQuality  = 80
+	jpegQuality       = 90
+)
+
+// sniffed type, not what the browser says.
+// anything else is refused, svg and html could carry scripts.
+var attachmentTypes = map[string]string{
+	"image/jpeg":                "image/jpeg",
+	"image/png":                 "image/png",
+	"image/gif":                 "image/gif",
+	"application/pdf":           "application/pdf",
+	"text/plain; charset=utf-8": "text/plain",
+}
+
+func IsAllowedAttachmentType(mimeType string) bool {
+	for _, allowed := range attachmentTypes {
+		if allowed == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+// an upload ready for Storage.
+// Attachment has everything but the keys and PostId.
+type ProcessedUpload struct {
+	Attachment Attachment
+	Data       []byte
+	// nil unless an image
+	Thumbnail []byte
+}
+
+// checks size and type, images lose their metadata and get a thumbnail
+func ProcessUpload(name string, data []byte) (processed *ProcessedUpload, err error) {
+	if len(data) == 0 {
+		err = NewError(CodeInvalid, "empty file", nil)
+		return
+	}
+	if len(data) > MaxAttachmentSize {
+		err = NewError(CodeInvalid, "file is too large", nil)
+		return
+	}
+	mimeType, ok := attachmentTypes[http.DetectContentType(data)]
+	if !ok {
+		err = NewError(CodeInvalid, "file type is not allowed", nil)
+		return
+	}
+	processed = &ProcessedUpload{
+		Attachment: Attachment{
+			Name:     SanitizeFileName(name),
+			MimeType: mimeType,
+		},
+		Data: data,
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		err = processImageInternal(processed)
+		if err != nil {
+			processed = nil
+			return
+		}
+	}
+	processed.Attachment.Size = int64(len(processed.Data))
+	return
+}
+
+// jpeg and png are encoded again, which drops exif and text chunks.
+// jpeg orientation is applied first so photos do not turn sideways.
+// gif has no exif and encoding would lose the animation, kept as is.
+func processImageInternal(processed *ProcessedUpload) (err error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(processed.Data))
+	if err != nil {
+		return NewError(CodeInvalid, "broken image", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		config.Width*config.Height > MaxImagePixels {
+		return NewError(CodeInvalid, "image is too large", nil)
+	}
+	img, _, err := image.Decode(bytes.NewReader(processed.Data))
+	if err != nil {
+		return NewError(CodeInvalid, "broken image", err)
+	}
+
+	var buf bytes.Buffer
+	switch processed.Attachment.MimeType {
+	case "image/jpeg":
+		img = orientInternal(img, JpegOrientation(processed.Data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+		processed.Data = buf.Bytes()
+	case "image/png":
+		err = png.Encode(&buf, img)
+		processed.Data = buf.Bytes()
+	}
+	if err != nil {
+		return
+	}
+	bounds := img.Bounds()
+	processed.Attachment.Width = bounds.Dx()
+	processed.Attachment.Height = bounds.Dy()
+	processed.Thumbnail, err = encodeThumbnailInternal(Thumbnail(img, ThumbnailSize), processed.Attachment.MimeType)
+	return
+}
+
+// png keeps transparency, the rest become jpeg
+func encodeThumbnailInternal(img image.Image, mimeType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mimeType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// no path, no control characters, not too long.
+// used in Content-Disposition, so quotes go too.
+func SanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	for len(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if len(name) == 0 || name == "." || name == ".." || name == "/" {
+		name = "file"
+	}
+	return name
+}
+
+// box filter, every source pixel counts once.
+// an image smaller than size is only copied.
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	src := toRGBAInternal(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	thumbWidth, thumbHeight := width, height
+	if width >= height && width > size {
+		thumbWidth, thumbHeight = size, height*size/width
+	} else if height > width && height > size {
+		thumbWidth, thumbHeight = width*size/height, size
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0, y1 := y*height/thumbHeight, (y+1)*height/thumbHeight
+		for x := 0; x < thumbWidth; x++ {
+			x0, x1 := x*width/thumbWidth, (x+1)*width/thumbWidth
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// origin is moved to 0, 0 so Pix can be indexed directly
+func toRGBAInternal(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// exif orientation 1 to 8, 1 when there is none
+func JpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// start of scan, no more metadata after this
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientationInternal(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiff header, then tag 0x0112 in the first ifd
+func exifOrientationInternal(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// turns the pixels so the image looks right without exif.
+// 5 to 8 swap width and height.
+func orientInternal(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBAInternal(img)
+	width, height := src.Rect.Dx(), src.Rect.Dy()
+	dstRect := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		dstRect = image.Rect(0, 0, height, width)
+	}
+	dst := image.NewRGBA(dstRect)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testingImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// exif app1 segment with only the orientation tag, put right after SOI
+func withOrientation(data []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.BigEndian, uint16(3))
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, orientation)
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+func Test_LocalStorage(t *testing.T) {
+	storage := NewLocalStorage(t.TempDir())
+	bg := context.Background()
+	key, err := storage.Put(bg, []byte("hello"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if key != StorageKey([]byte("hello")) || !IsValidStorageKey(key) {
+		t.Fatalf("key %s", key)
+	}
+	again, err := storage.Put(bg, []byte("hello"))
+	if err != nil || again != key {
+		t.Fatalf("same content got key %s, %v", again, err)
+	}
+	file, err := storage.Open(bg, key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "hello" {
+		t.Fatalf("read %q", data)
+	}
+	for _, bad := range []string{"../../etc/passwd", strings.Repeat("0", 64), ""} {
+		if _, err := storage.Open(bg, bad); ErrorCodeOf(err) != CodeNotFound {
+			t.Fatalf("%q opened, %v", bad, err)
+		}
+	}
+}
+
+func Test_ProcessUploadJpeg(t *testing.T) {
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, testingImage(400, 100), nil)
+	// rotated 90 degrees by the camera
+	data := withOrientation(buf.Bytes(), 6)
+	if JpegOrientation(data) != 6 {
+		t.Fatalf("orientation %d", JpegOrientation(data))
+	}
+
+	processed, err := ProcessUpload("C:\\photos\\cat.jpg", data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if bytes.Contains(processed.Data, []byte("Exif")) || JpegOrientation(processed.Data) != 1 {
+		t.Fatal("exif is kept")
+	}
+	attachment := processed.Attachment
+	if attachment.Name != "cat.jpg" || attachment.MimeType != "image/jpeg" ||
+		attachment.Width != 100 || attachment.Height != 400 ||
+		attachment.Size != int64(len(processed.Data)) {
+		t.Fatalf("attachment %+v", attachment)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(processed.Thumbnail))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if size := thumb.Bounds().Size(); size.X != 50 || size.Y != ThumbnailSize {
+		t.Fatalf("thumbnail %v", size)
+	}
+}
+
+func Test_ProcessUploadPng(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testingImage(30, 20))
+	processed, err := ProcessUpload("small.png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// small ones are not made bigger
+	thumb, err := png.Decode(bytes.NewReader(processed.Thumbnail))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if size := thumb.Bounds().Size(); size.X != 30 || size.Y != 20 {
+		t.Fatalf("thumbnail %v", size)
+	}
+
+	processed, err = ProcessUpload("notes.txt", []byte("just text"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if processed.Attachment.MimeType != "text/plain" || processed.Thumbnail != nil {
+		t.Fatalf("text %+v", processed.Attachment)
+	}
+}
+
+func Test_ProcessUploadRejects(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testingImage(30, 20))
+	broken := buf.Bytes()[:buf.Len()/2]
+	cases := map[string][]byte{
+		"empty":    nil,
+		"html":     []byte("<html><script>alert(1)</script></html>"),
+		"too big":  bytes.Repeat([]byte("a"), MaxAttachmentSize+1),
+		"broken":   broken,
+		"zip file": []byte("PK\x03\x04 not allowed"),
+	}
+	for name, data := range cases {
+		if _, err := ProcessUpload("x", data); ErrorCodeOf(err) != CodeInvalid {
+			t.Fatalf("%s was accepted, %v", name, err)
+		}
+	}
+}
+
+func Test_SanitizeFileName(t *testing.T) {
+	cases := map[string]string{
+		"cat.jpg":             "cat.jpg",
+		"../../etc/passwd":    "passwd",
+		"C:\\Users\\a\\b.txt": "b.txt",
+		"a\"b\x00c.pdf":       "abc.pdf",
+		"写真.png":              "写真.png",
+		"":                    "file",
+		"..":                  "file",
+	}
+	for name, want := range cases {
+		if got := SanitizeFileName(name); got != want {
+			t.Fatalf("%q became %q, want %q", name, got, want)
+		}
+	}
+	if got := SanitizeFileName(strings.Repeat("あ", 100)); len(got) > maxFileNameLength {
+		t.Fatalf("long name kept %d bytes", len(got))
+	}
+}
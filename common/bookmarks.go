@@ -0,0 +1,60 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const MaxBookmarkNoteRunes = 500
+
+// a post kept by one user with a note only they see.
+// nobody is told how many kept a post.
+type Bookmark struct {
+	Id       uint   `xorm:"pk autoincr 'id'" json:"id"`
+	UserId   uint   `xorm:"not null 'user_id'" json:"user_id"`
+	PostId   uint   `xorm:"not null 'post_id'" json:"post_id"`
+	PostUuId string `xorm:"-" json:"post_uuid"`
+	Note     string `xorm:"TEXT 'note'" json:"note"`
+	// the post and its thread, nil when the post was removed
+	Post      *Post     `xorm:"-" json:"post,omitempty"`
+	Thread    *Thread   `xorm:"-" json:"thread,omitempty"`
+	UpdatedAt time.Time `xorm:"not null 'updated_at'" json:"updated_at"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// bookmarks of UserId on posts of ThreadId
+type BookmarkQuery struct {
+	UserId   uint `json:"user_id"`
+	ThreadId uint `json:"thread_id"`
+}
+
+// one page of bookmarks, Total counts all of them
+type BookmarkPage struct {
+	Bookmarks []Bookmark `json:"bookmarks"`
+	Total     int64      `json:"total"`
+}
+
+// trims the note, false when it is too long
+func NormalizeBookmark(bookmark *Bookmark) bool {
+	bookmark.Note = strings.TrimSpace(bookmark.Note)
+	return utf8.RuneCountInString(bookmark.Note) <= MaxBookmarkNoteRunes
+}
+
+// the post in its thread page, empty when it was removed
+func (bookmark *Bookmark) URL() string {
+	if bookmark.Post == nil || bookmark.Thread == nil {
+		return ""
+	}
+	return fmt.Sprintf("/thread/read?id=%s#p%d", bookmark.Thread.PublicURL(), bookmark.Post.Number)
+}
+
+// posts of the list the user kept, for marking buttons
+func BookmarkedPosts(bookmarks []Bookmark) map[uint]bool {
+	kept := make(map[uint]bool)
+	for _, bookmark := range bookmarks {
+		kept[bookmark.PostId] = true
+	}
+	return kept
+}
@@ -0,0 +1,27 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Bookmark(t *testing.T) {
+	bookmark := Bookmark{Note: "  read later  "}
+	if !NormalizeBookmark(&bookmark) || bookmark.Note != "read later" {
+		t.Fatalf("note %q", bookmark.Note)
+	}
+	long := Bookmark{Note: strings.Repeat("あ", MaxBookmarkNoteRunes+1)}
+	if NormalizeBookmark(&long) {
+		t.Fatal("long note is accepted")
+	}
+
+	if bookmark.URL() != "" {
+		t.Fatal("url of removed post")
+	}
+	thre := &Thread{UuId: "uuid"}
+	bookmark.Post = &Post{Number: 7}
+	bookmark.Thread = thre
+	if want := "/thread/read?id=" + thre.PublicURL() + "#p7"; bookmark.URL() != want {
+		t.Fatalf("url %s want %s", bookmark.URL(), want)
+	}
+}
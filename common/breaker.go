@@ -0,0 +1,88 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit is open")
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker stops calling a service after maxFailures in a row.
+// after cooldown only one trial call is allowed,
+// circuit closes again when it succeeds.
+type CircuitBreaker struct {
+	mutex       sync.Mutex
+	state       int
+	failures    int
+	maxFailures int
+	cooldown    time.Duration
+	openedAt    time.Time
+}
+
+// set maxFailures<=0 if never open
+func NewCircuitBreaker(maxFailures int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+	}
+}
+
+// Allow returns ErrCircuitOpen when caller should not call the service.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return ErrCircuitOpen
+		}
+		cb.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// trial call is running
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.state = circuitClosed
+	cb.failures = 0
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures++
+	if cb.state == circuitHalfOpen ||
+		(cb.maxFailures > 0 && cb.failures >= cb.maxFailures) {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// call was given up by the caller, it tells nothing about the service.
+// a trial call given up lets the next one try.
+func (cb *CircuitBreaker) Abandon() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+func (cb *CircuitBreaker) IsOpen() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state == circuitOpen && time.Since(cb.openedAt) < cb.cooldown
+}
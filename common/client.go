@@ -0,0 +1,288 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const HttpPrefix = "http://"
+
+const (
+	defaultTimeout             = time.Second * 5
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 16
+	defaultIdleConnTimeout     = time.Second * 90
+	defaultRetryBackoff        = time.Millisecond * 100
+	defaultBreakerMaxFailures  = 5
+	defaultBreakerCooldown     = time.Second * 10
+)
+
+// StatusError is returned by the service clients
+// when the other side replied with non-200 status.
+// Code and Message are decoded from the error envelope if any.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Code       ErrorCode
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Message) > 0 {
+		return fmt.Sprintf("%s: %s", e.Status, e.Message)
+	}
+	return e.Status
+}
+
+func BuildHTTP_URL(address string, path string) string {
+	return fmt.Sprintf("%s%s%s", HttpPrefix, address, path)
+}
+
+// IsUnavailable reports the error means the service is down or too slow,
+// not that the request itself was wrong.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	// caller went away, e.g. browser closed the tab. *url.Error
+	// wrapping it is a net.Error too, so this goes first.
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ServiceClient calls one downstream service.
+// it owns the connection pool, retries and the circuit breaker for it.
+type ServiceClient struct {
+	address string
+	client  *http.Client
+	// same pool without timeout, for long-lived streams
+	streamClient *http.Client
+	breaker      *CircuitBreaker
+	maxRetries   int
+	backoff      time.Duration
+}
+
+func NewServiceClient(address string, conf ClientConfiguration) *ServiceClient {
+	timeout := time.Duration(conf.TimeoutMillis) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = defaultMaxIdleConns
+	if conf.MaxIdleConns > 0 {
+		transport.MaxIdleConns = conf.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if conf.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+	if conf.IdleConnTimeoutSec > 0 {
+		transport.IdleConnTimeout = time.Duration(conf.IdleConnTimeoutSec) * time.Second
+	}
+	backoff := time.Duration(conf.RetryBackoffMillis) * time.Millisecond
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxFailures := conf.BreakerMaxFailures
+	if maxFailures == 0 {
+		maxFailures = defaultBreakerMaxFailures
+	}
+	cooldown := time.Duration(conf.BreakerCooldownSec) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+
+	return &ServiceClient{
+		address: address,
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		streamClient: &http.Client{
+			Transport: transport,
+		},
+		breaker:    NewCircuitBreaker(maxFailures, cooldown),
+		maxRetries: conf.MaxRetries,
+		backoff:    backoff,
+	}
+}
+
+func (c *ServiceClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
+// DoJSON sends in as json body (nil for no body)
+// and decodes the response into out (nil for ignoring it).
+// only idempotent calls are retried.
+func (c *ServiceClient) DoJSON(
+	ctx context.Context,
+	method string,
+	path string,
+	in interface{},
+	out interface{},
+	idempotent bool,
+) (err error) {
+	var bin []byte
+	if in != nil {
+		bin, err = json.Marshal(in)
+		if err != nil {
+			return
+		}
+	}
+	url := BuildHTTP_URL(c.address, path)
+	attempts := 1
+	if idempotent && c.maxRetries > 0 {
+		attempts += c.maxRetries
+	}
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			err = waitBackoff(ctx, c.backoff, i)
+			if err != nil {
+				return
+			}
+		}
+		err = c.breaker.Allow()
+		if err != nil {
+			return
+		}
+		err = doJSONOnce(ctx, c.client, method, url, bin, out)
+		if errors.Is(err, context.Canceled) {
+			c.breaker.Abandon()
+			return
+		}
+		if !IsUnavailable(err) {
+			c.breaker.Success()
+			return
+		}
+		c.breaker.Failure()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// OpenStream starts a long-lived GET, e.g. server-sent events.
+// only connecting counts for the breaker, caller must close the body.
+// ctx is the only way to stop the stream.
+func (c *ServiceClient) OpenStream(ctx context.Context, path string) (body io.ReadCloser, err error) {
+	err = c.breaker.Allow()
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, BuildHTTP_URL(c.address, path), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Add("Accept", "text/event-stream")
+	res, err := c.streamClient.Do(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			c.breaker.Abandon()
+		} else if IsUnavailable(err) {
+			c.breaker.Failure()
+		}
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		bin, _ := io.ReadAll(res.Body)
+		err = decodeStatusError(res, bin)
+		if IsUnavailable(err) {
+			c.breaker.Failure()
+		} else {
+			c.breaker.Success()
+		}
+		return
+	}
+	c.breaker.Success()
+	body = res.Body
+	return
+}
+
+func waitBackoff(ctx context.Context, base time.Duration, retried int) error {
+	wait := base << (retried - 1)
+	// jitter up to half of wait, not to retry at once
+	wait += time.Duration(rand.Int63n(int64(wait)/2 + 1))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func doJSONOnce(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	url string,
+	bin []byte,
+	out interface{},
+) (err error) {
+	var body io.Reader
+	if bin != nil {
+		body = bytes.NewReader(bin)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return
+	}
+	if bin != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Add("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	resBin, err := io.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		err = decodeStatusError(res, resBin)
+		return
+	}
+	if out != nil {
+		err = json.Unmarshal(resBin, out)
+	}
+	return
+}
+
+func decodeStatusError(res *http.Response, bin []byte) error {
+	statusErr := &StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+	}
+	// body is only informative, ignore broken ones
+	var envelope ErrorEnvelope
+	if json.Unmarshal(bin, &envelope) == nil && envelope.Error != nil {
+		statusErr.Code = envelope.Error.Code
+		statusErr.Message = envelope.Error.Message
+	}
+	return statusErr
+}
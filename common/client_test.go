@@ -0,0 +1,105 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newFlakyServer(failures int) (*httptest.Server, *int) {
+	var mutex sync.Mutex
+	called := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		called++
+		if called <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"topic":"ok"}`))
+	}))
+	return server, &called
+}
+
+func Test_RetryOnlyIdempotent(t *testing.T) {
+	server, called := newFlakyServer(1)
+	defer server.Close()
+	client := NewServiceClient(
+		strings.TrimPrefix(server.URL, HttpPrefix),
+		ClientConfiguration{MaxRetries: 2, RetryBackoffMillis: 1},
+	)
+
+	err := client.DoJSON(context.Background(), http.MethodPost, "/create", nil, nil, false)
+	if !IsUnavailable(err) {
+		t.Fatalf("non idempotent call should not be retried, got %v", err)
+	}
+
+	var thre Thread
+	err = client.DoJSON(context.Background(), http.MethodPost, "/read", nil, &thre, true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if thre.Topic != "ok" || *called != 2 {
+		t.Fatalf("topic %s, called %d", thre.Topic, *called)
+	}
+}
+
+func Test_CircuitOpens(t *testing.T) {
+	server, called := newFlakyServer(100)
+	defer server.Close()
+	client := NewServiceClient(
+		strings.TrimPrefix(server.URL, HttpPrefix),
+		ClientConfiguration{BreakerMaxFailures: 2, BreakerCooldownSec: 60},
+	)
+
+	for i := 0; i < 3; i++ {
+		client.DoJSON(context.Background(), http.MethodGet, "/read-index", nil, nil, true)
+	}
+	err := client.DoJSON(context.Background(), http.MethodGet, "/read-index", nil, nil, true)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if *called != 2 {
+		t.Fatalf("service was called %d times after circuit opened", *called)
+	}
+}
+
+func Test_CanceledIsNotUnavailable(t *testing.T) {
+	release := make(chan struct{})
+	arrived := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewServiceClient(
+		strings.TrimPrefix(server.URL, HttpPrefix),
+		ClientConfiguration{BreakerMaxFailures: 1, BreakerCooldownSec: 60},
+	)
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-arrived
+			cancel()
+		}()
+		err := client.DoJSON(ctx, http.MethodGet, "/read-index", nil, nil, true)
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) || IsUnavailable(err) {
+			t.Fatalf("canceled call reported as %v", err)
+		}
+	}
+	if client.breaker.IsOpen() {
+		t.Fatal("canceled calls opened the circuit")
+	}
+}
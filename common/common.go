@@ -18,17 +18,55 @@ import (
 
 type Configuration struct {
 	//should address be in envs or args ??
-	AddressRouter      string `json:"address_router"`
-	AddressUsers       string `json:"address_users"`
-	AddressThreads     string `json:"address_threads"`
-	UseSecureCookie    bool   `json:"use_secure_cookie"`
-	SetHttpOnlyCookie  bool   `json:"set_http_only_cookie"`
-	DbName             string `json:"db_name"`
-	ShowSQL            bool   `json:"show_sql"`
-	LogToFile          bool   `json:"log_to_file"`
-	LogFileNameRouter  string `json:"log_file_name_router"`
-	LogFileNameUsers   string `json:"log_file_name_users"`
-	LogFileNameThreads string `json:"log_file_name_threads"`
+	AddressRouter      string              `json:"address_router"`
+	AddressUsers       string              `json:"address_users"`
+	AddressThreads     string              `json:"address_threads"`
+	UseSecureCookie    bool                `json:"use_secure_cookie"`
+	SetHttpOnlyCookie  bool                `json:"set_http_only_cookie"`
+	DbName             string              `json:"db_name"`
+	ShowSQL            bool                `json:"show_sql"`
+	LogToFile          bool                `json:"log_to_file"`
+	LogFileNameRouter  string              `json:"log_file_name_router"`
+	LogFileNameUsers   string              `json:"log_file_name_users"`
+	LogFileNameThreads string              `json:"log_file_name_threads"`
+	UsersClient        ClientConfiguration `json:"users_client"`
+	ThreadsClient      ClientConfiguration `json:"threads_client"`
+	// "memory" or "postgres", see eventbus.go
+	EventBus string `json:"event_bus"`
+	// outbox tables postgres bus reads again after reconnect
+	EventSources []string `json:"event_sources"`
+	// only for local testing, see NewWebhookClient
+	WebhookAllowPrivate bool `json:"webhook_allow_private"`
+	// directory for uploaded files, see storage.go
+	StoragePath string              `json:"storage_path"`
+	Mailer      MailerConfiguration `json:"mailer"`
+}
+
+// where mails go, see mailer.go
+type MailerConfiguration struct {
+	// "smtp" or "file"
+	Kind string `json:"kind"`
+	// "file" writes here
+	OutboxPath  string `json:"outbox_path"`
+	SMTPAddress string `json:"smtp_address"`
+	From        string `json:"from"`
+	// links in mails start with this
+	BaseURL string `json:"base_url"`
+	// watched thread digests, zero sends none
+	DigestIntervalMinutes int `json:"digest_interval_minutes"`
+}
+
+// settings for calling one downstream service
+// zero values fall back to defaults in client.go
+type ClientConfiguration struct {
+	TimeoutMillis       int `json:"timeout_millis"`
+	MaxIdleConns        int `json:"max_idle_conns"`
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutSec  int `json:"idle_conn_timeout_sec"`
+	MaxRetries          int `json:"max_retries"`
+	RetryBackoffMillis  int `json:"retry_backoff_millis"`
+	BreakerMaxFailures  int `json:"breaker_max_failures"`
+	BreakerCooldownSec  int `json:"breaker_cooldown_sec"`
 }
 
 const (
@@ -57,6 +95,15 @@ func LoadConfig() (config *Configuration, err error) {
 	return
 }
 
+func DataSourceName(dbName string) string {
+	return fmt.Sprintf(
+		DbParameter,
+		dbName,
+		os.Getenv("DBUSER"),
+		os.Getenv("DBPASS"),
+	)
+}
+
 // set maxConn<=0 if use default
 func OpenDb(
 	dbName string,
@@ -65,12 +112,7 @@ func OpenDb(
 ) (dbEngine *xorm.Engine, err error) {
 	dbEngine, err = xorm.NewEngine(
 		DbDriver,
-		fmt.Sprintf(
-			DbParameter,
-			dbName,
-			os.Getenv("DBUSER"),
-			os.Getenv("DBPASS"),
-		),
+		DataSourceName(dbName),
 	)
 	if err != nil {
 		return
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"strings"
+	"time"
+)
+
+// autosave sends the whole form every time, bigger ones are refused
+const MaxDraftBytes = 64 << 10
+
+// text of a reply or new thread form not sent yet, one per user and
+// thread. ThreadId is 0 for the new thread form, only that one has
+// Topic, Board and Tags. attachments and polls are not kept.
+type Draft struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	ThreadId  uint      `xorm:"not null 'thread_id'" json:"thread_id"`
+	Topic     string    `xorm:"TEXT 'topic'" json:"topic"`
+	Board     string    `xorm:"'board'" json:"board"`
+	Tags      string    `xorm:"'tags'" json:"tags"`
+	Body      string    `xorm:"TEXT 'body'" json:"body"`
+	UpdatedAt time.Time `xorm:"not null 'updated_at'" json:"updated_at"`
+}
+
+// replies have no topic, board or tags.
+// false when the draft is too big to keep.
+func NormalizeDraft(draft *Draft) bool {
+	if draft.ThreadId != 0 {
+		draft.Topic = ""
+		draft.Board = ""
+		draft.Tags = ""
+	}
+	size := len(draft.Topic) + len(draft.Board) + len(draft.Tags) + len(draft.Body)
+	return size <= MaxDraftBytes
+}
+
+// nothing typed, board alone is only the default choice
+func (draft *Draft) IsEmpty() bool {
+	return len(strings.TrimSpace(draft.Topic)) == 0 &&
+		len(strings.TrimSpace(draft.Tags)) == 0 &&
+		len(strings.TrimSpace(draft.Body)) == 0
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Draft(t *testing.T) {
+	reply := Draft{ThreadId: 3, Topic: "topic", Board: "misc", Tags: "go", Body: "hello"}
+	if !NormalizeDraft(&reply) {
+		t.Fatal("small draft is refused")
+	}
+	if reply.Topic != "" || reply.Board != "" || reply.Tags != "" || reply.Body != "hello" {
+		t.Fatalf("reply draft %+v", reply)
+	}
+	newThread := Draft{Topic: "topic", Board: "misc"}
+	if !NormalizeDraft(&newThread) || newThread.Topic != "topic" || newThread.Board != "misc" {
+		t.Fatalf("new thread draft %+v", newThread)
+	}
+	big := Draft{Body: strings.Repeat("a", MaxDraftBytes+1)}
+	if NormalizeDraft(&big) {
+		t.Fatal("big draft is accepted")
+	}
+
+	if !(&Draft{Board: "misc", Body: " \n"}).IsEmpty() {
+		t.Fatal("board alone is not empty")
+	}
+	if newThread.IsEmpty() {
+		t.Fatal("topic is empty")
+	}
+}
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+type ErrorCode string
+
+// machine-readable codes shared by every service
+const (
+	CodeBadRequest   ErrorCode = "bad_request"
+	CodeUnauthorized ErrorCode = "unauthorized"
+	CodeForbidden    ErrorCode = "forbidden"
+	CodeNotFound     ErrorCode = "not_found"
+	CodeConflict     ErrorCode = "conflict"
+	CodeInvalid      ErrorCode = "invalid"
+	CodeRateLimited  ErrorCode = "rate_limited"
+	CodeInternal     ErrorCode = "internal"
+	CodeUnavailable  ErrorCode = "unavailable"
+)
+
+// postgres unique_violation
+const pqUniqueViolation = "23505"
+
+// Error is what services reply on failure.
+// Cause is only for server side log, never sent.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Cause   error     `json:"-"`
+}
+
+// ErrorEnvelope is the json body of every error response
+// {"error": {"code": "not_found", "message": "no such thread"}}
+type ErrorEnvelope struct {
+	Error *Error `json:"error"`
+}
+
+func NewError(code ErrorCode, message string, cause error) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		Cause:   cause,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.Cause.Error())
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func (e *Error) HTTPStatus() int {
+	return HTTPStatusOf(e.Code)
+}
+
+func HTTPStatusOf(code ErrorCode) int {
+	switch code {
+	case CodeBadRequest:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeInvalid:
+		return http.StatusUnprocessableEntity
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func codeOfHTTPStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeInvalid
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// ErrorCodeOf finds the code in any error chain.
+// errors without code are internal ones.
+func ErrorCodeOf(err error) ErrorCode {
+	var codeErr *Error
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if len(statusErr.Code) > 0 {
+			return statusErr.Code
+		}
+		return codeOfHTTPStatus(statusErr.StatusCode)
+	}
+	if IsUnavailable(err) {
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// MessageOf finds what a service said about the error,
+// empty for errors without code.
+func MessageOf(err error) string {
+	var codeErr *Error
+	if errors.As(err, &codeErr) {
+		return codeErr.Message
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Message
+	}
+	return ""
+}
+
+// AsError converts any error to *Error for replying.
+// unknown errors become internal and keep original as cause.
+func AsError(err error) *Error {
+	var codeErr *Error
+	if errors.As(err, &codeErr) {
+		return codeErr
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		return NewError(CodeConflict, "already exists", err)
+	}
+	code := ErrorCodeOf(err)
+	message := "internal error"
+	if code != CodeInternal {
+		message = string(code)
+	}
+	return NewError(code, message, err)
+}
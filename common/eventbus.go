@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+// topics published between services.
+// post ones carry ThreadEvent, same as the stream.
+const (
+	TopicThreadCreated = "thread.created"
+	TopicPostCreated   = EventPostCreated
+	TopicPostUpdated   = EventPostUpdated
+	TopicPostDeleted   = EventPostDeleted
+	TopicUserSignedUp  = "user.signed_up"
+	// carries ModerationAction
+	TopicModeration = "moderation.action"
+)
+
+// Event is something that happened in one service.
+// it is a row of an outbox table until dispatched.
+// delivery is at-least-once, so subscribers dedupe by UuId if they care.
+type Event struct {
+	Id           uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UuId         string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	Topic        string    `xorm:"not null 'topic'" json:"topic"`
+	Payload      string    `xorm:"TEXT 'payload'" json:"payload"`
+	Attempts     int       `xorm:"attempts" json:"-"`
+	LastError    string    `xorm:"TEXT 'last_error'" json:"-"`
+	ClaimedUntil time.Time `xorm:"claimed_until" json:"-"`
+	DispatchedAt time.Time `xorm:"dispatched_at" json:"-"`
+	CreatedAt    time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// outbox table it came from, set by the dispatcher
+	Source string `xorm:"-" json:"-"`
+}
+
+func NewEvent(topic string, payload interface{}) (event *Event, err error) {
+	bin, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	event = &Event{
+		UuId:      NewUuIdString(),
+		Topic:     topic,
+		Payload:   string(bin),
+		CreatedAt: time.Now(),
+	}
+	return
+}
+
+func (event *Event) Decode(out interface{}) error {
+	return json.Unmarshal([]byte(event.Payload), out)
+}
+
+// returning error means "deliver it again later"
+type EventHandler func(ctx context.Context, event *Event) error
+
+// EventBus carries events from the dispatcher to subscribers.
+// pattern of Subscribe is a topic, "post.*" or "*".
+type EventBus interface {
+	Publish(ctx context.Context, event *Event) error
+	Subscribe(pattern string, handler EventHandler)
+	Close() error
+}
+
+const (
+	EventBusMemory   = "memory"
+	EventBusPostgres = "postgres"
+	eventChannel     = "chatboard_events"
+)
+
+// StartEventBus opens the bus chosen in config and
+// starts dispatching outboxTable to it until ctx is done.
+// memory bus only reaches subscribers in this process.
+func StartEventBus(
+	ctx context.Context,
+	conf *Configuration,
+	engine *xorm.Engine,
+	outboxTable string,
+	logger *log.Logger,
+) (bus EventBus, dispatcher *OutboxDispatcher, err error) {
+	switch conf.EventBus {
+	case EventBusPostgres:
+		bus, err = NewPostgresBus(engine, conf.DbName, eventChannel, conf.EventSources, logger)
+		if err != nil {
+			return
+		}
+	case EventBusMemory, "":
+		bus = NewMemoryBus()
+	default:
+		err = fmt.Errorf("unknown event bus %q", conf.EventBus)
+		return
+	}
+	dispatcher = NewOutboxDispatcher(engine, outboxTable, bus, logger)
+	go dispatcher.Run(ctx)
+	return
+}
+
+func MatchTopic(pattern string, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	if strings.HasSuffix(pattern, ".*") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+type subscription struct {
+	pattern string
+	handler EventHandler
+}
+
+// handlers of one process, shared by both buses
+type subscriptions struct {
+	mutex sync.RWMutex
+	subs  []subscription
+}
+
+func (s *subscriptions) add(pattern string, handler EventHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subs = append(s.subs, subscription{pattern: pattern, handler: handler})
+}
+
+func (s *subscriptions) matches(topic string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, sub := range s.subs {
+		if MatchTopic(sub.pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// every matching handler is called even if one fails,
+// the first error is returned.
+func (s *subscriptions) deliver(ctx context.Context, event *Event) (err error) {
+	s.mutex.RLock()
+	matched := make([]subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		if MatchTopic(sub.pattern, event.Topic) {
+			matched = append(matched, sub)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, sub := range matched {
+		herr := sub.handler(ctx, event)
+		if herr != nil && err == nil {
+			err = fmt.Errorf("%s to %s: %w", event.Topic, sub.pattern, herr)
+		}
+	}
+	return
+}
+
+// MemoryBus calls handlers in Publish itself.
+// handler errors go back to the dispatcher, which delivers again later.
+// for tests and a single process.
+type MemoryBus struct {
+	subs subscriptions
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, event *Event) error {
+	return b.subs.deliver(ctx, event)
+}
+
+func (b *MemoryBus) Subscribe(pattern string, handler EventHandler) {
+	b.subs.add(pattern, handler)
+}
+
+func (b *MemoryBus) Close() error {
+	return nil
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_MemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	var posts, all []string
+	failing := true
+	bus.Subscribe("post.*", func(ctx context.Context, event *Event) error {
+		posts = append(posts, event.Topic)
+		return nil
+	})
+	bus.Subscribe("*", func(ctx context.Context, event *Event) error {
+		all = append(all, event.Topic)
+		if failing {
+			return errors.New("not now")
+		}
+		return nil
+	})
+
+	event, err := NewEvent(TopicPostCreated, &ThreadEvent{Id: 7, Kind: TopicPostCreated})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// failed one goes back to dispatcher, others are still called
+	if err = bus.Publish(context.Background(), event); err == nil {
+		t.Fatal("handler error is lost")
+	}
+	failing = false
+	if err = bus.Publish(context.Background(), event); err != nil {
+		t.Fatal(err.Error())
+	}
+	signedUp, _ := NewEvent(TopicUserSignedUp, &User{Name: "TestingTaro"})
+	bus.Publish(context.Background(), signedUp)
+
+	if len(posts) != 2 || len(all) != 3 {
+		t.Fatalf("delivered post %v, all %v", posts, all)
+	}
+	var decoded ThreadEvent
+	if err = event.Decode(&decoded); err != nil || decoded.Id != 7 {
+		t.Fatalf("payload is broken %v %v", decoded, err)
+	}
+}
+
+func Test_MatchTopic(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"*", TopicUserSignedUp, true},
+		{"post.*", TopicPostDeleted, true},
+		{"post.*", TopicThreadCreated, false},
+		{TopicThreadCreated, TopicThreadCreated, true},
+		{"post", TopicPostCreated, false},
+	}
+	for _, c := range cases {
+		if MatchTopic(c.pattern, c.topic) != c.want {
+			t.Fatalf("%s with %s should be %v", c.pattern, c.topic, c.want)
+		}
+	}
+}
+
+func Test_DispatchBackoff(t *testing.T) {
+	if DispatchBackoff(1) != time.Second || DispatchBackoff(3) != time.Second*4 {
+		t.Fatal("backoff does not double")
+	}
+	if DispatchBackoff(100) != maxDispatchBackoff {
+		t.Fatal("backoff is not capped")
+	}
+}
+
+func Test_PostgresBusSeen(t *testing.T) {
+	bus := &PostgresBus{seen: make(map[string]map[uint]time.Time)}
+	events := []Event{{Id: 1, Topic: TopicPostCreated}}
+	// nobody subscribed yet, kept for later
+	bus.deliverInternal("threads_outbox", events)
+	if bus.isSeenInternal("threads_outbox", 1) {
+		t.Fatal("event without handler is marked seen")
+	}
+	delivered := 0
+	bus.Subscribe("post.*", func(ctx context.Context, event *Event) error {
+		delivered++
+		return nil
+	})
+	bus.deliverInternal("threads_outbox", events)
+	bus.deliverInternal("threads_outbox", events)
+	if delivered != 1 || !bus.isSeenInternal("threads_outbox", 1) {
+		t.Fatalf("delivered %d times", delivered)
+	}
+	bus.sweepSeenInternal(time.Now().Add(pgBusSeenTTL + time.Second))
+	if bus.isSeenInternal("threads_outbox", 1) {
+		t.Fatal("seen is not forgotten")
+	}
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultOutboxPath = "../outbox"
+
+// one plain text mail to one address
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mails, FileMailer only writes them down.
+type Mailer interface {
+	Send(ctx context.Context, mail *Mail) error
+}
+
+// kind is "smtp" or "file", "file" is the default
+func NewMailer(conf MailerConfiguration) (Mailer, error) {
+	switch conf.Kind {
+	case "", "file":
+		dir := conf.OutboxPath
+		if len(dir) == 0 {
+			dir = defaultOutboxPath
+		}
+		return NewFileMailer(dir, conf.From), nil
+	case "smtp":
+		return NewSMTPMailer(conf.SMTPAddress, conf.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", conf.Kind)
+	}
+}
+
+// headers come from users, nothing may break out of them
+func checkMailInternal(from string, m *Mail) (err error) {
+	if _, err = mail.ParseAddress(from); err != nil {
+		return NewError(CodeInvalid, "invalid sender address", err)
+	}
+	if _, err = mail.ParseAddress(m.To); err != nil {
+		return NewError(CodeInvalid, "invalid mail address", err)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return NewError(CodeInvalid, "broken subject", nil)
+	}
+	return
+}
+
+// whole message with headers, lines end with CRLF
+func (m *Mail) Message(from string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// FileMailer writes every mail as a .eml file into dir.
+// names sort by time, for tests and for running without a mail server.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, message *Mail) (err error) {
+	err = checkMailInternal(m.from, message)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), NewUuIdString())
+	// readers never see half a mail
+	temp, err := os.CreateTemp(m.dir, ".mail-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(message.Message(m.from, now))
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(temp.Name(), filepath.Join(m.dir, name))
+}
+
+// SMTPMailer hands mails to a server.
+// login is taken from SMTPUSER and SMTPPASS like the database one.
+type SMTPMailer struct {
+	address string
+	from    string
+	auth    smtp.Auth
+}
+
+func NewSMTPMailer(address string, from string) *SMTPMailer {
+	mailer := &SMTPMailer{address: address, from: from}
+	if user := os.Getenv("SMTPUSER"); len(user) > 0 {
+		host, _, _ := net.SplitHostPort(address)
+		mailer.auth = smtp.PlainAuth("", user, os.Getenv("SMTPPASS"), host)
+	}
+	return mailer
+}
+
+// net/smtp takes no context, only a cancelled one is honoured
+func (m *SMTPMailer) Send(ctx context.Context, message *Mail) (err error) {
+	err = checkMailInternal(m.from, message)
+	if err != nil {
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	// envelope takes the bare address
+	sender, _ := mail.ParseAddress(m.from)
+	return smtp.SendMail(
+		m.address,
+		m.auth,
+		sender.Address,
+		[]string{message.To},
+		message.Message(m.from, time.Now()),
+	)
+}
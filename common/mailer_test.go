@@ -0,0 +1,84 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewMailer(MailerConfiguration{Kind: "file", OutboxPath: dir, From: "KEIJIBAN <noreply@localhost>"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	bg := context.Background()
+	err = mailer.Send(bg, &Mail{To: "taro@go.com", Subject: "新しい投稿", Body: "line one\nline two"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("files %v", files)
+	}
+	bytes, _ := os.ReadFile(files[0])
+	message := string(bytes)
+	for _, want := range []string{
+		"From: KEIJIBAN <noreply@localhost>\r\n",
+		"To: taro@go.com\r\n",
+		"Subject: =?utf-8?q?",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(message, want) {
+			t.Fatalf("no %q in\n%s", want, message)
+		}
+	}
+
+	// nothing may sneak into headers
+	for _, broken := range []*Mail{
+		{To: "taro@go.com\r\nBcc: all@go.com", Subject: "hi"},
+		{To: "taro@go.com", Subject: "hi\r\nBcc: all@go.com"},
+	} {
+		if err := mailer.Send(bg, broken); ErrorCodeOf(err) != CodeInvalid {
+			t.Errorf("%+v sent, %v", broken, err)
+		}
+	}
+	if _, err := NewMailer(MailerConfiguration{Kind: "pigeon"}); err == nil {
+		t.Fatal("unknown mailer is made")
+	}
+}
+
+func Test_FormatDigest(t *testing.T) {
+	user := &User{Name: "TestingTaro", Email: "taro@go.com"}
+	thre := Thread{UuId: "uuid", Topic: "lunch", LastUpdate: time.Now()}
+	if mail := FormatDigest(user, "http://localhost:8080", []DigestThread{{Thread: thre}}); mail != nil {
+		t.Fatalf("empty digest %+v", mail)
+	}
+	mail := FormatDigest(user, "http://localhost:8080/", []DigestThread{{
+		Thread: thre,
+		Posts: []Post{
+			{Number: 2, Contributor: "hanako", Body: "ramen\n\nor   udon"},
+			{Number: 3, Contributor: "jiro", Body: strings.Repeat("a", 200)},
+		},
+		NumNew: 5,
+	}})
+	if mail == nil || mail.To != user.Email || mail.Subject != "5 new posts in watched threads" {
+		t.Fatalf("digest %+v", mail)
+	}
+	for _, want := range []string{
+		"Hi TestingTaro,",
+		"== lunch (5 new)",
+		"#2 hanako: ramen or udon\n",
+		"…\n",
+		"...and 3 more",
+		"http://localhost:8080/thread/read?id=" + thre.PublicURL(),
+		"http://localhost:8080/thread/watched",
+	} {
+		if !strings.Contains(mail.Body, want) {
+			t.Fatalf("no %q in\n%s", want, mail.Body)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// markdown subset for post bodies:
+// **strong**, *em*, `code`, ``` blocks, - and 1. lists,
+// > quotes, [text](https://...), ||spoiler||, bare urls,
+// plus @mentions and >>N. everything else is plain text.
+
+var (
+	codeSpanPattern = regexp.MustCompile("`([^`\n]+)`")
+	linkPattern     = regexp.MustCompile(`\[([^\]\n]{1,200})\]\((https?://[^\s()<>"]+)\)`)
+	strongPattern   = regexp.MustCompile(`\*\*([^\s*](?:.*?[^\s*])?)\*\*`)
+	emPattern       = regexp.MustCompile(`\*([^\s*](?:[^*]*?[^\s*])?)\*`)
+	spoilerPattern  = regexp.MustCompile(`\|\|([^|\n]+)\|\|`)
+	urlPattern      = regexp.MustCompile(`https?://[^\s<>"]+`)
+	bulletPattern   = regexp.MustCompile(`^\s*[-*] (.*)$`)
+	orderedPattern  = regexp.MustCompile(`^\s*\d{1,3}[.)] (.*)$`)
+)
+
+// part of a text replaced by html
+type bodyLink struct {
+	start int
+	end   int
+	html  string
+}
+
+// cached one is used when threads service rendered it
+func (post *Post) BodyHTML() template.HTML {
+	if len(post.RenderedBody) > 0 {
+		return template.HTML(post.RenderedBody)
+	}
+	return template.HTML(RenderBody(post.Body, post.Mentions))
+}
+
+// markdown to html, sanitized. only known mentions become links.
+func RenderBody(body string, mentions []Mention) string {
+	known := make(map[string]bool, len(mentions))
+	for _, mention := range mentions {
+		known[mention.UserName] = true
+	}
+	return SanitizeHTML(renderBlocksInternal(body, known))
+}
+
+// one pass over lines, a block ends where another kind starts
+func renderBlocksInternal(body string, known map[string]bool) string {
+	var out strings.Builder
+	var lines []string
+	kind := ""
+	flush := func() {
+		if len(lines) == 0 {
+			kind = ""
+			return
+		}
+		switch kind {
+		case "code":
+			out.WriteString("<pre><code>")
+			out.WriteString(template.HTMLEscapeString(strings.Join(lines, "\n")))
+			out.WriteString("</code></pre>")
+		case "ul", "ol":
+			out.WriteString("<" + kind + ">")
+			for _, line := range lines {
+				out.WriteString("<li>" + renderInlineInternal(line, known) + "</li>")
+			}
+			out.WriteString("</" + kind + ">")
+		case "quote":
+			out.WriteString("<blockquote>" + renderLinesInternal(lines, known) + "</blockquote>")
+		default:
+			out.WriteString("<p>" + renderLinesInternal(lines, known) + "</p>")
+		}
+		lines = nil
+		kind = ""
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		if kind == "code" {
+			if strings.HasPrefix(strings.TrimSpace(line), "```") {
+				// empty block still shows
+				if lines == nil {
+					lines = []string{""}
+				}
+				flush()
+			} else {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		next, text := lineKindInternal(line)
+		if next == "fence" {
+			flush()
+			kind = "code"
+			continue
+		}
+		if next != kind {
+			flush()
+		}
+		if next == "" {
+			continue
+		}
+		kind = next
+		lines = append(lines, text)
+	}
+	// unclosed fence runs to the end
+	flush()
+	return out.String()
+}
+
+// kind is "" for blank lines
+func lineKindInternal(line string) (kind string, text string) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case len(trimmed) == 0:
+		return "", ""
+	case strings.HasPrefix(trimmed, "```"):
+		return "fence", ""
+	case strings.HasPrefix(line, ">") && !strings.HasPrefix(line, ">>"):
+		return "quote", strings.TrimPrefix(strings.TrimPrefix(line, ">"), " ")
+	}
+	if match := bulletPattern.FindStringSubmatch(line); match != nil {
+		return "ul", match[1]
+	}
+	if match := orderedPattern.FindStringSubmatch(line); match != nil {
+		return "ol", match[1]
+	}
+	return "p", line
+}
+
+func renderLinesInternal(lines []string, known map[string]bool) string {
+	rendered := make([]string, 0, len(lines))
+	for _, line := range lines {
+		rendered = append(rendered, renderInlineInternal(line, known))
+	}
+	return strings.Join(rendered, "<br>")
+}
+
+// earlier match wins, on a tie the one found first below.
+// strong, em and spoiler can hold other inline ones.
+func renderInlineInternal(text string, known map[string]bool) string {
+	var links []bodyLink
+	for _, loc := range codeSpanPattern.FindAllStringSubmatchIndex(text, -1) {
+		links = append(links, bodyLink{loc[0], loc[1],
+			"<code>" + template.HTMLEscapeString(text[loc[2]:loc[3]]) + "</code>"})
+	}
+	for _, loc := range linkPattern.FindAllStringSubmatchIndex(text, -1) {
+		links = append(links, bodyLink{loc[0], loc[1], fmt.Sprintf(
+			`<a href="%s" rel="nofollow ugc noopener">%s</a>`,
+			template.HTMLEscapeString(text[loc[4]:loc[5]]),
+			template.HTMLEscapeString(text[loc[2]:loc[3]]),
+		)})
+	}
+	wrap := func(pattern *regexp.Regexp, open, close string) {
+		for _, loc := range pattern.FindAllStringSubmatchIndex(text, -1) {
+			inner := renderInlineInternal(text[loc[2]:loc[3]], known)
+			links = append(links, bodyLink{loc[0], loc[1], open + inner + close})
+		}
+	}
+	wrap(strongPattern, "<strong>", "</strong>")
+	wrap(emPattern, "<em>", "</em>")
+	wrap(spoilerPattern, `<span class="spoiler">`, "</span>")
+	links = append(links, mentionLinksInternal(text, known)...)
+	links = append(links, referenceLinksInternal(text)...)
+	for _, loc := range urlPattern.FindAllStringIndex(text, -1) {
+		// sentence end is not part of the url
+		url := strings.TrimRight(text[loc[0]:loc[1]], ".,!?)")
+		links = append(links, bodyLink{loc[0], loc[0] + len(url), fmt.Sprintf(
+			`<a href="%s" rel="nofollow ugc noopener">%s</a>`,
+			template.HTMLEscapeString(url),
+			template.HTMLEscapeString(url),
+		)})
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].start < links[j].start
+	})
+
+	var out strings.Builder
+	last := 0
+	for _, link := range links {
+		if link.start < last {
+			continue
+		}
+		out.WriteString(template.HTMLEscapeString(text[last:link.start]))
+		out.WriteString(link.html)
+		last = link.end
+	}
+	out.WriteString(template.HTMLEscapeString(text[last:]))
+	return out.String()
+}
+
+func mentionLinksInternal(text string, known map[string]bool) (links []bodyLink) {
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// loc[4]:loc[5] is the name, @ is right before it
+		name := text[loc[4]:loc[5]]
+		if !known[name] {
+			continue
+		}
+		links = append(links, bodyLink{loc[4] - 1, loc[5], fmt.Sprintf(
+			`<a href="%s">@%s</a>`,
+			template.HTMLEscapeString(ProfileURL(name)),
+			template.HTMLEscapeString(name),
+		)})
+	}
+	return
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_RenderBody(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+	}{
+		{"**bold *em* end**", "<p><strong>bold <em>em</em> end</strong></p>"},
+		{"`a*b*` ||spoiler||", `<p><code>a*b*</code> <span class="spoiler">spoiler</span></p>`},
+		{"- one\n- two\n\n1. first", "<ul><li>one</li><li>two</li></ul><ol><li>first</li></ol>"},
+		{"> quoted\n>>1 is not", `<blockquote>quoted</blockquote><p><a class="post-ref" href="#p1" data-from="1" data-to="1">&gt;&gt;1</a> is not</p>`},
+		{"```\n<b>*x*</b>\n```", "<pre><code>&lt;b&gt;*x*&lt;/b&gt;</code></pre>"},
+		{"line\nnext", "<p>line<br>next</p>"},
+		{
+			"[go](https://go.dev) https://example.com.",
+			`<p><a href="https://go.dev" rel="nofollow ugc noopener">go</a> <a href="https://example.com" rel="nofollow ugc noopener">https://example.com</a>.</p>`,
+		},
+		{"[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+	}
+	for _, c := range cases {
+		if got := RenderBody(c.body, nil); got != c.want {
+			t.Errorf("%q\n got %s\nwant %s", c.body, got, c.want)
+		}
+	}
+}
+
+func Test_SanitizeHTML(t *testing.T) {
+	dirty := `<p onclick="x">a<script>bad()</script>` +
+		`<a href="javascript:x" class="evil">l</a><a href="//evil.com">m</a>` +
+		`<span class="spoiler">s</span><img src=x onerror=y><em><strong>x</em>`
+	got := SanitizeHTML(dirty)
+	want := `<p>a<a>l</a><a>m</a><span class="spoiler">s</span><em><strong>x</strong></em></p>`
+	if got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	if strings.Contains(SanitizeHTML(`<a href=" JavaScript:x">`), "href") {
+		t.Fatal("javascript url kept")
+	}
+}
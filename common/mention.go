@@ -0,0 +1,33 @@
+package common
+
+import (
+	"net/url"
+	"regexp"
+)
+
+// names are what signup allows without spaces.
+// @ right after a word is an email address, not a mention.
+var mentionPattern = regexp.MustCompile(`(^|[^\w@])@([\w-]{1,64})`)
+
+const maxMentions = 10
+
+// unique names in order of appearance, at most maxMentions
+func ParseMentions(body string) (names []string) {
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		name := match[2]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxMentions {
+			break
+		}
+	}
+	return
+}
+
+func ProfileURL(name string) string {
+	return "/user/profile?name=" + url.QueryEscape(name)
+}
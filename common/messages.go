@@ -0,0 +1,166 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// members of a group, the one who starts it included
+	MaxConversationMembers    = 8
+	MaxConversationTitleRunes = 100
+	MaxDirectMessageRunes     = 4000
+)
+
+// private talk of two or a few users, kept by users service.
+// only members can read or write it, checked on every call.
+type Conversation struct {
+	Id   uint   `xorm:"pk autoincr 'id'" json:"id"`
+	UuId string `xorm:"not null unique 'uu_id'" json:"uuid"`
+	// empty for one to one, member names are shown then
+	Title string `xorm:"'title'" json:"title"`
+	// lower and higher user id of a one to one, so there is only one
+	// for the two. empty for groups and for titled talks of two.
+	PairKey       string    `xorm:"'pair_key'" json:"-"`
+	LastMessageAt time.Time `xorm:"not null 'last_message_at'" json:"last_message_at"`
+	CreatedAt     time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// filled for whoever asked
+	Members     []ConversationMember `xorm:"-" json:"members"`
+	Unread      int64                `xorm:"-" json:"unread"`
+	LastMessage *DirectMessage       `xorm:"-" json:"last_message,omitempty"`
+}
+
+type ConversationMember struct {
+	Id             uint   `xorm:"pk autoincr 'id'" json:"-"`
+	ConversationId uint   `xorm:"not null 'conversation_id'" json:"-"`
+	UserId         uint   `xorm:"not null 'user_id'" json:"user_id"`
+	UserName       string `xorm:"not null 'user_name'" json:"user_name"`
+	// messages up to this one were seen
+	LastReadId uint      `xorm:"not null 'last_read_id'" json:"last_read_id"`
+	JoinedAt   time.Time `xorm:"not null 'joined_at'" json:"joined_at"`
+}
+
+type DirectMessage struct {
+	Id               uint      `xorm:"pk autoincr 'id'" json:"id"`
+	ConversationId   uint      `xorm:"not null 'conversation_id'" json:"-"`
+	ConversationUuId string    `xorm:"-" json:"conversation_uuid"`
+	UserId           uint      `xorm:"not null 'user_id'" json:"user_id"`
+	UserName         string    `xorm:"not null 'user_name'" json:"user_name"`
+	Body             string    `xorm:"TEXT 'body'" json:"body"`
+	CreatedAt        time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// UserId starts a talk with Names. more than one name,
+// or a title, makes a group.
+type ConversationRequest struct {
+	UserId uint     `json:"user_id"`
+	Names  []string `json:"names"`
+	Title  string   `json:"title"`
+}
+
+// conversation UuId as seen by UserId, who has to be a member.
+// UuId is left empty for the inbox.
+type ConversationQuery struct {
+	UuId   string `json:"uuid"`
+	UserId uint   `json:"user_id"`
+}
+
+// messages from others not seen yet, in every conversation
+type MessageCount struct {
+	UserId uint  `json:"user_id"`
+	Unread int64 `json:"unread"`
+}
+
+// names separated by commas or spaces, @ is fine too
+func SplitNames(names string) []string {
+	fields := strings.FieldsFunc(names, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	split := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if name := strings.TrimPrefix(field, "@"); len(name) > 0 {
+			split = append(split, name)
+		}
+	}
+	return split
+}
+
+// trims title, drops repeated names.
+// false when nobody is named, too many are or title is too long.
+func NormalizeConversationRequest(req *ConversationRequest) bool {
+	req.Title = strings.TrimSpace(req.Title)
+	if utf8.RuneCountInString(req.Title) > MaxConversationTitleRunes {
+		return false
+	}
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(req.Names))
+	for _, name := range req.Names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	req.Names = names
+	return len(names) > 0 && len(names) < MaxConversationMembers
+}
+
+// false when the body is blank or too long
+func NormalizeDirectMessage(msg *DirectMessage) bool {
+	if len(strings.TrimSpace(msg.Body)) == 0 {
+		return false
+	}
+	return utf8.RuneCountInString(msg.Body) <= MaxDirectMessageRunes
+}
+
+// same for both, whoever starts it
+func ConversationPairKey(userId uint, otherId uint) string {
+	if otherId < userId {
+		userId, otherId = otherId, userId
+	}
+	return fmt.Sprintf("%d:%d", userId, otherId)
+}
+
+// by members, pair key is not sent to clients and titled talks
+// of two have none
+func (c *Conversation) IsGroup() bool {
+	return len(c.Members) > 2
+}
+
+// title, or names of the others for who is looking
+func (c *Conversation) NameFor(userId uint) string {
+	if len(c.Title) > 0 {
+		return c.Title
+	}
+	var names []string
+	for _, member := range c.Members {
+		if member.UserId != userId {
+			names = append(names, member.UserName)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// router page of it, uuid encoded like for threads
+func (c *Conversation) URL() string {
+	return "/messages/read?id=" + base64.URLEncoding.EncodeToString([]byte(c.UuId))
+}
+
+func (c *Conversation) Member(userId uint) *ConversationMember {
+	for i := range c.Members {
+		if c.Members[i].UserId == userId {
+			return &c.Members[i]
+		}
+	}
+	return nil
+}
+
+func (msg *DirectMessage) When() string {
+	return msg.CreatedAt.Format("2006/Jan/2 at 3:04pm")
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_ConversationRequest(t *testing.T) {
+	names := SplitNames("@taro, hanako  jiro,,taro")
+	if strings.Join(names, "|") != "taro|hanako|jiro|taro" {
+		t.Fatalf("split %v", names)
+	}
+	req := ConversationRequest{Names: names, Title: "  lunch  "}
+	if !NormalizeConversationRequest(&req) || len(req.Names) != 3 || req.Title != "lunch" {
+		t.Fatalf("normalized %+v", req)
+	}
+	if NormalizeConversationRequest(&ConversationRequest{Names: []string{" "}}) {
+		t.Fatal("nobody is accepted")
+	}
+	crowd := ConversationRequest{}
+	for i := 0; i < MaxConversationMembers; i++ {
+		crowd.Names = append(crowd.Names, strings.Repeat("a", i+1))
+	}
+	if NormalizeConversationRequest(&crowd) {
+		t.Fatal("too many members are accepted")
+	}
+	long := ConversationRequest{Names: []string{"taro"}, Title: strings.Repeat("あ", MaxConversationTitleRunes+1)}
+	if NormalizeConversationRequest(&long) {
+		t.Fatal("long title is accepted")
+	}
+
+	if NormalizeDirectMessage(&DirectMessage{Body: " \n"}) ||
+		NormalizeDirectMessage(&DirectMessage{Body: strings.Repeat("a", MaxDirectMessageRunes+1)}) {
+		t.Fatal("empty or long message is accepted")
+	}
+	if ConversationPairKey(7, 3) != ConversationPairKey(3, 7) {
+		t.Fatal("pair key depends on who starts")
+	}
+}
+
+func Test_ConversationName(t *testing.T) {
+	conv := Conversation{
+		PairKey: ConversationPairKey(1, 2),
+		Members: []ConversationMember{
+			{UserId: 1, UserName: "taro"},
+			{UserId: 2, UserName: "hanako"},
+		},
+	}
+	if conv.IsGroup() || conv.NameFor(1) != "hanako" || conv.NameFor(2) != "taro" {
+		t.Fatalf("one to one named %q and %q", conv.NameFor(1), conv.NameFor(2))
+	}
+	conv.Title = "lunch"
+	if conv.IsGroup() {
+		t.Fatal("titled talk of two is a group")
+	}
+	conv.Title = ""
+	conv.Members = append(conv.Members, ConversationMember{UserId: 3, UserName: "jiro"})
+	if !conv.IsGroup() || conv.NameFor(1) != "hanako, jiro" {
+		t.Fatalf("named %q", conv.NameFor(1))
+	}
+	conv.Title = "lunch"
+	if conv.NameFor(1) != "lunch" || conv.Member(3) == nil || conv.Member(4) != nil {
+		t.Fatal("title or members")
+	}
+}
@@ -2,16 +2,64 @@ package common
 
 import (
 	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
 type User struct {
-	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
-	UuId      string    `xorm:"not null unique 'uu_id'" json:"uuid"`
-	Name      string    `xorm:"not null unique 'name'" json:"name"`
-	Email     string    `xorm:"not null unique 'email'" json:"email"`
-	Password  string    `xorm:"not null 'password'" json:"password"`
-	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	Id       uint   `xorm:"pk autoincr 'id'" json:"id"`
+	UuId     string `xorm:"not null unique 'uu_id'" json:"uuid"`
+	Name     string `xorm:"not null unique 'name'" json:"name"`
+	Email    string `xorm:"not null unique 'email'" json:"email"`
+	Password string `xorm:"not null 'password'" json:"password"`
+	Role     string `xorm:"not null 'role'" json:"role"`
+	// no posting until then
+	BannedUntil time.Time `xorm:"banned_until" json:"banned_until"`
+	CreatedAt   time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// roles are given by hand in db, everyone signs up as member
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+// admins can moderate too
+func (user *User) IsModerator() bool {
+	return user.Role == RoleModerator || user.Role == RoleAdmin
+}
+
+// empty role is anyone who can post at all
+var roleRank = map[string]int{
+	"":            0,
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// the role or one above it
+func (user *User) HasRole(role string) bool {
+	return RoleAtLeast(user.Role, role)
+}
+
+func RoleAtLeast(role string, need string) bool {
+	return roleRank[role] >= roleRank[need]
+}
+
+func (user *User) IsBanned(now time.Time) bool {
+	return user.BannedUntil.After(now)
 }
 
 // this is private session
@@ -38,26 +86,366 @@ type Visit struct {
 }
 
 type Thread struct {
+	Id         uint   `xorm:"pk autoincr 'id'" json:"id"`
+	UuId       string `xorm:"not null unique 'uu_id'" json:"uuid"`
+	Topic      string `xorm:"TEXT 'topic'" json:"topic"`
+	NumReplies uint   `xorm:"num_replies" json:"num_replies"`
+	Owner      string `xorm:"owner" json:"owner"`
+	UserId     uint   `xorm:"user_id" json:"user_id"`
+	// zero is DefaultBoardSlug when created
+	BoardId uint `xorm:"board_id" json:"board_id"`
+	// kept in thread_tags table
+	Tags []string `xorm:"-" json:"tags"`
+	// only sent when creating, polls are read on their own
+	Poll *Poll `xorm:"-" json:"poll,omitempty"`
+	// sent from the form whose draft goes away with it.
+	// api and scheduled ones leave the draft alone.
+	ClearDraft bool `xorm:"-" json:"clear_draft,omitempty"`
+	// no more replies when locked
+	Locked     bool      `xorm:"locked" json:"locked"`
+	LastUpdate time.Time `xorm:"not null 'last_update'" json:"last_update"`
+	CreatedAt  time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// threads are grouped by board, admins manage them
+type Board struct {
+	Id          uint   `xorm:"pk autoincr 'id'" json:"id"`
+	Slug        string `xorm:"not null unique 'slug'" json:"slug"`
+	Title       string `xorm:"not null 'title'" json:"title"`
+	Description string `xorm:"TEXT 'description'" json:"description"`
+	// smaller comes first
+	SortOrder int `xorm:"sort_order" json:"sort_order"`
+	// least role to start a thread and to reply, empty is anyone
+	ThreadRole string    `xorm:"thread_role" json:"thread_role"`
+	PostRole   string    `xorm:"post_role" json:"post_role"`
+	CreatedAt  time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// made by setup_db.sql, can not be deleted
+const DefaultBoardSlug = "general"
+
+// slug is in urls, keep it plain
+var boardSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+func IsValidBoardSlug(slug string) bool {
+	return boardSlugPattern.MatchString(slug)
+}
+
+type Post struct {
+	Id          uint   `xorm:"ok autoincr 'id'" json:"id"`
+	UuId        string `xorm:"not null unique 'uu_id'" json:"uuid"`
+	Body        string `xorm:"TEXT 'body'" json:"body"`
+	Contributor string `xorm:"contributor" json:"contributor"`
+	UserId      uint   `xorm:"user_id" json:"user_id"`
+	ThreadId    uint   `xorm:"thread_id" json:"thread_id"`
+	// 1, 2, 3... in the thread, for >>N
+	Number    uint      `xorm:"number" json:"number"`
+	UpdatedAt time.Time `xorm:"updated_at" json:"updated_at"`
+	DeletedAt time.Time `xorm:"deleted 'deleted_at'" json:"-"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// Body rendered by RenderBody, kept so pages do not render again
+	RenderedBody string `xorm:"TEXT 'rendered_body'" json:"rendered_body"`
+	// kept in mentions table, filled by threads service
+	Mentions []Mention `xorm:"-" json:"mentions,omitempty"`
+	// kept in attachments table, files are in Storage
+	Attachments []Attachment `xorm:"-" json:"attachments,omitempty"`
+	// like Thread.ClearDraft, for the reply form
+	ClearDraft bool `xorm:"-" json:"clear_draft,omitempty"`
+	// counts per kind, filled by threads service
+	Reactions []ReactionCount `xorm:"-" json:"reactions,omitempty"`
+	// numbers of later posts referring this one, see IndexReplies
+	RepliedBy []uint `xorm:"-" json:"-"`
+}
+
+// @name in a post, only for users that exist
+// and did not block the writer
+type Mention struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"-"`
+	PostId    uint      `xorm:"not null 'post_id'" json:"post_id"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	UserName  string    `xorm:"not null 'user_name'" json:"user_name"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"-"`
+}
+
+// a file of a post, Hash and ThumbHash are Storage keys
+type Attachment struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"-"`
+	PostId    uint      `xorm:"not null 'post_id'" json:"post_id"`
+	Hash      string    `xorm:"not null 'hash'" json:"hash"`
+	ThumbHash string    `xorm:"'thumb_hash'" json:"thumb_hash,omitempty"`
+	Name      string    `xorm:"not null 'name'" json:"name"`
+	MimeType  string    `xorm:"not null 'mime_type'" json:"mime_type"`
+	Size      int64     `xorm:"not null 'size'" json:"size"`
+	Width     int       `xorm:"'width'" json:"width,omitempty"`
+	Height    int       `xorm:"'height'" json:"height,omitempty"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// served by router, name is only for saving
+func (attachment *Attachment) URL() string {
+	return "/files/" + attachment.Hash + "?name=" + url.QueryEscape(attachment.Name)
+}
+
+// empty when it is not an image
+func (attachment *Attachment) ThumbURL() string {
+	if len(attachment.ThumbHash) == 0 {
+		return ""
+	}
+	return "/files/" + attachment.ThumbHash
+}
+
+// what threads service asks users service when a post has @names
+type MentionQuery struct {
+	AuthorId uint     `json:"author_id"`
+	Names    []string `json:"names"`
+}
+
+// UserId does not want to hear from BlockedId
+type UserBlock struct {
+	Id          uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UserId      uint      `xorm:"not null 'user_id'" json:"user_id"`
+	BlockedId   uint      `xorm:"not null 'blocked_id'" json:"blocked_id"`
+	BlockedName string    `xorm:"blocked_name" json:"blocked_name"`
+	CreatedAt   time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// what happened to posts in a thread.
+// Id is ordered, clients resume from the last one they saw.
+type ThreadEvent struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	ThreadId  uint      `xorm:"not null 'thread_id'" json:"thread_id"`
+	Kind      string    `xorm:"not null 'kind'" json:"kind"`
+	Post      Post      `xorm:"json TEXT 'payload'" json:"post"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// only on the bus, for subscribers not owning threads
+	Thread *Thread `xorm:"-" json:"thread,omitempty"`
+}
+
+const (
+	EventPostCreated = "post.created"
+	EventPostUpdated = "post.updated"
+	EventPostDeleted = "post.deleted"
+)
+
+// what a moderator did, published on the bus.
+// Target is whose content or account it was.
+type ModerationAction struct {
+	Action        string    `json:"action"`
+	ModeratorId   uint      `json:"moderator_id"`
+	ModeratorName string    `json:"moderator_name"`
+	TargetUserId  uint      `json:"target_user_id"`
+	ThreadUuId    string    `json:"thread_uuid"`
+	ThreadTopic   string    `json:"thread_topic"`
+	PostUuId      string    `json:"post_uuid"`
+	Reason        string    `json:"reason"`
+	Until         time.Time `json:"until"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const (
+	ModerationPostDeleted    = "post_deleted"
+	ModerationThreadLocked   = "thread_locked"
+	ModerationThreadUnlocked = "thread_unlocked"
+	ModerationUserBanned     = "user_banned"
+)
+
+// kept by users service, one per thing to tell
+type Notification struct {
 	Id         uint      `xorm:"pk autoincr 'id'" json:"id"`
 	UuId       string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	UserId     uint      `xorm:"not null 'user_id'" json:"user_id"`
+	Kind       string    `xorm:"not null 'kind'" json:"kind"`
+	EventUuId  string    `xorm:"not null 'event_uu_id'" json:"event_uuid"`
+	ActorName  string    `xorm:"actor_name" json:"actor_name"`
+	ThreadUuId string    `xorm:"thread_uu_id" json:"thread_uuid"`
 	Topic      string    `xorm:"TEXT 'topic'" json:"topic"`
-	NumReplies uint      `xorm:"num_replies" json:"num_replies"`
-	Owner      string    `xorm:"owner" json:"owner"`
-	UserId     uint      `xorm:"user_id" json:"user_id"`
-	LastUpdate time.Time `xorm:"not null 'last_update'" json:"last_update"`
+	Excerpt    string    `xorm:"TEXT 'excerpt'" json:"excerpt"`
+	ReadAt     time.Time `xorm:"read_at" json:"read_at"`
 	CreatedAt  time.Time `xorm:"not null 'created_at'" json:"created_at"`
 }
 
-type Post struct {
-	Id          uint      `xorm:"ok autoincr 'id'" json:"id"`
+const (
+	NotifyReply      = "reply"
+	NotifyMention    = "mention"
+	NotifyModeration = "moderation"
+)
+
+type NotificationCount struct {
+	UserId uint  `json:"user_id"`
+	Unread int64 `json:"unread"`
+}
+
+func (n *Notification) IsRead() bool {
+	return !n.ReadAt.IsZero()
+}
+
+func (n *Notification) When() string {
+	return n.CreatedAt.Format("2006/Jan/2 at 3:04pm")
+}
+
+func (n *Notification) ThreadURL() string {
+	return base64.URLEncoding.EncodeToString([]byte(n.ThreadUuId))
+}
+
+// named chat room, messages there are short and live
+type ChatRoom struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UuId      string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	Name      string    `xorm:"not null unique 'name'" json:"name"`
+	Owner     string    `xorm:"owner" json:"owner"`
+	UserId    uint      `xorm:"user_id" json:"user_id"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+type ChatMessage struct {
+	Id          uint      `xorm:"pk autoincr 'id'" json:"id"`
 	UuId        string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	RoomId      uint      `xorm:"not null 'room_id'" json:"room_id"`
 	Body        string    `xorm:"TEXT 'body'" json:"body"`
 	Contributor string    `xorm:"contributor" json:"contributor"`
 	UserId      uint      `xorm:"user_id" json:"user_id"`
-	ThreadId    uint      `xorm:"thread_id" json:"thread_id"`
 	CreatedAt   time.Time `xorm:"not null 'created_at'" json:"created_at"`
 }
 
+// outgoing webhook, ThreadId 0 means every thread (admins only).
+// Events is comma separated topic patterns like "post.*".
+type Webhook struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UuId      string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	Owner     string    `xorm:"owner" json:"owner"`
+	ThreadId  uint      `xorm:"thread_id" json:"thread_id"`
+	URL       string    `xorm:"not null 'url'" json:"url"`
+	Secret    string    `xorm:"not null 'secret'" json:"secret"`
+	Events    string    `xorm:"not null 'events'" json:"events"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// one event to one webhook, kept as delivery log
+type WebhookDelivery struct {
+	Id            uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UuId          string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	WebhookId     uint      `xorm:"not null 'webhook_id'" json:"webhook_id"`
+	EventUuId     string    `xorm:"not null 'event_uu_id'" json:"event_uuid"`
+	Topic         string    `xorm:"not null 'topic'" json:"topic"`
+	Payload       string    `xorm:"TEXT 'payload'" json:"payload"`
+	StatusCode    int       `xorm:"status_code" json:"status_code"`
+	Attempts      int       `xorm:"attempts" json:"attempts"`
+	LastError     string    `xorm:"TEXT 'last_error'" json:"last_error"`
+	NextAttemptAt time.Time `xorm:"next_attempt_at" json:"next_attempt_at"`
+	DeliveredAt   time.Time `xorm:"delivered_at" json:"delivered_at"`
+	FailedAt      time.Time `xorm:"failed_at" json:"failed_at"`
+	CreatedAt     time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// body of webhook requests
+type WebhookPayload struct {
+	Id        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Thread    *Thread   `json:"thread"`
+	Post      *Post     `json:"post,omitempty"`
+}
+
+func (hook *Webhook) EventList() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(hook.Events, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) > 0 {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func (hook *Webhook) Wants(topic string) bool {
+	for _, pattern := range hook.EventList() {
+		if MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (delivery *WebhookDelivery) State() string {
+	switch {
+	case !delivery.DeliveredAt.IsZero():
+		return "delivered"
+	case !delivery.FailedAt.IsZero():
+		return "failed"
+	default:
+		return "pending"
+	}
+}
+
+func (delivery *WebhookDelivery) When() string {
+	return delivery.CreatedAt.Format("2006/Jan/2 at 3:04pm")
+}
+
+// personal api token for bots and scripts.
+// secret is shown only once when created, db keeps its hash.
+type APIToken struct {
+	Id         uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UuId       string    `xorm:"not null unique 'uu_id'" json:"uuid"`
+	UserId     uint      `xorm:"not null 'user_id'" json:"user_id"`
+	UserName   string    `xorm:"user_name" json:"user_name"`
+	Name       string    `xorm:"not null 'name'" json:"name"`
+	Scope      string    `xorm:"not null 'scope'" json:"scope"`
+	TokenHash  string    `xorm:"not null unique 'token_hash'" json:"-"`
+	RateLimit  int       `xorm:"rate_limit" json:"rate_limit"`
+	Revoked    bool      `xorm:"revoked" json:"revoked"`
+	LastUsedAt time.Time `xorm:"last_used_at" json:"last_used_at"`
+	CreatedAt  time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// reply of token creation, the only time Secret is visible
+type NewAPIToken struct {
+	Token  APIToken `json:"token"`
+	Secret string   `json:"secret"`
+}
+
+// token scopes, each one includes the ones before
+const (
+	TokenScopeRead  = "read"
+	TokenScopePost  = "post"
+	TokenScopeAdmin = "admin"
+)
+
+var tokenScopeRank = map[string]int{
+	TokenScopeRead:  1,
+	TokenScopePost:  2,
+	TokenScopeAdmin: 3,
+}
+
+func IsValidTokenScope(scope string) bool {
+	_, ok := tokenScopeRank[scope]
+	return ok
+}
+
+func (token *APIToken) Allows(scope string) bool {
+	return tokenScopeRank[token.Scope] >= tokenScopeRank[scope] &&
+		tokenScopeRank[scope] > 0
+}
+
+func (token *APIToken) LastUsed() string {
+	if token.LastUsedAt.IsZero() {
+		return "never"
+	}
+	return token.LastUsedAt.Format("2006/Jan/2 at 3:04pm")
+}
+
+// one page of threads, Total counts all of them
+type ThreadPage struct {
+	Threads []Thread `json:"threads"`
+	Total   int64    `json:"total"`
+}
+
+// one page of posts in a thread, Total counts all of them
+type PostPage struct {
+	Posts []Post `json:"posts"`
+	Total int64  `json:"total"`
+}
+
 func (thread *Thread) When() string {
 	return thread.CreatedAt.Format("2006/Jan/2 at 3:04pm")
 }
@@ -66,6 +454,20 @@ func (post *Post) When() string {
 	return post.CreatedAt.Format("2006/Jan/2 at 3:04pm")
 }
 
+// what events keep of a deleted post, its text is gone with it
+func (post *Post) Removed() Post {
+	return Post{
+		Id:       post.Id,
+		UuId:     post.UuId,
+		ThreadId: post.ThreadId,
+		Number:   post.Number,
+	}
+}
+
+func (msg *ChatMessage) When() string {
+	return msg.CreatedAt.Format("3:04pm")
+}
+
 func (thread *Thread) PublicURL() string {
 	return base64.URLEncoding.EncodeToString([]byte(thread.UuId))
 }
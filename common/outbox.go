@@ -0,0 +1,190 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const (
+	defaultDispatchInterval = time.Second
+	defaultDispatchBatch    = 100
+	// claimed rows are left to other dispatchers after this
+	dispatchClaim      = time.Second * 30
+	maxDispatchBackoff = time.Minute * 5
+)
+
+// WriteOutbox stores event in the transaction of the change it tells about,
+// so there is an event if and only if the change is committed.
+func WriteOutbox(session *xorm.Session, table string, event *Event) (err error) {
+	affected, err := session.
+		Table(table).
+		Omit("claimed_until", "dispatched_at").
+		InsertOne(event)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+// OutboxDispatcher moves events from an outbox table to the bus.
+// rows are marked dispatched only after Publish succeeded,
+// so an event may go out twice but is never lost.
+// several dispatchers on one table are fine, rows are claimed with SKIP LOCKED.
+type OutboxDispatcher struct {
+	engine   *xorm.Engine
+	table    string
+	bus      EventBus
+	logger   *log.Logger
+	interval time.Duration
+	batch    int
+	wake     chan struct{}
+}
+
+func NewOutboxDispatcher(
+	engine *xorm.Engine,
+	table string,
+	bus EventBus,
+	logger *log.Logger,
+) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		engine:   engine,
+		table:    table,
+		bus:      bus,
+		logger:   logger,
+		interval: defaultDispatchInterval,
+		batch:    defaultDispatchBatch,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run look at the table now, e.g. right after commit.
+// polling still finds everything if nobody calls it.
+func (d *OutboxDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches until ctx is done.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-d.wake:
+		}
+		dispatched, err := d.DispatchOnce(ctx)
+		if err != nil {
+			LogError(d.logger).Printf("dispatching %s: %s\n", d.table, err.Error())
+		}
+		// full batch means there may be more
+		wait := d.interval
+		if dispatched == d.batch {
+			wait = 0
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// DispatchOnce publishes one batch and reports how many went out.
+// failed ones are retried later with backoff.
+func (d *OutboxDispatcher) DispatchOnce(ctx context.Context) (dispatched int, err error) {
+	events, err := d.claimInternal()
+	if err != nil {
+		return
+	}
+	for i := range events {
+		event := &events[i]
+		event.Source = d.table
+		perr := d.bus.Publish(ctx, event)
+		if perr != nil {
+			LogWarning(d.logger).Printf(
+				"event %d (%s) not delivered: %s\n",
+				event.Id, event.Topic, perr.Error(),
+			)
+			err = d.failInternal(event, perr)
+		} else {
+			dispatched++
+			err = d.doneInternal(event)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *OutboxDispatcher) claimInternal() (events []Event, err error) {
+	now := time.Now()
+	query := fmt.Sprintf(
+		`UPDATE %[1]s SET claimed_until = ? WHERE id IN (
+  SELECT id FROM %[1]s
+  WHERE dispatched_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)
+  ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED
+) RETURNING *`,
+		d.table,
+	)
+	err = d.engine.
+		SQL(query, now.Add(dispatchClaim), now, d.batch).
+		Find(&events)
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Id < events[j].Id
+	})
+	return
+}
+
+func (d *OutboxDispatcher) doneInternal(event *Event) (err error) {
+	event.DispatchedAt = time.Now()
+	_, err = d.engine.
+		Table(d.table).
+		Where("id = ?", event.Id).
+		Cols("dispatched_at").
+		Update(event)
+	return
+}
+
+// claim is kept until the backoff ends, so nobody picks it up before
+func (d *OutboxDispatcher) failInternal(event *Event, cause error) (err error) {
+	event.Attempts++
+	event.LastError = cause.Error()
+	event.ClaimedUntil = time.Now().Add(DispatchBackoff(event.Attempts))
+	_, err = d.engine.
+		Table(d.table).
+		Where("id = ?", event.Id).
+		Cols("attempts", "last_error", "claimed_until").
+		Update(event)
+	return
+}
+
+// 1s, 2s, 4s... up to 5 minutes
+func DispatchBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 20 {
+		return maxDispatchBackoff
+	}
+	backoff := time.Second << (attempts - 1)
+	if backoff > maxDispatchBackoff || backoff <= 0 {
+		backoff = maxDispatchBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,276 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"xorm.io/xorm"
+)
+
+const (
+	pgBusReconnectMin = time.Second
+	pgBusReconnectMax = time.Minute
+	// how long delivered ids are remembered for dedupe,
+	// longer than the longest dispatch backoff
+	pgBusSeenTTL   = time.Minute * 10
+	pgBusSeenSweep = time.Minute
+)
+
+var outboxTablePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// what goes through NOTIFY, payload stays in the outbox
+// because NOTIFY is limited to 8000 bytes
+type pgNotice struct {
+	Source string `json:"source"`
+	Id     uint   `json:"id"`
+}
+
+// PostgresBus spreads events to every process listening on the channel.
+// notifications only point at outbox rows, listeners read the rows themselves.
+// NOTIFY is lost while a listener is disconnected or has no handlers yet,
+// so after reconnect and on Subscribe it reads again what sources
+// dispatched meanwhile.
+// an event whose handlers fail is given back to its outbox, the
+// dispatcher publishes it again after backoff. listeners that
+// delivered it already skip it then.
+type PostgresBus struct {
+	engine   *xorm.Engine
+	channel  string
+	logger   *log.Logger
+	listener *pq.Listener
+	subs     subscriptions
+	// outbox tables read again after reconnect
+	sources []string
+	mutex   sync.Mutex
+	// source -> id -> when delivered
+	seen       map[string]map[uint]time.Time
+	lastHeard  time.Time
+	subscribed chan struct{}
+	done       chan struct{}
+}
+
+func NewPostgresBus(
+	engine *xorm.Engine,
+	dbName string,
+	channel string,
+	sources []string,
+	logger *log.Logger,
+) (bus *PostgresBus, err error) {
+	for _, source := range sources {
+		if !outboxTablePattern.MatchString(source) {
+			return nil, fmt.Errorf("event source %q is not an outbox table", source)
+		}
+	}
+	bus = &PostgresBus{
+		engine:     engine,
+		channel:    channel,
+		logger:     logger,
+		sources:    sources,
+		seen:       make(map[string]map[uint]time.Time),
+		lastHeard:  time.Now(),
+		subscribed: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	bus.listener = pq.NewListener(
+		DataSourceName(dbName),
+		pgBusReconnectMin,
+		pgBusReconnectMax,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				LogWarning(logger).Printf("event listener: %s\n", err.Error())
+			}
+		},
+	)
+	err = bus.listener.Listen(channel)
+	if err != nil {
+		bus.listener.Close()
+		return
+	}
+	go bus.listenLoop()
+	go bus.sweepLoop()
+	return
+}
+
+func (b *PostgresBus) Publish(ctx context.Context, event *Event) (err error) {
+	if !outboxTablePattern.MatchString(event.Source) {
+		return fmt.Errorf("event %d has no outbox table", event.Id)
+	}
+	bin, err := json.Marshal(&pgNotice{Source: event.Source, Id: event.Id})
+	if err != nil {
+		return
+	}
+	_, err = b.engine.Context(ctx).Exec("SELECT pg_notify(?, ?)", b.channel, string(bin))
+	return
+}
+
+// new handler also gets what was dispatched just before,
+// e.g. while the process was starting
+func (b *PostgresBus) Subscribe(pattern string, handler EventHandler) {
+	b.subs.add(pattern, handler)
+	select {
+	case b.subscribed <- struct{}{}:
+	default:
+	}
+}
+
+func (b *PostgresBus) Close() error {
+	err := b.listener.Close()
+	<-b.done
+	return err
+}
+
+func (b *PostgresBus) listenLoop() {
+	defer close(b.done)
+	for {
+		var n *pq.Notification
+		select {
+		case <-b.subscribed:
+			b.catchUpInternal()
+			continue
+		case received, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			n = received
+		}
+		// nil comes after reconnect
+		if n == nil {
+			b.catchUpInternal()
+			continue
+		}
+		var notice pgNotice
+		err := json.Unmarshal([]byte(n.Extra), &notice)
+		if err != nil || !outboxTablePattern.MatchString(notice.Source) {
+			LogWarning(b.logger).Printf("broken notice %q\n", n.Extra)
+			continue
+		}
+		b.mutex.Lock()
+		b.lastHeard = time.Now()
+		b.mutex.Unlock()
+		var events []Event
+		err = b.engine.
+			Table(notice.Source).
+			Where("id = ?", notice.Id).
+			Find(&events)
+		if err != nil {
+			LogError(b.logger).Println(err.Error())
+			continue
+		}
+		b.deliverInternal(notice.Source, events)
+	}
+}
+
+// what was dispatched while we were away, seen ones are skipped
+func (b *PostgresBus) catchUpInternal() {
+	b.mutex.Lock()
+	since := b.lastHeard.Add(-dispatchClaim)
+	b.mutex.Unlock()
+
+	for _, source := range b.sources {
+		var events []Event
+		err := b.engine.
+			Table(source).
+			Where("dispatched_at >= ?", since).
+			Asc("id").
+			Find(&events)
+		if err != nil {
+			LogError(b.logger).Println(err.Error())
+			continue
+		}
+		b.deliverInternal(source, events)
+	}
+}
+
+// only called from listenLoop, so an event is not delivered twice at once
+func (b *PostgresBus) deliverInternal(source string, events []Event) {
+	for i := range events {
+		event := &events[i]
+		event.Source = source
+		// nobody here wants it yet, e.g. before Subscribe at start
+		if b.isSeenInternal(source, event.Id) || !b.subs.matches(event.Topic) {
+			continue
+		}
+		err := b.subs.deliver(context.Background(), event)
+		if err != nil {
+			LogWarning(b.logger).Printf(
+				"event %s %d (%s) not delivered, given back: %s\n",
+				source, event.Id, event.Topic, err.Error(),
+			)
+			b.giveBackInternal(event, err)
+			continue
+		}
+		b.markSeenInternal(source, event.Id)
+	}
+}
+
+// outbox row is not dispatched any more, claim is kept
+// until the backoff ends like OutboxDispatcher does
+func (b *PostgresBus) giveBackInternal(event *Event, cause error) {
+	query := fmt.Sprintf(
+		`UPDATE %s SET dispatched_at = NULL, attempts = attempts + 1,
+  last_error = ?, claimed_until = ? WHERE id = ?`,
+		event.Source,
+	)
+	_, err := b.engine.Exec(
+		query,
+		cause.Error(),
+		time.Now().Add(DispatchBackoff(event.Attempts+1)),
+		event.Id,
+	)
+	if err != nil {
+		LogError(b.logger).Printf(
+			"event %s %d (%s) lost: %s\n",
+			event.Source, event.Id, event.Topic, err.Error(),
+		)
+	}
+}
+
+func (b *PostgresBus) isSeenInternal(source string, id uint) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, ok := b.seen[source][id]
+	return ok
+}
+
+func (b *PostgresBus) markSeenInternal(source string, id uint) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	seen, ok := b.seen[source]
+	if !ok {
+		seen = make(map[uint]time.Time)
+		b.seen[source] = seen
+	}
+	seen[id] = time.Now()
+}
+
+func (b *PostgresBus) sweepLoop() {
+	ticker := time.NewTicker(pgBusSeenSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			b.sweepSeenInternal(now)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// forgets ids delivered longer than pgBusSeenTTL ago
+func (b *PostgresBus) sweepSeenInternal(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, seen := range b.seen {
+		for id, at := range seen {
+			if now.Sub(at) > pgBusSeenTTL {
+				delete(seen, id)
+			}
+		}
+	}
+}
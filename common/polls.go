@@ -0,0 +1,171 @@
+package common
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinPollOptions       = 2
+	MaxPollOptions       = 10
+	maxPollQuestionRunes = 200
+	maxPollOptionRunes   = 100
+)
+
+// at most one per thread, made with the thread by its owner
+type Poll struct {
+	Id        uint   `xorm:"pk autoincr 'id'" json:"id"`
+	ThreadId  uint   `xorm:"not null unique 'thread_id'" json:"thread_id"`
+	Question  string `xorm:"not null 'question'" json:"question"`
+	Multiple  bool   `xorm:"not null 'multiple'" json:"multiple"`
+	Anonymous bool   `xorm:"not null 'anonymous'" json:"anonymous"`
+	// zero is open forever
+	ClosesAt  time.Time    `xorm:"'closes_at'" json:"closes_at"`
+	CreatedAt time.Time    `xorm:"not null 'created_at'" json:"created_at"`
+	Options   []PollOption `xorm:"-" json:"options"`
+	// users who voted, one may choose many options
+	Voters int64 `xorm:"-" json:"voters"`
+	// options chosen by who asked, empty before voting
+	MyChoices []uint `xorm:"-" json:"my_choices,omitempty"`
+}
+
+type PollOption struct {
+	Id       uint   `xorm:"pk autoincr 'id'" json:"id"`
+	PollId   uint   `xorm:"not null 'poll_id'" json:"-"`
+	Position int    `xorm:"not null 'position'" json:"position"`
+	Label    string `xorm:"not null 'label'" json:"label"`
+	Votes    int64  `xorm:"-" json:"votes"`
+	// who chose it, always empty for anonymous polls
+	VoterNames []string `xorm:"-" json:"voter_names,omitempty"`
+}
+
+// UserName is not kept for anonymous polls,
+// UserId is, so nobody votes twice
+type PollVote struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"-"`
+	PollId    uint      `xorm:"not null 'poll_id'" json:"poll_id"`
+	OptionId  uint      `xorm:"not null 'option_id'" json:"option_id"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	UserName  string    `xorm:"'user_name'" json:"user_name"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// every choice of one user at once, votes can not be changed
+type PollBallot struct {
+	PollId    uint   `json:"poll_id"`
+	UserId    uint   `json:"user_id"`
+	UserName  string `json:"user_name"`
+	OptionIds []uint `json:"option_ids"`
+}
+
+// poll of ThreadId, MyChoices are of UserId when not 0
+type PollQuery struct {
+	ThreadId uint `json:"thread_id"`
+	UserId   uint `json:"user_id"`
+}
+
+// trims the texts and drops empty options.
+// false when something is missing, too long, repeated or already closed.
+func NormalizePoll(poll *Poll, now time.Time) bool {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if len(poll.Question) == 0 || utf8.RuneCountInString(poll.Question) > maxPollQuestionRunes {
+		return false
+	}
+	if !poll.ClosesAt.IsZero() && !poll.ClosesAt.After(now) {
+		return false
+	}
+	seen := make(map[string]bool)
+	options := make([]PollOption, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		label := strings.TrimSpace(option.Label)
+		if len(label) == 0 {
+			continue
+		}
+		if utf8.RuneCountInString(label) > maxPollOptionRunes || seen[label] {
+			return false
+		}
+		seen[label] = true
+		options = append(options, PollOption{Position: len(options), Label: label})
+	}
+	poll.Options = options
+	return len(options) >= MinPollOptions && len(options) <= MaxPollOptions
+}
+
+// form input, one option per line
+func SplitPollOptions(input string) (options []PollOption) {
+	for _, line := range strings.Split(input, "\n") {
+		options = append(options, PollOption{Label: line})
+	}
+	return
+}
+
+func (poll *Poll) IsClosed(now time.Time) bool {
+	return !poll.ClosesAt.IsZero() && !now.Before(poll.ClosesAt)
+}
+
+func (poll *Poll) HasVoted() bool {
+	return len(poll.MyChoices) > 0
+}
+
+func (poll *Poll) Chose(optionId uint) bool {
+	for _, id := range poll.MyChoices {
+		if id == optionId {
+			return true
+		}
+	}
+	return false
+}
+
+// share of voters who chose it, 0 to 100
+func (poll *Poll) Percent(option PollOption) int64 {
+	if poll.Voters == 0 {
+		return 0
+	}
+	return option.Votes * 100 / poll.Voters
+}
+
+// checks choices of a ballot against the poll.
+// single choice polls take exactly one.
+func (poll *Poll) IsValidBallot(optionIds []uint) bool {
+	if len(optionIds) == 0 || (!poll.Multiple && len(optionIds) > 1) {
+		return false
+	}
+	valid := make(map[uint]bool)
+	for _, option := range poll.Options {
+		valid[option.Id] = true
+	}
+	for _, id := range optionIds {
+		if !valid[id] {
+			return false
+		}
+		// each only once
+		valid[id] = false
+	}
+	return true
+}
+
+// fills Votes, VoterNames, Voters and MyChoices from every vote.
+// polls are small, so this is done in memory.
+func CountPollVotes(poll *Poll, votes []PollVote, userId uint) {
+	byOption := make(map[uint]int)
+	for i := range poll.Options {
+		byOption[poll.Options[i].Id] = i
+	}
+	voters := make(map[uint]bool)
+	for _, given := range votes {
+		i, ok := byOption[given.OptionId]
+		if !ok {
+			continue
+		}
+		poll.Options[i].Votes++
+		if !poll.Anonymous && len(given.UserName) > 0 {
+			poll.Options[i].VoterNames = append(poll.Options[i].VoterNames, given.UserName)
+		}
+		voters[given.UserId] = true
+		if userId != 0 && given.UserId == userId {
+			poll.MyChoices = append(poll.MyChoices, given.OptionId)
+		}
+	}
+	poll.Voters = int64(len(voters))
+}
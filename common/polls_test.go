@@ -0,0 +1,85 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_NormalizePoll(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	poll := Poll{
+		Question: "  lunch?  ",
+		Options:  SplitPollOptions("ramen\n\n  udon \n"),
+	}
+	if !NormalizePoll(&poll, now) {
+		t.Fatal("valid poll is rejected")
+	}
+	if poll.Question != "lunch?" || len(poll.Options) != 2 ||
+		poll.Options[1].Label != "udon" || poll.Options[1].Position != 1 {
+		t.Fatalf("normalized %+v", poll)
+	}
+
+	tests := map[string]Poll{
+		"no question":  {Options: SplitPollOptions("a\nb")},
+		"one option":   {Question: "q", Options: SplitPollOptions("a\n ")},
+		"repeated":     {Question: "q", Options: SplitPollOptions("a\nb\na")},
+		"closed":       {Question: "q", Options: SplitPollOptions("a\nb"), ClosesAt: now},
+		"many options": {Question: "q", Options: SplitPollOptions("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11")},
+	}
+	for name, poll := range tests {
+		if NormalizePoll(&poll, now) {
+			t.Errorf("%s is accepted", name)
+		}
+	}
+}
+
+func Test_CountPollVotes(t *testing.T) {
+	newPoll := func(anonymous bool) *Poll {
+		return &Poll{
+			Anonymous: anonymous,
+			Multiple:  true,
+			Options:   []PollOption{{Id: 10, Label: "ramen"}, {Id: 11, Label: "udon"}},
+		}
+	}
+	votes := []PollVote{
+		{OptionId: 10, UserId: 1, UserName: "taro"},
+		{OptionId: 11, UserId: 1, UserName: "taro"},
+		{OptionId: 10, UserId: 2, UserName: "hanako"},
+		{OptionId: 99, UserId: 3, UserName: "gone"},
+	}
+
+	poll := newPoll(false)
+	CountPollVotes(poll, votes, 1)
+	if poll.Voters != 2 || poll.Options[0].Votes != 2 || poll.Options[1].Votes != 1 {
+		t.Fatalf("counted %+v", poll)
+	}
+	if !reflect.DeepEqual(poll.Options[0].VoterNames, []string{"taro", "hanako"}) {
+		t.Fatalf("names %v", poll.Options[0].VoterNames)
+	}
+	if !poll.HasVoted() || !poll.Chose(11) || poll.Percent(poll.Options[1]) != 50 {
+		t.Fatalf("mine %v percent %d", poll.MyChoices, poll.Percent(poll.Options[1]))
+	}
+
+	poll = newPoll(true)
+	CountPollVotes(poll, votes, 0)
+	if len(poll.Options[0].VoterNames) != 0 || poll.HasVoted() {
+		t.Fatalf("anonymous %+v", poll)
+	}
+}
+
+func Test_IsValidBallot(t *testing.T) {
+	poll := Poll{Options: []PollOption{{Id: 1}, {Id: 2}}}
+	if !poll.IsValidBallot([]uint{2}) {
+		t.Fatal("single choice is rejected")
+	}
+	for _, ids := range [][]uint{nil, {1, 2}, {3}} {
+		if poll.IsValidBallot(ids) {
+			t.Errorf("%v is accepted by single choice", ids)
+		}
+	}
+	poll.Multiple = true
+	if !poll.IsValidBallot([]uint{1, 2}) || poll.IsValidBallot([]uint{1, 1}) {
+		t.Fatal("multiple choice")
+	}
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// >>N or >>N-M, 2ch style
+var referencePattern = regexp.MustCompile(`>>(\d{1,6})(?:-(\d{1,6}))?`)
+
+// >>1-1000 would make every post a reply
+const maxReferenceRange = 50
+
+// post numbers From to To, both included
+type PostRange struct {
+	From uint
+	To   uint
+}
+
+// ranges are clipped to maxReferenceRange, >>0 and reversed ones are dropped
+func ParseReferences(body string) (refs []PostRange) {
+	for _, match := range referencePattern.FindAllStringSubmatch(body, -1) {
+		ref, ok := postRangeInternal(match[1], match[2])
+		if ok {
+			refs = append(refs, ref)
+		}
+	}
+	return
+}
+
+func postRangeInternal(from, to string) (ref PostRange, ok bool) {
+	n, _ := strconv.ParseUint(from, 10, 32)
+	ref = PostRange{From: uint(n), To: uint(n)}
+	if len(to) > 0 {
+		m, _ := strconv.ParseUint(to, 10, 32)
+		ref.To = uint(m)
+	}
+	if ref.From == 0 || ref.To < ref.From {
+		return
+	}
+	if ref.To-ref.From >= maxReferenceRange {
+		ref.To = ref.From + maxReferenceRange - 1
+	}
+	ok = true
+	return
+}
+
+// fills RepliedBy of posts with later posts referring them.
+// posts are of one thread.
+func IndexReplies(posts []Post) {
+	byNumber := make(map[uint]int, len(posts))
+	for i := range posts {
+		posts[i].RepliedBy = nil
+		byNumber[posts[i].Number] = i
+	}
+	for i := range posts {
+		seen := make(map[uint]bool)
+		for _, ref := range ParseReferences(posts[i].Body) {
+			for n := ref.From; n <= ref.To && n < posts[i].Number; n++ {
+				j, ok := byNumber[n]
+				if !ok || seen[n] {
+					continue
+				}
+				seen[n] = true
+				posts[j].RepliedBy = append(posts[j].RepliedBy, posts[i].Number)
+			}
+		}
+	}
+	for i := range posts {
+		sort.Slice(posts[i].RepliedBy, func(a, b int) bool {
+			return posts[i].RepliedBy[a] < posts[i].RepliedBy[b]
+		})
+	}
+}
+
+// links point at #pN, the page shows previews on hover
+func referenceLinksInternal(text string) (links []bodyLink) {
+	for _, loc := range referencePattern.FindAllStringSubmatchIndex(text, -1) {
+		to := ""
+		if loc[4] >= 0 {
+			to = text[loc[4]:loc[5]]
+		}
+		ref, ok := postRangeInternal(text[loc[2]:loc[3]], to)
+		if !ok {
+			continue
+		}
+		links = append(links, bodyLink{
+			start: loc[0],
+			end:   loc[1],
+			html: fmt.Sprintf(
+				`<a class="post-ref" href="#p%d" data-from="%d" data-to="%d">%s</a>`,
+				ref.From,
+				ref.From,
+				ref.To,
+				template.HTMLEscapeString(text[loc[0]:loc[1]]),
+			),
+		})
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ParseMentions(t *testing.T) {
+	names := ParseMentions("@taro hi, mail me at hanako@go.com. (@jiro) @taro again")
+	want := []string{"taro", "jiro"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names %v, want %v", names, want)
+	}
+	if ParseMentions("nobody here") != nil {
+		t.Fatal("mentions without @")
+	}
+}
+
+func Test_BodyHTML(t *testing.T) {
+	post := Post{
+		Body:     "<b>@taro</b> and @ghost",
+		Mentions: []Mention{{UserName: "taro"}},
+	}
+	html := string(post.BodyHTML())
+	if !strings.Contains(html, `<a href="/user/profile?name=taro">@taro</a>`) {
+		t.Fatalf("mention not linked %s", html)
+	}
+	if strings.Contains(html, "<b>") || !strings.Contains(html, "&lt;b&gt;") {
+		t.Fatalf("body not escaped %s", html)
+	}
+	if !strings.HasSuffix(html, "and @ghost</p>") {
+		t.Fatalf("unknown name linked %s", html)
+	}
+}
+
+func Test_ParseReferences(t *testing.T) {
+	refs := ParseReferences(">>1 >>3-5\n>>0 >>9-7 >>10-500")
+	want := []PostRange{{1, 1}, {3, 5}, {10, 59}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("refs %v, want %v", refs, want)
+	}
+}
+
+func Test_IndexReplies(t *testing.T) {
+	posts := []Post{
+		{Number: 1, Body: "first"},
+		{Number: 2, Body: ">>1 hi >>1"},
+		{Number: 3, Body: ">>1-2 and >>3 and >>9"},
+	}
+	IndexReplies(posts)
+	if !reflect.DeepEqual(posts[0].RepliedBy, []uint{2, 3}) {
+		t.Fatalf("replies of 1: %v", posts[0].RepliedBy)
+	}
+	if !reflect.DeepEqual(posts[1].RepliedBy, []uint{3}) {
+		t.Fatalf("replies of 2: %v", posts[1].RepliedBy)
+	}
+	if posts[2].RepliedBy != nil {
+		t.Fatalf("post replied itself %v", posts[2].RepliedBy)
+	}
+}
+
+func Test_BodyHTMLReference(t *testing.T) {
+	post := Post{Body: ">>2-3 <3 >>0"}
+	html := string(post.BodyHTML())
+	want := `<p><a class="post-ref" href="#p2" data-from="2" data-to="3">&gt;&gt;2-3</a> &lt;3 &gt;&gt;0</p>`
+	if html != want {
+		t.Fatalf("body %s", html)
+	}
+}
@@ -0,0 +1,98 @@
+package common
+
+import "time"
+
+// the fixed set, in the order they are shown
+var ReactionKinds = []string{"like", "love", "laugh", "wow", "sad"}
+
+var reactionEmoji = map[string]string{
+	"like":  "👍",
+	"love":  "❤️",
+	"laugh": "😂",
+	"wow":   "😮",
+	"sad":   "😢",
+}
+
+func IsValidReactionKind(kind string) bool {
+	_, ok := reactionEmoji[kind]
+	return ok
+}
+
+func ReactionEmoji(kind string) string {
+	return reactionEmoji[kind]
+}
+
+// one of each kind per user and post, a second one takes it back.
+// PostUuId is how router names the post, threads service finds PostId.
+type Reaction struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"-"`
+	PostId    uint      `xorm:"not null 'post_id'" json:"post_id"`
+	PostUuId  string    `xorm:"-" json:"post_uuid,omitempty"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	Kind      string    `xorm:"not null 'kind'" json:"kind"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+}
+
+// how many users gave the kind to a post.
+// Mine is only known to router, threads service leaves it false.
+type ReactionCount struct {
+	PostId uint   `xorm:"'post_id'" json:"-"`
+	Kind   string `xorm:"'kind'" json:"kind"`
+	Emoji  string `xorm:"-" json:"emoji"`
+	Count  int64  `xorm:"'count'" json:"count"`
+	Mine   bool   `xorm:"-" json:"mine,omitempty"`
+}
+
+// reactions of UserId on posts of ThreadId
+type ReactionQuery struct {
+	ThreadId uint `json:"thread_id"`
+	UserId   uint `json:"user_id"`
+}
+
+// kinds in ReactionKinds order, ones nobody gave are left out
+func SortReactionCounts(counts []ReactionCount) []ReactionCount {
+	byKind := make(map[string]ReactionCount)
+	for _, count := range counts {
+		byKind[count.Kind] = count
+	}
+	sorted := make([]ReactionCount, 0, len(counts))
+	for _, kind := range ReactionKinds {
+		if count, ok := byKind[kind]; ok && count.Count > 0 {
+			count.Emoji = ReactionEmoji(kind)
+			sorted = append(sorted, count)
+		}
+	}
+	return sorted
+}
+
+// every kind for the buttons under a post, with zero counts
+func (post *Post) ReactionBar() []ReactionCount {
+	byKind := make(map[string]ReactionCount)
+	for _, count := range post.Reactions {
+		byKind[count.Kind] = count
+	}
+	bar := make([]ReactionCount, 0, len(ReactionKinds))
+	for _, kind := range ReactionKinds {
+		count := byKind[kind]
+		count.Kind = kind
+		count.Emoji = ReactionEmoji(kind)
+		bar = append(bar, count)
+	}
+	return bar
+}
+
+// sets Mine on counts of the posts the user reacted to
+func MarkMyReactions(posts []Post, mine []Reaction) {
+	given := make(map[uint]map[string]bool)
+	for _, reaction := range mine {
+		if given[reaction.PostId] == nil {
+			given[reaction.PostId] = make(map[string]bool)
+		}
+		given[reaction.PostId][reaction.Kind] = true
+	}
+	for i := range posts {
+		for j := range posts[i].Reactions {
+			posts[i].Reactions[j].Mine = given[posts[i].Id][posts[i].Reactions[j].Kind]
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_ReactionBar(t *testing.T) {
+	post := Post{Id: 1, Reactions: SortReactionCounts([]ReactionCount{
+		{Kind: "sad", Count: 1},
+		{Kind: "like", Count: 3},
+		{Kind: "nope", Count: 9},
+		{Kind: "wow", Count: 0},
+	})}
+	var kinds []string
+	for _, count := range post.Reactions {
+		kinds = append(kinds, count.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []string{"like", "sad"}) {
+		t.Fatalf("sorted %v", kinds)
+	}
+	if post.Reactions[0].Emoji != "👍" {
+		t.Fatalf("emoji %q", post.Reactions[0].Emoji)
+	}
+
+	posts := []Post{post}
+	MarkMyReactions(posts, []Reaction{{PostId: 1, Kind: "sad"}, {PostId: 2, Kind: "like"}})
+	bar := posts[0].ReactionBar()
+	if len(bar) != len(ReactionKinds) {
+		t.Fatalf("bar %v", bar)
+	}
+	for _, count := range bar {
+		wantMine := count.Kind == "sad"
+		if count.Mine != wantMine || len(count.Emoji) == 0 {
+			t.Fatalf("count %+v", count)
+		}
+	}
+	if bar[0].Count != 3 || bar[1].Count != 0 {
+		t.Fatalf("counts %v", bar)
+	}
+}
@@ -0,0 +1,70 @@
+package common
+
+import "time"
+
+// last post a user saw in a thread. rows exist only for threads
+// opened since the user last marked everything read.
+type ThreadRead struct {
+	Id         uint      `xorm:"pk autoincr 'id'" json:"-"`
+	UserId     uint      `xorm:"not null 'user_id'" json:"user_id"`
+	ThreadId   uint      `xorm:"not null 'thread_id'" json:"thread_id"`
+	LastNumber uint      `xorm:"not null 'last_number'" json:"last_number"`
+	UpdatedAt  time.Time `xorm:"not null 'updated_at'" json:"updated_at"`
+	// posts till then are read in every thread, see ReadHorizon
+	ReadAllAt time.Time `xorm:"-" json:"read_all_at"`
+}
+
+// one row per user, moved by mark all read
+type ReadHorizon struct {
+	UserId    uint      `xorm:"pk 'user_id'" json:"user_id"`
+	ReadAllAt time.Time `xorm:"not null 'read_all_at'" json:"read_all_at"`
+}
+
+// unread posts of ThreadIds for UserId
+type UnreadQuery struct {
+	UserId    uint   `json:"user_id"`
+	ThreadIds []uint `json:"thread_ids"`
+}
+
+type UnreadCount struct {
+	ThreadId uint  `xorm:"'thread_id'" json:"thread_id"`
+	Unread   int64 `xorm:"'unread'" json:"unread"`
+}
+
+// own posts are never news
+func (read *ThreadRead) IsRead(post *Post) bool {
+	return post.Number <= read.LastNumber ||
+		!post.CreatedAt.After(read.ReadAllAt) ||
+		post.UserId == read.UserId
+}
+
+// number of the first post not read yet, 0 when all are
+func FirstUnread(posts []Post, read *ThreadRead) uint {
+	for i := range posts {
+		if !read.IsRead(&posts[i]) {
+			return posts[i].Number
+		}
+	}
+	return 0
+}
+
+// highest number in posts, what reading them all reached
+func LastPostNumber(posts []Post) (last uint) {
+	for _, post := range posts {
+		if post.Number > last {
+			last = post.Number
+		}
+	}
+	return
+}
+
+// unread per thread id, threads with none are left out
+func UnreadByThread(counts []UnreadCount) map[uint]int64 {
+	unread := make(map[uint]int64)
+	for _, count := range counts {
+		if count.Unread > 0 {
+			unread[count.ThreadId] = count.Unread
+		}
+	}
+	return unread
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_FirstUnread(t *testing.T) {
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	posts := []Post{
+		{Number: 1, UserId: 2, CreatedAt: start},
+		{Number: 2, UserId: 1, CreatedAt: start.Add(time.Minute)},
+		{Number: 3, UserId: 2, CreatedAt: start.Add(time.Minute * 2)},
+		{Number: 4, UserId: 2, CreatedAt: start.Add(time.Minute * 3)},
+	}
+	tests := []struct {
+		name string
+		read ThreadRead
+		want uint
+	}{
+		{"never read", ThreadRead{UserId: 3}, 1},
+		{"own posts are read", ThreadRead{UserId: 1, LastNumber: 1}, 3},
+		{"horizon", ThreadRead{UserId: 3, LastNumber: 1, ReadAllAt: start.Add(time.Minute * 2)}, 4},
+		{"all read", ThreadRead{UserId: 3, LastNumber: 4}, 0},
+	}
+	for _, test := range tests {
+		if got := FirstUnread(posts, &test.read); got != test.want {
+			t.Errorf("%s: got %d want %d", test.name, got, test.want)
+		}
+	}
+	if LastPostNumber(posts) != 4 || LastPostNumber(nil) != 0 {
+		t.Fatal("last post number")
+	}
+	unread := UnreadByThread([]UnreadCount{{ThreadId: 1, Unread: 2}, {ThreadId: 2}})
+	if !reflect.DeepEqual(unread, map[uint]int64{1: 2}) {
+		t.Fatalf("unread %v", unread)
+	}
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// tags RenderBody makes, with their allowed attributes
+var allowedTags = map[string][]string{
+	"p":          nil,
+	"br":         nil,
+	"strong":     nil,
+	"em":         nil,
+	"code":       nil,
+	"pre":        nil,
+	"ul":         nil,
+	"ol":         nil,
+	"li":         nil,
+	"blockquote": nil,
+	"a":          {"href", "class", "rel", "data-from", "data-to"},
+	"span":       {"class"},
+}
+
+var allowedClasses = map[string]bool{
+	"post-ref": true,
+	"spoiler":  true,
+}
+
+// contents of these are dropped too, not only the tags
+var droppedTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"object":   true,
+	"textarea": true,
+}
+
+var voidTags = map[string]bool{
+	"br": true,
+}
+
+// SanitizeHTML keeps only allowed tags and attributes.
+// RenderBody escapes everything already, this is the second line
+// in case a renderer bug lets something through.
+func SanitizeHTML(dirty string) string {
+	var out strings.Builder
+	tokenizer := nethtml.NewTokenizer(strings.NewReader(dirty))
+	var open []string
+	dropping := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == nethtml.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case nethtml.TextToken:
+			if dropping == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			if droppedTags[token.Data] {
+				if tokenType == nethtml.StartTagToken {
+					dropping++
+				}
+				continue
+			}
+			attrs, ok := allowedTags[token.Data]
+			if !ok || dropping > 0 {
+				continue
+			}
+			out.WriteString("<" + token.Data)
+			for _, attr := range token.Attr {
+				value, ok := allowedAttrInternal(attrs, attr)
+				if ok {
+					out.WriteString(" " + attr.Key + `="` + html.EscapeString(value) + `"`)
+				}
+			}
+			out.WriteString(">")
+			if !voidTags[token.Data] {
+				open = append(open, token.Data)
+			}
+		case nethtml.EndTagToken:
+			if droppedTags[token.Data] {
+				if dropping > 0 {
+					dropping--
+				}
+				continue
+			}
+			// close only what is open, and everything opened inside it
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != token.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					out.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+func allowedAttrInternal(allowed []string, attr nethtml.Attribute) (value string, ok bool) {
+	if len(attr.Namespace) > 0 {
+		return
+	}
+	for _, key := range allowed {
+		if key == attr.Key {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return
+	}
+	value = attr.Val
+	switch attr.Key {
+	case "href":
+		ok = isSafeURLInternal(value)
+	case "class":
+		ok = allowedClasses[value]
+	case "data-from", "data-to":
+		ok = len(strings.Trim(value, "0123456789")) == 0
+	}
+	return
+}
+
+// http(s), or paths and anchors on this site
+func isSafeURLInternal(url string) bool {
+	lower := strings.ToLower(strings.TrimSpace(url))
+	switch {
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"):
+		return true
+	case strings.HasPrefix(lower, "//"):
+		return false
+	case strings.HasPrefix(lower, "/"), strings.HasPrefix(lower, "#"):
+		return true
+	}
+	return false
+}
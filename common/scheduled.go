@@ -0,0 +1,87 @@
+package common
+
+import (
+	"encoding/base64"
+	"time"
+)
+
+const (
+	ScheduledPending   = "pending"
+	ScheduledPublished = "published"
+	ScheduledCancelled = "cancelled"
+	// locked thread, banned user and such, Reason tells
+	ScheduledFailed = "failed"
+
+	// pending ones a user may have at once
+	MaxScheduledPerUser = 50
+	// how far ahead things may be scheduled
+	MaxScheduleAhead = 365 * 24 * time.Hour
+)
+
+// a thread or reply to be published later by threads service.
+// ThreadId is 0 for a new thread, only that one has Topic, BoardId
+// and Tags. files and polls can not be scheduled.
+type ScheduledPost struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	UserName  string    `xorm:"not null 'user_name'" json:"user_name"`
+	ThreadId  uint      `xorm:"not null 'thread_id'" json:"thread_id"`
+	BoardId   uint      `xorm:"not null 'board_id'" json:"board_id"`
+	Topic     string    `xorm:"TEXT 'topic'" json:"topic"`
+	Tags      []string  `xorm:"json TEXT 'tags'" json:"tags"`
+	Body      string    `xorm:"TEXT 'body'" json:"body"`
+	PublishAt time.Time `xorm:"not null 'publish_at'" json:"publish_at"`
+	State     string    `xorm:"not null 'state'" json:"state"`
+	// why it failed, empty otherwise
+	Reason string `xorm:"'reason'" json:"reason"`
+	// thread it went to once published, for the reply itself too
+	PublishedUuId string    `xorm:"'published_uu_id'" json:"published_uuid"`
+	CreatedAt     time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// the thread of a reply, for listing
+	Thread *Thread `xorm:"-" json:"thread,omitempty"`
+}
+
+// one page of scheduled posts, Total counts all of them
+type ScheduledPage struct {
+	Scheduled []ScheduledPost `json:"scheduled"`
+	Total     int64           `json:"total"`
+}
+
+func (scheduled *ScheduledPost) IsThread() bool {
+	return scheduled.ThreadId == 0
+}
+
+func (scheduled *ScheduledPost) IsPending() bool {
+	return scheduled.State == ScheduledPending
+}
+
+func (scheduled *ScheduledPost) When() string {
+	return scheduled.PublishAt.Format("2006/Jan/2 at 3:04pm")
+}
+
+// thread page it went to, empty till published
+func (scheduled *ScheduledPost) PublishedURL() string {
+	if len(scheduled.PublishedUuId) == 0 {
+		return ""
+	}
+	return "/thread/read?id=" + base64.URLEncoding.EncodeToString([]byte(scheduled.PublishedUuId))
+}
+
+// false when publish time is not in the coming year
+func CheckPublishAt(publishAt time.Time, now time.Time) bool {
+	return publishAt.After(now) && !publishAt.After(now.Add(MaxScheduleAhead))
+}
+
+// publishing again can not help with these, the post fails for good.
+// anything else, like a service being down, is tried next time.
+func IsFinalPublishError(err error) (reason string, final bool) {
+	switch ErrorCodeOf(err) {
+	case CodeForbidden, CodeNotFound, CodeInvalid:
+	default:
+		return "", false
+	}
+	if message := MessageOf(err); len(message) > 0 {
+		return message, true
+	}
+	return err.Error(), true
+}
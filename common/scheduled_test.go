@@ -0,0 +1,40 @@
+package common
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_Scheduled(t *testing.T) {
+	now := time.Now()
+	if CheckPublishAt(now, now) || CheckPublishAt(now.Add(-time.Minute), now) {
+		t.Fatal("past time is accepted")
+	}
+	if !CheckPublishAt(now.Add(time.Hour), now) {
+		t.Fatal("next hour is refused")
+	}
+	if CheckPublishAt(now.Add(MaxScheduleAhead+time.Hour), now) {
+		t.Fatal("too far ahead is accepted")
+	}
+
+	if reason, final := IsFinalPublishError(NewError(CodeForbidden, "thread is locked", nil)); !final || reason != "thread is locked" {
+		t.Fatalf("locked thread %q %v", reason, final)
+	}
+	if _, final := IsFinalPublishError(&StatusError{Code: CodeUnavailable, Message: "down"}); final {
+		t.Fatal("unavailable is final")
+	}
+	if _, final := IsFinalPublishError(errors.New("connection reset")); final {
+		t.Fatal("unknown error is final")
+	}
+
+	scheduled := ScheduledPost{}
+	if !scheduled.IsThread() || scheduled.PublishedURL() != "" {
+		t.Fatalf("new thread %+v", scheduled)
+	}
+	scheduled.PublishedUuId = "uuid"
+	want := "/thread/read?id=" + (&Thread{UuId: "uuid"}).PublicURL()
+	if scheduled.PublishedURL() != want {
+		t.Fatalf("url %s want %s", scheduled.PublishedURL(), want)
+	}
+}
@@ -0,0 +1,266 @@
+package common
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// postgres has no japanese parser, so text is cut here.
+// latin words stay words, runs of kana and kanji become
+// overlapping bigrams: 東京都 -> 東京 京都.
+// both sides are casted (::tsvector, ::tsquery), no db parser touches them.
+
+const (
+	// longer query is not a search any more
+	maxSearchQueryLength = 256
+	maxSearchTerms       = 16
+	// runes around the first hit
+	snippetBefore = 30
+	snippetLength = 120
+)
+
+// what to search, zero value filters are not used
+type SearchQuery struct {
+	Query string `json:"query"`
+	// author name of thread or post
+	Author string `json:"author"`
+	// thread uuid
+	Thread string    `json:"thread"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// one hit, thread topic or post body
+type SearchResult struct {
+	Kind       string    `xorm:"'kind'" json:"kind"`
+	ThreadUuId string    `xorm:"'thread_uu_id'" json:"thread_uu_id"`
+	Topic      string    `xorm:"'topic'" json:"topic"`
+	Number     uint      `xorm:"'number'" json:"number"`
+	Author     string    `xorm:"'author'" json:"author"`
+	Text       string    `xorm:"'text'" json:"-"`
+	Rank       float64   `xorm:"'rank'" json:"rank"`
+	Total      int64     `xorm:"'total'" json:"-"`
+	CreatedAt  time.Time `xorm:"'created_at'" json:"created_at"`
+	// escaped text with <mark>ed hits
+	Snippet string `xorm:"-" json:"snippet"`
+}
+
+const (
+	SearchKindThread = "thread"
+	SearchKindPost   = "post"
+)
+
+// one page of hits, best first
+type SearchPage struct {
+	Results []SearchResult `json:"results"`
+	Total   int64          `json:"total"`
+}
+
+func (result *SearchResult) When() string {
+	return result.CreatedAt.Format("2006/Jan/2 at 3:04pm")
+}
+
+func (result *SearchResult) ThreadURL() string {
+	return (&Thread{UuId: result.ThreadUuId}).PublicURL()
+}
+
+// snippet is escaped by threads service
+func (result *SearchResult) SnippetHTML() template.HTML {
+	return template.HTML(result.Snippet)
+}
+
+func isNGramRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r == 'ー' || r == '々'
+}
+
+// one word or one run of kana/kanji
+type searchToken struct {
+	text  string
+	ngram bool
+}
+
+// splits on anything not a letter or digit, lower cased
+func searchTokensInternal(text string) (tokens []searchToken) {
+	var current []rune
+	ngram := false
+	flush := func() {
+		if len(current) > 0 {
+			tokens = append(tokens, searchToken{text: string(current), ngram: ngram})
+		}
+		current = current[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isNGramRune(r):
+			if !ngram {
+				flush()
+				ngram = true
+			}
+			current = append(current, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if ngram {
+				flush()
+				ngram = false
+			}
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return
+}
+
+// one char run stays as it is
+func bigramsInternal(run string) []string {
+	runes := []rune(run)
+	if len(runes) < 2 {
+		return []string{run}
+	}
+	grams := make([]string, 0, len(runes)-1)
+	for i := 0; i+1 < len(runes); i++ {
+		grams = append(grams, string(runes[i:i+2]))
+	}
+	return grams
+}
+
+// tokens only have letters and digits, quoting is enough
+func quoteLexeme(lexeme string) string {
+	return "'" + strings.ReplaceAll(lexeme, "'", "''") + "'"
+}
+
+// text for `?::tsvector`, positions make phrase search work on bigrams
+func SearchDocument(text string) string {
+	var lexemes []string
+	position := 0
+	for _, token := range searchTokensInternal(text) {
+		grams := []string{token.text}
+		if token.ngram {
+			grams = bigramsInternal(token.text)
+		}
+		for _, gram := range grams {
+			position++
+			lexemes = append(lexemes, fmt.Sprintf("%s:%d", quoteLexeme(gram), position))
+		}
+	}
+	return strings.Join(lexemes, " ")
+}
+
+// text for `?::tsquery`, every term must hit.
+// words and single kanji match as prefix, runs as phrase of bigrams.
+// empty when nothing is searchable.
+func SearchTSQuery(query string) string {
+	var terms []string
+	for _, token := range searchTermsInternal(query) {
+		if !token.ngram {
+			terms = append(terms, quoteLexeme(token.text)+":*")
+			continue
+		}
+		grams := bigramsInternal(token.text)
+		if len(grams) == 1 {
+			terms = append(terms, quoteLexeme(grams[0])+":*")
+			continue
+		}
+		quoted := make([]string, 0, len(grams))
+		for _, gram := range grams {
+			quoted = append(quoted, quoteLexeme(gram))
+		}
+		terms = append(terms, "("+strings.Join(quoted, " <-> ")+")")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// words of the query as they are matched, also for highlight
+func SearchTerms(query string) (terms []string) {
+	for _, token := range searchTermsInternal(query) {
+		terms = append(terms, token.text)
+	}
+	return
+}
+
+// tokens of the query without duplicates
+func searchTermsInternal(query string) (terms []searchToken) {
+	if len(query) > maxSearchQueryLength {
+		query = query[:maxSearchQueryLength]
+	}
+	seen := make(map[string]bool)
+	for _, token := range searchTokensInternal(query) {
+		if seen[token.text] {
+			continue
+		}
+		seen[token.text] = true
+		terms = append(terms, token)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return
+}
+
+// a window of text around the first hit, escaped,
+// every hit in it wrapped by <mark>
+func Snippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	needles := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		needles = append(needles, []rune(term))
+	}
+	// longest needle starting at i
+	hitAt := func(i int) int {
+		longest := 0
+		for _, needle := range needles {
+			if len(needle) > longest && i+len(needle) <= len(lower) &&
+				string(lower[i:i+len(needle)]) == string(needle) {
+				longest = len(needle)
+			}
+		}
+		return longest
+	}
+
+	from := 0
+	for i := range lower {
+		if hitAt(i) > 0 {
+			from = i - snippetBefore
+			break
+		}
+	}
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetLength
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	var out strings.Builder
+	if from > 0 {
+		out.WriteString("…")
+	}
+	for i := from; i < to; {
+		if n := hitAt(i); n > 0 {
+			end := i + n
+			if end > to {
+				end = to
+			}
+			out.WriteString("<mark>")
+			out.WriteString(template.HTMLEscapeString(string(runes[i:end])))
+			out.WriteString("</mark>")
+			i = end
+			continue
+		}
+		out.WriteString(template.HTMLEscapeString(string(runes[i])))
+		i++
+	}
+	if to < len(runes) {
+		out.WriteString("…")
+	}
+	return out.String()
+}
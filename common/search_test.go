@@ -0,0 +1,58 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_SearchDocument(t *testing.T) {
+	cases := map[string]string{
+		"Hello, World!": "'hello':1 'world':2",
+		"東京都へ行く":        "'東京':1 '京都':2 '都へ':3 'へ行':4 '行く':5",
+		"Go言語":          "'go':1 '言語':2",
+		"猫":             "'猫':1",
+		"it's":          "'it':1 's':2",
+		"<>&":           "",
+	}
+	for text, want := range cases {
+		if got := SearchDocument(text); got != want {
+			t.Errorf("%q gave %q, want %q", text, got, want)
+		}
+	}
+}
+
+func Test_SearchTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"hello":       "'hello':*",
+		"Hello hello": "'hello':*",
+		"東京都":         "('東京' <-> '京都')",
+		"猫 go":        "'猫':* & 'go':*",
+		"' | !":       "",
+	}
+	for query, want := range cases {
+		if got := SearchTSQuery(query); got != want {
+			t.Errorf("%q gave %q, want %q", query, got, want)
+		}
+	}
+	if terms := SearchTerms(strings.Repeat("a ", 100)); len(terms) != 1 {
+		t.Errorf("terms %v", terms)
+	}
+}
+
+func Test_Snippet(t *testing.T) {
+	got := Snippet("<b>Go</b> is fun", []string{"go"})
+	want := "&lt;b&gt;<mark>Go</mark>&lt;/b&gt; is fun"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("あ", 100) + "東京" + strings.Repeat("い", 200)
+	got = Snippet(long, []string{"東京"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") ||
+		!strings.Contains(got, "<mark>東京</mark>") {
+		t.Errorf("got %q", got)
+	}
+	if n := len([]rune(got)); n > snippetLength+len("<mark></mark>")+2 {
+		t.Errorf("snippet too long %d", n)
+	}
+}
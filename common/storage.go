@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Storage keeps uploaded files by their content.
+// same bytes give the same key, so a file is stored once.
+// LocalStorage is on disk, an S3 compatible one can come later.
+type Storage interface {
+	Put(ctx context.Context, data []byte) (key string, err error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+var storageKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// hex sha-256, nothing else reaches the file system
+func IsValidStorageKey(key string) bool {
+	return storageKeyPattern.MatchString(key)
+}
+
+func StorageKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// files go to root/ab/cd/abcd..., so no directory gets too big
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+func (storage *LocalStorage) path(key string) string {
+	return filepath.Join(storage.root, key[0:2], key[2:4], key)
+}
+
+// written to a temp file and renamed, readers never see half a file
+func (storage *LocalStorage) Put(ctx context.Context, data []byte) (key string, err error) {
+	key = StorageKey(data)
+	path := storage.path(key)
+	if _, err = os.Stat(path); err == nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(temp.Name())
+	_, err = io.Copy(temp, bytes.NewReader(data))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(temp.Name(), path)
+	return
+}
+
+func (storage *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !IsValidStorageKey(key) {
+		return nil, NewError(CodeNotFound, "no such file", nil)
+	}
+	file, err := os.Open(storage.path(key))
+	if os.IsNotExist(err) {
+		return nil, NewError(CodeNotFound, "no such file", err)
+	}
+	return file, err
+}
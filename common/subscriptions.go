@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// posts quoted per thread, the rest is only counted
+	DigestPostsPerThread = 3
+	digestExcerptRunes   = 120
+)
+
+// one user watching one thread. posting subscribes too.
+type Subscription struct {
+	Id        uint      `xorm:"pk autoincr 'id'" json:"id"`
+	ThreadId  uint      `xorm:"not null 'thread_id'" json:"thread_id"`
+	UserId    uint      `xorm:"not null 'user_id'" json:"user_id"`
+	CreatedAt time.Time `xorm:"not null 'created_at'" json:"created_at"`
+	// posts up to here were in a digest already
+	DigestedAt time.Time `xorm:"not null 'digested_at'" json:"-"`
+}
+
+// new posts of one watched thread, oldest first
+type DigestThread struct {
+	Thread Thread
+	Posts  []Post
+	// all new ones, Posts has at most DigestPostsPerThread
+	NumNew int64
+}
+
+// plain text digest for one user, nil when there is nothing new
+func FormatDigest(to *User, baseURL string, threads []DigestThread) *Mail {
+	var total int64
+	var body strings.Builder
+	baseURL = strings.TrimRight(baseURL, "/")
+	fmt.Fprintf(&body, "Hi %s,\n\nthere are new posts in threads you watch.\n", to.Name)
+	for _, digest := range threads {
+		if digest.NumNew == 0 {
+			continue
+		}
+		total += digest.NumNew
+		fmt.Fprintf(&body, "\n== %s (%d new)\n", digest.Thread.Topic, digest.NumNew)
+		for _, post := range digest.Posts {
+			fmt.Fprintf(&body, "#%d %s: %s\n", post.Number, post.Contributor, digestExcerptInternal(post.Body))
+		}
+		if more := digest.NumNew - int64(len(digest.Posts)); more > 0 {
+			fmt.Fprintf(&body, "...and %d more\n", more)
+		}
+		fmt.Fprintf(&body, "%s/thread/read?id=%s\n", baseURL, digest.Thread.PublicURL())
+	}
+	if total == 0 {
+		return nil
+	}
+	fmt.Fprintf(&body, "\nStop watching a thread on its page, all of them are at %s/thread/watched\n", baseURL)
+	subject := "1 new post in watched threads"
+	if total > 1 {
+		subject = fmt.Sprintf("%d new posts in watched threads", total)
+	}
+	return &Mail{To: to.Email, Subject: subject, Body: body.String()}
+}
+
+// one line, markdown left as it is
+func digestExcerptInternal(body string) string {
+	runes := []rune(strings.Join(strings.Fields(body), " "))
+	if len(runes) <= digestExcerptRunes {
+		return string(runes)
+	}
+	return string(runes[:digestExcerptRunes]) + "…"
+}
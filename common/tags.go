@@ -0,0 +1,68 @@
+package common
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// a thread gets at most this many tags
+const MaxThreadTags = 5
+
+// after normalising, letters of any script, digits, - and _
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+// how many threads have the tag
+type TagCount struct {
+	Tag   string `xorm:"'tag'" json:"tag"`
+	Count int64  `xorm:"'count'" json:"count"`
+}
+
+// one row per tag of a thread
+type ThreadTag struct {
+	Id       uint   `xorm:"pk autoincr 'id'" json:"id"`
+	ThreadId uint   `xorm:"not null 'thread_id'" json:"thread_id"`
+	Tag      string `xorm:"not null 'tag'" json:"tag"`
+}
+
+// NFKC folds full width ｇｏ to go and half width ｶﾞ to ガ,
+// then case is folded and spaces become -.
+// a leading # is dropped, empty when it is not a tag.
+func NormalizeTag(tag string) string {
+	tag = norm.NFKC.String(tag)
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if !tagPattern.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+// normalised and without duplicates, order is kept.
+// ok is false when a tag is broken or there are too many.
+func NormalizeTags(tags []string) (normalized []string, ok bool) {
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if len(strings.TrimSpace(tag)) == 0 {
+			continue
+		}
+		tag = NormalizeTag(tag)
+		if len(tag) == 0 {
+			return nil, false
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized, len(normalized) <= MaxThreadTags
+}
+
+// form input, separated by commas or spaces
+func SplitTags(input string) []string {
+	return strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || r == '、' || r == '，' || r == ' ' || r == '　' || r == '\t' || r == '\n'
+	})
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_NormalizeTag(t *testing.T) {
+	cases := map[string]string{
+		"Go":                "go",
+		"Ｇｏ":                "go",
+		"ｶﾞｲﾄﾞ":             "ガイド",
+		"#Machine Learning": "machine-learning",
+		"  日本語  ":           "日本語",
+		"c++":               "",
+		"a/b":               "",
+		"":                  "",
+	}
+	for tag, want := range cases {
+		if got := NormalizeTag(tag); got != want {
+			t.Errorf("%q gave %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func Test_NormalizeTags(t *testing.T) {
+	tags, ok := NormalizeTags(SplitTags("Go, ｇｏ、golang  news"))
+	if !ok || !reflect.DeepEqual(tags, []string{"go", "golang", "news"}) {
+		t.Errorf("got %v %v", tags, ok)
+	}
+	if _, ok := NormalizeTags([]string{"a", "b", "c", "d", "e", "f"}); ok {
+		t.Error("too many tags passed")
+	}
+	if _, ok := NormalizeTags([]string{"ok", "not ok!"}); ok {
+		t.Error("broken tag passed")
+	}
+}
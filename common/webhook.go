@@ -0,0 +1,151 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// receivers check the signature over "<timestamp>.<body>"
+// with the secret shown when the webhook was created,
+// and refuse old timestamps against replays.
+const (
+	WebhookEventHeader     = "X-Keijiban-Event"
+	WebhookDeliveryHeader  = "X-Keijiban-Delivery"
+	WebhookTimestampHeader = "X-Keijiban-Timestamp"
+	WebhookSignatureHeader = "X-Keijiban-Signature"
+	webhookSignaturePrefix = "sha256="
+)
+
+const (
+	webhookTimeout     = time.Second * 10
+	webhookBaseDelay   = time.Second * 10
+	webhookMaxDelay    = time.Hour
+	MaxWebhookAttempts = 8
+	// receivers reply is only for the log
+	webhookMaxReplyBytes = 1024
+)
+
+var ErrPrivateAddress = errors.New("webhook to private address is not allowed")
+
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func VerifyWebhook(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// only absolute http(s) urls with a host
+func ValidateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return NewError(CodeInvalid, "broken url", err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Hostname()) == 0 {
+		return NewError(CodeInvalid, "url must be http or https with a host", nil)
+	}
+	if parsed.User != nil {
+		return NewError(CodeInvalid, "url must not contain credentials", nil)
+	}
+	return nil
+}
+
+// NewWebhookClient refuses to connect to loopback, private and link-local
+// addresses unless allowPrivate, so webhooks can not reach our own services.
+// checked on connect, after dns, so rebinding does not help.
+func NewWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// proxy would connect for us and skip the check
+	transport.Proxy = nil
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		// redirects are not followed, 3xx is a failure
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// SendWebhook posts one delivery. status is 0 if nothing came back.
+// anything but 2xx is an error.
+func SendWebhook(
+	ctx context.Context,
+	client *http.Client,
+	hook *Webhook,
+	delivery *WebhookDelivery,
+) (status int, err error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "KEIJIBAN-Webhook/1")
+	req.Header.Set(WebhookEventHeader, delivery.Topic)
+	req.Header.Set(WebhookDeliveryHeader, delivery.UuId)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, timestamp, body))
+	res, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	status = res.StatusCode
+	if status < 200 || status >= 300 {
+		reply, _ := io.ReadAll(io.LimitReader(res.Body, webhookMaxReplyBytes))
+		err = fmt.Errorf("receiver replied %s: %s", res.Status, string(reply))
+	}
+	return
+}
+
+// 10s, 20s, 40s... up to an hour
+func WebhookBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 20 {
+		return webhookMaxDelay
+	}
+	delay := webhookBaseDelay << (attempts - 1)
+	if delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay
+}
@@ -3,14 +3,17 @@ module learning-web-chatboard2
 go 1.18
 
 require (
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/gin v1.7.7
 	github.com/google/uuid v1.0.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/lib/pq v1.10.4
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/text v0.3.6
 	xorm.io/xorm v1.2.5
 )
 
 require (
-	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.13.0 // indirect
 	github.com/go-playground/universal-translator v0.17.0 // indirect
 	github.com/go-playground/validator/v10 v10.4.1 // indirect
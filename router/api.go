@@ -0,0 +1,545 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiPrefix         = "/api/v1"
+	apiDefaultPerPage = 20
+	apiMaxPerPage     = 100
+	bearerPrefix      = "Bearer "
+	tokenPtrLabel     = "token-ptr"
+)
+
+var apiRateLimiter = newRateLimiter()
+
+// api representations, kept apart from db models
+// so that api does not change with tables.
+// ids in api are always uuid.
+
+type apiThread struct {
+	Id         string    `json:"id"`
+	Topic      string    `json:"topic"`
+	Owner      string    `json:"owner"`
+	NumReplies uint      `json:"num_replies"`
+	Tags       []string  `json:"tags"`
+	LastUpdate time.Time `json:"last_update"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type apiPost struct {
+	Id          string                 `json:"id"`
+	ThreadId    string                 `json:"thread_id"`
+	Number      uint                   `json:"number"`
+	Body        string                 `json:"body"`
+	BodyHTML    string                 `json:"body_html"`
+	Attachments []attachmentView       `json:"attachments"`
+	Reactions   []common.ReactionCount `json:"reactions"`
+	Contributor string                 `json:"contributor"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+type apiPoll struct {
+	Question  string `json:"question"`
+	Multiple  bool   `json:"multiple"`
+	Anonymous bool   `json:"anonymous"`
+	// null when it never closes
+	ClosesAt *time.Time      `json:"closes_at"`
+	Closed   bool            `json:"closed"`
+	Voters   int64           `json:"voters"`
+	Options  []apiPollOption `json:"options"`
+	// option ids the caller chose
+	MyChoices []uint `json:"my_choices"`
+}
+
+type apiPollOption struct {
+	Id    uint   `json:"id"`
+	Label string `json:"label"`
+	Votes int64  `json:"votes"`
+	// empty for anonymous polls
+	VoterNames []string `json:"voter_names"`
+}
+
+type apiUser struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiPaging struct {
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+type apiThreadList struct {
+	Threads []apiThread `json:"threads"`
+	Paging  apiPaging   `json:"paging"`
+}
+
+type apiPostList struct {
+	Posts  []apiPost `json:"posts"`
+	Paging apiPaging `json:"paging"`
+}
+
+type apiNewThread struct {
+	Topic string `json:"topic"`
+	// board slug, default board when empty
+	Board string   `json:"board"`
+	Tags  []string `json:"tags"`
+}
+
+type apiNewPost struct {
+	Body string `json:"body"`
+}
+
+// apiRoute describes one endpoint.
+// routes are registered and openapi document is generated from these.
+// scope is what api tokens need, session cookie can do everything.
+type apiRoute struct {
+	method   string
+	path     string
+	summary  string
+	scope    string
+	auth     bool
+	paged    bool
+	request  interface{}
+	response interface{}
+	status   int
+	handler  gin.HandlerFunc
+}
+
+var apiRoutes = []apiRoute{
+	{
+		method:   http.MethodGet,
+		path:     "/threads",
+		summary:  "list threads, recently updated first",
+		scope:    common.TokenScopeRead,
+		paged:    true,
+		response: apiThreadList{},
+		status:   http.StatusOK,
+		handler:  apiThreadsGet,
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/threads",
+		summary:  "start a new thread",
+		scope:    common.TokenScopePost,
+		auth:     true,
+		request:  apiNewThread{},
+		response: apiThread{},
+		status:   http.StatusCreated,
+		handler:  apiThreadsPost,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/threads/:id",
+		summary:  "read a thread",
+		scope:    common.TokenScopeRead,
+		response: apiThread{},
+		status:   http.StatusOK,
+		handler:  apiThreadGet,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/threads/:id/posts",
+		summary:  "list posts in a thread, oldest first",
+		scope:    common.TokenScopeRead,
+		paged:    true,
+		response: apiPostList{},
+		status:   http.StatusOK,
+		handler:  apiPostsGet,
+	},
+	{
+		method:   http.MethodPost,
+		path:     "/threads/:id/posts",
+		summary:  "reply to a thread",
+		scope:    common.TokenScopePost,
+		auth:     true,
+		request:  apiNewPost{},
+		response: apiPost{},
+		status:   http.StatusCreated,
+		handler:  apiPostsPost,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/threads/:id/poll",
+		summary:  "read poll results of a thread",
+		scope:    common.TokenScopeRead,
+		response: apiPoll{},
+		status:   http.StatusOK,
+		handler:  apiPollGet,
+	},
+	{
+		method:   http.MethodGet,
+		path:     "/me",
+		summary:  "read the current user",
+		scope:    common.TokenScopeRead,
+		auth:     true,
+		response: apiUser{},
+		status:   http.StatusOK,
+		handler:  apiMeGet,
+	},
+}
+
+func setupAPIRoutes(engine *gin.Engine) {
+	apiRoute := engine.Group(apiPrefix)
+	apiRoute.Use(APIAuthMiddleware)
+	for _, route := range apiRoutes {
+		handlers := []gin.HandlerFunc{APIScopeMiddleware(route.scope)}
+		if route.auth {
+			handlers = append(handlers, APIRequireLoginMiddleware)
+		}
+		handlers = append(handlers, route.handler)
+		apiRoute.Handle(route.method, route.path, handlers...)
+	}
+	apiRoute.GET("/openapi.json", openAPIGet)
+}
+
+// session cookie or personal api token as bearer
+func APIAuthMiddleware(ctx *gin.Context) {
+	secret, hasToken := pickupBearerToken(ctx)
+	if !hasToken {
+		err := checkLoggedIn(ctx)
+		if err != nil && gin.IsDebugging() {
+			common.LogWarning(logger).Println(err.Error())
+		}
+		ctx.Set(loggedInLabel, err == nil)
+		ctx.Next()
+		return
+	}
+
+	// wrong token is always an error, not an anonymous access
+	token, err := usersClient.CheckToken(ctx.Request.Context(), secret)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		ctx.Abort()
+		return
+	}
+	ok, retryAfter := apiRateLimiter.allow(token.UuId, token.RateLimit, time.Now())
+	if !ok {
+		ctx.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		apiErrorInternal(
+			common.NewError(common.CodeRateLimited, "too many requests", nil),
+			ctx,
+		)
+		ctx.Abort()
+		return
+	}
+
+	// handlers see token owner same as logged in user
+	ctx.Set(sessionPtrLabel, &common.Session{
+		UserName: token.UserName,
+		UserId:   token.UserId,
+	})
+	ctx.Set(tokenPtrLabel, token)
+	ctx.Set(loggedInLabel, true)
+	ctx.Next()
+}
+
+func APIScopeMiddleware(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		val, ok := ctx.Get(tokenPtrLabel)
+		if !ok {
+			ctx.Next()
+			return
+		}
+		token, ok := val.(*common.APIToken)
+		if !ok || !token.Allows(scope) {
+			apiErrorInternal(
+				common.NewError(common.CodeForbidden, "token needs scope "+scope, nil),
+				ctx,
+			)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func APIRequireLoginMiddleware(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		apiErrorInternal(
+			common.NewError(common.CodeUnauthorized, "login required", nil),
+			ctx,
+		)
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+func pickupBearerToken(ctx *gin.Context) (token string, ok bool) {
+	auth := ctx.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(auth, bearerPrefix))
+	ok = len(token) > 0
+	return
+}
+
+func apiErrorInternal(err error, ctx *gin.Context) {
+	replyErr := common.AsError(err)
+	common.LogError(logger).Println(replyErr.Error())
+	ctx.JSON(replyErr.HTTPStatus(), &common.ErrorEnvelope{Error: replyErr})
+}
+
+// page starts from 1
+func apiPagingInternal(ctx *gin.Context) (paging apiPaging, offset int, err error) {
+	paging.Page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || paging.Page < 1 {
+		err = common.NewError(common.CodeInvalid, "page must be 1 or more", err)
+		return
+	}
+	paging.PerPage, err = strconv.Atoi(
+		ctx.DefaultQuery("per_page", strconv.Itoa(apiDefaultPerPage)),
+	)
+	if err != nil || paging.PerPage < 1 || paging.PerPage > apiMaxPerPage {
+		err = common.NewError(
+			common.CodeInvalid,
+			"per_page must be 1 to "+strconv.Itoa(apiMaxPerPage),
+			err,
+		)
+		return
+	}
+	offset = (paging.Page - 1) * paging.PerPage
+	return
+}
+
+func toAPIThread(thre *common.Thread) apiThread {
+	return apiThread{
+		Id:         thre.UuId,
+		Topic:      thre.Topic,
+		Owner:      thre.Owner,
+		NumReplies: thre.NumReplies,
+		Tags:       thre.Tags,
+		LastUpdate: thre.LastUpdate,
+		CreatedAt:  thre.CreatedAt,
+	}
+}
+
+func toAPIPost(post *common.Post, threUuId string) apiPost {
+	return apiPost{
+		Id:          post.UuId,
+		ThreadId:    threUuId,
+		Number:      post.Number,
+		Body:        post.Body,
+		BodyHTML:    string(post.BodyHTML()),
+		Attachments: toAttachmentViews(post.Attachments),
+		Reactions:   common.SortReactionCounts(post.Reactions),
+		Contributor: post.Contributor,
+		CreatedAt:   post.CreatedAt,
+	}
+}
+
+func apiThreadsGet(ctx *gin.Context) {
+	list, err := apiThreadsGetInternal(ctx)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, list)
+}
+
+func apiThreadsGetInternal(ctx *gin.Context) (list *apiThreadList, err error) {
+	paging, offset, err := apiPagingInternal(ctx)
+	if err != nil {
+		return
+	}
+	page, err := threadsClient.ListThreadsPage(ctx.Request.Context(), offset, paging.PerPage)
+	if err != nil {
+		return
+	}
+	paging.Total = page.Total
+	list = &apiThreadList{
+		Threads: make([]apiThread, 0, len(page.Threads)),
+		Paging:  paging,
+	}
+	for i := range page.Threads {
+		list.Threads = append(list.Threads, toAPIThread(&page.Threads[i]))
+	}
+	return
+}
+
+func apiThreadsPost(ctx *gin.Context) {
+	thre, err := apiThreadsPostInternal(ctx)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusCreated, thre)
+}
+
+func apiThreadsPostInternal(ctx *gin.Context) (thre *apiThread, err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	var newThre apiNewThread
+	err = ctx.ShouldBindJSON(&newThre)
+	if err != nil {
+		err = common.NewError(common.CodeBadRequest, "invalid body", err)
+		return
+	}
+	created, err := createThreadInternal(ctx, sess, &newThreadRequest{
+		Topic:     newThre.Topic,
+		BoardSlug: newThre.Board,
+		Tags:      newThre.Tags,
+	})
+	if err != nil {
+		return
+	}
+	converted := toAPIThread(created)
+	thre = &converted
+	return
+}
+
+func apiThreadGet(ctx *gin.Context) {
+	thre, err := threadsClient.ReadThread(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, toAPIThread(thre))
+}
+
+func apiPostsGet(ctx *gin.Context) {
+	list, err := apiPostsGetInternal(ctx)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, list)
+}
+
+func apiPostsGetInternal(ctx *gin.Context) (list *apiPostList, err error) {
+	paging, offset, err := apiPagingInternal(ctx)
+	if err != nil {
+		return
+	}
+	thre, err := threadsClient.ReadThread(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		return
+	}
+	page, err := threadsClient.ReadPostsPage(ctx.Request.Context(), thre, offset, paging.PerPage)
+	if err != nil {
+		return
+	}
+	paging.Total = page.Total
+	list = &apiPostList{
+		Posts:  make([]apiPost, 0, len(page.Posts)),
+		Paging: paging,
+	}
+	for i := range page.Posts {
+		list.Posts = append(list.Posts, toAPIPost(&page.Posts[i], thre.UuId))
+	}
+	return
+}
+
+func apiPostsPost(ctx *gin.Context) {
+	post, err := apiPostsPostInternal(ctx)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusCreated, post)
+}
+
+func apiPostsPostInternal(ctx *gin.Context) (post *apiPost, err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	var newPost apiNewPost
+	err = ctx.ShouldBindJSON(&newPost)
+	if err != nil {
+		err = common.NewError(common.CodeBadRequest, "invalid body", err)
+		return
+	}
+	threUuId := ctx.Param("id")
+	created, err := createReplyInternal(ctx, sess, &newReplyRequest{
+		ThreadUuId: threUuId,
+		Body:       newPost.Body,
+	})
+	if err != nil {
+		return
+	}
+	converted := toAPIPost(created, threUuId)
+	post = &converted
+	return
+}
+
+func toAPIPoll(poll *common.Poll) apiPoll {
+	converted := apiPoll{
+		Question:  poll.Question,
+		Multiple:  poll.Multiple,
+		Anonymous: poll.Anonymous,
+		Closed:    poll.IsClosed(time.Now()),
+		Voters:    poll.Voters,
+		Options:   make([]apiPollOption, 0, len(poll.Options)),
+		MyChoices: append([]uint{}, poll.MyChoices...),
+	}
+	if !poll.ClosesAt.IsZero() {
+		closesAt := poll.ClosesAt
+		converted.ClosesAt = &closesAt
+	}
+	for _, option := range poll.Options {
+		converted.Options = append(converted.Options, apiPollOption{
+			Id:         option.Id,
+			Label:      option.Label,
+			Votes:      option.Votes,
+			VoterNames: append([]string{}, option.VoterNames...),
+		})
+	}
+	return converted
+}
+
+func apiPollGet(ctx *gin.Context) {
+	thre, err := threadsClient.ReadThread(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	poll, err := threadsClient.ReadPoll(ctx.Request.Context(), pollQueryInternal(ctx, thre))
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, toAPIPoll(poll))
+}
+
+func apiMeGet(ctx *gin.Context) {
+	user, err := apiMeGetInternal(ctx)
+	if err != nil {
+		apiErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+func apiMeGetInternal(ctx *gin.Context) (user *apiUser, err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	found, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	user = &apiUser{
+		Id:        found.UuId,
+		Name:      found.Name,
+		Email:     found.Email,
+		CreatedAt: found.CreatedAt,
+	}
+	return
+}
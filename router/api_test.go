@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func doAPIRequest(
+	engine *gin.Engine,
+	method string,
+	path string,
+	token string,
+	body interface{},
+) *httptest.ResponseRecorder {
+	var bin []byte
+	if body != nil {
+		bin, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(bin))
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", bearerPrefix+token)
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_APIThreads(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, _ := newTestingUser(t, users, "TestingTaro")
+	postToken, _ := users.CreateToken(bg, &common.APIToken{
+		UserId:   user.Id,
+		UserName: user.Name,
+		Name:     "ci",
+		Scope:    common.TokenScopePost,
+	})
+	readToken, _ := users.CreateToken(bg, &common.APIToken{
+		UserId:   user.Id,
+		UserName: user.Name,
+		Name:     "reader",
+		Scope:    common.TokenScopeRead,
+	})
+	for i := 0; i < 3; i++ {
+		threads.CreateThread(bg, &common.Thread{
+			Topic: fmt.Sprintf("topic %d", i),
+			Owner: user.Name,
+		})
+	}
+	engine := gin.New()
+	setupAPIRoutes(engine)
+
+	rec := doAPIRequest(engine, http.MethodGet, "/api/v1/threads?page=2&per_page=2", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d %s", rec.Code, rec.Body.String())
+	}
+	var list apiThreadList
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Threads) != 1 || list.Paging.Total != 3 {
+		t.Fatalf("unexpected page %v", list)
+	}
+
+	rec = doAPIRequest(engine, http.MethodPost, "/api/v1/threads", "", apiNewThread{Topic: "anonymous"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous post status %d", rec.Code)
+	}
+
+	threId := list.Threads[0].Id
+	// a draft in the browser is not the api's to clear
+	thre, _ := threads.ReadThread(bg, threId)
+	threads.SaveDraft(bg, &common.Draft{UserId: user.Id, ThreadId: thre.Id, Body: "half written"})
+	rec = doAPIRequest(
+		engine,
+		http.MethodPost,
+		"/api/v1/threads/"+threId+"/posts",
+		readToken.Secret,
+		apiNewPost{Body: "build passed"},
+	)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("read token reply status %d", rec.Code)
+	}
+	rec = doAPIRequest(
+		engine,
+		http.MethodPost,
+		"/api/v1/threads/"+threId+"/posts",
+		postToken.Secret,
+		apiNewPost{Body: "build passed"},
+	)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("reply status %d %s", rec.Code, rec.Body.String())
+	}
+	updated, _ := threads.ReadThread(bg, threId)
+	if updated.NumReplies != 1 {
+		t.Fatalf("num replies %d", updated.NumReplies)
+	}
+	if len(threads.Drafts) != 1 {
+		t.Fatalf("api reply removed the draft %+v", threads.Drafts)
+	}
+
+	rec = doAPIRequest(engine, http.MethodGet, "/api/v1/threads/no-such-uuid", "", nil)
+	var envelope common.ErrorEnvelope
+	json.Unmarshal(rec.Body.Bytes(), &envelope)
+	if rec.Code != http.StatusNotFound || envelope.Error.Code != common.CodeNotFound {
+		t.Fatalf("status %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func Test_APITokenRateLimit(t *testing.T) {
+	users, _ := setupTesting(t)
+	bg := context.Background()
+	token, _ := users.CreateToken(bg, &common.APIToken{
+		UserId:    1,
+		UserName:  "TestingTaro",
+		Name:      "ci",
+		Scope:     common.TokenScopeRead,
+		RateLimit: 2,
+	})
+	engine := gin.New()
+	setupAPIRoutes(engine)
+
+	for i := 0; i < 2; i++ {
+		rec := doAPIRequest(engine, http.MethodGet, "/api/v1/threads", token.Secret, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+	rec := doAPIRequest(engine, http.MethodGet, "/api/v1/threads", token.Secret, nil)
+	if rec.Code != http.StatusTooManyRequests || len(rec.Header().Get("Retry-After")) == 0 {
+		t.Fatalf("status %d", rec.Code)
+	}
+
+	rec = doAPIRequest(engine, http.MethodGet, "/api/v1/threads", "kjb_wrong", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token status %d", rec.Code)
+	}
+}
+
+func Test_OpenAPI(t *testing.T) {
+	setupTesting(t)
+	engine := gin.New()
+	setupAPIRoutes(engine)
+
+	rec := doAPIRequest(engine, http.MethodGet, "/api/v1/openapi.json", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &doc)
+	for _, route := range apiRoutes {
+		methods, ok := doc.Paths[openAPIPath(route.path)]
+		if !ok {
+			t.Fatalf("%s is not documented", route.path)
+		}
+		if _, ok = methods[strings.ToLower(route.method)]; !ok {
+			t.Fatalf("%s %s is not documented", route.method, route.path)
+		}
+	}
+}
+
+func Test_AdminTokenScope(t *testing.T) {
+	users, _ := setupTesting(t)
+	member, memberSess := newTestingUser(t, users, "TestingTaro")
+	admin, adminSess := newTestingUser(t, users, "TestingHanako")
+	users.Users[admin.Email].Role = common.RoleAdmin
+
+	engine := newTestingEngine()
+	engine.GET("/user/settings", GenerateSessionStateMiddleware, settingsGet)
+	engine.POST("/user/tokens/create", createTokenPost)
+
+	settings := func(sess *common.Session) string {
+		req := httptest.NewRequest(http.MethodGet, "/user/settings", nil)
+		return serveTesting(t, engine, req, sess, nil).Body.String()
+	}
+	if strings.Contains(settings(memberSess), `value="admin"`) {
+		t.Fatal("admin scope is offered to a member")
+	}
+	if !strings.Contains(settings(adminSess), `value="admin"`) {
+		t.Fatal("admin scope is not offered to an admin")
+	}
+
+	form := url.Values{"name": {"ci"}, "scope": {common.TokenScopeAdmin}}
+	rec := postWithState(t, engine, memberSess, nil, "/user/tokens/create", form)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("member admin token status %d", rec.Code)
+	}
+	if tokens, _ := users.ReadTokens(context.Background(), member.Id); len(tokens) != 0 {
+		t.Fatalf("member got tokens %v", tokens)
+	}
+	rec = postWithState(t, engine, adminSess, nil, "/user/tokens/create", form)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin token status %d", rec.Code)
+	}
+}
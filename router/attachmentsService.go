@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"learning-web-chatboard2/common"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// every file at the limit plus the text fields
+	maxUploadBytes = common.MaxPostAttachments*common.MaxAttachmentSize + 1<<20
+	// bigger parts go to temp files
+	uploadMemory = 8 << 20
+)
+
+// types served inline, the rest are downloads
+var inlineFileTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// what browsers and api clients see of an attachment
+type attachmentView struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Url      string `json:"url"`
+	ThumbUrl string `json:"thumb_url,omitempty"`
+}
+
+func toAttachmentViews(attachments []common.Attachment) []attachmentView {
+	views := make([]attachmentView, 0, len(attachments))
+	for i := range attachments {
+		views = append(views, attachmentView{
+			Name:     attachments[i].Name,
+			MimeType: attachments[i].MimeType,
+			Size:     attachments[i].Size,
+			Url:      attachments[i].URL(),
+			ThumbUrl: attachments[i].ThumbURL(),
+		})
+	}
+	return views
+}
+
+// must run before the form is read, sessionStateCheckProcess reads it too.
+// gin would only log a broken form and the state would look missing.
+func parseUploadFormInternal(ctx *gin.Context) (err error) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxUploadBytes)
+	err = ctx.Request.ParseMultipartForm(uploadMemory)
+	if err == http.ErrNotMultipart {
+		// forms without files
+		err = ctx.Request.ParseForm()
+	}
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "upload is broken or too large", err)
+	}
+	return
+}
+
+// files of the "files" field are checked, cleaned and stored.
+// rows are made by threads service with the post.
+func storeAttachmentsInternal(ctx *gin.Context) (attachments []common.Attachment, err error) {
+	if ctx.Request.MultipartForm == nil {
+		return
+	}
+	var files [][]byte
+	var names []string
+	for _, header := range ctx.Request.MultipartForm.File["files"] {
+		// browsers send an empty part when nothing was picked
+		if header.Size == 0 && len(header.Filename) == 0 {
+			continue
+		}
+		if header.Size > common.MaxAttachmentSize {
+			err = common.NewError(common.CodeInvalid, "file is too large", nil)
+			return
+		}
+		var data []byte
+		data, err = readUploadInternal(header)
+		if err != nil {
+			return
+		}
+		files = append(files, data)
+		names = append(names, header.Filename)
+	}
+	if len(files) > common.MaxPostAttachments {
+		err = common.NewError(common.CodeInvalid, "too many attachments", nil)
+		return
+	}
+	for i, data := range files {
+		var processed *common.ProcessedUpload
+		processed, err = common.ProcessUpload(names[i], data)
+		if err != nil {
+			return
+		}
+		attachment := processed.Attachment
+		attachment.Hash, err = fileStorage.Put(ctx.Request.Context(), processed.Data)
+		if err != nil {
+			return
+		}
+		if processed.Thumbnail != nil {
+			attachment.ThumbHash, err = fileStorage.Put(ctx.Request.Context(), processed.Thumbnail)
+			if err != nil {
+				return
+			}
+		}
+		attachments = append(attachments, attachment)
+	}
+	return
+}
+
+func readUploadInternal(header *multipart.FileHeader) (data []byte, err error) {
+	file, err := header.Open()
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, common.MaxAttachmentSize+1))
+}
+
+// keys are content hashes, so a file never changes and can be cached for good.
+// type is sniffed again, storage does not keep it.
+// ?name= is only for the download name.
+func fileGet(ctx *gin.Context) {
+	file, err := fileStorage.Open(ctx.Request.Context(), ctx.Param("key"))
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read file")
+		return
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		handleErrorInternal(err, ctx, "failed to read file")
+		return
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	disposition := "attachment"
+	if inlineFileTypes[contentType] {
+		disposition = "inline"
+	} else if contentType != "application/pdf" && contentType != "text/plain; charset=utf-8" {
+		contentType = "application/octet-stream"
+	}
+	name := common.SanitizeFileName(ctx.DefaultQuery("name", ctx.Param("key")))
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", contentType)
+	if formatted := mime.FormatMediaType(disposition, map[string]string{"filename": name}); len(formatted) > 0 {
+		disposition = formatted
+	}
+	header.Set("Content-Disposition", disposition)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	ctx.Status(http.StatusOK)
+	io.Copy(ctx.Writer, io.MultiReader(bytes.NewReader(head), file))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"learning-web-chatboard2/common"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_UploadAttachments(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "cat pictures",
+		Owner:  user.Name,
+		UserId: user.Id,
+	})
+	vis, _ := users.CreateVisit(bg)
+	vis.ThreadId = thre.Id
+	vis.ThreadUuId = thre.UuId
+	users.UpdateVisit(bg, vis)
+
+	engine := newTestingEngine()
+	engine.POST("/thread/post", newReplyPost)
+	engine.GET("/files/:key", fileGet)
+	upload := func(name string, data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("state", newTestingState(t, sess))
+		part, _ := writer.CreateFormFile("files", name)
+		part.Write(data)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/thread/post", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return serveTesting(t, engine, req, sess, vis)
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 400, 300)))
+	// a post of only a picture
+	if rec := upload("cat.png", img.Bytes()); rec.Code != http.StatusFound {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if len(threads.Posts) != 1 || len(threads.Posts[0].Attachments) != 1 {
+		t.Fatalf("post not created %v", threads.Posts)
+	}
+	attachment := threads.Posts[0].Attachments[0]
+	if attachment.Name != "cat.png" || attachment.MimeType != "image/png" ||
+		!common.IsValidStorageKey(attachment.Hash) || !common.IsValidStorageKey(attachment.ThumbHash) {
+		t.Fatalf("attachment %+v", attachment)
+	}
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, attachment.ThumbURL(), nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" ||
+		rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatalf("thumbnail status %d headers %v", rec.Code, rec.Header())
+	}
+	thumb, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if size := thumb.Bounds().Size(); size.X != 200 || size.Y != 150 {
+		t.Fatalf("thumbnail %v", size)
+	}
+
+	// browser would run it if it was served as html
+	if rec := upload("evil.html", []byte("<html><script>alert(1)</script></html>")); rec.Code == http.StatusFound {
+		t.Fatal("html was accepted")
+	}
+	if len(threads.Posts) != 1 {
+		t.Fatalf("posts %d", len(threads.Posts))
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/"+strings.Repeat("0", 64), nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing file status %d", rec.Code)
+	}
+}
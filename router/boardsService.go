@@ -0,0 +1,199 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const boardPageSize = 20
+
+// threads of one board, newest update first
+func boardGet(ctx *gin.Context) {
+	board, page, threads, err := boardGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read board")
+		return
+	}
+	loggedin := confirmLoggedIn(ctx)
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
+	data := gin.H{
+		"navbar":   navbar,
+		"board":    board,
+		"threads":  threads.Threads,
+		"total":    threads.Total,
+		"loggedin": loggedin,
+		"unread":   unreadCountsInternal(ctx, threads.Threads),
+	}
+	if page > 1 {
+		data["prevPage"] = page - 1
+	}
+	if int64(page*boardPageSize) < threads.Total {
+		data["nextPage"] = page + 1
+	}
+	ctx.HTML(http.StatusOK, "board.html", data)
+}
+
+func boardGetInternal(ctx *gin.Context) (
+	board *common.Board,
+	page int,
+	threads *common.ThreadPage,
+	err error,
+) {
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	board, err = threadsClient.ReadBoard(ctx.Request.Context(), &common.Board{Slug: ctx.Query("slug")})
+	if err != nil {
+		return
+	}
+	threads, err = threadsClient.ListBoardThreadsPage(
+		ctx.Request.Context(),
+		board,
+		(page-1)*boardPageSize,
+		boardPageSize,
+	)
+	return
+}
+
+// boards where the user can start a thread.
+// user is taken as a member when users service can not tell,
+// threads service checks again anyway.
+func threadBoardsInternal(ctx *gin.Context, sess *common.Session) (boards []common.Board, err error) {
+	all, err := threadsClient.ListBoards(ctx.Request.Context())
+	if err != nil {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if common.IsUnavailable(err) {
+		user, err = &common.User{Role: common.RoleMember}, nil
+	} else if err != nil {
+		return
+	}
+	for _, board := range all {
+		if user.HasRole(board.ThreadRole) {
+			boards = append(boards, board)
+		}
+	}
+	return
+}
+
+func boardsAdminGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	boards, err := boardsAdminGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read boards")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"boards.html",
+		gin.H{
+			"navbar": navbar,
+			"boards": boards,
+			"state":  getStateFromCTX(ctx),
+			"roles":  []string{"", common.RoleMember, common.RoleModerator, common.RoleAdmin},
+		},
+	)
+}
+
+func boardsAdminGetInternal(ctx *gin.Context) (boards []common.Board, err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if !user.IsAdmin() {
+		err = common.NewError(common.CodeForbidden, "boards are managed by admins", nil)
+		return
+	}
+	boards, err = threadsClient.ListBoards(ctx.Request.Context())
+	return
+}
+
+// consumes the form state, role is read fresh every time
+func readAdminInternal(ctx *gin.Context) (admin *common.User, err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	admin, err = usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if !admin.IsAdmin() {
+		err = common.NewError(common.CodeForbidden, "not an admin", nil)
+	}
+	return
+}
+
+func boardFromFormInternal(ctx *gin.Context) *common.Board {
+	sortOrder, _ := strconv.Atoi(ctx.PostForm("sort_order"))
+	return &common.Board{
+		Slug:        ctx.PostForm("slug"),
+		Title:       ctx.PostForm("title"),
+		Description: ctx.PostForm("description"),
+		SortOrder:   sortOrder,
+		ThreadRole:  ctx.PostForm("thread_role"),
+		PostRole:    ctx.PostForm("post_role"),
+	}
+}
+
+func createBoardPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	_, err := readAdminInternal(ctx)
+	if err == nil {
+		_, err = threadsClient.CreateBoard(ctx.Request.Context(), boardFromFormInternal(ctx))
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to create board")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/board/admin")
+}
+
+func updateBoardPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	_, err := readAdminInternal(ctx)
+	if err == nil {
+		_, err = threadsClient.UpdateBoard(ctx.Request.Context(), boardFromFormInternal(ctx))
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to update board")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/board/admin")
+}
+
+func deleteBoardPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	_, err := readAdminInternal(ctx)
+	if err == nil {
+		err = threadsClient.DeleteBoard(ctx.Request.Context(), &common.Board{Slug: ctx.PostForm("slug")})
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to delete board")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/board/admin")
+}
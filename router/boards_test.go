@@ -0,0 +1,74 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_Boards(t *testing.T) {
+	users, _ := setupTesting(t)
+	member, memberSess := newTestingUser(t, users, "TestingTaro")
+	admin, adminSess := newTestingUser(t, users, "TestingHanako")
+	users.Users[admin.Email].Role = common.RoleAdmin
+
+	engine := newTestingEngine()
+	engine.GET("/board", boardGet)
+	engine.POST("/board/create", createBoardPost)
+	engine.POST("/board/delete", deleteBoardPost)
+	newThread := func(user *common.User, board string) (*common.Thread, error) {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		sess := &common.Session{UserId: user.Id, UserName: user.Name}
+		return createThreadInternal(ctx, sess, &newThreadRequest{Topic: "hello", BoardSlug: board})
+	}
+
+	news := url.Values{
+		"slug":        {"news"},
+		"title":       {"News"},
+		"thread_role": {common.RoleAdmin},
+	}
+	if rec := postWithState(t, engine, memberSess, nil, "/board/create", news); rec.Code != http.StatusForbidden {
+		t.Fatalf("member created board, status %d", rec.Code)
+	}
+	if rec := postWithState(t, engine, adminSess, nil, "/board/create", news); rec.Code != http.StatusFound {
+		t.Fatalf("create status %d", rec.Code)
+	}
+
+	if _, err := newThread(member, "news"); common.ErrorCodeOf(err) != common.CodeForbidden {
+		t.Fatalf("member started thread in admin board, %v", err)
+	}
+	thre, err := newThread(admin, "news")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	general, err := newThread(member, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if general.BoardId == thre.BoardId {
+		t.Fatal("no board went to default board")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/board?slug=news", nil)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), "Read more") != 1 {
+		t.Fatalf("board page %d %s", rec.Code, rec.Body.String())
+	}
+
+	// threads are never dropped with their board
+	del := url.Values{"slug": {"news"}}
+	if rec := postWithState(t, engine, adminSess, nil, "/board/delete", del); rec.Code != http.StatusConflict {
+		t.Fatalf("deleted board with threads, status %d", rec.Code)
+	}
+	del.Set("slug", common.DefaultBoardSlug)
+	if rec := postWithState(t, engine, adminSess, nil, "/board/delete", del); rec.Code != http.StatusForbidden {
+		t.Fatalf("deleted default board, status %d", rec.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const bookmarksPageSize = 20
+
+// posts the user kept, newest first. notes are only shown here.
+func bookmarksGet(ctx *gin.Context) {
+	loggedin := confirmLoggedIn(ctx)
+	if !loggedin {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	page, bookmarks, err := bookmarksGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read bookmarks")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
+	data := gin.H{
+		"navbar":    navbar,
+		"state":     getStateFromCTX(ctx),
+		"bookmarks": bookmarks.Bookmarks,
+		"total":     bookmarks.Total,
+	}
+	if page > 1 {
+		data["prevPage"] = page - 1
+	}
+	if int64(page*bookmarksPageSize) < bookmarks.Total {
+		data["nextPage"] = page + 1
+	}
+	ctx.HTML(http.StatusOK, "bookmarks.html", data)
+}
+
+func bookmarksGetInternal(ctx *gin.Context) (page int, bookmarks *common.BookmarkPage, err error) {
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	bookmarks, err = threadsClient.ListBookmarksPage(
+		ctx.Request.Context(),
+		sess.UserId,
+		(page-1)*bookmarksPageSize,
+		bookmarksPageSize,
+	)
+	return
+}
+
+// form has state, post, note and thread or back to return to.
+// keeping a kept post again saves the note.
+func bookmarkPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := bookmarkPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to bookmark")
+		return
+	}
+	redirectAfterBookmarkInternal(ctx)
+}
+
+func bookmarkPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	_, err = threadsClient.CreateBookmark(ctx.Request.Context(), &common.Bookmark{
+		UserId:   sess.UserId,
+		PostUuId: ctx.PostForm("post"),
+		Note:     ctx.PostForm("note"),
+	})
+	return
+}
+
+// form has state, id and thread or back
+func unbookmarkPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := unbookmarkPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to remove bookmark")
+		return
+	}
+	redirectAfterBookmarkInternal(ctx)
+}
+
+func unbookmarkPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseUint(ctx.PostForm("id"), 10, 32)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "invalid bookmark", err)
+		return
+	}
+	err = threadsClient.DeleteBookmark(ctx.Request.Context(), &common.Bookmark{
+		Id:     uint(id),
+		UserId: sess.UserId,
+	})
+	return
+}
+
+func redirectAfterBookmarkInternal(ctx *gin.Context) {
+	if ctx.PostForm("back") == "bookmarks" {
+		ctx.Redirect(http.StatusFound, "/bookmarks")
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+// post id -> bookmark id of what the viewer kept in the thread.
+// nil when threads service can not tell, buttons show as not kept.
+func myBookmarksInternal(ctx *gin.Context, thre *common.Thread, sess *common.Session) map[uint]uint {
+	bookmarks, err := threadsClient.ReadUserBookmarks(ctx.Request.Context(), &common.BookmarkQuery{
+		UserId:   sess.UserId,
+		ThreadId: thre.Id,
+	})
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return nil
+	}
+	kept := make(map[uint]uint)
+	for _, bookmark := range bookmarks {
+		kept[bookmark.PostId] = bookmark.Id
+	}
+	return kept
+}
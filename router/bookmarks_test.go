@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_Bookmarks(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	_, sess := newTestingUser(t, users, "TestingTaro")
+	other, otherSess := newTestingUser(t, users, "TestingHanako")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  other.Name,
+		UserId: other.Id,
+	})
+	kept, _ := threads.CreatePost(bg, &common.Post{
+		Body:        "ramen",
+		Contributor: other.Name,
+		UserId:      other.Id,
+		ThreadId:    thre.Id,
+	})
+
+	engine := newTestingEngine()
+	engine.GET("/thread/read", GenerateSessionStateMiddleware, threadGet)
+	engine.GET("/bookmarks", GenerateSessionStateMiddleware, bookmarksGet)
+	engine.POST("/bookmarks/create", bookmarkPost)
+	engine.POST("/bookmarks/delete", unbookmarkPost)
+	get := func(path string, sess *common.Session) string {
+		rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	rec := postWithState(t, engine, sess, nil, "/bookmarks/create", url.Values{
+		"thread": {thre.PublicURL()},
+		"post":   {kept.UuId},
+		"note":   {"the good ramen place"},
+	})
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/thread/read?id=") {
+		t.Fatalf("status %d location %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if html := get("/thread/read?id="+thre.PublicURL(), sess); !strings.Contains(html, ">Bookmarked</button>") {
+		t.Fatal("kept post is not marked")
+	}
+	html := get("/bookmarks", sess)
+	if !strings.Contains(html, "the good ramen place") ||
+		!strings.Contains(html, fmt.Sprintf(`href="/thread/read?id=%s#p%d"`, thre.PublicURL(), kept.Number)) {
+		t.Fatal("bookmark is not listed with its link")
+	}
+
+	// notes and marks are for the keeper only
+	html = get("/thread/read?id="+thre.PublicURL(), otherSess)
+	if strings.Contains(html, "Bookmarked") || strings.Contains(html, "the good ramen place") {
+		t.Fatal("bookmark is shown to others")
+	}
+	if html := get("/bookmarks", otherSess); strings.Contains(html, "ramen") {
+		t.Fatal("others see the bookmark")
+	}
+
+	// keeping again edits the note
+	postWithState(t, engine, sess, nil, "/bookmarks/create", url.Values{"post": {kept.UuId}, "note": {"closed on mondays"}, "back": {"bookmarks"}})
+	if len(threads.Bookmarks) != 1 || threads.Bookmarks[0].Note != "closed on mondays" {
+		t.Fatalf("bookmarks %+v", threads.Bookmarks)
+	}
+	rec = postWithState(t, engine, sess, nil, "/bookmarks/delete", url.Values{"id": {fmt.Sprint(threads.Bookmarks[0].Id)}, "back": {"bookmarks"}})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/bookmarks" {
+		t.Fatalf("status %d location %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(threads.Bookmarks) != 0 {
+		t.Fatalf("left %+v", threads.Bookmarks)
+	}
+}
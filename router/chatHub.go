@@ -0,0 +1,130 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// what goes over the socket to browser.
+// Type is "message" or "error".
+type chatFrame struct {
+	Type    string           `json:"type"`
+	Message *chatMessageView `json:"message,omitempty"`
+	Error   *common.Error    `json:"error,omitempty"`
+}
+
+type chatMessageView struct {
+	Id          uint   `json:"id"`
+	Body        string `json:"body"`
+	Contributor string `json:"contributor"`
+	When        string `json:"when"`
+}
+
+// what browser sends
+type chatIncoming struct {
+	Body string `json:"body"`
+}
+
+func newMessageFrame(msg *common.ChatMessage) *chatFrame {
+	return &chatFrame{
+		Type: "message",
+		Message: &chatMessageView{
+			Id:          msg.Id,
+			Body:        msg.Body,
+			Contributor: msg.Contributor,
+			When:        msg.When(),
+		},
+	}
+}
+
+func newErrorFrame(err error) *chatFrame {
+	return &chatFrame{
+		Type:  "error",
+		Error: common.AsError(err),
+	}
+}
+
+// one socket in a room
+type chatClient struct {
+	conn   *websocket.Conn
+	sess   *common.Session
+	roomId uint
+	send   chan *chatFrame
+}
+
+// chatHub knows who is in which room and fans messages out.
+// sending never blocks, clients too slow to keep up are dropped.
+type chatHub struct {
+	mutex sync.Mutex
+	rooms map[uint]map[*chatClient]struct{}
+}
+
+func newChatHub() *chatHub {
+	return &chatHub{
+		rooms: make(map[uint]map[*chatClient]struct{}),
+	}
+}
+
+func (h *chatHub) join(client *chatClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	clients, ok := h.rooms[client.roomId]
+	if !ok {
+		clients = make(map[*chatClient]struct{})
+		h.rooms[client.roomId] = clients
+	}
+	clients[client] = struct{}{}
+}
+
+// closes send of the client, safe to call twice
+func (h *chatHub) leave(client *chatClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.removeInternal(client)
+}
+
+func (h *chatHub) removeInternal(client *chatClient) {
+	clients := h.rooms[client.roomId]
+	if _, ok := clients[client]; !ok {
+		return
+	}
+	delete(clients, client)
+	close(client.send)
+	if len(clients) == 0 {
+		delete(h.rooms, client.roomId)
+	}
+}
+
+func (h *chatHub) broadcast(roomId uint, frame *chatFrame) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for client := range h.rooms[roomId] {
+		select {
+		case client.send <- frame:
+		default:
+			h.removeInternal(client)
+		}
+	}
+}
+
+// only to the client, e.g. errors for what it sent
+func (h *chatHub) reply(client *chatClient, frame *chatFrame) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.rooms[client.roomId][client]; !ok {
+		return
+	}
+	select {
+	case client.send <- frame:
+	default:
+		h.removeInternal(client)
+	}
+}
+
+func (h *chatHub) count(roomId uint) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.rooms[roomId])
+}
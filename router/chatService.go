@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	chatMaxMessageRunes   = 500
+	chatMessagesPerMinute = 20
+	chatBacklog           = 50
+	chatSendBuffer        = 32
+	// a rune is up to 4 bytes, rest is for json around body
+	chatReadLimit  = chatMaxMessageRunes*4 + 256
+	chatWriteWait  = time.Second * 10
+	chatPongWait   = time.Second * 60
+	chatPingPeriod = chatPongWait * 9 / 10
+)
+
+// default CheckOrigin refuses other origins, sockets carry session cookie
+var chatUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+var chatHubs = newChatHub()
+var chatRateLimiter = newRateLimiter()
+
+func chatGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	rooms, err := threadsClient.ListRooms(ctx.Request.Context())
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read rooms")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"chat.html",
+		gin.H{
+			"navbar": navbar,
+			"rooms":  rooms,
+			"state":  getStateFromCTX(ctx),
+		},
+	)
+}
+
+func chatCreatePost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	room, err := chatCreatePostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to create room")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/chat/room?name="+url.QueryEscape(room.Name))
+}
+
+func chatCreatePostInternal(ctx *gin.Context) (room *common.ChatRoom, err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	room, err = threadsClient.CreateRoom(ctx.Request.Context(), &common.ChatRoom{
+		Name:   strings.ToLower(strings.TrimSpace(ctx.PostForm("name"))),
+		Owner:  sess.UserName,
+		UserId: sess.UserId,
+	})
+	return
+}
+
+func chatRoomGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	room, err := threadsClient.ReadRoom(ctx.Request.Context(), ctx.Query("name"))
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to open room")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"chatroom.html",
+		gin.H{
+			"navbar":   navbar,
+			"room":     room,
+			"maxRunes": chatMaxMessageRunes,
+		},
+	)
+}
+
+// upgrades to websocket and stays in the room until either side closes.
+// backlog is sent first, then live messages.
+func chatSocketGet(ctx *gin.Context) {
+	client, room, err := chatJoinInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to join room")
+		return
+	}
+	conn, err := chatUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		// upgrader already replied
+		common.LogWarning(logger).Println(err.Error())
+		return
+	}
+	defer conn.Close()
+	client.conn = conn
+
+	// join before reading backlog not to miss anything,
+	// writer skips what backlog already had
+	chatHubs.join(client)
+	defer chatHubs.leave(client)
+	backlog, err := threadsClient.ReadMessages(ctx.Request.Context(), room, chatBacklog)
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+		conn.WriteJSON(newErrorFrame(err))
+		return
+	}
+	var lastId uint
+	for i := range backlog {
+		conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+		err = conn.WriteJSON(newMessageFrame(&backlog[i]))
+		if err != nil {
+			return
+		}
+		lastId = backlog[i].Id
+	}
+
+	done := make(chan struct{})
+	go chatWriteLoop(client, lastId, done)
+	chatReadLoop(ctx, client)
+	chatHubs.leave(client)
+	<-done
+}
+
+func chatJoinInternal(ctx *gin.Context) (client *chatClient, room *common.ChatRoom, err error) {
+	if !confirmLoggedIn(ctx) {
+		err = common.NewError(common.CodeUnauthorized, "not logged in", nil)
+		return
+	}
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	room, err = threadsClient.ReadRoom(ctx.Request.Context(), ctx.Query("name"))
+	if err != nil {
+		return
+	}
+	client = &chatClient{
+		sess:   sess,
+		roomId: room.Id,
+		send:   make(chan *chatFrame, chatSendBuffer),
+	}
+	return
+}
+
+func chatReadLoop(ctx *gin.Context, client *chatClient) {
+	conn := client.conn
+	// too big ones close the socket with 1009
+	conn.SetReadLimit(chatReadLimit)
+	conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(chatPongWait))
+	})
+	for {
+		var in chatIncoming
+		err := conn.ReadJSON(&in)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(
+				err,
+				websocket.CloseNormalClosure,
+				websocket.CloseGoingAway,
+			) {
+				common.LogWarning(logger).Println(err.Error())
+			}
+			return
+		}
+		msg, err := chatSendInternal(ctx, client, in.Body)
+		if err != nil {
+			if common.ErrorCodeOf(err) == common.CodeInternal {
+				common.LogError(logger).Println(err.Error())
+			}
+			chatHubs.reply(client, newErrorFrame(err))
+			continue
+		}
+		chatHubs.broadcast(client.roomId, newMessageFrame(msg))
+	}
+}
+
+func chatSendInternal(
+	ctx *gin.Context,
+	client *chatClient,
+	body string,
+) (msg *common.ChatMessage, err error) {
+	body = strings.TrimSpace(body)
+	if len(body) == 0 {
+		err = common.NewError(common.CodeInvalid, "message is empty", nil)
+		return
+	}
+	if len([]rune(body)) > chatMaxMessageRunes {
+		err = common.NewError(
+			common.CodeInvalid,
+			fmt.Sprintf("message is longer than %d characters", chatMaxMessageRunes),
+			nil,
+		)
+		return
+	}
+	key := "chat:" + strconv.FormatUint(uint64(client.sess.UserId), 10)
+	ok, retryAfter := chatRateLimiter.allow(key, chatMessagesPerMinute, time.Now())
+	if !ok {
+		err = common.NewError(
+			common.CodeRateLimited,
+			fmt.Sprintf("too many messages, wait %d seconds", int(retryAfter.Seconds())+1),
+			nil,
+		)
+		return
+	}
+	err = checkNotBannedInternal(ctx, client.sess)
+	if err != nil {
+		return
+	}
+	msg, err = threadsClient.CreateMessage(ctx.Request.Context(), &common.ChatMessage{
+		RoomId:      client.roomId,
+		Body:        body,
+		Contributor: client.sess.UserName,
+		UserId:      client.sess.UserId,
+	})
+	return
+}
+
+// the only goroutine writing to the socket after backlog
+func chatWriteLoop(client *chatClient, skipUntil uint, done chan<- struct{}) {
+	defer close(done)
+	conn := client.conn
+	ping := time.NewTicker(chatPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case frame, ok := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+			// left the room or dropped as too slow
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.Close()
+				return
+			}
+			if frame.Message != nil && frame.Message.Id <= skipUntil {
+				continue
+			}
+			if err := conn.WriteJSON(frame); err != nil {
+				// reader notices closed socket and leaves
+				conn.Close()
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialChat(t *testing.T, server *httptest.Server, cookie *http.Cookie, room string) *websocket.Conn {
+	header := http.Header{}
+	header.Set("Cookie", cookie.String())
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/chat/socket?name=" + room
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	return conn
+}
+
+func readChatFrame(t *testing.T, conn *websocket.Conn) *chatFrame {
+	var frame chatFrame
+	err := conn.ReadJSON(&frame)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return &frame
+}
+
+func Test_ChatRoom(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	taro, taroSess := newTestingUser(t, users, "TestingTaro")
+	_, hanakoSess := newTestingUser(t, users, "TestingHanako")
+	room, err := threads.CreateRoom(bg, &common.ChatRoom{Name: "lobby", Owner: taro.Name})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	threads.CreateMessage(bg, &common.ChatMessage{
+		RoomId:      room.Id,
+		Body:        "earlier",
+		Contributor: taro.Name,
+	})
+
+	engine := newTestingEngine()
+	engine.GET("/chat/socket", chatSocketGet)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	taroConn := dialChat(t, server, makeTestingCookie(t, storeSessionCookie, taroSess.UuId), "lobby")
+	defer taroConn.Close()
+	if frame := readChatFrame(t, taroConn); frame.Message == nil || frame.Message.Body != "earlier" {
+		t.Fatalf("backlog is not sent %v", frame)
+	}
+	hanakoConn := dialChat(t, server, makeTestingCookie(t, storeSessionCookie, hanakoSess.UuId), "lobby")
+	defer hanakoConn.Close()
+	readChatFrame(t, hanakoConn)
+	for chatHubs.count(room.Id) != 2 {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	err = taroConn.WriteJSON(&chatIncoming{Body: "hello"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, conn := range []*websocket.Conn{taroConn, hanakoConn} {
+		frame := readChatFrame(t, conn)
+		if frame.Type != "message" || frame.Message.Body != "hello" ||
+			frame.Message.Contributor != taro.Name {
+			t.Fatalf("wrong frame %v", frame)
+		}
+	}
+	if len(threads.Messages) != 2 {
+		t.Fatalf("message is not stored %v", threads.Messages)
+	}
+
+	// too long for the form is an error frame, socket stays
+	hanakoConn.WriteJSON(&chatIncoming{Body: strings.Repeat("a", chatMaxMessageRunes+1)})
+	if frame := readChatFrame(t, hanakoConn); frame.Error == nil || frame.Error.Code != common.CodeInvalid {
+		t.Fatalf("long message is accepted %v", frame)
+	}
+	// over the read limit closes socket
+	hanakoConn.WriteMessage(websocket.TextMessage, make([]byte, chatReadLimit+1))
+	_, _, err = hanakoConn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("socket is not closed for big message %v", err)
+	}
+}
+
+func Test_ChatRateLimit(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingJiro")
+	threads.CreateRoom(bg, &common.ChatRoom{Name: "flood", Owner: user.Name})
+
+	engine := newTestingEngine()
+	engine.GET("/chat/socket", chatSocketGet)
+	server := httptest.NewServer(engine)
+	defer server.Close()
+	conn := dialChat(t, server, makeTestingCookie(t, storeSessionCookie, sess.UuId), "flood")
+	defer conn.Close()
+
+	for i := 0; i < chatMessagesPerMinute; i++ {
+		conn.WriteJSON(&chatIncoming{Body: "spam"})
+		if frame := readChatFrame(t, conn); frame.Type != "message" {
+			t.Fatalf("message %d is refused %v", i, frame.Error)
+		}
+	}
+	conn.WriteJSON(&chatIncoming{Body: "spam"})
+	if frame := readChatFrame(t, conn); frame.Error == nil || frame.Error.Code != common.CodeRateLimited {
+		t.Fatalf("not rate limited %v", frame)
+	}
+}
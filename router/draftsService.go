@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long forms wait after the last key before saving the draft
+const draftSaveMillis = 2000
+
+// page script saves the form here while typing.
+// thread is left out for the new thread form.
+func draftPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		handleFetchErrorInternal(common.NewError(common.CodeUnauthorized, "not logged in", nil), ctx)
+		return
+	}
+	draft, err := draftPostInternal(ctx)
+	if err != nil {
+		handleFetchErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"saved": draft.UpdatedAt})
+}
+
+// state is kept, sending the form consumes it
+func draftPostInternal(ctx *gin.Context) (draft *common.Draft, err error) {
+	sess, err := sessionStateVerifyInternal(ctx)
+	if err != nil {
+		return
+	}
+	draft, err = saveFormDraftInternal(ctx, sess)
+	return
+}
+
+func saveFormDraftInternal(ctx *gin.Context, sess *common.Session) (saved *common.Draft, err error) {
+	draft := formAsDraftInternal(ctx, sess)
+	if public := ctx.PostForm("thread"); len(public) > 0 {
+		var bytes []byte
+		bytes, err = decode(public)
+		if err != nil {
+			err = common.NewError(common.CodeNotFound, "broken thread id", err)
+			return
+		}
+		var thre *common.Thread
+		thre, err = threadsClient.ReadThread(ctx.Request.Context(), string(bytes))
+		if err != nil {
+			return
+		}
+		draft.ThreadId = thre.Id
+	}
+	saved, err = threadsClient.SaveDraft(ctx.Request.Context(), draft)
+	return
+}
+
+// text of the posted form, nothing is stored
+func formAsDraftInternal(ctx *gin.Context, sess *common.Session) *common.Draft {
+	return &common.Draft{
+		UserId: sess.UserId,
+		Topic:  ctx.PostForm("topic"),
+		Board:  ctx.PostForm("board"),
+		Tags:   ctx.PostForm("tags"),
+		Body:   ctx.PostForm("body"),
+	}
+}
+
+// nil when nothing is kept. form is shown empty
+// when threads service can not tell.
+func formDraftInternal(ctx *gin.Context, sess *common.Session, threadId uint) *common.Draft {
+	draft, err := threadsClient.ReadDraft(ctx.Request.Context(), sess.UserId, threadId)
+	if err != nil {
+		if common.ErrorCodeOf(err) != common.CodeNotFound {
+			common.LogError(logger).Println(err.Error())
+		}
+		return nil
+	}
+	return draft
+}
+
+// what happened to a form sent back to the user
+type formNotice struct {
+	// state had expired or was used, form is kept as draft
+	Expired bool
+	// state was missing or not this session's, form is only shown again
+	Refused bool
+	// files are never kept, they have to be attached again
+	DroppedFiles bool
+}
+
+func formNoticeFromQueryInternal(ctx *gin.Context) formNotice {
+	return formNotice{
+		Expired:      ctx.Query("expired") == "1",
+		DroppedFiles: ctx.Query("dropped") == "1",
+	}
+}
+
+// a form sent after its state expired, or after another form of
+// the page used it, is kept as draft. the user is sent back to the
+// form, which shows it again with a new state. a form with no state,
+// or one never given to this session, is not stored: the form is
+// shown again from what was sent. false when it could not be kept,
+// the error page is shown then.
+func keepExpiredFormInternal(ctx *gin.Context, err error) bool {
+	if !isInvalidState(err) {
+		return false
+	}
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return false
+	}
+	notice := formNotice{DroppedFiles: hasAttachedFilesInternal(ctx)}
+	thread := ctx.PostForm("thread")
+	if !stateIssuedFor(ctx.PostForm("state"), sess.UuId) {
+		notice.Refused = true
+		return showFormAgainInternal(ctx, sess, thread, notice)
+	}
+
+	_, err = saveFormDraftInternal(ctx, sess)
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return false
+	}
+	common.LogInfo(logger).Printf("form of user %d expired, kept as draft\n", sess.UserId)
+	query := "expired=1"
+	if notice.DroppedFiles {
+		query += "&dropped=1"
+	}
+	if len(thread) > 0 {
+		ctx.Redirect(http.StatusFound, fmt.Sprintf("/thread/read?id=%s&%s#post", thread, query))
+	} else {
+		ctx.Redirect(http.StatusFound, "/thread/new?"+query)
+	}
+	return true
+}
+
+// form page rendered for the post, with a new state
+func showFormAgainInternal(ctx *gin.Context, sess *common.Session, thread string, notice formNotice) bool {
+	state, err := generateSessionState(ctx)
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return false
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Set(stateLabel, state)
+	common.LogInfo(logger).Printf("form of user %d came without a state of its session, shown again\n", sess.UserId)
+	sent := formAsDraftInternal(ctx, sess)
+	if len(thread) > 0 {
+		threadPageInternal(ctx, thread, sent, notice)
+	} else {
+		newThreadPageInternal(ctx, sent, notice)
+	}
+	return true
+}
+
+func hasAttachedFilesInternal(ctx *gin.Context) bool {
+	if ctx.Request.MultipartForm == nil {
+		return false
+	}
+	for _, header := range ctx.Request.MultipartForm.File["files"] {
+		// browsers send an empty part when nothing was picked
+		if header.Size > 0 || len(header.Filename) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"learning-web-chatboard2/common"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_Drafts(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  user.Name,
+		UserId: user.Id,
+	})
+	vis, _ := users.CreateVisit(bg)
+	vis.ThreadId = thre.Id
+	vis.ThreadUuId = thre.UuId
+	users.UpdateVisit(bg, vis)
+
+	engine := newTestingEngine()
+	engine.GET("/thread/read", GenerateSessionStateMiddleware, threadGet)
+	engine.GET("/thread/new", GenerateSessionStateMiddleware, newThreadGet)
+	engine.POST("/thread/draft", draftPost)
+	engine.POST("/thread/post", newReplyPost)
+	engine.POST("/thread/create", newThreadPost)
+	get := func(path string) string {
+		rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, vis)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	// state is a fresh one unless the form carries one
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		if _, ok := form["state"]; !ok {
+			form.Set("state", newTestingState(t, sess))
+		}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		return serveTesting(t, engine, req, sess, vis)
+	}
+
+	rec := post("/thread/draft", url.Values{
+		"thread": {thre.PublicURL()},
+		"body":   {"half <written>"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("autosave status %d %s", rec.Code, rec.Body.String())
+	}
+	if len(threads.Drafts) != 1 || threads.Drafts[0].ThreadId != thre.Id {
+		t.Fatalf("drafts %+v", threads.Drafts)
+	}
+	if page := get("/thread/read?id=" + thre.PublicURL()); !strings.Contains(page, "half &lt;written&gt;</textarea>") {
+		t.Fatal("reply form does not show the draft")
+	}
+
+	// state used up by another form, text comes back instead of an error
+	used := newTestingState(t, sess)
+	newTestingState(t, sess)
+	rec = post("/thread/post", url.Values{
+		"state":  {used},
+		"thread": {thre.PublicURL()},
+		"body":   {"all written"},
+	})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expired reply status %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/thread/read?id="+thre.PublicURL()+"&expired=1#post" {
+		t.Fatalf("redirected to %s", loc)
+	}
+	if len(threads.Posts) != 0 || threads.Drafts[0].Body != "all written" {
+		t.Fatalf("posts %v drafts %+v", threads.Posts, threads.Drafts)
+	}
+	page := get("/thread/read?expired=1&id=" + thre.PublicURL())
+	if !strings.Contains(page, "The form had expired") || !strings.Contains(page, "all written</textarea>") {
+		t.Fatal("expired form is not shown again")
+	}
+
+	// no state, a forged one or one of another session is
+	// shown again from the post, nothing is stored
+	_, otherSess := newTestingUser(t, users, "TestingHanako")
+	for _, state := range []string{"", "forged", newTestingState(t, otherSess)} {
+		rec = post("/thread/post", url.Values{
+			"state":  {state},
+			"thread": {thre.PublicURL()},
+			"body":   {"not kept"},
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("refused reply status %d", rec.Code)
+		}
+		if page := rec.Body.String(); !strings.Contains(page, "could not be accepted") ||
+			!strings.Contains(page, "not kept</textarea>") {
+			t.Fatalf("refused form is not shown again for state %q", state)
+		}
+		if len(threads.Posts) != 0 || len(threads.Drafts) != 1 || threads.Drafts[0].Body != "all written" {
+			t.Fatalf("posts %v drafts %+v", threads.Posts, threads.Drafts)
+		}
+	}
+
+	rec = post("/thread/create", url.Values{"state": {""}, "topic": {"not kept either"}})
+	if page := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(page, ">not kept either</textarea>") {
+		t.Fatalf("refused thread status %d", rec.Code)
+	}
+	if len(threads.Drafts) != 1 {
+		t.Fatalf("drafts %+v", threads.Drafts)
+	}
+
+	// files are not kept with the draft, user is told
+	var upload bytes.Buffer
+	writer := multipart.NewWriter(&upload)
+	writer.WriteField("state", used)
+	writer.WriteField("thread", thre.PublicURL())
+	writer.WriteField("body", "all written")
+	part, _ := writer.CreateFormFile("files", "cat.png")
+	part.Write([]byte("not really a cat"))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/thread/post", &upload)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec = serveTesting(t, engine, req, sess, vis)
+	if loc := rec.Header().Get("Location"); loc != "/thread/read?id="+thre.PublicURL()+"&expired=1&dropped=1#post" {
+		t.Fatalf("redirected to %s", loc)
+	}
+	if page := get("/thread/read?expired=1&dropped=1&id=" + thre.PublicURL()); !strings.Contains(page, "Files you attached were dropped") {
+		t.Fatal("dropped files are not told")
+	}
+
+	rec = post("/thread/post", url.Values{
+		"thread": {thre.PublicURL()},
+		"body":   {"all written"},
+	})
+	if rec.Code != http.StatusFound || len(threads.Posts) != 1 {
+		t.Fatalf("reply status %d posts %v", rec.Code, threads.Posts)
+	}
+	if len(threads.Drafts) != 0 {
+		t.Fatalf("draft is left after posting %+v", threads.Drafts)
+	}
+
+	rec = post("/thread/draft", url.Values{
+		"topic": {"dinner"},
+		"tags":  {"food"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("autosave status %d %s", rec.Code, rec.Body.String())
+	}
+	page = get("/thread/new")
+	if !strings.Contains(page, ">dinner</textarea>") || !strings.Contains(page, `value="food"`) {
+		t.Fatal("new thread form does not show the draft")
+	}
+	rec = post("/thread/create", url.Values{
+		"topic": {"dinner"},
+		"tags":  {"food"},
+	})
+	if rec.Code != http.StatusFound || len(threads.Drafts) != 0 {
+		t.Fatalf("create status %d drafts %+v", rec.Code, threads.Drafts)
+	}
+
+	// autosave of an emptied form removes the draft
+	post("/thread/draft", url.Values{"thread": {thre.PublicURL()}, "body": {"again"}})
+	post("/thread/draft", url.Values{"thread": {thre.PublicURL()}, "body": {""}})
+	if len(threads.Drafts) != 0 {
+		t.Fatalf("empty draft is kept %+v", threads.Drafts)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const eventsHeartbeat = time.Second * 15
+
+// what browser gets for each post event.
+// only what thread.html shows, not user ids.
+type postEventView struct {
+	Kind   string `json:"kind"`
+	UuId   string `json:"uuid"`
+	Number uint   `json:"number"`
+	Body   string `json:"body"`
+	Html   string `json:"html"`
+	// earlier posts it refers, for their reply lists
+	Refs        []uint           `json:"refs"`
+	Attachments []attachmentView `json:"attachments"`
+	Contributor string           `json:"contributor"`
+	When        string           `json:"when"`
+}
+
+// relays events of the thread from threads service as server-sent events.
+// browser reconnects by itself with Last-Event-ID and gets missed ones.
+func threadEventsGet(ctx *gin.Context) {
+	thre, after, err := threadEventsParamsInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to follow thread")
+		return
+	}
+	streamCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
+	events, err := threadsClient.StreamEvents(streamCtx, thre.Id, after)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to follow thread")
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			// threads service went away, let browser reconnect
+			if !ok {
+				return
+			}
+			ctx.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(uint64(event.Id), 10),
+				Event: event.Kind,
+				Data:  toPostEventView(&event),
+			})
+		case <-heartbeat.C:
+			fmt.Fprint(ctx.Writer, ":\n\n")
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// deleted posts are only named, their text is not sent
+func toPostEventView(event *common.ThreadEvent) *postEventView {
+	if event.Kind == common.EventPostDeleted {
+		return &postEventView{
+			Kind:   event.Kind,
+			UuId:   event.Post.UuId,
+			Number: event.Post.Number,
+			Refs:   []uint{},
+		}
+	}
+	return &postEventView{
+		Kind:        event.Kind,
+		UuId:        event.Post.UuId,
+		Number:      event.Post.Number,
+		Body:        event.Post.Body,
+		Html:        string(event.Post.BodyHTML()),
+		Refs:        referredNumbersInternal(&event.Post),
+		Attachments: toAttachmentViews(event.Post.Attachments),
+		Contributor: event.Post.Contributor,
+		When:        event.Post.When(),
+	}
+}
+
+func threadEventsParamsInternal(ctx *gin.Context) (thread *common.Thread, after uint, err error) {
+	bytes, err := base64.URLEncoding.DecodeString(ctx.Query("id"))
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	lastEventId := ctx.GetHeader("Last-Event-ID")
+	if len(lastEventId) == 0 {
+		lastEventId = ctx.DefaultQuery("after", "0")
+	}
+	id, err := strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		err = common.NewError(common.CodeBadRequest, "invalid last event id", err)
+		return
+	}
+	after = uint(id)
+	thread, err = threadsClient.ReadThread(ctx.Request.Context(), string(bytes))
+	return
+}
+
+// unique numbers before the post, like IndexReplies counts them
+func referredNumbersInternal(post *common.Post) (numbers []uint) {
+	numbers = []uint{}
+	seen := make(map[uint]bool)
+	for _, ref := range common.ParseReferences(post.Body) {
+		for n := ref.From; n <= ref.To && n < post.Number; n++ {
+			if !seen[n] {
+				seen[n] = true
+				numbers = append(numbers, n)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ThreadEventsForgetText(t *testing.T) {
+	_, threads := setupTesting(t)
+	bg := context.Background()
+	thre, _ := threads.CreateThread(bg, &common.Thread{Topic: "lunch", Owner: "taro", UserId: 1})
+	edited, _ := threads.CreatePost(bg, &common.Post{Body: "first draft", Contributor: "taro", UserId: 1, ThreadId: thre.Id})
+	edited.Body = "edited"
+	threads.UpdatePost(bg, edited)
+	deleted, _ := threads.CreatePost(bg, &common.Post{Body: "regretted", Contributor: "taro", UserId: 1, ThreadId: thre.Id})
+	threads.DeletePost(bg, deleted)
+
+	engine := newTestingEngine()
+	engine.GET("/thread/events", threadEventsGet)
+	// stream follows until the browser goes away
+	ctx, cancel := context.WithTimeout(bg, time.Millisecond*200)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/thread/events?after=0&id="+thre.PublicURL(), nil)
+	rec := serveTesting(t, engine, req.WithContext(ctx), nil, nil)
+
+	stream := rec.Body.String()
+	if strings.Contains(stream, "first draft") || strings.Contains(stream, "regretted") {
+		t.Fatalf("replay has text posts do not have any more %s", stream)
+	}
+	if !strings.Contains(stream, `"body":"edited"`) || !strings.Contains(stream, "event:post.deleted") {
+		t.Fatalf("events are missing %s", stream)
+	}
+}
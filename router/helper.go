@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -27,6 +26,8 @@ const (
 	pwSalt             = "LV2vP8vq"
 	sessionCookieLabel = "short-time"
 	visitCookieLabel   = "long-time"
+	// forms sent with a bad state are told apart by this
+	invalidStateMessage = "invalid state"
 )
 const (
 	aes256KeySize uint          = 32
@@ -58,16 +59,6 @@ func startHelper() (err error) {
 	return
 }
 
-func buildHTTP_URL(domain string, path string) (url string) {
-	url = fmt.Sprintf(
-		"%s%s%s",
-		httpPrefix,
-		domain,
-		path,
-	)
-	return
-}
-
 func makeHash(plainText string) (hashed string) {
 	asBytes := sha256.Sum256([]byte(plainText))
 	hashed = fmt.Sprintf("%x", asBytes)
@@ -131,6 +122,19 @@ func verifyMAC(mac []byte, value []byte) bool {
 	return hmac.Equal(mac, hashedVal)
 }
 
+// mac has fixed length and can contain '|',
+// so split by length, not by searching separator
+func splitMAC(value []byte) (mac []byte, rest []byte, err error) {
+	macLength := len(macSalt) + sha256.Size
+	if len(value) <= macLength || value[macLength] != '|' {
+		err = errors.New("invalid mac format")
+		return
+	}
+	mac = value[:macLength]
+	rest = value[macLength+1:]
+	return
+}
+
 func encode(value []byte) string {
 	return base64.URLEncoding.EncodeToString(value)
 }
@@ -144,25 +148,7 @@ func checkLoggedIn(ctx *gin.Context) (err error) {
 	if err != nil {
 		return
 	}
-	sess := common.Session{
-		UuId: uuid,
-	}
-	req, err := common.MakeRequestFromSession(
-		&sess,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/check-session"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	sessPtr, err := common.MakeSessionFromResponse(res)
+	sessPtr, err := usersClient.CheckSession(ctx.Request.Context(), uuid)
 	if err != nil {
 		return
 	}
@@ -182,7 +168,7 @@ func visitCheck(ctx *gin.Context) (err error) {
 			common.LogWarning(logger).
 				Printf("creating new visit because [%s]\n", err.Error())
 		}
-		vis, err = requestVisitCreate()
+		vis, err = usersClient.CreateVisit(ctx.Request.Context())
 		if err != nil {
 			return
 		}
@@ -268,9 +254,10 @@ func pickupCookie(ctx *gin.Context, name string) (value string, err error) {
 	if err != nil {
 		return
 	}
-	splited := bytes.SplitN(bytesVal, []byte("|"), 2)
-	mac := splited[0]
-	encrypted := splited[1]
+	mac, encrypted, err := splitMAC(bytesVal)
+	if err != nil {
+		return
+	}
 	if !verifyMAC(mac, encrypted) {
 		err = fmt.Errorf("invalid cookie %s", rawStored)
 		return
@@ -301,11 +288,11 @@ func generateVisitState(ctx *gin.Context) (stateAndMACEncoded string, err error)
 		return
 	}
 
-	vis.State, stateAndMACEncoded, err = generateState()
+	vis.State, stateAndMACEncoded, err = generateState(vis.UuId)
 	if err != nil {
 		return
 	}
-	err = requestVisitUpdate(vis)
+	_, err = usersClient.UpdateVisit(ctx.Request.Context(), vis)
 	if err != nil {
 		return
 	}
@@ -319,11 +306,11 @@ func generateSessionState(ctx *gin.Context) (stateAndMACEncoded string, err erro
 		return
 	}
 
-	sess.State, stateAndMACEncoded, err = generateState()
+	sess.State, stateAndMACEncoded, err = generateState(sess.UuId)
 	if err != nil {
 		return
 	}
-	err = requestSessionUpdate(sess)
+	_, err = usersClient.UpdateSession(ctx.Request.Context(), sess)
 	if err != nil {
 		return
 	}
@@ -331,7 +318,9 @@ func generateSessionState(ctx *gin.Context) (stateAndMACEncoded string, err erro
 	return
 }
 
-func generateState() (stateRaw, stateAndMACEncoded string, err error) {
+// owner is uuid of the session or visit keeping it,
+// mac covers it so a state can not be used by another one
+func generateState(owner string) (stateRaw, stateAndMACEncoded string, err error) {
 	state, err := generateString(stateSize)
 	if err != nil {
 		return
@@ -345,97 +334,27 @@ func generateState() (stateRaw, stateAndMACEncoded string, err error) {
 
 	// same proc with cookie
 	stateAsBytes := []byte(state)
-	bytesVal := makeMAC(stateAsBytes)
+	bytesVal := makeMAC(ownedState(owner, stateAsBytes))
 	bytesVal = append(bytesVal, []byte("|")...)
 	bytesVal = append(bytesVal, stateAsBytes...)
 	stateAndMACEncoded = encode(bytesVal)
 	return
 }
 
-func requestSessionUpdate(sess *common.Session) (err error) {
-	req, err := common.MakeRequestFromSession(
-		sess,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/update-session"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	sess, err = common.MakeSessionFromResponse(res)
-	return
-}
-
-func requestVisitCreate() (vis *common.Visit, err error) {
-	req, err := http.NewRequest(
-		http.MethodGet,
-		buildHTTP_URL(config.AddressUsers, "/create-visit"),
-		nil,
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	vis, err = common.MakeVisitFromResponse(res)
-	return
-}
-
 func requestVisitPtr(ctx *gin.Context) (ptr *common.Visit, err error) {
 	uuid, err := pickupCookie(ctx, visitCookieLabel)
 	if err != nil {
 		return
 	}
-	vis := common.Visit{UuId: uuid}
-	req, err := common.MakeRequestFromVisit(
-		&vis,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/check-visit"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	ptr, err = common.MakeVisitFromResponse(res)
+	ptr, err = usersClient.CheckVisit(ctx.Request.Context(), uuid)
 	return
 }
 
-func requestVisitUpdate(vis *common.Visit) (err error) {
-	req, err := common.MakeRequestFromVisit(
-		vis,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/update-visit"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-	}
-	return
+func ownedState(owner string, state []byte) []byte {
+	return append([]byte(owner+"|"), state...)
 }
 
-func checkState(exposedVal, privateVal string) (err error) {
+func checkState(exposedVal, privateVal, owner string) (err error) {
 	if strings.Compare(exposedVal, "") == 0 {
 		err = errors.New("exposed value is empty")
 		return
@@ -449,11 +368,13 @@ func checkState(exposedVal, privateVal string) (err error) {
 	if err != nil {
 		return
 	}
-	splited := bytes.SplitN(bytesVal, []byte("|"), 2)
-	macStored := splited[0]
-	stateStored := string(splited[1])
+	macStored, stateBytes, err := splitMAC(bytesVal)
+	if err != nil {
+		return
+	}
+	stateStored := string(stateBytes)
 
-	if !verifyMAC(macStored, []byte(privateVal)) {
+	if !verifyMAC(macStored, ownedState(owner, []byte(privateVal))) {
 		err = errors.New("invalid mac")
 		return
 	}
@@ -484,32 +405,63 @@ func visitStateCheckProcess(ctx *gin.Context) (vis *common.Visit, err error) {
 
 	// check state
 	state := ctx.PostForm("state")
-	err = checkState(state, vis.State)
+	err = checkState(state, vis.State, vis.UuId)
 	if err != nil {
+		err = common.NewError(common.CodeForbidden, invalidStateMessage, err)
 		return
 	}
 
 	// state is consumed, delete it
 	vis.State = ""
-	err = requestVisitUpdate(vis)
+	_, err = usersClient.UpdateVisit(ctx.Request.Context(), vis)
 	return
 }
 
 func sessionStateCheckProcess(ctx *gin.Context) (sess *common.Session, err error) {
-	sess, err = getSessionPtrFromCTX(ctx)
+	sess, err = sessionStateVerifyInternal(ctx)
 	if err != nil {
 		return
 	}
 
-	// check state
-	state := ctx.PostForm("state")
-	err = checkState(state, sess.State)
+	// state is consumed, delete it
+	sess.State = ""
+	_, err = usersClient.UpdateSession(ctx.Request.Context(), sess)
+	return
+}
+
+// state was given to owner once, it may have expired
+// or been used since. only mac is checked.
+func stateIssuedFor(exposedVal, owner string) bool {
+	bytesVal, err := decode(exposedVal)
 	if err != nil {
-		return
+		return false
+	}
+	macStored, stateBytes, err := splitMAC(bytesVal)
+	if err != nil {
+		return false
 	}
+	return verifyMAC(macStored, ownedState(owner, stateBytes))
+}
 
-	// state is consumed, delete it
-	sess.State = ""
-	err = requestSessionUpdate(sess)
+// form came with a state that expired or another form used
+func isInvalidState(err error) bool {
+	var codeErr *common.Error
+	return errors.As(err, &codeErr) &&
+		codeErr.Code == common.CodeForbidden &&
+		codeErr.Message == invalidStateMessage
+}
+
+// checks state but keeps it, for calls a page makes many times
+// without reloading. next form of the page consumes it.
+func sessionStateVerifyInternal(ctx *gin.Context) (sess *common.Session, err error) {
+	sess, err = getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	state := ctx.PostForm("state")
+	err = checkState(state, sess.State, sess.UuId)
+	if err != nil {
+		err = common.NewError(common.CodeForbidden, invalidStateMessage, err)
+	}
 	return
 }
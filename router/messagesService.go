@@ -0,0 +1,194 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversations of the user, latest first, and form for a new one.
+// ?to= fills the names, profile pages link here with it.
+func inboxGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := inboxGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read messages")
+	}
+}
+
+func inboxGetInternal(ctx *gin.Context) (err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	convs, err := usersClient.ReadConversations(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"messages.html",
+		gin.H{
+			"navbar":        navbar,
+			"conversations": convs,
+			"userId":        sess.UserId,
+			"to":            ctx.Query("to"),
+			"maxMembers":    common.MaxConversationMembers - 1,
+			"state":         getStateFromCTX(ctx),
+		},
+	)
+	return
+}
+
+// form has state, names, title and the first message.
+// a one to one already there is just opened.
+func createConversationPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	conv, err := createConversationPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to start conversation")
+		return
+	}
+	ctx.Redirect(http.StatusFound, conv.URL())
+}
+
+func createConversationPostInternal(ctx *gin.Context) (conv *common.Conversation, err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	conv, err = usersClient.CreateConversation(ctx.Request.Context(), &common.ConversationRequest{
+		UserId: sess.UserId,
+		Names:  common.SplitNames(ctx.PostForm("names")),
+		Title:  ctx.PostForm("title"),
+	})
+	if err != nil {
+		return
+	}
+	body := ctx.PostForm("body")
+	if len(strings.TrimSpace(body)) == 0 {
+		return
+	}
+	_, err = usersClient.CreateDirectMessage(ctx.Request.Context(), &common.DirectMessage{
+		ConversationUuId: conv.UuId,
+		UserId:           sess.UserId,
+		Body:             body,
+	})
+	return
+}
+
+// members only, users service tells not found to anyone else.
+// seeing it marks everything in it read.
+func conversationGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := conversationGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read conversation")
+	}
+}
+
+func conversationGetInternal(ctx *gin.Context) (err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	uuid, err := conversationUuIdInternal(ctx.Query("id"))
+	if err != nil {
+		return
+	}
+	conv, err := usersClient.ReadConversation(ctx.Request.Context(), sess.UserId, uuid)
+	if err != nil {
+		return
+	}
+	msgs, err := usersClient.ReadDirectMessages(ctx.Request.Context(), sess.UserId, uuid)
+	if err != nil {
+		return
+	}
+	// badge is a little off at worst, page is fine without it
+	readErr := usersClient.MarkConversationRead(ctx.Request.Context(), sess.UserId, uuid)
+	if readErr != nil {
+		common.LogWarning(logger).Printf("failed to mark conversation read: %s\n", readErr.Error())
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"conversation.html",
+		gin.H{
+			"navbar":       navbar,
+			"conversation": conv,
+			"name":         conv.NameFor(sess.UserId),
+			"messages":     msgs,
+			"userId":       sess.UserId,
+			"id":           ctx.Query("id"),
+			"state":        getStateFromCTX(ctx),
+		},
+	)
+	return
+}
+
+// form has state, id and body
+func sendMessagePost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	conv, err := sendMessagePostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to send message")
+		return
+	}
+	ctx.Redirect(http.StatusFound, conv.URL())
+}
+
+// conversation is only the uuid, encoded again for the redirect
+// rather than trusting what the form sent
+func sendMessagePostInternal(ctx *gin.Context) (conv *common.Conversation, err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	uuid, err := conversationUuIdInternal(ctx.PostForm("id"))
+	if err != nil {
+		return
+	}
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	_, err = usersClient.CreateDirectMessage(ctx.Request.Context(), &common.DirectMessage{
+		ConversationUuId: uuid,
+		UserId:           sess.UserId,
+		Body:             ctx.PostForm("body"),
+	})
+	if err != nil {
+		return
+	}
+	conv = &common.Conversation{UuId: uuid}
+	return
+}
+
+func conversationUuIdInternal(public string) (uuid string, err error) {
+	bytes, err := decode(public)
+	if err != nil || len(bytes) == 0 {
+		err = common.NewError(common.CodeNotFound, "broken conversation id", err)
+		return
+	}
+	uuid = string(bytes)
+	return
+}
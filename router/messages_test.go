@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_Messages(t *testing.T) {
+	users, _ := setupTesting(t)
+	bg := context.Background()
+	_, taro := newTestingUser(t, users, "taro")
+	_, hanako := newTestingUser(t, users, "hanako")
+	_, jiro := newTestingUser(t, users, "jiro")
+	vis, _ := users.CreateVisit(bg)
+
+	engine := newTestingEngine()
+	engine.GET("/messages", GenerateSessionStateMiddleware, inboxGet)
+	engine.GET("/messages/read", GenerateSessionStateMiddleware, conversationGet)
+	engine.POST("/messages/create", createConversationPost)
+	engine.POST("/messages/send", sendMessagePost)
+	get := func(sess *common.Session, path string) *httptest.ResponseRecorder {
+		return serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, vis)
+	}
+	post := func(sess *common.Session, path string, form url.Values) *httptest.ResponseRecorder {
+		return postWithState(t, engine, sess, vis, path, form)
+	}
+
+	rec := post(taro, "/messages/create", url.Values{"names": {"hanako"}, "body": {"lunch today?"}})
+	if rec.Code != http.StatusFound || len(users.Conversations) != 1 || len(users.DirectMessages) != 1 {
+		t.Fatalf("create status %d conversations %+v", rec.Code, users.Conversations)
+	}
+	convURL := rec.Header().Get("Location")
+	if convURL != users.Conversations[0].URL() {
+		t.Fatalf("redirected to %s", convURL)
+	}
+	rec = post(taro, "/messages/create", url.Values{"names": {"@hanako"}})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != convURL || len(users.Conversations) != 1 {
+		t.Fatalf("one to one made twice, status %d", rec.Code)
+	}
+
+	rec = get(hanako, "/messages")
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, "lunch today?") ||
+		!strings.Contains(page, `Messages <span class="badge">1</span>`) {
+		t.Fatalf("inbox status %d", rec.Code)
+	}
+	rec = get(hanako, convURL)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "lunch today?") {
+		t.Fatalf("conversation status %d", rec.Code)
+	}
+	if unread, _ := users.CountUnreadMessages(bg, hanako.UserId); unread != 0 {
+		t.Fatalf("%d unread after reading", unread)
+	}
+
+	// participants only
+	if rec = get(jiro, convURL); rec.Code != http.StatusNotFound {
+		t.Fatalf("outsider read status %d", rec.Code)
+	}
+	id := strings.TrimPrefix(convURL, "/messages/read?id=")
+	if rec = post(jiro, "/messages/send", url.Values{"id": {id}, "body": {"me too"}}); rec.Code != http.StatusNotFound {
+		t.Fatalf("outsider send status %d", rec.Code)
+	}
+
+	// decoder skips line breaks, they must not reach the redirect
+	rec = post(hanako, "/messages/send", url.Values{"id": {id + "\r\n"}, "body": {"sure"}})
+	if rec.Code != http.StatusFound || len(users.DirectMessages) != 2 {
+		t.Fatalf("reply status %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != convURL {
+		t.Fatalf("redirected to %q", loc)
+	}
+	if unread, _ := users.CountUnreadMessages(bg, taro.UserId); unread != 1 {
+		t.Fatalf("%d unread for taro", unread)
+	}
+
+	// a title makes another talk of the same two, not a group
+	rec = post(taro, "/messages/create", url.Values{"names": {"hanako"}, "title": {"plans"}})
+	if rec.Code != http.StatusFound || len(users.Conversations) != 2 {
+		t.Fatalf("titled create status %d", rec.Code)
+	}
+	titledId := strings.TrimPrefix(rec.Header().Get("Location"), "/messages/read?id=")
+
+	users.BlockUser(bg, &common.UserBlock{UserId: hanako.UserId, BlockedId: taro.UserId})
+	for _, convId := range []string{id, titledId} {
+		if rec = post(taro, "/messages/send", url.Values{"id": {convId}, "body": {"why?"}}); rec.Code != http.StatusForbidden {
+			t.Fatalf("blocked send status %d", rec.Code)
+		}
+	}
+	rec = post(taro, "/messages/create", url.Values{"names": {"jiro hanako"}, "title": {"lunch club"}})
+	if rec.Code != http.StatusForbidden || len(users.Conversations) != 2 {
+		t.Fatalf("group with blocker status %d", rec.Code)
+	}
+	if rec = post(taro, "/messages/create", url.Values{"names": {"taro"}}); rec.Code != common.HTTPStatusOf(common.CodeInvalid) {
+		t.Fatalf("talk to yourself status %d", rec.Code)
+	}
+}
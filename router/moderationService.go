@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxBanDays = 365
+
+// form has action, thread (base64 uuid), post and reason.
+// goes back to the thread after.
+func moderatePost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := moderatePostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to moderate")
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+func moderatePostInternal(ctx *gin.Context) (err error) {
+	moderator, err := readModeratorInternal(ctx)
+	if err != nil {
+		return
+	}
+	bytes, err := decode(ctx.PostForm("thread"))
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	_, err = threadsClient.Moderate(ctx.Request.Context(), &common.ModerationAction{
+		Action:        ctx.PostForm("action"),
+		ModeratorId:   moderator.Id,
+		ModeratorName: moderator.Name,
+		ThreadUuId:    string(bytes),
+		PostUuId:      ctx.PostForm("post"),
+		Reason:        ctx.PostForm("reason"),
+	})
+	return
+}
+
+// form has user_id, days, reason and thread to go back to
+func banUserPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := banUserPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to ban")
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+func banUserPostInternal(ctx *gin.Context) (err error) {
+	moderator, err := readModeratorInternal(ctx)
+	if err != nil {
+		return
+	}
+	target, _ := strconv.ParseUint(ctx.PostForm("user_id"), 10, 64)
+	days, _ := strconv.Atoi(ctx.PostForm("days"))
+	if days <= 0 || days > maxBanDays {
+		err = common.NewError(
+			common.CodeInvalid,
+			fmt.Sprintf("days must be 1 to %d", maxBanDays),
+			nil,
+		)
+		return
+	}
+	if uint(target) == moderator.Id {
+		err = common.NewError(common.CodeInvalid, "can not ban yourself", nil)
+		return
+	}
+	bytes, _ := decode(ctx.PostForm("thread"))
+	_, err = usersClient.BanUser(ctx.Request.Context(), &common.ModerationAction{
+		ModeratorId:   moderator.Id,
+		ModeratorName: moderator.Name,
+		TargetUserId:  uint(target),
+		ThreadUuId:    string(bytes),
+		Reason:        ctx.PostForm("reason"),
+		Until:         time.Now().AddDate(0, 0, days),
+	})
+	return
+}
+
+// tags of the thread are replaced by the form ones
+func tagsPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := tagsPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to tag")
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+func tagsPostInternal(ctx *gin.Context) (err error) {
+	_, err = readModeratorInternal(ctx)
+	if err != nil {
+		return
+	}
+	bytes, err := decode(ctx.PostForm("thread"))
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	_, err = threadsClient.UpdateTags(ctx.Request.Context(), &common.Thread{
+		UuId: string(bytes),
+		Tags: common.SplitTags(ctx.PostForm("tags")),
+	})
+	return
+}
+
+// consumes the form state, role is read fresh every time
+func readModeratorInternal(ctx *gin.Context) (moderator *common.User, err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	moderator, err = usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if !moderator.IsModerator() {
+		err = common.NewError(common.CodeForbidden, "not a moderator", nil)
+	}
+	return
+}
+
+// banned users still read, they can not write
+func checkNotBannedInternal(ctx *gin.Context, sess *common.Session) (err error) {
+	user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if user.IsBanned(time.Now()) {
+		err = common.NewError(
+			common.CodeForbidden,
+			"banned until "+user.BannedUntil.Format("2006/Jan/2 at 3:04pm"),
+			nil,
+		)
+	}
+	return
+}
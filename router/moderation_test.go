@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_ModerateAndBan(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	member, memberSess := newTestingUser(t, users, "TestingTaro")
+	moderator, moderatorSess := newTestingUser(t, users, "TestingHanako")
+	users.Users[moderator.Email].Role = common.RoleModerator
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "rules",
+		Owner:  member.Name,
+		UserId: member.Id,
+	})
+
+	engine := newTestingEngine()
+	engine.POST("/moderate", moderatePost)
+	engine.POST("/moderate/ban", banUserPost)
+	post := func(sess *common.Session, path string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("thread", thre.PublicURL())
+		return postWithState(t, engine, sess, nil, path, form)
+	}
+	reply := func(user *common.User) error {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		sess := &common.Session{UserId: user.Id, UserName: user.Name}
+		_, err := createReplyInternal(ctx, sess, &newReplyRequest{ThreadUuId: thre.UuId, Body: "hello"})
+		return err
+	}
+
+	lock := url.Values{"action": {common.ModerationThreadLocked}}
+	if rec := post(memberSess, "/moderate", lock); rec.Code != http.StatusForbidden {
+		t.Fatalf("member moderated, status %d", rec.Code)
+	}
+	if rec := post(moderatorSess, "/moderate", lock); rec.Code != http.StatusFound {
+		t.Fatalf("lock status %d", rec.Code)
+	}
+	if len(threads.Actions) != 1 || threads.Actions[0].TargetUserId != member.Id {
+		t.Fatalf("action not recorded %v", threads.Actions)
+	}
+	if common.ErrorCodeOf(reply(member)) != common.CodeForbidden {
+		t.Fatal("replied to locked thread")
+	}
+	unlock := url.Values{"action": {common.ModerationThreadUnlocked}}
+	if rec := post(moderatorSess, "/moderate", unlock); rec.Code != http.StatusFound {
+		t.Fatalf("unlock status %d", rec.Code)
+	}
+	if err := reply(member); err != nil {
+		t.Fatalf("reply after unlock %s", err.Error())
+	}
+
+	ban := url.Values{
+		"user_id": {strconv.FormatUint(uint64(member.Id), 10)},
+		"days":    {"3"},
+	}
+	if rec := post(memberSess, "/moderate/ban", ban); rec.Code != http.StatusForbidden {
+		t.Fatalf("member banned, status %d", rec.Code)
+	}
+	if rec := post(moderatorSess, "/moderate/ban", ban); rec.Code != http.StatusFound {
+		t.Fatalf("ban status %d", rec.Code)
+	}
+	if common.ErrorCodeOf(reply(member)) != common.CodeForbidden {
+		t.Fatal("banned user replied")
+	}
+}
+
+func Test_Notifications(t *testing.T) {
+	users, _ := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	for _, kind := range []string{common.NotifyReply, common.NotifyMention} {
+		users.Notifications = append(users.Notifications, common.Notification{
+			UuId:       common.NewUuIdString(),
+			UserId:     user.Id,
+			Kind:       kind,
+			ActorName:  "TestingHanako",
+			ThreadUuId: common.NewUuIdString(),
+			Topic:      "rules",
+		})
+	}
+
+	engine := newTestingEngine()
+	engine.GET("/notifications", GenerateSessionStateMiddleware, notificationsGet)
+	engine.POST("/notifications/read", markNotificationsReadPost)
+
+	rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, "/notifications", nil), sess, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `<span class="badge">2</span>`) {
+		t.Fatal("unread badge is not shown")
+	}
+
+	rec = postWithState(t, engine, sess, nil, "/notifications/read", url.Values{
+		"uuid": {users.Notifications[0].UuId},
+	})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("mark status %d", rec.Code)
+	}
+	unread, _ := users.CountUnreadNotifications(bg, user.Id)
+	if unread != 1 {
+		t.Fatalf("unread %d, want 1", unread)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func notificationsGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := notificationsGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read notifications")
+	}
+}
+
+func notificationsGetInternal(ctx *gin.Context) (err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	notifications, err := usersClient.ReadNotifications(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	unread := false
+	for i := range notifications {
+		if !notifications[i].IsRead() {
+			unread = true
+			break
+		}
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"notifications.html",
+		gin.H{
+			"navbar":        navbar,
+			"notifications": notifications,
+			"unread":        unread,
+			"state":         getStateFromCTX(ctx),
+		},
+	)
+	return
+}
+
+// uuid is empty for "mark all"
+func markNotificationsReadPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := markNotificationsReadPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to mark notifications")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/notifications")
+}
+
+func markNotificationsReadPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	err = usersClient.MarkNotificationsRead(
+		ctx.Request.Context(),
+		sess.UserId,
+		ctx.PostForm("uuid"),
+	)
+	return
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openapi document is generated from apiRoutes,
+// so adding a route to the table is enough to document it.
+
+func openAPIGet(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, buildOpenAPI())
+}
+
+func buildOpenAPI() gin.H {
+	paths := gin.H{}
+	for _, route := range apiRoutes {
+		path := openAPIPath(route.path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.method)] = openAPIOperation(&route)
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "KEIJIBAN API",
+			"version": "v1",
+		},
+		"servers": []gin.H{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{
+					"type":   "http",
+					"scheme": "bearer",
+				},
+				"cookieAuth": gin.H{
+					"type": "apiKey",
+					"in":   "cookie",
+					"name": sessionCookieLabel,
+				},
+			},
+		},
+	}
+}
+
+// gin style ':id' to openapi style '{id}'
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func openAPIOperation(route *apiRoute) gin.H {
+	params := []gin.H{}
+	for _, seg := range strings.Split(route.path, "/") {
+		if strings.HasPrefix(seg, ":") {
+			params = append(params, gin.H{
+				"name":     seg[1:],
+				"in":       "path",
+				"required": true,
+				"schema":   gin.H{"type": "string"},
+			})
+		}
+	}
+	if route.paged {
+		params = append(params,
+			gin.H{
+				"name":   "page",
+				"in":     "query",
+				"schema": gin.H{"type": "integer", "minimum": 1, "default": 1},
+			},
+			gin.H{
+				"name": "per_page",
+				"in":   "query",
+				"schema": gin.H{
+					"type":    "integer",
+					"minimum": 1,
+					"maximum": apiMaxPerPage,
+					"default": apiDefaultPerPage,
+				},
+			},
+		)
+	}
+
+	op := gin.H{
+		"summary":     route.summary,
+		"description": "api tokens need scope: " + route.scope,
+		"operationId": openAPIOperationId(route),
+		"parameters":  params,
+		"responses": gin.H{
+			strconv.Itoa(route.status): gin.H{
+				"description": http.StatusText(route.status),
+				"content":     openAPIContent(route.response),
+			},
+			"default": gin.H{
+				"description": "error",
+				"content":     openAPIContent(common.ErrorEnvelope{}),
+			},
+		},
+	}
+	if route.request != nil {
+		op["requestBody"] = gin.H{
+			"required": true,
+			"content":  openAPIContent(route.request),
+		}
+	}
+	if route.auth {
+		op["security"] = []gin.H{
+			{"bearerAuth": []string{}},
+			{"cookieAuth": []string{}},
+		}
+	}
+	return op
+}
+
+// e.g. GET /threads/:id/posts -> getThreadsIdPosts
+func openAPIOperationId(route *apiRoute) string {
+	id := strings.ToLower(route.method)
+	for _, seg := range strings.Split(route.path, "/") {
+		seg = strings.TrimPrefix(seg, ":")
+		if len(seg) > 0 {
+			id += strings.ToUpper(seg[:1]) + seg[1:]
+		}
+	}
+	return id
+}
+
+func openAPIContent(value interface{}) gin.H {
+	return gin.H{
+		"application/json": gin.H{
+			"schema": openAPISchema(reflect.TypeOf(value)),
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func openAPISchema(t reflect.Type) gin.H {
+	if t == timeType {
+		return gin.H{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return openAPISchema(t.Elem())
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return gin.H{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": openAPISchema(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": openAPISchema(t.Elem())}
+	case reflect.Struct:
+		props := gin.H{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if len(name) == 0 {
+				name = field.Name
+			}
+			props[name] = openAPISchema(field.Type)
+		}
+		return gin.H{"type": "object", "properties": props}
+	default:
+		return gin.H{}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// datetime-local input, taken as server local time
+	pollTimeLayout = "2006-01-02T15:04"
+	// how often thread.html asks for new counts
+	pollRefreshMillis = 15000
+)
+
+// nil when both question and options are left empty
+func pollFromFormInternal(ctx *gin.Context) (poll *common.Poll, err error) {
+	question := ctx.PostForm("poll_question")
+	options := ctx.PostForm("poll_options")
+	if len(strings.TrimSpace(question)) == 0 && len(strings.TrimSpace(options)) == 0 {
+		return
+	}
+	poll = &common.Poll{
+		Question:  question,
+		Multiple:  len(ctx.PostForm("poll_multiple")) > 0,
+		Anonymous: len(ctx.PostForm("poll_anonymous")) > 0,
+		Options:   common.SplitPollOptions(options),
+	}
+	if closesAt := ctx.PostForm("poll_closes_at"); len(closesAt) > 0 {
+		poll.ClosesAt, err = time.ParseInLocation(pollTimeLayout, closesAt, time.Local)
+		if err != nil {
+			err = common.NewError(common.CodeInvalid, "invalid poll close time", err)
+		}
+	}
+	return
+}
+
+// nil when the thread has none.
+// page is shown without it when threads service can not tell.
+func threadPollInternal(ctx *gin.Context, thre *common.Thread) *common.Poll {
+	poll, err := threadsClient.ReadPoll(ctx.Request.Context(), pollQueryInternal(ctx, thre))
+	if err != nil {
+		if common.ErrorCodeOf(err) != common.CodeNotFound {
+			common.LogError(logger).Println(err.Error())
+		}
+		return nil
+	}
+	return poll
+}
+
+// page script refreshes the bars with this
+func pollGet(ctx *gin.Context) {
+	poll, err := pollGetInternal(ctx)
+	if err != nil {
+		handleFetchErrorInternal(err, ctx)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, gin.H{"poll": poll})
+}
+
+func pollGetInternal(ctx *gin.Context) (poll *common.Poll, err error) {
+	bytes, err := base64.URLEncoding.DecodeString(ctx.Query("id"))
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	thre, err := threadsClient.ReadThread(ctx.Request.Context(), string(bytes))
+	if err != nil {
+		return
+	}
+	poll, err = threadsClient.ReadPoll(ctx.Request.Context(), pollQueryInternal(ctx, thre))
+	return
+}
+
+// choices are of the viewer when logged in, by session or token
+func pollQueryInternal(ctx *gin.Context, thre *common.Thread) *common.PollQuery {
+	query := &common.PollQuery{ThreadId: thre.Id}
+	if sess, err := getSessionPtrFromCTX(ctx); confirmLoggedIn(ctx) && err == nil {
+		query.UserId = sess.UserId
+	}
+	return query
+}
+
+// same as reactions, json for the page script, redirect without it
+func votePost(ctx *gin.Context) {
+	wantJSON := wantsJSON(ctx)
+	if !confirmLoggedIn(ctx) {
+		if wantJSON {
+			handleFetchErrorInternal(common.NewError(common.CodeUnauthorized, "not logged in", nil), ctx)
+		} else {
+			ctx.Redirect(http.StatusFound, "/user/login")
+		}
+		return
+	}
+	poll, err := votePostInternal(ctx)
+	if err != nil && wantJSON {
+		handleFetchErrorInternal(err, ctx)
+		return
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to vote")
+		return
+	}
+	if wantJSON {
+		ctx.JSON(http.StatusOK, gin.H{"poll": poll})
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+// state is kept, other forms of the page still work after voting
+func votePostInternal(ctx *gin.Context) (poll *common.Poll, err error) {
+	sess, err := sessionStateVerifyInternal(ctx)
+	if err != nil {
+		return
+	}
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	pollId, err := strconv.ParseUint(ctx.PostForm("poll"), 10, 32)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "invalid poll", err)
+		return
+	}
+	var optionIds []uint
+	for _, option := range ctx.PostFormArray("option") {
+		var id uint64
+		id, err = strconv.ParseUint(option, 10, 32)
+		if err != nil {
+			err = common.NewError(common.CodeInvalid, "invalid choice", err)
+			return
+		}
+		optionIds = append(optionIds, uint(id))
+	}
+	poll, err = threadsClient.Vote(ctx.Request.Context(), &common.PollBallot{
+		PollId:    uint(pollId),
+		UserId:    sess.UserId,
+		UserName:  sess.UserName,
+		OptionIds: optionIds,
+	})
+	return
+}
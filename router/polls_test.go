@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_VotePost(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, err := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  user.Name,
+		UserId: user.Id,
+		Poll: &common.Poll{
+			Question: "where?",
+			Options:  common.SplitPollOptions("ramen\nudon\nsoba"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	state := newTestingState(t, sess)
+
+	engine := newTestingEngine()
+	engine.POST("/thread/vote", votePost)
+	engine.GET("/thread/poll", pollGet)
+	engine.GET("/thread/read", threadGet)
+	get := func(path string) *httptest.ResponseRecorder {
+		return serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, nil)
+	}
+	readPoll := func(rec *httptest.ResponseRecorder) *common.Poll {
+		var body struct {
+			Poll *common.Poll `json:"poll"`
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d %s", rec.Code, rec.Body.String())
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body.Poll
+	}
+
+	poll := readPoll(get("/thread/poll?id=" + thre.PublicURL()))
+	if poll == nil || len(poll.Options) != 3 || poll.Voters != 0 {
+		t.Fatalf("before voting %+v", poll)
+	}
+	if html := get("/thread/read?id=" + thre.PublicURL()).Body.String(); !strings.Contains(html, `type="radio" name="option"`) {
+		t.Fatal("no choices on the thread page")
+	}
+
+	vote := func(options ...uint) *httptest.ResponseRecorder {
+		form := url.Values{
+			"state":  {state},
+			"thread": {thre.PublicURL()},
+			"poll":   {fmt.Sprint(poll.Id)},
+		}
+		for _, id := range options {
+			form.Add("option", fmt.Sprint(id))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/thread/vote", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		return serveTesting(t, engine, req, sess, nil)
+	}
+	// single choice takes one
+	if rec := vote(poll.Options[0].Id, poll.Options[1].Id); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("two choices status %d", rec.Code)
+	}
+	voted := readPoll(vote(poll.Options[1].Id))
+	if voted.Voters != 1 || voted.Options[1].Votes != 1 || !voted.Chose(poll.Options[1].Id) {
+		t.Fatalf("after voting %+v", voted)
+	}
+	if len(voted.Options[1].VoterNames) != 1 || voted.Options[1].VoterNames[0] != user.Name {
+		t.Fatalf("voter names %v", voted.Options[1].VoterNames)
+	}
+	if rec := vote(poll.Options[2].Id); rec.Code != http.StatusConflict {
+		t.Fatalf("second vote status %d", rec.Code)
+	}
+
+	html := get("/thread/read?id=" + thre.PublicURL()).Body.String()
+	if strings.Contains(html, `name="option"`) || !strings.Contains(html, `style="width: 100%"`) {
+		t.Fatal("results are not shown after voting")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// a post body bigger than this is not worth previewing
+const previewMaxBytes = 64 * 1024
+
+// renders form body the same way threads service will.
+// answers an html fragment, the page puts it under the form.
+func previewPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.String(http.StatusUnauthorized, "please login")
+		return
+	}
+	rendered, err := previewPostInternal(ctx)
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		code := common.ErrorCodeOf(err)
+		ctx.String(common.HTTPStatusOf(code), errorHintInternal(err))
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rendered))
+}
+
+func previewPostInternal(ctx *gin.Context) (rendered string, err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	body := ctx.PostForm("body")
+	if len(body) > previewMaxBytes {
+		err = common.NewError(common.CodeInvalid, "body too big", nil)
+		return
+	}
+	var mentions []common.Mention
+	if names := common.ParseMentions(body); len(names) > 0 {
+		users, err := usersClient.ResolveMentions(ctx.Request.Context(), sess.UserId, names)
+		// preview without links is fine
+		if err != nil && !common.IsUnavailable(err) {
+			return "", err
+		}
+		for _, user := range users {
+			mentions = append(mentions, common.Mention{UserId: user.Id, UserName: user.Name})
+		}
+	}
+	rendered = common.RenderBody(body, mentions)
+	return
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_PreviewPost(t *testing.T) {
+	users, _ := setupTesting(t)
+	_, sess := newTestingUser(t, users, "taro")
+	engine := newTestingEngine()
+	engine.POST("/thread/preview", previewPost)
+	preview := func(body string, login bool) *httptest.ResponseRecorder {
+		form := url.Values{"body": {body}}
+		req := httptest.NewRequest(http.MethodPost, "/thread/preview", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !login {
+			return serveTesting(t, engine, req, nil, nil)
+		}
+		return serveTesting(t, engine, req, sess, nil)
+	}
+
+	if rec := preview("**hi**", false); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d without login", rec.Code)
+	}
+	rec := preview("**hi** <script>alert(1)</script>", true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if html := rec.Body.String(); !strings.Contains(html, "<strong>hi</strong>") ||
+		strings.Contains(html, "<script>") {
+		t.Fatalf("preview %s", html)
+	}
+	if rec := preview(strings.Repeat("a", previewMaxBytes+1), true); rec.Code == http.StatusOK {
+		t.Fatal("too big body was previewed")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ?name= is the user name, anyone can see profiles
+func profileGet(ctx *gin.Context) {
+	err := profileGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read profile")
+	}
+}
+
+func profileGetInternal(ctx *gin.Context) (err error) {
+	user, err := usersClient.ReadProfile(ctx.Request.Context(), ctx.Query("name"))
+	if err != nil {
+		return
+	}
+	loggedin := confirmLoggedIn(ctx)
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
+	data := gin.H{
+		"navbar": navbar,
+		"user":   user,
+		"state":  getStateFromCTX(ctx),
+	}
+	if sess, err := getSessionPtrFromCTX(ctx); loggedin && err == nil {
+		blocks, err := usersClient.ReadBlocks(ctx.Request.Context(), sess.UserId)
+		if err != nil {
+			return err
+		}
+		blocked := false
+		for _, block := range blocks {
+			if block.BlockedId == user.Id {
+				blocked = true
+				break
+			}
+		}
+		data["canBlock"] = sess.UserId != user.Id
+		data["blocked"] = blocked
+	}
+	ctx.HTML(http.StatusOK, "profile.html", data)
+	return
+}
+
+func blockUserPost(ctx *gin.Context) {
+	blockPostInternal(ctx, true)
+}
+
+func unblockUserPost(ctx *gin.Context) {
+	blockPostInternal(ctx, false)
+}
+
+// form has user_id and name to go back to the profile
+func blockPostInternal(ctx *gin.Context, block bool) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := blockPostProcessInternal(ctx, block)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to change block")
+		return
+	}
+	ctx.Redirect(http.StatusFound, common.ProfileURL(ctx.PostForm("name")))
+}
+
+func blockPostProcessInternal(ctx *gin.Context, block bool) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	blockedId, _ := strconv.ParseUint(ctx.PostForm("user_id"), 10, 64)
+	userBlock := common.UserBlock{
+		UserId:    sess.UserId,
+		BlockedId: uint(blockedId),
+	}
+	if block {
+		err = usersClient.BlockUser(ctx.Request.Context(), &userBlock)
+	} else {
+		err = usersClient.UnblockUser(ctx.Request.Context(), &userBlock)
+	}
+	return
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_MentionAndBlock(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	taro, sess := newTestingUser(t, users, "taro")
+	hanako, _ := newTestingUser(t, users, "hanako")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  taro.Name,
+		UserId: taro.Id,
+	})
+	reply := func(user *common.User, body string) *common.Post {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		sess := &common.Session{UserId: user.Id, UserName: user.Name}
+		post, err := createReplyInternal(ctx, sess, &newReplyRequest{ThreadUuId: thre.UuId, Body: body})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return post
+	}
+
+	post := reply(hanako, "@taro @hanako @nobody see you")
+	if len(post.Mentions) != 1 || post.Mentions[0].UserId != taro.Id {
+		t.Fatalf("mentions %v, want only taro", post.Mentions)
+	}
+	if html := string(post.BodyHTML()); !strings.Contains(html, `href="/user/profile?name=taro"`) ||
+		strings.Contains(html, "name=nobody") {
+		t.Fatalf("body %s", html)
+	}
+
+	engine := newTestingEngine()
+	engine.POST("/user/block", blockUserPost)
+	rec := postWithState(t, engine, sess, nil, "/user/block", url.Values{
+		"user_id": {strconv.FormatUint(uint64(hanako.Id), 10)},
+		"name":    {hanako.Name},
+	})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("block status %d", rec.Code)
+	}
+
+	post = reply(hanako, "@taro are you there?")
+	if len(post.Mentions) != 0 {
+		t.Fatalf("blocked user mentioned %v", post.Mentions)
+	}
+}
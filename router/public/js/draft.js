@@ -0,0 +1,58 @@
+// forms with data-draft="ms" are saved as draft that long after
+// the last key, so the text is back when the form is opened again.
+// sending the form clears the draft on the server.
+(function () {
+  if (!window.fetch) {
+    return;
+  }
+  var fields = ["state", "thread", "topic", "board", "tags", "body"];
+  function watch(form) {
+    var delay = parseInt(form.dataset.draft, 10) || 2000;
+    var status = form.querySelector(".draft-status");
+    var timer = null;
+    function show(text) {
+      if (status) {
+        status.textContent = text;
+      }
+    }
+    function save() {
+      // files can not be kept, only the named text fields are sent
+      var data = new URLSearchParams();
+      for (var i = 0; i < fields.length; i++) {
+        var field = form.elements.namedItem(fields[i]);
+        if (field) {
+          data.set(fields[i], field.value);
+        }
+      }
+      fetch("/thread/draft", {
+        method: "POST",
+        headers: { "Accept": "application/json" },
+        credentials: "same-origin",
+        body: data
+      }).then(function (res) {
+        return res.json().then(function (json) {
+          if (!res.ok) {
+            throw new Error(json.error);
+          }
+          show("draft saved");
+        });
+      }).catch(function (err) {
+        show("draft not saved. " + err.message);
+      });
+    }
+    form.addEventListener("input", function (e) {
+      if (e.target.type === "file") {
+        return;
+      }
+      clearTimeout(timer);
+      timer = setTimeout(save, delay);
+    });
+    form.addEventListener("submit", function () {
+      clearTimeout(timer);
+    });
+  }
+  var forms = document.querySelectorAll("form[data-draft]");
+  for (var i = 0; i < forms.length; i++) {
+    watch(forms[i]);
+  }
+})();
@@ -0,0 +1,33 @@
+// buttons with data-preview="textarea id" data-target="div id"
+// show the rendered body, same as it will be posted.
+(function () {
+  document.addEventListener("click", function (e) {
+    var button = e.target;
+    if (!button.dataset || !button.dataset.preview) {
+      return;
+    }
+    var textarea = document.getElementById(button.dataset.preview);
+    var target = document.getElementById(button.dataset.target);
+    if (!textarea || !target) {
+      return;
+    }
+    var form = new URLSearchParams();
+    form.set("body", textarea.value);
+    fetch("/thread/preview", {
+      method: "POST",
+      credentials: "same-origin",
+      body: form
+    }).then(function (res) {
+      return res.text().then(function (text) {
+        if (res.ok) {
+          // sanitized by the server
+          target.innerHTML = text;
+        } else {
+          target.textContent = text;
+        }
+      });
+    }).catch(function () {
+      target.textContent = "preview is not available now.";
+    });
+  });
+})();
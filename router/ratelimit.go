@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is token bucket per key, refilled every minute.
+// keys are api token uuids, so the map stays small.
+type rateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes one token, retryAfter is set when there is none.
+func (rl *rateLimiter) allow(
+	key string,
+	perMinute int,
+	now time.Time,
+) (ok bool, retryAfter time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	capacity := float64(perMinute)
+	rate := capacity / time.Minute.Seconds()
+	bucket, found := rl.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > capacity {
+		bucket.tokens = capacity
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		retryAfter = time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+		return
+	}
+	bucket.tokens--
+	ok = true
+	return
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// page script asks for json and updates the buttons.
+// without script the form is posted and the thread is shown again.
+func reactPost(ctx *gin.Context) {
+	wantJSON := wantsJSON(ctx)
+	if !confirmLoggedIn(ctx) {
+		if wantJSON {
+			handleFetchErrorInternal(common.NewError(common.CodeUnauthorized, "not logged in", nil), ctx)
+		} else {
+			ctx.Redirect(http.StatusFound, "/user/login")
+		}
+		return
+	}
+	counts, err := reactPostInternal(ctx)
+	if err != nil && wantJSON {
+		handleFetchErrorInternal(err, ctx)
+		return
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to react")
+		return
+	}
+	if wantJSON {
+		ctx.JSON(http.StatusOK, gin.H{"reactions": counts})
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+// state is kept, a page toggles many times
+func reactPostInternal(ctx *gin.Context) (counts []common.ReactionCount, err error) {
+	sess, err := sessionStateVerifyInternal(ctx)
+	if err != nil {
+		return
+	}
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	counts, err = threadsClient.ToggleReaction(ctx.Request.Context(), &common.Reaction{
+		PostUuId: ctx.PostForm("post"),
+		UserId:   sess.UserId,
+		Kind:     ctx.PostForm("kind"),
+	})
+	return
+}
+
+// marks what the viewer gave, buttons are plain when users can not be told
+func markMyReactionsInternal(ctx *gin.Context, thread *common.Thread, posts []common.Post) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	mine, err := threadsClient.ReadUserReactions(ctx.Request.Context(), &common.ReactionQuery{
+		ThreadId: thread.Id,
+		UserId:   sess.UserId,
+	})
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return
+	}
+	common.MarkMyReactions(posts, mine)
+}
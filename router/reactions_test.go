@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_ReactPost(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  user.Name,
+		UserId: user.Id,
+	})
+	post, _ := threads.CreatePost(bg, &common.Post{
+		Body:        "ramen",
+		Contributor: user.Name,
+		UserId:      user.Id,
+		ThreadId:    thre.Id,
+	})
+	state := newTestingState(t, sess)
+
+	engine := newTestingEngine()
+	engine.POST("/thread/react", reactPost)
+	engine.GET("/thread/read", threadGet)
+	react := func(kind string, accept string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"state":  {state},
+			"thread": {thre.PublicURL()},
+			"post":   {post.UuId},
+			"kind":   {kind},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/thread/react", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", accept)
+		return serveTesting(t, engine, req, sess, nil)
+	}
+	counts := func(rec *httptest.ResponseRecorder) []common.ReactionCount {
+		var body struct {
+			Reactions []common.ReactionCount `json:"reactions"`
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d %s", rec.Code, rec.Body.String())
+		}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body.Reactions
+	}
+
+	got := counts(react("like", "application/json"))
+	if len(got) != 1 || got[0].Kind != "like" || got[0].Count != 1 || !got[0].Mine {
+		t.Fatalf("after like %+v", got)
+	}
+	// state is kept, so the same page can toggle again
+	if got := counts(react("like", "application/json")); len(got) != 0 {
+		t.Fatalf("after taking back %+v", got)
+	}
+	if rec := react("angry", "application/json"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown kind status %d", rec.Code)
+	}
+
+	// without script the form posts and comes back to the thread
+	rec := react("love", "text/html")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/thread/read?id=") {
+		t.Fatalf("status %d location %s", rec.Code, rec.Header().Get("Location"))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/thread/read?id="+thre.PublicURL(), nil)
+	rec = serveTesting(t, engine, req, sess, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("thread status %d", rec.Code)
+	}
+	if html := rec.Body.String(); !strings.Contains(html, `btn-primary reaction" type="submit" name="kind" value="love"`) {
+		t.Fatal("own reaction is not marked")
+	}
+}
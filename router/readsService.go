@@ -0,0 +1,69 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// marks every shown post read and answers the number of
+// the first one that was new, 0 when none was or it can not tell
+func markThreadReadInternal(
+	ctx *gin.Context,
+	thre *common.Thread,
+	posts []common.Post,
+	sess *common.Session,
+) uint {
+	previous, err := threadsClient.MarkRead(ctx.Request.Context(), &common.ThreadRead{
+		ThreadId:   thre.Id,
+		UserId:     sess.UserId,
+		LastNumber: common.LastPostNumber(posts),
+	})
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return 0
+	}
+	return common.FirstUnread(posts, previous)
+}
+
+// thread id -> unread posts. nil for guests and while
+// threads service can not tell, lists are shown without badges.
+func unreadCountsInternal(ctx *gin.Context, threads []common.Thread) map[uint]int64 {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if !confirmLoggedIn(ctx) || err != nil || len(threads) == 0 {
+		return nil
+	}
+	query := &common.UnreadQuery{UserId: sess.UserId}
+	for _, thre := range threads {
+		query.ThreadIds = append(query.ThreadIds, thre.Id)
+	}
+	counts, err := threadsClient.CountUnread(ctx.Request.Context(), query)
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return nil
+	}
+	return common.UnreadByThread(counts)
+}
+
+func markAllReadPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := markAllReadPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to mark read")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/")
+}
+
+func markAllReadPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	err = threadsClient.MarkAllRead(ctx.Request.Context(), sess.UserId)
+	return
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_UnreadPosts(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	owner, _ := newTestingUser(t, users, "TestingHanako")
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  owner.Name,
+		UserId: owner.Id,
+	})
+	write := func(body string) *common.Post {
+		post, err := threads.CreatePost(bg, &common.Post{
+			Body:        body,
+			Contributor: owner.Name,
+			UserId:      owner.Id,
+			ThreadId:    thre.Id,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return post
+	}
+	write("ramen")
+	write("udon")
+
+	engine := newTestingEngine()
+	engine.GET("/", GenerateSessionStateMiddleware, indexGet)
+	engine.GET("/thread/read", GenerateSessionStateMiddleware, threadGet)
+	engine.POST("/thread/read-all", markAllReadPost)
+	get := func(path string) string {
+		rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	// marker comes right before the post
+	unreadAt := func(html string, post *common.Post) bool {
+		return strings.Contains(html, `id="unread"><span>new posts</span></div>
+        <div class="panel-body" id="post-`+post.UuId+`"`)
+	}
+
+	if html := get("/"); !strings.Contains(html, "2 new") {
+		t.Fatal("no unread badge")
+	}
+	first := threads.Posts[0]
+	if html := get("/thread/read?id=" + thre.PublicURL()); !unreadAt(html, &first) {
+		t.Fatal("first post is not marked new")
+	}
+	if html := get("/"); strings.Contains(html, " new</span>") || strings.Contains(html, "Mark all read") {
+		t.Fatal("badge after reading")
+	}
+	third := write("soba")
+	if html := get("/"); !strings.Contains(html, "1 new") {
+		t.Fatal("no badge for the new post")
+	}
+	if html := get("/thread/read?id=" + thre.PublicURL()); !unreadAt(html, third) {
+		t.Fatal("new post is not marked")
+	}
+	if html := get("/thread/read?id=" + thre.PublicURL()); strings.Contains(html, `id="unread"`) {
+		t.Fatal("marker after reading all")
+	}
+
+	write("curry")
+	html := get("/")
+	if !strings.Contains(html, "Mark all read") {
+		t.Fatal("no mark all read")
+	}
+	rec := postWithState(t, engine, sess, nil, "/thread/read-all", url.Values{})
+	if rec.Code != http.StatusFound {
+		t.Fatalf("mark all read status %d", rec.Code)
+	}
+	if html := get("/"); strings.Contains(html, " new</span>") {
+		t.Fatal("badge after marking all read")
+	}
+	// one horizon instead of a row per thread
+	for _, read := range threads.ThreadReads {
+		if read.UserId == user.Id {
+			t.Fatalf("row left %+v", read)
+		}
+	}
+}
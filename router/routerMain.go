@@ -2,15 +2,18 @@ package main
 
 import (
 	"learning-web-chatboard2/common"
+	"learning-web-chatboard2/threadsclient"
+	"learning-web-chatboard2/usersclient"
 	"log"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-var httpClient *http.Client
+var usersClient usersclient.Client
+var threadsClient threadsclient.Client
 var config *common.Configuration
 var logger *log.Logger
+var fileStorage common.Storage
 
 func main() {
 	var err error
@@ -43,6 +46,7 @@ func main() {
 	webEngine.GET(
 		"/",
 		VisitCheckMiddleware, LoggedInCheckerMiddleware,
+		GenerateSessionStateMiddleware,
 		indexGet,
 	)
 	webEngine.GET(
@@ -50,6 +54,17 @@ func main() {
 		VisitCheckMiddleware, LoggedInCheckerMiddleware,
 		errorGet,
 	)
+	webEngine.GET(
+		"/search",
+		VisitCheckMiddleware, LoggedInCheckerMiddleware,
+		searchGet,
+	)
+	webEngine.GET(
+		"/tag",
+		VisitCheckMiddleware, LoggedInCheckerMiddleware,
+		tagGet,
+	)
+	webEngine.GET("/files/:key", fileGet)
 
 	usersRoute := webEngine.Group("/user")
 	usersRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
@@ -64,6 +79,20 @@ func main() {
 		signupGet,
 	)
 	usersRoute.GET("logout", logoutGet)
+	usersRoute.GET(
+		"/settings",
+		GenerateSessionStateMiddleware,
+		settingsGet,
+	)
+	usersRoute.GET(
+		"/profile",
+		GenerateSessionStateMiddleware,
+		profileGet,
+	)
+	usersRoute.POST("/block", blockUserPost)
+	usersRoute.POST("/unblock", unblockUserPost)
+	usersRoute.POST("/tokens/create", createTokenPost)
+	usersRoute.POST("/tokens/revoke", revokeTokenPost)
 	usersRoute.POST("/signup-account", signupPost)
 	usersRoute.POST("/authenticate", authenticatePost)
 
@@ -79,9 +108,107 @@ func main() {
 		GenerateSessionStateMiddleware,
 		newThreadGet,
 	)
+	threadsRoute.GET(
+		"/watched",
+		GenerateSessionStateMiddleware,
+		watchedGet,
+	)
+	threadsRoute.GET(
+		"/scheduled",
+		GenerateSessionStateMiddleware,
+		scheduledGet,
+	)
+	threadsRoute.GET("/events", threadEventsGet)
 	threadsRoute.POST("/create", newThreadPost)
 	threadsRoute.POST("/post", newReplyPost)
+	threadsRoute.POST("/preview", previewPost)
+	threadsRoute.POST("/draft", draftPost)
+	threadsRoute.POST("/react", reactPost)
+	threadsRoute.GET("/poll", pollGet)
+	threadsRoute.POST("/vote", votePost)
+	threadsRoute.POST("/subscribe", subscribePost)
+	threadsRoute.POST("/unsubscribe", unsubscribePost)
+	threadsRoute.POST("/read-all", markAllReadPost)
+	threadsRoute.POST("/scheduled/cancel", cancelScheduledPost)
+
+	chatRoute := webEngine.Group("/chat")
+	chatRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	chatRoute.GET(
+		"",
+		GenerateSessionStateMiddleware,
+		chatGet,
+	)
+	chatRoute.GET("/room", chatRoomGet)
+	chatRoute.GET("/socket", chatSocketGet)
+	chatRoute.POST("/create", chatCreatePost)
+
+	webhooksRoute := webEngine.Group("/webhooks")
+	webhooksRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	webhooksRoute.GET(
+		"",
+		GenerateSessionStateMiddleware,
+		webhooksGet,
+	)
+	webhooksRoute.GET("/deliveries", webhookDeliveriesGet)
+	webhooksRoute.POST("/create", createWebhookPost)
+	webhooksRoute.POST("/delete", deleteWebhookPost)
+
+	notificationsRoute := webEngine.Group("/notifications")
+	notificationsRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	notificationsRoute.GET(
+		"",
+		GenerateSessionStateMiddleware,
+		notificationsGet,
+	)
+	notificationsRoute.POST("/read", markNotificationsReadPost)
+
+	bookmarksRoute := webEngine.Group("/bookmarks")
+	bookmarksRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	bookmarksRoute.GET(
+		"",
+		GenerateSessionStateMiddleware,
+		bookmarksGet,
+	)
+	bookmarksRoute.POST("/create", bookmarkPost)
+	bookmarksRoute.POST("/delete", unbookmarkPost)
+
+	messagesRoute := webEngine.Group("/messages")
+	messagesRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	messagesRoute.GET(
+		"",
+		GenerateSessionStateMiddleware,
+		inboxGet,
+	)
+	messagesRoute.GET(
+		"/read",
+		GenerateSessionStateMiddleware,
+		conversationGet,
+	)
+	messagesRoute.POST("/create", createConversationPost)
+	messagesRoute.POST("/send", sendMessagePost)
+
+	boardsRoute := webEngine.Group("/board")
+	boardsRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	boardsRoute.GET("", boardGet)
+	boardsRoute.GET(
+		"/admin",
+		GenerateSessionStateMiddleware,
+		boardsAdminGet,
+	)
+	boardsRoute.POST("/create", createBoardPost)
+	boardsRoute.POST("/update", updateBoardPost)
+	boardsRoute.POST("/delete", deleteBoardPost)
+
+	moderationRoute := webEngine.Group("/moderate")
+	moderationRoute.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	moderationRoute.POST("", moderatePost)
+	moderationRoute.POST("/ban", banUserPost)
+	moderationRoute.POST("/tags", tagsPost)
+
+	setupAPIRoutes(webEngine)
 
-	httpClient = http.DefaultClient
+	usersClient = usersclient.NewHTTPClient(config.AddressUsers, config.UsersClient)
+	threadsClient = threadsclient.NewHTTPClient(config.AddressThreads, config.ThreadsClient)
+	fileStorage = common.NewLocalStorage(config.StoragePath)
 	webEngine.Run(config.AddressRouter)
 }
@@ -14,11 +14,19 @@ const (
 	sessionPtrLabel = "session-ptr"
 	visitPtrLabel   = "visit-ptr"
 	stateLabel      = "state"
+	degradedLabel   = "degraded"
 )
 
 func VisitCheckMiddleware(ctx *gin.Context) {
 	err := visitCheck(ctx)
 	if err != nil {
+		if common.IsUnavailable(err) {
+			// users service is down, keep serving pages read-only
+			common.LogWarning(logger).Printf("users service unavailable %s\n", err.Error())
+			ctx.Set(degradedLabel, true)
+			ctx.Next()
+			return
+		}
 		if gin.IsDebugging() {
 			common.LogError(logger).Fatalln(err.Error())
 		} else {
@@ -30,9 +38,17 @@ func VisitCheckMiddleware(ctx *gin.Context) {
 }
 
 func LoggedInCheckerMiddleware(ctx *gin.Context) {
+	if isDegraded(ctx) {
+		ctx.Set(loggedInLabel, false)
+		ctx.Next()
+		return
+	}
 	err := checkLoggedIn(ctx)
 	if err != nil {
 		common.LogWarning(logger).Println(err.Error())
+		if common.IsUnavailable(err) {
+			ctx.Set(degradedLabel, true)
+		}
 	}
 	ctx.Set(loggedInLabel, err == nil)
 	ctx.Next()
@@ -57,6 +73,12 @@ func GenerateSessionStateMiddleware(ctx *gin.Context) {
 }
 
 func GenerateVisitStateMiddleware(ctx *gin.Context) {
+	if isDegraded(ctx) {
+		errorRedirect(ctx, "login is temporarily unavailable")
+		ctx.Abort()
+		return
+	}
+
 	state, err := generateVisitState(ctx)
 	if err != nil {
 		// safety for invalid cookie
@@ -100,10 +122,15 @@ func confirmLoggedIn(ctx *gin.Context) (isLoggedIn bool) {
 	return
 }
 
+// degraded means some service is down and pages are read-only
+func isDegraded(ctx *gin.Context) bool {
+	return ctx.GetBool(degradedLabel)
+}
+
 func getSessionPtrFromCTX(ctx *gin.Context) (ptr *common.Session, err error) {
 	val, ok := ctx.Get(sessionPtrLabel)
 	if !ok {
-		err = errors.New("not logged in")
+		err = common.NewError(common.CodeUnauthorized, "not logged in", nil)
 		return
 	}
 	if ptr, ok = val.(*common.Session); !ok {
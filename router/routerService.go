@@ -2,14 +2,13 @@ package main
 
 import (
 	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"learning-web-chatboard2/common"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,93 +20,206 @@ const (
       <a class="navbar-brand" href="/">KEIJIBAN</a>
     </div>
     <div class="nav navbar-nav navbar-right">
+      <a href="/search">Search</a>
       <a href="/user/login">Login</a>
     </div>
   </div>
 </div>`
 
-	privateNavbar template.HTML = `<div class="navbar navbar-default navbar-static-top" role="navigation">
+	// %s are the unread badges, notifications then messages
+	privateNavbarFormat = `<div class="navbar navbar-default navbar-static-top" role="navigation">
   <div class="container">
     <div class="navbar-header">
 	  <a class="navbar-brand" href="/">KEIJIBAN</a>
     </div>
     <div class="nav navbar-nav navbar-right">
+	  <a href="/search">Search</a>
+	  <a href="/notifications">Notifications%s</a>
+	  <a href="/messages">Messages%s</a>
+	  <a href="/thread/watched">Watched</a>
+	  <a href="/bookmarks">Bookmarks</a>
+	  <a href="/thread/scheduled">Scheduled</a>
+	  <a href="/chat">Chat</a>
+	  <a href="/user/settings">Settings</a>
 	  <a href="/user/logout">Logout</a>
     </div>
   </div>
 </div>`
+)
 
-	replyForm template.HTML = `<div class="panel panel-info">
+// body is the kept draft, empty without one
+var replyFormTemplate = template.Must(template.New("reply").Parse(`<div class="panel panel-info">
   <div class="panel-body">
-    <form id="post" role="form" action="/thread/post" method="post">
+    <form id="post" role="form" action="/thread/post" method="post" enctype="multipart/form-data" data-draft="{{ .SaveMillis }}">
 	  <div class="form-group">
-	    <textarea class="form-control" name="body" id="body" placeholder="Write your reply here" rows="3"></textarea>
+	    <textarea class="form-control" name="body" id="body" placeholder="Write your reply here, markdown works" rows="3">{{ .Body }}</textarea>
+	     <div id="body-preview" class="post-preview"></div>
+	     <input type="file" name="files" multiple accept="image/jpeg,image/png,image/gif,application/pdf,text/plain">
 	     <br/>
+	     <button class="btn btn-default" type="button" data-preview="body" data-target="body-preview">Preview</button>
+	     <span class="draft-status text-muted"></span>
+	     <label class="publish-at">publish later at <input type="datetime-local" name="publish_at"></label>
 	     <button class="btn btn-primary pull-right" type="submit">Reply</button>
 	  </div>
     </form>
   </div>
-</div>`
-)
+</div>`))
+
+var replyForm = replyFormInternal("")
 
-const httpPrefix = "http://"
+func replyFormInternal(body string) template.HTML {
+	var buf strings.Builder
+	err := replyFormTemplate.Execute(&buf, struct {
+		Body       string
+		SaveMillis int
+	}{body, draftSaveMillis})
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return ""
+	}
+	return template.HTML(buf.String())
+}
+
+// what to tell users for each error code
+var errorHints = map[common.ErrorCode]string{
+	common.CodeBadRequest:   "the request was broken.",
+	common.CodeUnauthorized: "please login and try again.",
+	common.CodeForbidden:    "you are not allowed to do that.",
+	common.CodeNotFound:     "it does not exist or was removed.",
+	common.CodeConflict:     "it already exists.",
+	common.CodeInvalid:      "please check what you entered.",
+	common.CodeRateLimited:  "too many requests. please wait a moment.",
+	common.CodeUnavailable:  "the service is temporarily unavailable. please try again later.",
+	common.CodeInternal:     "something went wrong on our side.",
+}
+
+const expiredFormHint = "the form has expired. please reload the page and try again."
+
+// hint for the code. refusals and rejections say why too,
+// like a ban or a too large file.
+func errorHintInternal(err error) string {
+	if isInvalidState(err) {
+		return expiredFormHint
+	}
+	code := common.ErrorCodeOf(err)
+	hint := errorHints[code]
+	if code == common.CodeForbidden || code == common.CodeInvalid {
+		if reason := common.MessageOf(err); len(reason) > 0 {
+			hint = reason + ". " + hint
+		}
+	}
+	return hint
+}
 
 func handleErrorInternal(
-	loggerErrorMsg string,
+	err error,
 	ctx *gin.Context,
 	publicErrorMsg string,
 ) {
-	common.LogError(logger).Println(loggerErrorMsg)
-	errorRedirect(ctx, publicErrorMsg)
+	common.LogError(logger).Println(err.Error())
+	code := common.ErrorCodeOf(err)
+	renderError(
+		ctx,
+		common.HTTPStatusOf(code),
+		fmt.Sprintf("%s. %s", publicErrorMsg, errorHintInternal(err)),
+	)
+}
+
+// page scripts ask for json and show the hint themselves
+func wantsJSON(ctx *gin.Context) bool {
+	return ctx.GetHeader("Accept") == "application/json"
+}
+
+func handleFetchErrorInternal(err error, ctx *gin.Context) {
+	common.LogError(logger).Println(err.Error())
+	code := common.ErrorCodeOf(err)
+	ctx.JSON(common.HTTPStatusOf(code), gin.H{"error": errorHintInternal(err)})
 }
 
-func getHTMLElemntInternal(isLoggedin bool) (template.HTML, template.HTML) {
+func renderError(ctx *gin.Context, status int, msg string) {
+	navbar, _ := getHTMLElemntInternal(ctx, confirmLoggedIn(ctx))
+	ctx.HTML(
+		status,
+		"error.html",
+		gin.H{
+			"navbar": navbar,
+			"msg":    msg,
+		},
+	)
+}
+
+func getHTMLElemntInternal(ctx *gin.Context, isLoggedin bool) (template.HTML, template.HTML) {
 	if isLoggedin {
-		return privateNavbar, replyForm
+		return privateNavbarInternal(ctx), replyForm
 	} else {
 		return publicNavbar, ""
 	}
 }
 
+// badges are left out when users service can not tell,
+// pages should not fail for them
+func privateNavbarInternal(ctx *gin.Context) template.HTML {
+	badge, messagesBadge := "", ""
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err == nil {
+		unread, err := usersClient.CountUnreadNotifications(ctx.Request.Context(), sess.UserId)
+		if err == nil && unread > 0 {
+			badge = fmt.Sprintf(` <span class="badge">%d</span>`, unread)
+		}
+		unread, err = usersClient.CountUnreadMessages(ctx.Request.Context(), sess.UserId)
+		if err == nil && unread > 0 {
+			messagesBadge = fmt.Sprintf(` <span class="badge">%d</span>`, unread)
+		}
+	}
+	return template.HTML(fmt.Sprintf(privateNavbarFormat, badge, messagesBadge))
+}
+
 func indexGet(ctx *gin.Context) {
-	thres, err := indexGetInternal(ctx)
-	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to read thread")
+	var notice string
+	thres, boards, tags, err := indexGetInternal(ctx)
+	if common.IsUnavailable(err) {
+		common.LogWarning(logger).Printf("threads service unavailable %s\n", err.Error())
+		notice = "threads are temporarily unavailable. please try again later."
+	} else if err != nil {
+		handleErrorInternal(err, ctx, "failed to read thread")
 		return
+	} else if isDegraded(ctx) {
+		notice = "login is temporarily unavailable. threads are read-only for now."
 	}
-	navbar, _ := getHTMLElemntInternal(confirmLoggedIn(ctx))
+	loggedin := confirmLoggedIn(ctx)
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
 	ctx.HTML(
 		http.StatusOK,
 		"index.html",
 		gin.H{
-			"navbar":  navbar,
-			"threads": thres,
+			"navbar":   navbar,
+			"threads":  thres,
+			"boards":   boards,
+			"cloud":    weighTagsInternal(tags),
+			"notice":   notice,
+			"readonly": len(notice) > 0,
+			"loggedin": loggedin,
+			"state":    getStateFromCTX(ctx),
+			"unread":   unreadCountsInternal(ctx, thres),
 		},
 	)
 }
 
-func indexGetInternal(ctx *gin.Context) (threads []common.Thread, err error) {
-	req, err := http.NewRequest(
-		http.MethodGet,
-		buildHTTP_URL(config.AddressThreads, "/read-index"),
-		nil,
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
+func indexGetInternal(ctx *gin.Context) (
+	threads []common.Thread,
+	boards []common.Board,
+	tags []common.TagCount,
+	err error,
+) {
+	boards, err = threadsClient.ListBoards(ctx.Request.Context())
 	if err != nil {
 		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
 	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+	tags, err = threadsClient.ListTags(ctx.Request.Context(), indexTagCloudSize)
 	if err != nil {
 		return
 	}
-	err = json.Unmarshal(body, &threads)
+	threads, err = threadsClient.ListThreads(ctx.Request.Context())
 	return
 }
 
@@ -117,22 +229,13 @@ func errorRedirect(ctx *gin.Context, msg string) {
 		fmt.Sprintf(
 			"%s%s",
 			"/error?msg=",
-			msg,
+			url.QueryEscape(msg),
 		),
 	)
 }
 
 func errorGet(ctx *gin.Context) {
-	errMsg := ctx.Query("msg")
-	navbar, _ := getHTMLElemntInternal(confirmLoggedIn(ctx))
-	ctx.HTML(
-		http.StatusOK,
-		"error.html",
-		gin.H{
-			"navbar": navbar,
-			"msg":    errMsg,
-		},
-	)
+	renderError(ctx, http.StatusOK, ctx.Query("msg"))
 }
 
 func loginGet(ctx *gin.Context) {
@@ -161,7 +264,7 @@ func logoutGet(ctx *gin.Context) {
 	if confirmLoggedIn(ctx) {
 		err := logoutGetInternal(ctx)
 		if err != nil {
-			handleErrorInternal(err.Error(), ctx, "failed to logout")
+			handleErrorInternal(err, ctx, "failed to logout")
 			return
 		}
 	}
@@ -173,25 +276,14 @@ func logoutGetInternal(ctx *gin.Context) (err error) {
 	if err != nil {
 		return
 	}
-	req, err := common.MakeRequestFromSession(
-		sess,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/delete-session"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-	}
+	err = usersClient.DeleteSession(ctx.Request.Context(), sess)
 	return
 }
 
 func signupPost(ctx *gin.Context) {
 	err := signupPostInternal(ctx)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to sign-up")
+		handleErrorInternal(err, ctx, "failed to sign-up")
 		return
 	}
 	ctx.Redirect(http.StatusFound, "/user/login")
@@ -209,26 +301,14 @@ func signupPostInternal(ctx *gin.Context) (err error) {
 		Email:    ctx.PostForm("email"),
 		Password: pw,
 	}
-
-	req, err := common.MakeRequestFromUser(
-		&newUser,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/signup-account"),
-	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-	}
+	_, err = usersClient.SignupAccount(ctx.Request.Context(), &newUser)
 	return
 }
 
 func authenticatePost(ctx *gin.Context) {
 	err := authenticatePostInternal(ctx)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to authenticate")
+		handleErrorInternal(err, ctx, "failed to authenticate")
 		return
 	}
 	ctx.Redirect(http.StatusFound, "/")
@@ -240,31 +320,19 @@ func authenticatePostInternal(ctx *gin.Context) (err error) {
 		return
 	}
 
-	authUser := common.User{
-		Email: ctx.PostForm("email"),
-	}
-	req, err := common.MakeRequestFromUser(
-		&authUser,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/authenticate"),
+	authedUser, err := usersClient.Authenticate(
+		ctx.Request.Context(),
+		ctx.PostForm("email"),
 	)
-	if err != nil {
-		return
-	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
+	if common.ErrorCodeOf(err) == common.CodeNotFound {
+		err = common.NewError(common.CodeUnauthorized, "unknown email", err)
 		return
-	}
-	authedUser, err := common.MakeUserFromResponse(res)
-	if err != nil {
+	} else if err != nil {
 		return
 	}
 	pw := processPassword(ctx.PostForm("password"))
 	if strings.Compare(authedUser.Password, pw) != 0 {
-		err = errors.New("password mismatch")
+		err = common.NewError(common.CodeUnauthorized, "password mismatch", nil)
 		return
 	}
 
@@ -273,35 +341,12 @@ func authenticatePostInternal(ctx *gin.Context) (err error) {
 		UserName: authedUser.Name,
 		UserId:   authedUser.Id,
 	}
-	req, err = common.MakeRequestFromSession(
-		&delSess,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/delete-session"),
-	)
+	err = usersClient.DeleteSession(ctx.Request.Context(), &delSess)
 	if err != nil {
 		return
 	}
-	res, err = httpClient.Do(req)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-	}
 
-	req, err = common.MakeRequestFromUser(
-		authedUser,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressUsers, "/create-session"),
-	)
-	if err != nil {
-		return
-	}
-	res, err = httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	session, err := common.MakeSessionFromResponse(res)
+	session, err := usersClient.CreateSession(ctx.Request.Context(), authedUser)
 	if err != nil {
 		return
 	}
@@ -312,82 +357,122 @@ func authenticatePostInternal(ctx *gin.Context) (err error) {
 }
 
 func threadGet(ctx *gin.Context) {
-	thre, posts, err := threadGetInternal(ctx)
+	threadPageInternal(ctx, ctx.Query("id"), nil, formNoticeFromQueryInternal(ctx))
+}
+
+// sent is a reply form sent back to the user, nil shows the draft if any
+func threadPageInternal(ctx *gin.Context, id string, sent *common.Draft, notice formNotice) {
+	thre, posts, lastEvent, err := threadGetInternal(ctx, id)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to read thread")
+		handleErrorInternal(err, ctx, "failed to read thread")
 		return
 	}
 
-	navbar, reply := getHTMLElemntInternal(confirmLoggedIn(ctx))
+	loggedin := confirmLoggedIn(ctx)
+	navbar, reply := getHTMLElemntInternal(ctx, loggedin)
+	if thre.Locked {
+		reply = ""
+	}
 	state := getStateFromCTX(ctx)
+	// breadcrumb is left out when it can not be read
+	board, err := threadsClient.ReadBoard(ctx.Request.Context(), &common.Board{Id: thre.BoardId})
+	if err != nil {
+		board = nil
+	}
+	isOwner := false
+	isModerator := false
+	watching, showWatch := false, false
+	var firstUnread uint
+	var bookmarks map[uint]uint
+	if sess, err := getSessionPtrFromCTX(ctx); loggedin && err == nil {
+		isOwner = sess.UserId == thre.UserId
+		watching, showWatch = watchingInternal(ctx, thre, sess)
+		firstUnread = markThreadReadInternal(ctx, thre, posts, sess)
+		bookmarks = myBookmarksInternal(ctx, thre, sess)
+		// no controls when users service can not tell
+		role := common.RoleMember
+		if user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId); err == nil {
+			isModerator = user.IsModerator()
+			role = user.Role
+		}
+		if board != nil && !common.RoleAtLeast(role, board.PostRole) {
+			reply = ""
+		}
+		markMyReactionsInternal(ctx, thre, posts)
+		if len(reply) > 0 && sent != nil {
+			reply = replyFormInternal(sent.Body)
+		} else if len(reply) > 0 {
+			if draft := formDraftInternal(ctx, sess, thre.Id); draft != nil {
+				reply = replyFormInternal(draft.Body)
+			}
+		}
+	}
+	poll := threadPollInternal(ctx, thre)
+	pollClosed := poll != nil && poll.IsClosed(time.Now())
 
 	ctx.HTML(
 		http.StatusOK,
 		"thread.html",
 		gin.H{
-			"navbar": navbar,
-			"thread": thre,
-			"reply":  reply,
-			"posts":  posts,
-			"state":  state,
+			"navbar":            navbar,
+			"thread":            thre,
+			"board":             board,
+			"reply":             reply,
+			"posts":             posts,
+			"state":             state,
+			"isOwner":           isOwner,
+			"isModerator":       isModerator,
+			"loggedin":          loggedin,
+			"notice":            notice,
+			"watching":          watching,
+			"showWatch":         showWatch,
+			"firstUnread":       firstUnread,
+			"bookmarks":         bookmarks,
+			"poll":              poll,
+			"pollClosed":        pollClosed,
+			"canVote":           loggedin && poll != nil && !pollClosed && !poll.HasVoted(),
+			"pollRefreshMillis": pollRefreshMillis,
+			// buttons for posts that come live
+			"reactionBar": (&common.Post{}).ReactionBar(),
+			// page follows new posts from here
+			"lastEventId": lastEvent.Id,
 		},
 	)
 }
 
-func threadGetInternal(ctx *gin.Context) (thread *common.Thread, posts []common.Post, err error) {
-	base64_uuid := ctx.Query("id")
+func threadGetInternal(ctx *gin.Context, base64_uuid string) (
+	thread *common.Thread,
+	posts []common.Post,
+	lastEvent *common.ThreadEvent,
+	err error,
+) {
 	bytes, err := base64.URLEncoding.DecodeString(base64_uuid)
 	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
 		return
 	}
 	uuid := string(bytes)
 
-	thre := common.Thread{UuId: uuid}
-	req, err := common.MakeRequestFromThread(
-		&thre,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/read"),
-	)
+	thread, err = threadsClient.ReadThread(ctx.Request.Context(), uuid)
 	if err != nil {
 		return
 	}
-	res, err := httpClient.Do(req)
+	// read before posts, so nothing is lost in between.
+	// a post may come twice but page ignores known ones.
+	lastEvent, err = threadsClient.ReadLastEvent(ctx.Request.Context(), thread)
 	if err != nil {
 		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
 	}
-	thread, err = common.MakeThreadFromResponse(res)
+	posts, err = threadsClient.ReadPosts(ctx.Request.Context(), thread)
 	if err != nil {
 		return
 	}
+	common.IndexReplies(posts)
 
-	req, err = common.MakeRequestFromThread(
-		thread,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/read-posts"),
-	)
-	if err != nil {
-		return
-	}
-	res, err = httpClient.Do(req)
-	if err != nil {
-		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
+	// no visit to store into, page is read-only anyway
+	if isDegraded(ctx) {
 		return
 	}
-	err = json.Unmarshal(body, &posts)
-	if err != nil {
-		return
-	}
-
 	// store thread info into visit
 	vis, err := getVisitPtrFromCTX(ctx)
 	if err != nil {
@@ -395,26 +480,53 @@ func threadGetInternal(ctx *gin.Context) (thread *common.Thread, posts []common.
 	}
 	vis.ThreadId = thread.Id
 	vis.ThreadUuId = thread.UuId
-	err = requestVisitUpdate(vis)
+	_, err = usersClient.UpdateVisit(ctx.Request.Context(), vis)
 	return
 }
 
 func newThreadGet(ctx *gin.Context) {
-	loggedin := confirmLoggedIn(ctx)
-	navbar, _ := getHTMLElemntInternal(loggedin)
-	state := getStateFromCTX(ctx)
-	if loggedin {
-		ctx.HTML(
-			http.StatusOK,
-			"newthread.html",
-			gin.H{
-				"navbar": navbar,
-				"state":  state,
-			},
-		)
-	} else {
+	if !confirmLoggedIn(ctx) {
 		ctx.Redirect(http.StatusFound, "/user/login")
+		return
 	}
+	newThreadPageInternal(ctx, nil, formNoticeFromQueryInternal(ctx))
+}
+
+// sent is a form sent back to the user, nil shows the draft if any
+func newThreadPageInternal(ctx *gin.Context, sent *common.Draft, notice formNotice) {
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	state := getStateFromCTX(ctx)
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read boards")
+		return
+	}
+	boards, err := threadBoardsInternal(ctx, sess)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read boards")
+		return
+	}
+	board := ctx.Query("board")
+	draft := sent
+	if draft == nil {
+		draft = formDraftInternal(ctx, sess, 0)
+	}
+	if draft != nil && len(board) == 0 {
+		board = draft.Board
+	}
+	ctx.HTML(
+		http.StatusOK,
+		"newthread.html",
+		gin.H{
+			"navbar":          navbar,
+			"state":           state,
+			"boards":          boards,
+			"board":           board,
+			"draft":           draft,
+			"draftSaveMillis": draftSaveMillis,
+			"notice":          notice,
+		},
+	)
 }
 
 func newThreadPost(ctx *gin.Context) {
@@ -423,57 +535,149 @@ func newThreadPost(ctx *gin.Context) {
 		return
 	}
 
-	err := newThreadPostInternal(ctx)
+	later, err := newThreadPostInternal(ctx)
+	if err != nil && keepExpiredFormInternal(ctx, err) {
+		return
+	}
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to post thread")
+		handleErrorInternal(err, ctx, "failed to post thread")
+		return
+	}
+	if later {
+		ctx.Redirect(http.StatusFound, "/thread/scheduled")
 		return
 	}
 
 	ctx.Redirect(http.StatusFound, "/")
 }
 
-func newThreadPostInternal(ctx *gin.Context) (err error) {
+// later is true when the thread was scheduled instead
+func newThreadPostInternal(ctx *gin.Context) (later bool, err error) {
+	err = parseUploadFormInternal(ctx)
+	if err != nil {
+		return
+	}
 	sess, err := sessionStateCheckProcess(ctx)
 	if err != nil {
 		return
 	}
+	publishAt, later, err := publishAtFromFormInternal(ctx)
+	if err != nil {
+		return
+	}
+	if later {
+		err = scheduleThreadInternal(ctx, sess, publishAt)
+		return
+	}
+	// stored before the thread, a bad file should not leave an empty thread
+	attachments, err := storeAttachmentsInternal(ctx)
+	if err != nil {
+		return
+	}
 
-	thre := common.Thread{
-		Topic:  ctx.PostForm("topic"),
-		Owner:  sess.UserName,
-		UserId: sess.UserId,
-	}
-	req, err := common.MakeRequestFromThread(
-		&thre,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/create"),
-	)
+	poll, err := pollFromFormInternal(ctx)
+	if err != nil {
+		return
+	}
+	created, err := createThreadInternal(ctx, sess, &newThreadRequest{
+		Topic:     ctx.PostForm("topic"),
+		BoardSlug: ctx.PostForm("board"),
+		Tags:      common.SplitTags(ctx.PostForm("tags")),
+		Poll:      poll,
+		FromForm:  true,
+	})
 	if err != nil {
 		return
 	}
-	res, err := httpClient.Do(req)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
+	// first post is optional
+	if body := ctx.PostForm("body"); len(strings.TrimSpace(body)) > 0 || len(attachments) > 0 {
+		_, err = createReplyInternal(ctx, sess, &newReplyRequest{
+			ThreadUuId:  created.UuId,
+			Body:        body,
+			Attachments: attachments,
+		})
 	}
 	return
 }
 
+// what the form or the api asks for. left zero is left out,
+// so callers don't change when a field is added.
+type newThreadRequest struct {
+	Topic string
+	// empty is the default board, threads service picks it
+	BoardSlug string
+	// threads service normalises them
+	Tags []string
+	Poll *common.Poll
+	// draft of the form goes away, api leaves it
+	FromForm bool
+}
+
+type newReplyRequest struct {
+	ThreadUuId  string
+	Body        string
+	Attachments []common.Attachment
+	FromForm    bool
+}
+
+// shared by form and api
+func createThreadInternal(
+	ctx *gin.Context,
+	sess *common.Session,
+	req *newThreadRequest,
+) (created *common.Thread, err error) {
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	thre := common.Thread{
+		Topic:      req.Topic,
+		Owner:      sess.UserName,
+		UserId:     sess.UserId,
+		Tags:       req.Tags,
+		Poll:       req.Poll,
+		ClearDraft: req.FromForm,
+	}
+	if len(req.BoardSlug) > 0 {
+		var board *common.Board
+		board, err = threadsClient.ReadBoard(ctx.Request.Context(), &common.Board{Slug: req.BoardSlug})
+		if err != nil {
+			return
+		}
+		thre.BoardId = board.Id
+	}
+	created, err = threadsClient.CreateThread(ctx.Request.Context(), &thre)
+	return
+}
+
 func newReplyPost(ctx *gin.Context) {
 	if !confirmLoggedIn(ctx) {
 		ctx.Redirect(http.StatusFound, "/user/login")
 		return
 	}
 
-	threUuId, err := newReplyPostInternal(ctx)
+	threUuId, later, err := newReplyPostInternal(ctx)
+	if err != nil && keepExpiredFormInternal(ctx, err) {
+		return
+	}
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx, "failed to reply")
+		handleErrorInternal(err, ctx, "failed to reply")
+		return
+	}
+	if later {
+		ctx.Redirect(http.StatusFound, "/thread/scheduled")
 		return
 	}
 	encoded := encode([]byte(threUuId))
 	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", encoded))
 }
 
-func newReplyPostInternal(ctx *gin.Context) (threUuId string, err error) {
+// later is true when the reply was scheduled instead
+func newReplyPostInternal(ctx *gin.Context) (threUuId string, later bool, err error) {
+	err = parseUploadFormInternal(ctx)
+	if err != nil {
+		return
+	}
 	sess, err := sessionStateCheckProcess(ctx)
 	if err != nil {
 		return
@@ -481,66 +685,62 @@ func newReplyPostInternal(ctx *gin.Context) (threUuId string, err error) {
 
 	// pick up thread info from visit
 	vis, err := getVisitPtrFromCTX(ctx)
-	threId := vis.ThreadId
-	threUuId = vis.ThreadUuId
-
-	body := ctx.PostForm("body")
-
-	post := common.Post{
-		Body:        body,
-		Contributor: sess.UserName,
-		UserId:      sess.UserId,
-		ThreadId:    threId,
-	}
-	req, err := common.MakeRequestFromPost(
-		&post,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/create-post"),
-	)
 	if err != nil {
 		return
 	}
-	res, err := httpClient.Do(req)
+	threUuId = vis.ThreadUuId
+
+	publishAt, later, err := publishAtFromFormInternal(ctx)
 	if err != nil {
 		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
+	}
+	if later {
+		err = scheduleReplyInternal(ctx, sess, vis.ThreadId, publishAt)
 		return
 	}
 
-	thre := common.Thread{UuId: threUuId}
-	req, err = common.MakeRequestFromThread(
-		&thre,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/read"),
-	)
+	attachments, err := storeAttachmentsInternal(ctx)
 	if err != nil {
 		return
 	}
-	res, err = httpClient.Do(req)
+	_, err = createReplyInternal(ctx, sess, &newReplyRequest{
+		ThreadUuId:  threUuId,
+		Body:        ctx.PostForm("body"),
+		Attachments: attachments,
+		FromForm:    true,
+	})
+	return
+}
+
+// shared by form and api
+func createReplyInternal(
+	ctx *gin.Context,
+	sess *common.Session,
+	req *newReplyRequest,
+) (created *common.Post, err error) {
+	err = checkNotBannedInternal(ctx, sess)
 	if err != nil {
 		return
-	} else if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return
 	}
-	threPtr, err := common.MakeThreadFromResponse(res)
+	threPtr, err := threadsClient.ReadThread(ctx.Request.Context(), req.ThreadUuId)
 	if err != nil {
 		return
 	}
-	threPtr.NumReplies++
 
-	req, err = common.MakeRequestFromThread(
-		threPtr,
-		http.MethodPost,
-		buildHTTP_URL(config.AddressThreads, "/update"),
-	)
+	post := common.Post{
+		Body:        req.Body,
+		Contributor: sess.UserName,
+		UserId:      sess.UserId,
+		ThreadId:    threPtr.Id,
+		Attachments: req.Attachments,
+		ClearDraft:  req.FromForm,
+	}
+	created, err = threadsClient.CreatePost(ctx.Request.Context(), &post)
 	if err != nil {
 		return
 	}
-	res, err = httpClient.Do(req)
-	if err == nil && res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-	}
+
+	threPtr.NumReplies++
+	_, err = threadsClient.UpdateThread(ctx.Request.Context(), threPtr)
 	return
 }
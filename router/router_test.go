@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"learning-web-chatboard2/threadsclient"
+	"learning-web-chatboard2/usersclient"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupTesting(t *testing.T) (*usersclient.Fake, *threadsclient.Fake) {
+	gin.SetMode(gin.TestMode)
+	logger = log.Default()
+	config = &common.Configuration{AddressRouter: "localhost:8080"}
+	err := startHelper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	users := usersclient.NewFake()
+	threads := threadsclient.NewFake()
+	threads.Users = users
+	usersClient = users
+	threadsClient = threads
+	fileStorage = common.NewLocalStorage(t.TempDir())
+	// fakes start ids from 1 again, buckets must not carry over
+	apiRateLimiter = newRateLimiter()
+	chatRateLimiter = newRateLimiter()
+	return users, threads
+}
+
+func makeTestingCookie(
+	t *testing.T,
+	store func(*gin.Context, string) error,
+	value string,
+) *http.Cookie {
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	err := store(ctx, value)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected 1 cookie, got %d", len(cookies))
+	}
+	return cookies[0]
+}
+
+// signs name up and logs them in
+func newTestingUser(t *testing.T, users *usersclient.Fake, name string) (*common.User, *common.Session) {
+	bg := context.Background()
+	user, err := users.SignupAccount(bg, &common.User{
+		Name:     name,
+		Email:    name + "@go.com",
+		Password: processPassword(name + "Testing0721"),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	sess, err := users.CreateSession(bg, user)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return user, sess
+}
+
+// templates and the middlewares of pages, routes are up to the test
+func newTestingEngine() *gin.Engine {
+	engine := gin.New()
+	engine.LoadHTMLGlob("./templates/*")
+	engine.Use(VisitCheckMiddleware, LoggedInCheckerMiddleware)
+	return engine
+}
+
+// state a page would have given sess just now, for the form
+func newTestingState(t *testing.T, sess *common.Session) string {
+	bg := context.Background()
+	stored, err := usersClient.CheckSession(bg, sess.UuId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var state string
+	stored.State, state, err = generateState(stored.UuId)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = usersClient.UpdateSession(bg, stored)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return state
+}
+
+// with cookies of sess and vis, either may be nil
+func serveTesting(
+	t *testing.T,
+	engine http.Handler,
+	req *http.Request,
+	sess *common.Session,
+	vis *common.Visit,
+) *httptest.ResponseRecorder {
+	if sess != nil {
+		req.AddCookie(makeTestingCookie(t, storeSessionCookie, sess.UuId))
+	}
+	if vis != nil {
+		req.AddCookie(makeTestingCookie(t, storeVisitCookie, vis.UuId))
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+// form posted from a page sess opened just now
+func postWithState(
+	t *testing.T,
+	engine http.Handler,
+	sess *common.Session,
+	vis *common.Visit,
+	path string,
+	form url.Values,
+) *httptest.ResponseRecorder {
+	form.Set("state", newTestingState(t, sess))
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serveTesting(t, engine, req, sess, vis)
+}
+
+func Test_CheckLoggedIn(t *testing.T) {
+	users, _ := setupTesting(t)
+	user, sess := newTestingUser(t, users, "TestingTaro")
+
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request.AddCookie(makeTestingCookie(t, storeSessionCookie, sess.UuId))
+
+	err := checkLoggedIn(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stored, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if stored.UserId != user.Id {
+		t.Fatalf("session of user %d is stored, want %d", stored.UserId, user.Id)
+	}
+}
+
+func Test_NewReplyPost(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, err := threads.CreateThread(bg, &common.Thread{
+		Topic:  "I want eat meat pretty much.",
+		Owner:  user.Name,
+		UserId: user.Id,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// user opened the thread
+	vis, _ := users.CreateVisit(bg)
+	vis.ThreadId = thre.Id
+	vis.ThreadUuId = thre.UuId
+	users.UpdateVisit(bg, vis)
+
+	engine := newTestingEngine()
+	engine.POST("/thread/post", newReplyPost)
+	rec := postWithState(t, engine, sess, vis, "/thread/post", url.Values{"body": {"me too"}})
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); !strings.HasPrefix(loc, "/thread/read?id=") {
+		t.Fatalf("redirected to %s", loc)
+	}
+	if len(threads.Posts) != 1 || threads.Posts[0].Body != "me too" {
+		t.Fatalf("post not created %v", threads.Posts)
+	}
+	updated, _ := threads.ReadThread(bg, thre.UuId)
+	if updated.NumReplies != 1 {
+		t.Fatalf("num replies %d", updated.NumReplies)
+	}
+}
+
+func Test_IndexDegraded(t *testing.T) {
+	users, _ := setupTesting(t)
+	users.Down = true
+
+	engine := newTestingEngine()
+	engine.GET("/", indexGet)
+	rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, "/", nil), nil, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "read-only") {
+		t.Fatal("read-only notice is not shown")
+	}
+	if strings.Contains(rec.Body.String(), "/thread/new") {
+		t.Fatal("start a thread link is shown")
+	}
+}
+
+func Test_ThreadNotFound(t *testing.T) {
+	setupTesting(t)
+
+	engine := newTestingEngine()
+	engine.GET("/thread/read", threadGet)
+	id := encode([]byte("no-such-uuid"))
+	rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, "/thread/read?id="+id, nil), nil, nil)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d", rec.Code)
+	}
+}
+
+func Test_ReferredNumbers(t *testing.T) {
+	_, threads := setupTesting(t)
+	bg := context.Background()
+	thre, _ := threads.CreateThread(bg, &common.Thread{Topic: "numbers", Owner: "taro", UserId: 1})
+	var post *common.Post
+	for _, body := range []string{"first", "second", ">>1-2 >>1 >>3 >>9"} {
+		post, _ = threads.CreatePost(bg, &common.Post{
+			Body:        body,
+			Contributor: "taro",
+			UserId:      1,
+			ThreadId:    thre.Id,
+		})
+	}
+	if post.Number != 3 {
+		t.Fatalf("number %d, want 3", post.Number)
+	}
+	refs := referredNumbersInternal(post)
+	if len(refs) != 2 || refs[0] != 1 || refs[1] != 2 {
+		t.Fatalf("refs %v, want [1 2]", refs)
+	}
+}
+
+func Test_ErrorHint(t *testing.T) {
+	expired := common.NewError(common.CodeForbidden, invalidStateMessage, nil)
+	if errorHintInternal(expired) != expiredFormHint {
+		t.Fatalf("expired form hint %q", errorHintInternal(expired))
+	}
+	banned := &common.StatusError{
+		StatusCode: http.StatusForbidden,
+		Code:       common.CodeForbidden,
+		Message:    "banned until 2026/Oct/20 at 3:04pm",
+	}
+	if hint := errorHintInternal(banned); !strings.HasPrefix(hint, "banned until") || hint == expiredFormHint {
+		t.Fatalf("ban hint %q", hint)
+	}
+	tooLarge := common.NewError(common.CodeInvalid, "file is too large", nil)
+	if hint := errorHintInternal(tooLarge); !strings.HasPrefix(hint, "file is too large") {
+		t.Fatalf("invalid hint %q", hint)
+	}
+	if hint := errorHintInternal(common.NewError(common.CodeNotFound, "no such thread", nil)); hint != errorHints[common.CodeNotFound] {
+		t.Fatalf("not found hint %q", hint)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const scheduledPageSize = 20
+
+// threads and replies the user scheduled, latest publish time first
+func scheduledGet(ctx *gin.Context) {
+	loggedin := confirmLoggedIn(ctx)
+	if !loggedin {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	page, scheduled, err := scheduledGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read scheduled posts")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
+	data := gin.H{
+		"navbar":    navbar,
+		"state":     getStateFromCTX(ctx),
+		"scheduled": scheduled.Scheduled,
+		"total":     scheduled.Total,
+	}
+	if page > 1 {
+		data["prevPage"] = page - 1
+	}
+	if int64(page*scheduledPageSize) < scheduled.Total {
+		data["nextPage"] = page + 1
+	}
+	ctx.HTML(http.StatusOK, "scheduled.html", data)
+}
+
+func scheduledGetInternal(ctx *gin.Context) (page int, scheduled *common.ScheduledPage, err error) {
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	scheduled, err = threadsClient.ListScheduledPage(
+		ctx.Request.Context(),
+		sess.UserId,
+		(page-1)*scheduledPageSize,
+		scheduledPageSize,
+	)
+	return
+}
+
+// form has state and id, only pending ones can be cancelled
+func cancelScheduledPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := cancelScheduledPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to cancel")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/thread/scheduled")
+}
+
+func cancelScheduledPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseUint(ctx.PostForm("id"), 10, 32)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "invalid id", err)
+		return
+	}
+	_, err = threadsClient.CancelScheduled(ctx.Request.Context(), &common.ScheduledPost{
+		Id:     uint(id),
+		UserId: sess.UserId,
+	})
+	return
+}
+
+// later is false when the form has no publish time, it is posted now then.
+// same input as poll close time.
+func publishAtFromFormInternal(ctx *gin.Context) (publishAt time.Time, later bool, err error) {
+	raw := ctx.PostForm("publish_at")
+	if len(raw) == 0 {
+		return
+	}
+	publishAt, err = time.ParseInLocation(pollTimeLayout, raw, time.Local)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "invalid publish time", err)
+		return
+	}
+	later = true
+	return
+}
+
+// bans are checked now for a quick answer and again when published
+func scheduleInternal(ctx *gin.Context, sess *common.Session, scheduled *common.ScheduledPost) (err error) {
+	if ctx.Request.MultipartForm != nil {
+		for _, header := range ctx.Request.MultipartForm.File["files"] {
+			if header.Size > 0 || len(header.Filename) > 0 {
+				err = common.NewError(common.CodeInvalid, "files can not be scheduled", nil)
+				return
+			}
+		}
+	}
+	err = checkNotBannedInternal(ctx, sess)
+	if err != nil {
+		return
+	}
+	scheduled.UserId = sess.UserId
+	scheduled.UserName = sess.UserName
+	_, err = threadsClient.CreateScheduled(ctx.Request.Context(), scheduled)
+	return
+}
+
+func scheduleThreadInternal(ctx *gin.Context, sess *common.Session, publishAt time.Time) (err error) {
+	if len(strings.TrimSpace(ctx.PostForm("poll_question"))) > 0 ||
+		len(strings.TrimSpace(ctx.PostForm("poll_options"))) > 0 {
+		err = common.NewError(common.CodeInvalid, "polls can not be scheduled", nil)
+		return
+	}
+	scheduled := &common.ScheduledPost{
+		Topic:     ctx.PostForm("topic"),
+		Tags:      common.SplitTags(ctx.PostForm("tags")),
+		Body:      ctx.PostForm("body"),
+		PublishAt: publishAt,
+	}
+	if slug := ctx.PostForm("board"); len(slug) > 0 {
+		var board *common.Board
+		board, err = threadsClient.ReadBoard(ctx.Request.Context(), &common.Board{Slug: slug})
+		if err != nil {
+			return
+		}
+		scheduled.BoardId = board.Id
+	}
+	err = scheduleInternal(ctx, sess, scheduled)
+	return
+}
+
+func scheduleReplyInternal(ctx *gin.Context, sess *common.Session, threadId uint, publishAt time.Time) (err error) {
+	err = scheduleInternal(ctx, sess, &common.ScheduledPost{
+		ThreadId:  threadId,
+		Body:      ctx.PostForm("body"),
+		PublishAt: publishAt,
+	})
+	return
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Scheduled(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "weekly meeting",
+		Owner:  user.Name,
+		UserId: user.Id,
+	})
+	vis, _ := users.CreateVisit(bg)
+	vis.ThreadId = thre.Id
+	vis.ThreadUuId = thre.UuId
+	users.UpdateVisit(bg, vis)
+
+	engine := newTestingEngine()
+	engine.GET("/thread/scheduled", GenerateSessionStateMiddleware, scheduledGet)
+	engine.POST("/thread/post", newReplyPost)
+	engine.POST("/thread/create", newThreadPost)
+	engine.POST("/thread/scheduled/cancel", cancelScheduledPost)
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		return postWithState(t, engine, sess, vis, path, form)
+	}
+	later := time.Now().Add(time.Hour).Format(pollTimeLayout)
+
+	rec := post("/thread/post", url.Values{
+		"body":       {"agenda for today"},
+		"publish_at": {later},
+	})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/thread/scheduled" {
+		t.Fatalf("schedule reply status %d to %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(threads.Posts) != 0 || len(threads.Scheduled) != 1 {
+		t.Fatalf("posts %v scheduled %+v", threads.Posts, threads.Scheduled)
+	}
+	if s := threads.Scheduled[0]; s.ThreadId != thre.Id || s.UserId != user.Id || !s.IsPending() {
+		t.Fatalf("scheduled reply %+v", s)
+	}
+
+	rec = post("/thread/create", url.Values{
+		"topic":      {"next week"},
+		"publish_at": {time.Now().Add(-time.Hour).Format(pollTimeLayout)},
+	})
+	if rec.Code != common.HTTPStatusOf(common.CodeInvalid) {
+		t.Fatalf("past time status %d", rec.Code)
+	}
+	rec = post("/thread/create", url.Values{
+		"topic":         {"next week"},
+		"poll_question": {"come?"},
+		"publish_at":    {later},
+	})
+	if rec.Code != common.HTTPStatusOf(common.CodeInvalid) {
+		t.Fatalf("scheduled poll status %d", rec.Code)
+	}
+	rec = post("/thread/create", url.Values{
+		"topic":      {"next week"},
+		"tags":       {"meeting"},
+		"publish_at": {later},
+	})
+	if rec.Code != http.StatusFound || len(threads.Scheduled) != 2 || len(threads.Threads) != 1 {
+		t.Fatalf("schedule thread status %d scheduled %+v", rec.Code, threads.Scheduled)
+	}
+
+	rec = serveTesting(t, engine, httptest.NewRequest(http.MethodGet, "/thread/scheduled", nil), sess, vis)
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, "weekly meeting") || !strings.Contains(page, "next week") {
+		t.Fatalf("scheduled page status %d", rec.Code)
+	}
+	if strings.Count(page, "/thread/scheduled/cancel") != 2 {
+		t.Fatal("pending ones can not be cancelled")
+	}
+
+	id := fmt.Sprint(threads.Scheduled[0].Id)
+	if rec = post("/thread/scheduled/cancel", url.Values{"id": {id}}); rec.Code != http.StatusFound {
+		t.Fatalf("cancel status %d", rec.Code)
+	}
+	if threads.Scheduled[0].State != common.ScheduledCancelled {
+		t.Fatalf("state %s", threads.Scheduled[0].State)
+	}
+	if rec = post("/thread/scheduled/cancel", url.Values{"id": {id}}); rec.Code != http.StatusConflict {
+		t.Fatalf("cancel again status %d", rec.Code)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	searchPageSize = 20
+	// as <input type="date"> sends it
+	searchDateLayout = "2006-01-02"
+)
+
+// anyone can search, the form is a plain GET so results can be linked
+func searchGet(ctx *gin.Context) {
+	query, page, results, err := searchGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to search")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, confirmLoggedIn(ctx))
+	data := gin.H{
+		"navbar":  navbar,
+		"q":       query.Query,
+		"author":  ctx.Query("author"),
+		"thread":  ctx.Query("thread"),
+		"from":    ctx.Query("from"),
+		"to":      ctx.Query("to"),
+		"results": results,
+		"page":    page,
+	}
+	if results != nil {
+		data["total"] = results.Total
+		if page > 1 {
+			data["prevPage"] = page - 1
+		}
+		if int64(page*searchPageSize) < results.Total {
+			data["nextPage"] = page + 1
+		}
+	}
+	ctx.HTML(http.StatusOK, "search.html", data)
+}
+
+// results is nil when nothing was asked yet
+func searchGetInternal(ctx *gin.Context) (
+	query *common.SearchQuery,
+	page int,
+	results *common.SearchPage,
+	err error,
+) {
+	query, err = searchQueryInternal(ctx)
+	if err != nil {
+		return
+	}
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	if len(common.SearchTerms(query.Query)) == 0 {
+		return
+	}
+	results, err = threadsClient.Search(
+		ctx.Request.Context(),
+		query,
+		(page-1)*searchPageSize,
+		searchPageSize,
+	)
+	return
+}
+
+// thread comes as public url, to as the last day included
+func searchQueryInternal(ctx *gin.Context) (query *common.SearchQuery, err error) {
+	query = &common.SearchQuery{
+		Query:  ctx.Query("q"),
+		Author: ctx.Query("author"),
+	}
+	if thread := ctx.Query("thread"); len(thread) > 0 {
+		var bytes []byte
+		bytes, err = decode(thread)
+		if err != nil {
+			err = common.NewError(common.CodeInvalid, "broken thread id", err)
+			return
+		}
+		query.Thread = string(bytes)
+	}
+	if from := ctx.Query("from"); len(from) > 0 {
+		query.From, err = time.ParseInLocation(searchDateLayout, from, time.Local)
+		if err != nil {
+			err = common.NewError(common.CodeInvalid, "invalid date", err)
+			return
+		}
+	}
+	if to := ctx.Query("to"); len(to) > 0 {
+		query.To, err = time.ParseInLocation(searchDateLayout, to, time.Local)
+		if err != nil {
+			err = common.NewError(common.CodeInvalid, "invalid date", err)
+			return
+		}
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_SearchGet(t *testing.T) {
+	_, threads := setupTesting(t)
+	bg := context.Background()
+	lunch, _ := threads.CreateThread(bg, &common.Thread{Topic: "東京でランチ", Owner: "taro"})
+	other, _ := threads.CreateThread(bg, &common.Thread{Topic: "dinner", Owner: "hanako"})
+	threads.CreatePost(bg, &common.Post{ThreadId: lunch.Id, Body: "東京駅の<b>カレー</b>", Contributor: "hanako"})
+	threads.CreatePost(bg, &common.Post{ThreadId: other.Id, Body: "カレー again", Contributor: "taro"})
+
+	engine := newTestingEngine()
+	engine.GET("/search", searchGet)
+	search := func(query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil)
+		return serveTesting(t, engine, req, nil, nil)
+	}
+
+	rec := search(url.Values{"q": {"カレー"}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "2 results") {
+		t.Fatalf("status %d body %s", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "&lt;b&gt;<mark>カレー</mark>&lt;/b&gt;") {
+		t.Fatalf("snippet not highlighted %s", body)
+	}
+
+	rec = search(url.Values{"q": {"カレー"}, "author": {"taro"}})
+	if body := rec.Body.String(); !strings.Contains(body, "1 results") || !strings.Contains(body, "dinner") {
+		t.Fatalf("author filter %s", body)
+	}
+	rec = search(url.Values{"q": {"カレー"}, "thread": {lunch.PublicURL()}})
+	if body := rec.Body.String(); !strings.Contains(body, "1 results") || strings.Contains(body, "dinner") {
+		t.Fatalf("thread filter %s", body)
+	}
+	rec = search(url.Values{"q": {"カレー"}, "to": {"2000-01-01"}})
+	if body := rec.Body.String(); !strings.Contains(body, "0 results") {
+		t.Fatalf("date filter %s", body)
+	}
+
+	// empty form is just the page
+	if rec := search(url.Values{}); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "results") {
+		t.Fatalf("empty search %d", rec.Code)
+	}
+	if rec := search(url.Values{"q": {"x"}, "from": {"yesterday"}}); rec.Code == http.StatusOK {
+		t.Fatal("broken date accepted")
+	}
+}
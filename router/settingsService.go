@@ -0,0 +1,118 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func settingsGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := renderSettingsInternal(ctx, getStateFromCTX(ctx), nil)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read settings")
+	}
+}
+
+// newToken is shown only right after creation
+func renderSettingsInternal(
+	ctx *gin.Context,
+	state string,
+	newToken *common.NewAPIToken,
+) (err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	tokens, err := usersClient.ReadTokens(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	data := gin.H{
+		"navbar":  navbar,
+		"tokens":  tokens,
+		"state":   state,
+		"isAdmin": user.IsAdmin(),
+	}
+	if newToken != nil {
+		data["secret"] = newToken.Secret
+		data["secretName"] = newToken.Token.Name
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.HTML(http.StatusOK, "settings.html", data)
+	return
+}
+
+func createTokenPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := createTokenPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to create token")
+	}
+}
+
+func createTokenPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	rateLimit, _ := strconv.Atoi(ctx.PostForm("rate_limit"))
+	token := common.APIToken{
+		UserId:    sess.UserId,
+		UserName:  sess.UserName,
+		Name:      ctx.PostForm("name"),
+		Scope:     ctx.PostForm("scope"),
+		RateLimit: rateLimit,
+	}
+	newToken, err := usersClient.CreateToken(ctx.Request.Context(), &token)
+	if err != nil {
+		return
+	}
+
+	// state was consumed, page needs new one
+	state, err := generateSessionState(ctx)
+	if err != nil {
+		return
+	}
+	err = renderSettingsInternal(ctx, state, newToken)
+	return
+}
+
+func revokeTokenPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := revokeTokenPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to revoke token")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/user/settings")
+}
+
+func revokeTokenPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	token := common.APIToken{
+		UuId:   ctx.PostForm("uuid"),
+		UserId: sess.UserId,
+	}
+	err = usersClient.RevokeToken(ctx.Request.Context(), &token)
+	return
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const watchedPageSize = 20
+
+// threads the user watches, latest activity first
+func watchedGet(ctx *gin.Context) {
+	loggedin := confirmLoggedIn(ctx)
+	if !loggedin {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	page, threads, err := watchedGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read watched threads")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, loggedin)
+	data := gin.H{
+		"navbar":  navbar,
+		"state":   getStateFromCTX(ctx),
+		"threads": threads.Threads,
+		"total":   threads.Total,
+		"unread":  unreadCountsInternal(ctx, threads.Threads),
+	}
+	if page > 1 {
+		data["prevPage"] = page - 1
+	}
+	if int64(page*watchedPageSize) < threads.Total {
+		data["nextPage"] = page + 1
+	}
+	ctx.HTML(http.StatusOK, "watched.html", data)
+}
+
+func watchedGetInternal(ctx *gin.Context) (page int, threads *common.ThreadPage, err error) {
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	threads, err = threadsClient.ListWatchedThreadsPage(
+		ctx.Request.Context(),
+		sess.UserId,
+		(page-1)*watchedPageSize,
+		watchedPageSize,
+	)
+	return
+}
+
+// form has state and thread, back is "watched" when sent from that page
+func subscribePost(ctx *gin.Context) {
+	subscriptionPostInternal(ctx, true)
+}
+
+func unsubscribePost(ctx *gin.Context) {
+	subscriptionPostInternal(ctx, false)
+}
+
+func subscriptionPostInternal(ctx *gin.Context, watch bool) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := changeSubscriptionInternal(ctx, watch)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to change watching")
+		return
+	}
+	if ctx.PostForm("back") == "watched" {
+		ctx.Redirect(http.StatusFound, "/thread/watched")
+		return
+	}
+	ctx.Redirect(http.StatusFound, fmt.Sprint("/thread/read?id=", ctx.PostForm("thread")))
+}
+
+func changeSubscriptionInternal(ctx *gin.Context, watch bool) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	bytes, err := decode(ctx.PostForm("thread"))
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	thre, err := threadsClient.ReadThread(ctx.Request.Context(), string(bytes))
+	if err != nil {
+		return
+	}
+	sub := &common.Subscription{ThreadId: thre.Id, UserId: sess.UserId}
+	if watch {
+		_, err = threadsClient.Subscribe(ctx.Request.Context(), sub)
+	} else {
+		err = threadsClient.Unsubscribe(ctx.Request.Context(), sub)
+	}
+	return
+}
+
+// known is false when threads service can not tell,
+// the button is left out then
+func watchingInternal(ctx *gin.Context, thre *common.Thread, sess *common.Session) (watching bool, known bool) {
+	_, err := threadsClient.ReadSubscription(ctx.Request.Context(), &common.Subscription{
+		ThreadId: thre.Id,
+		UserId:   sess.UserId,
+	})
+	if common.ErrorCodeOf(err) == common.CodeNotFound {
+		return false, true
+	}
+	if err != nil {
+		common.LogError(logger).Println(err.Error())
+		return false, false
+	}
+	return true, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_WatchThread(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	owner, _ := newTestingUser(t, users, "TestingHanako")
+	user, sess := newTestingUser(t, users, "TestingTaro")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "lunch",
+		Owner:  owner.Name,
+		UserId: owner.Id,
+	})
+
+	engine := newTestingEngine()
+	engine.GET("/thread/read", GenerateSessionStateMiddleware, threadGet)
+	engine.GET("/thread/watched", GenerateSessionStateMiddleware, watchedGet)
+	engine.POST("/thread/subscribe", subscribePost)
+	engine.POST("/thread/unsubscribe", unsubscribePost)
+	get := func(path string) string {
+		rec := serveTesting(t, engine, httptest.NewRequest(http.MethodGet, path, nil), sess, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	post := func(path string, back string) *httptest.ResponseRecorder {
+		form := url.Values{"thread": {thre.PublicURL()}, "back": {back}}
+		return postWithState(t, engine, sess, nil, path, form)
+	}
+
+	if html := get("/thread/read?id=" + thre.PublicURL()); !strings.Contains(html, `action="/thread/subscribe"`) {
+		t.Fatal("no watch button")
+	}
+	if html := get("/thread/watched"); !strings.Contains(html, "no watched threads yet") {
+		t.Fatal("watching before anything")
+	}
+
+	// posting is watching
+	threads.CreatePost(bg, &common.Post{
+		Body:        "ramen",
+		Contributor: user.Name,
+		UserId:      user.Id,
+		ThreadId:    thre.Id,
+	})
+	if html := get("/thread/read?id=" + thre.PublicURL()); !strings.Contains(html, `action="/thread/unsubscribe"`) {
+		t.Fatal("posting did not subscribe")
+	}
+	if html := get("/thread/watched"); !strings.Contains(html, "lunch") {
+		t.Fatal("thread is not on watched page")
+	}
+
+	rec := post("/thread/unsubscribe", "watched")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/thread/watched" {
+		t.Fatalf("status %d location %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(threads.Subscriptions) != 0 {
+		t.Fatalf("left %+v", threads.Subscriptions)
+	}
+	rec = post("/thread/subscribe", "")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/thread/read?id=") {
+		t.Fatalf("status %d location %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// twice is still once
+	post("/thread/subscribe", "")
+	if len(threads.Subscriptions) != 1 || threads.Subscriptions[0].UserId != user.Id {
+		t.Fatalf("subscriptions %+v", threads.Subscriptions)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tagPageSize = 20
+	// on index, the tag page shows more
+	indexTagCloudSize = 20
+	tagCloudSize      = 200
+	// css has tag-weight-1 to tag-weight-5
+	maxTagWeight = 5
+)
+
+// a tag in the cloud, Weight grows with count
+type tagWeight struct {
+	Tag    string
+	Count  int64
+	Weight int
+}
+
+// ?name= lists threads with the tag, without it the whole cloud is shown
+func tagGet(ctx *gin.Context) {
+	name := ctx.Query("name")
+	navbar, _ := getHTMLElemntInternal(ctx, confirmLoggedIn(ctx))
+	if len(name) == 0 {
+		tags, err := threadsClient.ListTags(ctx.Request.Context(), tagCloudSize)
+		if err != nil {
+			handleErrorInternal(err, ctx, "failed to read tags")
+			return
+		}
+		ctx.HTML(
+			http.StatusOK,
+			"tag.html",
+			gin.H{
+				"navbar": navbar,
+				"cloud":  weighTagsInternal(tags),
+			},
+		)
+		return
+	}
+
+	page, threads, err := tagGetInternal(ctx, name)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read tag")
+		return
+	}
+	data := gin.H{
+		"navbar":  navbar,
+		"tag":     name,
+		"threads": threads.Threads,
+		"total":   threads.Total,
+	}
+	if page > 1 {
+		data["prevPage"] = page - 1
+	}
+	if int64(page*tagPageSize) < threads.Total {
+		data["nextPage"] = page + 1
+	}
+	ctx.HTML(http.StatusOK, "tag.html", data)
+}
+
+func tagGetInternal(ctx *gin.Context, name string) (page int, threads *common.ThreadPage, err error) {
+	page, err = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		err = common.NewError(common.CodeInvalid, "invalid page", err)
+		return
+	}
+	threads, err = threadsClient.ListTaggedThreadsPage(
+		ctx.Request.Context(),
+		name,
+		(page-1)*tagPageSize,
+		tagPageSize,
+	)
+	return
+}
+
+// weight is count relative to the most used tag, 1 to maxTagWeight
+func weighTagsInternal(tags []common.TagCount) []tagWeight {
+	var most int64 = 1
+	for _, tag := range tags {
+		if tag.Count > most {
+			most = tag.Count
+		}
+	}
+	weights := make([]tagWeight, 0, len(tags))
+	for _, tag := range tags {
+		weight := 1
+		if most > 1 {
+			weight += int((tag.Count - 1) * (maxTagWeight - 1) / (most - 1))
+		}
+		weights = append(weights, tagWeight{
+			Tag:    tag.Tag,
+			Count:  tag.Count,
+			Weight: weight,
+		})
+	}
+	return weights
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_Tags(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	member, memberSess := newTestingUser(t, users, "TestingTaro")
+	moderator, moderatorSess := newTestingUser(t, users, "TestingHanako")
+	users.Users[moderator.Email].Role = common.RoleModerator
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	sess := &common.Session{UserId: member.Id, UserName: member.Name}
+	thre, err := createThreadInternal(ctx, sess, &newThreadRequest{
+		Topic: "lunch",
+		Tags:  common.SplitTags("Food ｆｏｏｄ 東京"),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(thre.Tags, []string{"food", "東京"}) {
+		t.Fatalf("tags %v", thre.Tags)
+	}
+	if _, err := createThreadInternal(ctx, sess, &newThreadRequest{Topic: "bad", Tags: []string{"no!"}}); common.ErrorCodeOf(err) != common.CodeInvalid {
+		t.Fatalf("broken tag accepted %v", err)
+	}
+
+	engine := newTestingEngine()
+	engine.GET("/tag", tagGet)
+	engine.POST("/moderate/tags", tagsPost)
+	get := func(path string) string {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status %d", path, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	post := func(sess *common.Session, tags string) int {
+		form := url.Values{"thread": {thre.PublicURL()}, "tags": {tags}}
+		return postWithState(t, engine, sess, nil, "/moderate/tags", form).Code
+	}
+
+	// the tag is normalised on the way in
+	if body := get("/tag?name=" + url.QueryEscape("ＦＯＯＤ")); !strings.Contains(body, "lunch") {
+		t.Fatalf("tag page %s", body)
+	}
+	if body := get("/tag"); !strings.Contains(body, "#東京") {
+		t.Fatalf("cloud %s", body)
+	}
+
+	if code := post(memberSess, "ramen"); code != http.StatusForbidden {
+		t.Fatalf("member edited tags, status %d", code)
+	}
+	if code := post(moderatorSess, "ramen, Food"); code != http.StatusFound {
+		t.Fatalf("edit status %d", code)
+	}
+	edited, _ := threads.ReadThread(bg, thre.UuId)
+	if !reflect.DeepEqual(edited.Tags, []string{"ramen", "food"}) {
+		t.Fatalf("tags %v", edited.Tags)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// thread.created has no use for hooks of a thread, it exists already
+var (
+	threadWebhookEvents = []string{
+		common.TopicPostCreated,
+		common.TopicPostUpdated,
+		common.TopicPostDeleted,
+	}
+	globalWebhookEvents = append(
+		[]string{common.TopicThreadCreated},
+		threadWebhookEvents...,
+	)
+)
+
+// ?thread= is base64 uuid like /thread/read, empty for global hooks
+func webhooksGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := renderWebhooksInternal(ctx, getStateFromCTX(ctx), nil)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read webhooks")
+	}
+}
+
+// newHook is given only right after creation, to show its secret once
+func renderWebhooksInternal(
+	ctx *gin.Context,
+	state string,
+	newHook *common.Webhook,
+) (err error) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	threadParam := ctx.Query("thread")
+	if newHook != nil {
+		threadParam = ctx.PostForm("thread")
+	}
+	user, thre, err := webhookScopeInternal(ctx, sess, threadParam)
+	if err != nil {
+		return
+	}
+	var threadId uint
+	events := globalWebhookEvents
+	if thre != nil {
+		threadId = thre.Id
+		events = threadWebhookEvents
+	}
+	stored, err := threadsClient.ReadWebhooks(ctx.Request.Context(), threadId)
+	if err != nil {
+		return
+	}
+	// admins see all, others only their own
+	hooks := make([]common.Webhook, 0, len(stored))
+	for _, hook := range stored {
+		if user.IsAdmin() || hook.UserId == user.Id {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	data := gin.H{
+		"navbar": navbar,
+		"thread": thre,
+		"param":  threadParam,
+		"hooks":  hooks,
+		"events": events,
+		"state":  state,
+	}
+	if newHook != nil {
+		data["secret"] = newHook.Secret
+		data["secretURL"] = newHook.URL
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.HTML(http.StatusOK, "webhooks.html", data)
+	return
+}
+
+// owners manage hooks of their thread, admins of every thread and global ones
+func webhookScopeInternal(
+	ctx *gin.Context,
+	sess *common.Session,
+	threadParam string,
+) (user *common.User, thre *common.Thread, err error) {
+	user, err = usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if len(threadParam) == 0 {
+		if !user.IsAdmin() {
+			err = common.NewError(common.CodeForbidden, "global webhooks are for admins", nil)
+		}
+		return
+	}
+	bytes, err := base64.URLEncoding.DecodeString(threadParam)
+	if err != nil {
+		err = common.NewError(common.CodeNotFound, "broken thread id", err)
+		return
+	}
+	thre, err = threadsClient.ReadThread(ctx.Request.Context(), string(bytes))
+	if err != nil {
+		return
+	}
+	if thre.UserId != user.Id && !user.IsAdmin() {
+		err = common.NewError(common.CodeForbidden, "not your thread", nil)
+	}
+	return
+}
+
+func createWebhookPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := createWebhookPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to create webhook")
+	}
+}
+
+func createWebhookPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	_, thre, err := webhookScopeInternal(ctx, sess, ctx.PostForm("thread"))
+	if err != nil {
+		return
+	}
+	hook := common.Webhook{
+		UserId: sess.UserId,
+		Owner:  sess.UserName,
+		URL:    strings.TrimSpace(ctx.PostForm("url")),
+		Events: strings.Join(ctx.PostFormArray("events"), ","),
+	}
+	if thre != nil {
+		hook.ThreadId = thre.Id
+	}
+	created, err := threadsClient.CreateWebhook(ctx.Request.Context(), &hook)
+	if err != nil {
+		return
+	}
+
+	// state was consumed, page needs new one
+	state, err := generateSessionState(ctx)
+	if err != nil {
+		return
+	}
+	err = renderWebhooksInternal(ctx, state, created)
+	return
+}
+
+func deleteWebhookPost(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	err := deleteWebhookPostInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to delete webhook")
+		return
+	}
+	ctx.Redirect(http.StatusFound, "/webhooks?thread="+url.QueryEscape(ctx.PostForm("thread")))
+}
+
+func deleteWebhookPostInternal(ctx *gin.Context) (err error) {
+	sess, err := sessionStateCheckProcess(ctx)
+	if err != nil {
+		return
+	}
+	hook, err := ownWebhookInternal(ctx, sess, ctx.PostForm("uuid"))
+	if err != nil {
+		return
+	}
+	err = threadsClient.DeleteWebhook(ctx.Request.Context(), hook.UuId)
+	return
+}
+
+// who made it, or admins
+func ownWebhookInternal(
+	ctx *gin.Context,
+	sess *common.Session,
+	uuid string,
+) (hook *common.Webhook, err error) {
+	hook, err = threadsClient.ReadWebhook(ctx.Request.Context(), uuid)
+	if err != nil {
+		return
+	}
+	if hook.UserId == sess.UserId {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx.Request.Context(), sess.UserId)
+	if err != nil {
+		return
+	}
+	if !user.IsAdmin() {
+		err = common.NewError(common.CodeForbidden, "not your webhook", nil)
+	}
+	return
+}
+
+func webhookDeliveriesGet(ctx *gin.Context) {
+	if !confirmLoggedIn(ctx) {
+		ctx.Redirect(http.StatusFound, "/user/login")
+		return
+	}
+	hook, deliveries, err := webhookDeliveriesGetInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx, "failed to read deliveries")
+		return
+	}
+	navbar, _ := getHTMLElemntInternal(ctx, true)
+	ctx.HTML(
+		http.StatusOK,
+		"deliveries.html",
+		gin.H{
+			"navbar":     navbar,
+			"hook":       hook,
+			"deliveries": deliveries,
+		},
+	)
+}
+
+func webhookDeliveriesGetInternal(ctx *gin.Context) (
+	hook *common.Webhook,
+	deliveries []common.WebhookDelivery,
+	err error,
+) {
+	sess, err := getSessionPtrFromCTX(ctx)
+	if err != nil {
+		return
+	}
+	hook, err = ownWebhookInternal(ctx, sess, ctx.Query("id"))
+	if err != nil {
+		return
+	}
+	deliveries, err = threadsClient.ReadWebhookDeliveries(ctx.Request.Context(), hook)
+	return
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_CreateWebhook(t *testing.T) {
+	users, threads := setupTesting(t)
+	bg := context.Background()
+	owner, ownerSess := newTestingUser(t, users, "TestingTaro")
+	_, otherSess := newTestingUser(t, users, "TestingHanako")
+	thre, _ := threads.CreateThread(bg, &common.Thread{
+		Topic:  "ci results",
+		Owner:  owner.Name,
+		UserId: owner.Id,
+	})
+
+	engine := newTestingEngine()
+	engine.POST("/webhooks/create", createWebhookPost)
+	post := func(sess *common.Session, threadParam string) *httptest.ResponseRecorder {
+		return postWithState(t, engine, sess, nil, "/webhooks/create", url.Values{
+			"thread": {threadParam},
+			"url":    {"https://example.com/hook"},
+			"events": {common.TopicPostCreated},
+		})
+	}
+
+	if rec := post(otherSess, thre.PublicURL()); rec.Code != http.StatusForbidden {
+		t.Fatalf("others thread, status %d", rec.Code)
+	}
+	if rec := post(ownerSess, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("global hook by member, status %d", rec.Code)
+	}
+	rec := post(ownerSess, thre.PublicURL())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if len(threads.Webhooks) != 1 || threads.Webhooks[0].ThreadId != thre.Id {
+		t.Fatalf("webhook not created %v", threads.Webhooks)
+	}
+	if !strings.Contains(rec.Body.String(), threads.Webhooks[0].Secret) {
+		t.Fatal("secret is not shown after creation")
+	}
+}
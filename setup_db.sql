@@ -1,16 +1,47 @@
+DROP TABLE direct_messages;
+DROP TABLE conversation_members;
+DROP TABLE conversations;
+DROP TABLE scheduled_posts;
+DROP TABLE drafts;
+DROP TABLE bookmarks;
+DROP TABLE read_horizons;
+DROP TABLE thread_reads;
+DROP TABLE subscriptions;
+DROP TABLE thread_tags;
+DROP TABLE user_blocks;
+DROP TABLE poll_votes;
+DROP TABLE poll_options;
+DROP TABLE polls;
+DROP TABLE reactions;
+DROP TABLE attachments;
+DROP TABLE mentions;
+DROP TABLE notifications;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
+DROP TABLE users_outbox;
+DROP TABLE threads_outbox;
+DROP TABLE chat_messages;
+DROP TABLE chat_rooms;
+DROP TABLE thread_events;
+DROP TABLE api_tokens;
 DROP TABLE posts;
 DROP TABLE threads;
+DROP TABLE boards;
 DROP TABLE sessions;
 DROP TABLE visits;
 DROP TABLE users;
 
 CREATE TABLE users (
-  id         SERIAL PRIMARY KEY,
-  uu_id      VARCHAR(255) NOT NULL UNIQUE,
-  name       VARCHAR(255) NOT NULL UNIQUE,
-  email      VARCHAR(255) NOT NULL UNIQUE,
-  password   VARCHAR(255) NOT NULL,
-  created_at TIMESTAMP NOT NULL   
+  id           SERIAL PRIMARY KEY,
+  uu_id        VARCHAR(255) NOT NULL UNIQUE,
+  name         VARCHAR(255) NOT NULL UNIQUE,
+  email        VARCHAR(255) NOT NULL UNIQUE,
+  password     VARCHAR(255) NOT NULL,
+  -- member, moderator or admin. promote by hand:
+  -- UPDATE users SET role = 'admin' WHERE name = '...';
+  role         VARCHAR(16) NOT NULL DEFAULT 'member',
+  banned_until TIMESTAMP,
+  created_at   TIMESTAMP NOT NULL   
 );
 
 CREATE TABLE sessions (
@@ -32,6 +63,21 @@ CREATE TABLE visits (
   created_at   TIMESTAMP NOT NULL
 );
 
+CREATE TABLE boards (
+  id          SERIAL PRIMARY KEY,
+  slug        VARCHAR(32) NOT NULL UNIQUE,
+  title       VARCHAR(255) NOT NULL,
+  description TEXT,
+  sort_order  INTEGER NOT NULL DEFAULT 0,
+  -- least role to start a thread / to reply, empty is anyone
+  thread_role VARCHAR(16) NOT NULL DEFAULT '',
+  post_role   VARCHAR(16) NOT NULL DEFAULT '',
+  created_at  TIMESTAMP NOT NULL
+);
+-- threads without a board go here
+INSERT INTO boards (slug, title, description, created_at)
+  VALUES ('general', 'General', 'Anything goes.', NOW());
+
 CREATE TABLE threads (
   id          SERIAL PRIMARY KEY,
   uu_id       VARCHAR(255) NOT NULL UNIQUE,
@@ -39,16 +85,328 @@ CREATE TABLE threads (
   num_replies SERIAL,
   owner       VARCHAR(255),
   user_id     SERIAL REFERENCES users(id),
+  board_id    INTEGER NOT NULL REFERENCES boards(id),
+  locked      BOOLEAN NOT NULL DEFAULT FALSE,
+  -- written by threads service, see common.SearchDocument
+  search_vector TSVECTOR,
   last_update TIMESTAMP NOT NULL,
   created_at  TIMESTAMP NOT NULL       
 );
+CREATE INDEX threads_search_vector ON threads USING GIN (search_vector);
+CREATE INDEX threads_board_id ON threads (board_id, last_update);
 
 CREATE TABLE posts (
+  id            SERIAL PRIMARY KEY,
+  uu_id         VARCHAR(255) NOT NULL UNIQUE,
+  body          TEXT,
+  -- markdown rendered and sanitized at write time
+  rendered_body TEXT,
+  contributor   VARCHAR(255),
+  user_id       SERIAL REFERENCES users(id),
+  thread_id     SERIAL REFERENCES threads(id),
+  number        INTEGER NOT NULL DEFAULT 0,
+  updated_at    TIMESTAMP,
+  deleted_at    TIMESTAMP,
+  search_vector TSVECTOR,
+  created_at    TIMESTAMP NOT NULL,
+  UNIQUE (thread_id, number)
+);
+CREATE INDEX posts_search_vector ON posts USING GIN (search_vector);
+
+CREATE TABLE api_tokens (
+  id           SERIAL PRIMARY KEY,
+  uu_id        VARCHAR(255) NOT NULL UNIQUE,
+  user_id      INTEGER NOT NULL REFERENCES users(id),
+  user_name    VARCHAR(255),
+  name         VARCHAR(255) NOT NULL,
+  scope        VARCHAR(16) NOT NULL,
+  token_hash   VARCHAR(64) NOT NULL UNIQUE,
+  rate_limit   INTEGER,
+  revoked      BOOLEAN NOT NULL DEFAULT FALSE,
+  last_used_at TIMESTAMP,
+  created_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE thread_events (
+  id         SERIAL PRIMARY KEY,
+  thread_id  INTEGER NOT NULL REFERENCES threads(id),
+  kind       VARCHAR(32) NOT NULL,
+  payload    TEXT,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX thread_events_thread_id_id ON thread_events (thread_id, id);
+
+CREATE TABLE chat_rooms (
+  id         SERIAL PRIMARY KEY,
+  uu_id      VARCHAR(255) NOT NULL UNIQUE,
+  name       VARCHAR(32) NOT NULL UNIQUE,
+  owner      VARCHAR(255),
+  user_id    INTEGER REFERENCES users(id),
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE chat_messages (
   id          SERIAL PRIMARY KEY,
   uu_id       VARCHAR(255) NOT NULL UNIQUE,
+  room_id     INTEGER NOT NULL REFERENCES chat_rooms(id),
   body        TEXT,
   contributor VARCHAR(255),
-  user_id     SERIAL REFERENCES users(id),
-  thread_id   SERIAL REFERENCES threads(id),
-  created_at  TIMESTAMP NOT NULL  
+  user_id     INTEGER REFERENCES users(id),
+  created_at  TIMESTAMP NOT NULL
+);
+CREATE INDEX chat_messages_room_id_id ON chat_messages (room_id, id);
+
+CREATE TABLE threads_outbox (
+  id            SERIAL PRIMARY KEY,
+  uu_id         VARCHAR(255) NOT NULL UNIQUE,
+  topic         VARCHAR(64) NOT NULL,
+  payload       TEXT,
+  attempts      INTEGER NOT NULL DEFAULT 0,
+  last_error    TEXT,
+  claimed_until TIMESTAMP,
+  dispatched_at TIMESTAMP,
+  created_at    TIMESTAMP NOT NULL
+);
+CREATE INDEX threads_outbox_pending ON threads_outbox (id) WHERE dispatched_at IS NULL;
+
+CREATE TABLE users_outbox (
+  id            SERIAL PRIMARY KEY,
+  uu_id         VARCHAR(255) NOT NULL UNIQUE,
+  topic         VARCHAR(64) NOT NULL,
+  payload       TEXT,
+  attempts      INTEGER NOT NULL DEFAULT 0,
+  last_error    TEXT,
+  claimed_until TIMESTAMP,
+  dispatched_at TIMESTAMP,
+  created_at    TIMESTAMP NOT NULL
+);
+CREATE INDEX users_outbox_pending ON users_outbox (id) WHERE dispatched_at IS NULL;
+
+CREATE TABLE webhooks (
+  id         SERIAL PRIMARY KEY,
+  uu_id      VARCHAR(255) NOT NULL UNIQUE,
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  owner      VARCHAR(255),
+  thread_id  INTEGER NOT NULL DEFAULT 0,
+  url        TEXT NOT NULL,
+  secret     VARCHAR(64) NOT NULL,
+  events     TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX webhooks_thread_id ON webhooks (thread_id);
+
+CREATE TABLE webhook_deliveries (
+  id              SERIAL PRIMARY KEY,
+  uu_id           VARCHAR(255) NOT NULL UNIQUE,
+  webhook_id      INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+  event_uu_id     VARCHAR(255) NOT NULL,
+  topic           VARCHAR(64) NOT NULL,
+  payload         TEXT,
+  status_code     INTEGER NOT NULL DEFAULT 0,
+  attempts        INTEGER NOT NULL DEFAULT 0,
+  last_error      TEXT,
+  next_attempt_at TIMESTAMP,
+  delivered_at    TIMESTAMP,
+  failed_at       TIMESTAMP,
+  created_at      TIMESTAMP NOT NULL,
+  UNIQUE (webhook_id, event_uu_id)
+);
+CREATE INDEX webhook_deliveries_pending ON webhook_deliveries (next_attempt_at)
+  WHERE delivered_at IS NULL AND failed_at IS NULL;
+
+CREATE TABLE notifications (
+  id           SERIAL PRIMARY KEY,
+  uu_id        VARCHAR(255) NOT NULL UNIQUE,
+  user_id      INTEGER NOT NULL REFERENCES users(id),
+  kind         VARCHAR(16) NOT NULL,
+  event_uu_id  VARCHAR(255) NOT NULL,
+  actor_name   VARCHAR(255),
+  thread_uu_id VARCHAR(255),
+  topic        TEXT,
+  excerpt      TEXT,
+  read_at      TIMESTAMP,
+  created_at   TIMESTAMP NOT NULL,
+  UNIQUE (user_id, event_uu_id, kind)
+);
+CREATE INDEX notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
+
+CREATE TABLE mentions (
+  id         SERIAL PRIMARY KEY,
+  post_id    INTEGER NOT NULL REFERENCES posts(id),
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  user_name  VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX mentions_post_id ON mentions (post_id);
+
+CREATE TABLE attachments (
+  id         SERIAL PRIMARY KEY,
+  post_id    INTEGER NOT NULL REFERENCES posts(id),
+  hash       CHAR(64) NOT NULL,
+  thumb_hash CHAR(64),
+  name       VARCHAR(255) NOT NULL,
+  mime_type  VARCHAR(64) NOT NULL,
+  size       BIGINT NOT NULL,
+  width      INTEGER,
+  height     INTEGER,
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX attachments_post_id ON attachments (post_id);
+
+CREATE TABLE reactions (
+  id         SERIAL PRIMARY KEY,
+  post_id    INTEGER NOT NULL REFERENCES posts(id),
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  kind       VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (post_id, user_id, kind)
+);
+
+CREATE TABLE polls (
+  id         SERIAL PRIMARY KEY,
+  thread_id  INTEGER NOT NULL UNIQUE REFERENCES threads(id),
+  question   VARCHAR(255) NOT NULL,
+  multiple   BOOLEAN NOT NULL DEFAULT FALSE,
+  anonymous  BOOLEAN NOT NULL DEFAULT FALSE,
+  closes_at  TIMESTAMP,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE poll_options (
+  id       SERIAL PRIMARY KEY,
+  poll_id  INTEGER NOT NULL REFERENCES polls(id),
+  position INTEGER NOT NULL,
+  label    VARCHAR(255) NOT NULL
+);
+CREATE INDEX poll_options_poll_id ON poll_options (poll_id);
+
+CREATE TABLE poll_votes (
+  id         SERIAL PRIMARY KEY,
+  poll_id    INTEGER NOT NULL REFERENCES polls(id),
+  option_id  INTEGER NOT NULL REFERENCES poll_options(id),
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  user_name  VARCHAR(255),
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (poll_id, option_id, user_id)
+);
+
+CREATE TABLE user_blocks (
+  id           SERIAL PRIMARY KEY,
+  user_id      INTEGER NOT NULL REFERENCES users(id),
+  blocked_id   INTEGER NOT NULL REFERENCES users(id),
+  blocked_name VARCHAR(255),
+  created_at   TIMESTAMP NOT NULL,
+  UNIQUE (user_id, blocked_id)
+);
+
+-- normalised by threads service, see common.NormalizeTag
+CREATE TABLE thread_tags (
+  id        SERIAL PRIMARY KEY,
+  thread_id INTEGER NOT NULL REFERENCES threads(id),
+  tag       VARCHAR(64) NOT NULL,
+  UNIQUE (thread_id, tag)
+);
+CREATE INDEX thread_tags_tag ON thread_tags (tag);
+
+-- digests tell about posts after digested_at
+CREATE TABLE subscriptions (
+  id          SERIAL PRIMARY KEY,
+  thread_id   INTEGER NOT NULL REFERENCES threads(id),
+  user_id     INTEGER NOT NULL REFERENCES users(id),
+  created_at  TIMESTAMP NOT NULL,
+  digested_at TIMESTAMP NOT NULL,
+  UNIQUE (thread_id, user_id)
+);
+CREATE INDEX subscriptions_user_id ON subscriptions (user_id);
+
+-- only threads opened since the user last marked all read
+CREATE TABLE thread_reads (
+  id          SERIAL PRIMARY KEY,
+  user_id     INTEGER NOT NULL REFERENCES users(id),
+  thread_id   INTEGER NOT NULL REFERENCES threads(id),
+  last_number INTEGER NOT NULL,
+  updated_at  TIMESTAMP NOT NULL,
+  UNIQUE (user_id, thread_id)
+);
+
+-- posts up to read_all_at are read everywhere
+CREATE TABLE read_horizons (
+  user_id     INTEGER PRIMARY KEY REFERENCES users(id),
+  read_all_at TIMESTAMP NOT NULL
+);
+
+-- private to user_id, never counted for others
+CREATE TABLE bookmarks (
+  id         SERIAL PRIMARY KEY,
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  post_id    INTEGER NOT NULL REFERENCES posts(id),
+  note       TEXT,
+  updated_at TIMESTAMP NOT NULL,
+  created_at TIMESTAMP NOT NULL,
+  UNIQUE (user_id, post_id)
+);
+
+-- thread_id is 0 for the new thread form, so no reference
+CREATE TABLE drafts (
+  id         SERIAL PRIMARY KEY,
+  user_id    INTEGER NOT NULL REFERENCES users(id),
+  thread_id  INTEGER NOT NULL DEFAULT 0,
+  topic      TEXT,
+  board      VARCHAR(255),
+  tags       TEXT,
+  body       TEXT,
+  updated_at TIMESTAMP NOT NULL,
+  UNIQUE (user_id, thread_id)
+);
+
+-- thread_id is 0 for a new thread. tags is a json array.
+-- state is pending, published, cancelled or failed
+CREATE TABLE scheduled_posts (
+  id              SERIAL PRIMARY KEY,
+  user_id         INTEGER NOT NULL REFERENCES users(id),
+  user_name       VARCHAR(255) NOT NULL,
+  thread_id       INTEGER NOT NULL DEFAULT 0,
+  board_id        INTEGER NOT NULL DEFAULT 0,
+  topic           TEXT,
+  tags            TEXT,
+  body            TEXT,
+  publish_at      TIMESTAMP NOT NULL,
+  state           VARCHAR(16) NOT NULL,
+  reason          TEXT,
+  published_uu_id VARCHAR(255),
+  created_at      TIMESTAMP NOT NULL
+);
+CREATE INDEX scheduled_posts_due ON scheduled_posts (publish_at) WHERE state = 'pending';
+CREATE INDEX scheduled_posts_user ON scheduled_posts (user_id, publish_at);
+
+-- pair_key is "lower:higher" user id of a one to one, null for groups
+CREATE TABLE conversations (
+  id              SERIAL PRIMARY KEY,
+  uu_id           VARCHAR(255) NOT NULL UNIQUE,
+  title           VARCHAR(255),
+  pair_key        VARCHAR(64) UNIQUE,
+  last_message_at TIMESTAMP NOT NULL,
+  created_at      TIMESTAMP NOT NULL
+);
+CREATE INDEX conversations_last_message ON conversations (last_message_at);
+
+CREATE TABLE conversation_members (
+  id              SERIAL PRIMARY KEY,
+  conversation_id INTEGER NOT NULL REFERENCES conversations(id),
+  user_id         INTEGER NOT NULL REFERENCES users(id),
+  user_name       VARCHAR(255) NOT NULL,
+  last_read_id    INTEGER NOT NULL DEFAULT 0,
+  joined_at       TIMESTAMP NOT NULL,
+  UNIQUE (conversation_id, user_id)
+);
+CREATE INDEX conversation_members_user ON conversation_members (user_id);
+
+CREATE TABLE direct_messages (
+  id              SERIAL PRIMARY KEY,
+  conversation_id INTEGER NOT NULL REFERENCES conversations(id),
+  user_id         INTEGER NOT NULL REFERENCES users(id),
+  user_name       VARCHAR(255) NOT NULL,
+  body            TEXT,
+  created_at      TIMESTAMP NOT NULL
 );
+CREATE INDEX direct_messages_conversation ON direct_messages (conversation_id, id);
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const attachmentsTable = "attachments"
+
+// files are already in storage, router put them there.
+// only what was stored is checked here.
+func checkAttachmentsInternal(post *common.Post) (err error) {
+	if len(post.Attachments) > common.MaxPostAttachments {
+		err = common.NewError(common.CodeInvalid, "too many attachments", nil)
+		return
+	}
+	for _, attachment := range post.Attachments {
+		if !common.IsValidStorageKey(attachment.Hash) ||
+			(len(attachment.ThumbHash) > 0 && !common.IsValidStorageKey(attachment.ThumbHash)) {
+			err = common.NewError(common.CodeInvalid, "invalid attachment key", nil)
+			return
+		}
+		if !common.IsAllowedAttachmentType(attachment.MimeType) ||
+			attachment.Size <= 0 || attachment.Size > common.MaxAttachmentSize {
+			err = common.NewError(common.CodeInvalid, "invalid attachment", nil)
+			return
+		}
+	}
+	return
+}
+
+// in the same transaction as the post
+func createAttachmentsSQLInternal(session *xorm.Session, post *common.Post) (err error) {
+	for i := range post.Attachments {
+		post.Attachments[i].Id = 0
+		post.Attachments[i].PostId = post.Id
+		post.Attachments[i].Name = common.SanitizeFileName(post.Attachments[i].Name)
+		post.Attachments[i].CreatedAt = time.Now()
+		_, err = session.
+			Table(attachmentsTable).
+			InsertOne(&post.Attachments[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// fills Attachments of the posts with one query
+func attachAttachmentsSQLInternal(posts []common.Post) (err error) {
+	if len(posts) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(posts))
+	for i := range posts {
+		ids = append(ids, posts[i].Id)
+	}
+	var attachments []common.Attachment
+	err = dbEngine.
+		Table(attachmentsTable).
+		In("post_id", ids).
+		Asc("id").
+		Find(&attachments)
+	if err != nil {
+		return
+	}
+	byPost := make(map[uint][]common.Attachment)
+	for _, attachment := range attachments {
+		byPost[attachment.PostId] = append(byPost[attachment.PostId], attachment)
+	}
+	for i := range posts {
+		posts[i].Attachments = byPost[posts[i].Id]
+	}
+	return
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	boardsTable    = "boards"
+	ascendingBoard = "sort_order"
+)
+
+// router checked the admin role already for create, update and delete
+func createBoard(ctx *gin.Context) {
+	var board common.Board
+	err := createBoardInternal(ctx, &board)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &board)
+}
+
+func createBoardInternal(ctx *gin.Context, board *common.Board) (err error) {
+	err = bindInternal(ctx, board)
+	if err != nil {
+		return
+	}
+	if !common.IsValidBoardSlug(board.Slug) {
+		err = common.NewError(common.CodeInvalid, "invalid slug", nil)
+		return
+	}
+	err = validateBoardInternal(board)
+	if err != nil {
+		return
+	}
+	board.Id = 0
+	board.CreatedAt = time.Now()
+	err = createBoardSQLInternal(board)
+	return
+}
+
+func validateBoardInternal(board *common.Board) (err error) {
+	if common.IsEmpty(board.Title) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	if !common.IsValidRole(board.ThreadRole) || !common.IsValidRole(board.PostRole) {
+		err = common.NewError(common.CodeInvalid, "unknown role", nil)
+	}
+	return
+}
+
+func updateBoard(ctx *gin.Context) {
+	var board common.Board
+	err := updateBoardInternal(ctx, &board)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &board)
+}
+
+// slug stays, it is in links already
+func updateBoardInternal(ctx *gin.Context, board *common.Board) (err error) {
+	err = bindInternal(ctx, board)
+	if err != nil {
+		return
+	}
+	err = validateBoardInternal(board)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(board.Slug) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	stored := common.Board{Slug: board.Slug}
+	err = readBoardSQLInternal(&stored)
+	if err != nil {
+		return
+	}
+	stored.Title = board.Title
+	stored.Description = board.Description
+	stored.SortOrder = board.SortOrder
+	stored.ThreadRole = board.ThreadRole
+	stored.PostRole = board.PostRole
+	err = updateBoardSQLInternal(&stored)
+	if err != nil {
+		return
+	}
+	*board = stored
+	return
+}
+
+func deleteBoard(ctx *gin.Context) {
+	var board common.Board
+	err := deleteBoardInternal(ctx, &board)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &board)
+}
+
+// only empty boards go, threads are never moved silently
+func deleteBoardInternal(ctx *gin.Context, board *common.Board) (err error) {
+	err = bindInternal(ctx, board)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(board.Slug) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	if board.Slug == common.DefaultBoardSlug {
+		err = common.NewError(common.CodeForbidden, "default board can not be deleted", nil)
+		return
+	}
+	err = readBoardSQLInternal(board)
+	if err != nil {
+		return
+	}
+	err = deleteBoardSQLInternal(board)
+	return
+}
+
+func readBoards(ctx *gin.Context) {
+	boards, err := readBoardsSQLInternal()
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &boards)
+}
+
+// by id or by slug
+func readBoard(ctx *gin.Context) {
+	var board common.Board
+	err := bindInternal(ctx, &board)
+	if err == nil {
+		err = readBoardSQLInternal(&board)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &board)
+}
+
+func readBoardThreadsPage(ctx *gin.Context) {
+	var board common.Board
+	err := bindInternal(ctx, &board)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	err = readBoardSQLInternal(&board)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readBoardThreadsPageSQLInternal(&board, offset, limit)
+	if err == nil {
+		err = attachTagsSQLInternal(page.Threads)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// zero id is the default board
+func readThreadBoardInternal(boardId uint) (board *common.Board, err error) {
+	board = &common.Board{Id: boardId}
+	if boardId == 0 {
+		board.Slug = common.DefaultBoardSlug
+	}
+	err = readBoardSQLInternal(board)
+	return
+}
+
+// users service is asked only when a board needs more than a member,
+// nothing is allowed while it can not tell
+func checkBoardRoleInternal(ctx context.Context, need string, userId uint) (err error) {
+	if common.RoleAtLeast(common.RoleMember, need) {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx, userId)
+	if err != nil {
+		return
+	}
+	if !user.HasRole(need) {
+		err = common.NewError(common.CodeForbidden, "not allowed in this board", nil)
+	}
+	return
+}
+
+func createBoardSQLInternal(board *common.Board) (err error) {
+	exists, err := dbEngine.
+		Table(boardsTable).
+		Exist(&common.Board{Slug: board.Slug})
+	if err != nil {
+		return
+	}
+	if exists {
+		err = common.NewError(common.CodeConflict, "slug is taken", nil)
+		return
+	}
+	affected, err := dbEngine.
+		Table(boardsTable).
+		InsertOne(board)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+// empty board would get the first row
+func readBoardSQLInternal(board *common.Board) (err error) {
+	if board.Id == 0 && common.IsEmpty(board.Slug) {
+		err = common.NewError(common.CodeInvalid, "need board", nil)
+		return
+	}
+	ok, err := dbEngine.
+		Table(boardsTable).
+		Get(board)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such board", nil)
+	}
+	return
+}
+
+func readBoardsSQLInternal() (boards []common.Board, err error) {
+	err = dbEngine.
+		Table(boardsTable).
+		Asc(ascendingBoard, "id").
+		Find(&boards)
+	return
+}
+
+// zero values are written too, roles can be cleared
+func updateBoardSQLInternal(board *common.Board) (err error) {
+	affected, err := dbEngine.
+		Table(boardsTable).
+		ID(board.Id).
+		Cols("title", "description", "sort_order", "thread_role", "post_role").
+		Update(board)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+func deleteBoardSQLInternal(board *common.Board) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	// no thread can come in while deleting
+	_, err = session.Exec("SELECT id FROM boards WHERE id = ? FOR UPDATE", board.Id)
+	if err != nil {
+		return
+	}
+	count, err := session.
+		Table(threadsTable).
+		Where("board_id = ?", board.Id).
+		Count(&common.Thread{})
+	if err != nil {
+		return
+	}
+	if count > 0 {
+		err = common.NewError(common.CodeConflict, "board still has threads", nil)
+		return
+	}
+	affected, err := session.
+		Table(boardsTable).
+		ID(board.Id).
+		Delete(&common.Board{})
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+func readBoardThreadsPageSQLInternal(
+	board *common.Board,
+	offset int,
+	limit int,
+) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	page.Total, err = dbEngine.
+		Table(threadsTable).
+		Where("board_id = ?", board.Id).
+		Desc(descendingUpdate).
+		Limit(limit, offset).
+		FindAndCount(&page.Threads)
+	return
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const bookmarksTable = "bookmarks"
+
+// keeping a post again changes its note
+func createBookmark(ctx *gin.Context) {
+	var bookmark common.Bookmark
+	err := createBookmarkInternal(ctx, &bookmark)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &bookmark)
+}
+
+func createBookmarkInternal(ctx *gin.Context, bookmark *common.Bookmark) (err error) {
+	err = bindInternal(ctx, bookmark)
+	if err != nil {
+		return
+	}
+	if bookmark.UserId == 0 || common.IsEmpty(bookmark.PostUuId) {
+		err = common.NewError(common.CodeInvalid, "need post and user", nil)
+		return
+	}
+	if !common.NormalizeBookmark(bookmark) {
+		err = common.NewError(common.CodeInvalid, "note is too long", nil)
+		return
+	}
+	post := common.Post{UuId: bookmark.PostUuId}
+	err = readPostSQLInternal(&post)
+	if err != nil {
+		return
+	}
+	bookmark.PostId = post.Id
+	now := time.Now()
+	bookmark.UpdatedAt = now
+	bookmark.CreatedAt = now
+	err = createBookmarkSQLInternal(bookmark)
+	return
+}
+
+// only the owner removes, by id so removed posts can go too
+func deleteBookmark(ctx *gin.Context) {
+	var bookmark common.Bookmark
+	err := bindInternal(ctx, &bookmark)
+	if err == nil && (bookmark.Id == 0 || bookmark.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need id and user", nil)
+	}
+	if err == nil {
+		err = deleteBookmarkSQLInternal(&bookmark)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"deleted": "ok",
+	})
+}
+
+// newest first, with post and thread for each
+func readBookmarksPage(ctx *gin.Context) {
+	var bookmark common.Bookmark
+	err := bindInternal(ctx, &bookmark)
+	if err == nil && bookmark.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readBookmarksPageSQLInternal(bookmark.UserId, offset, limit)
+	if err == nil {
+		err = attachBookmarkedSQLInternal(page.Bookmarks)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// what the user kept in a thread, for marking buttons
+func readUserBookmarks(ctx *gin.Context) {
+	var query common.BookmarkQuery
+	err := bindInternal(ctx, &query)
+	if err == nil && (query.ThreadId == 0 || query.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	bookmarks := []common.Bookmark{}
+	err = dbEngine.
+		Table(bookmarksTable).
+		Where("user_id = ?", query.UserId).
+		And("post_id IN (SELECT id FROM posts WHERE thread_id = ?)", query.ThreadId).
+		Find(&bookmarks)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &bookmarks)
+}
+
+func createBookmarkSQLInternal(bookmark *common.Bookmark) (err error) {
+	_, err = dbEngine.Exec(
+		`INSERT INTO bookmarks (user_id, post_id, note, updated_at, created_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (user_id, post_id) DO UPDATE
+SET note = EXCLUDED.note, updated_at = EXCLUDED.updated_at`,
+		bookmark.UserId,
+		bookmark.PostId,
+		bookmark.Note,
+		bookmark.UpdatedAt,
+		bookmark.CreatedAt,
+	)
+	if err != nil {
+		return
+	}
+	_, err = dbEngine.
+		Table(bookmarksTable).
+		Where("user_id = ? AND post_id = ?", bookmark.UserId, bookmark.PostId).
+		Get(bookmark)
+	return
+}
+
+func deleteBookmarkSQLInternal(bookmark *common.Bookmark) (err error) {
+	affected, err := dbEngine.
+		Table(bookmarksTable).
+		Where("id = ? AND user_id = ?", bookmark.Id, bookmark.UserId).
+		Delete(&common.Bookmark{})
+	if err == nil && affected == 0 {
+		err = common.NewError(common.CodeNotFound, "no such bookmark", nil)
+	}
+	return
+}
+
+func readBookmarksPageSQLInternal(
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.BookmarkPage, err error) {
+	page = &common.BookmarkPage{}
+	page.Total, err = dbEngine.
+		Table(bookmarksTable).
+		Where("user_id = ?", userId).
+		Desc("id").
+		Limit(limit, offset).
+		FindAndCount(&page.Bookmarks)
+	return
+}
+
+// posts and threads of the page, one query each.
+// removed posts are not found and stay nil.
+func attachBookmarkedSQLInternal(bookmarks []common.Bookmark) (err error) {
+	if len(bookmarks) == 0 {
+		return
+	}
+	postIds := make([]uint, 0, len(bookmarks))
+	for i := range bookmarks {
+		postIds = append(postIds, bookmarks[i].PostId)
+	}
+	var posts []common.Post
+	err = dbEngine.
+		Table(postsTable).
+		In("id", postIds).
+		Find(&posts)
+	if err != nil || len(posts) == 0 {
+		return
+	}
+	threadIds := make([]uint, 0, len(posts))
+	for i := range posts {
+		threadIds = append(threadIds, posts[i].ThreadId)
+	}
+	var threads []common.Thread
+	err = dbEngine.
+		Table(threadsTable).
+		In("id", threadIds).
+		Find(&threads)
+	if err != nil {
+		return
+	}
+	postById := make(map[uint]*common.Post)
+	for i := range posts {
+		postById[posts[i].Id] = &posts[i]
+	}
+	threadById := make(map[uint]*common.Thread)
+	for i := range threads {
+		threadById[threads[i].Id] = &threads[i]
+	}
+	for i := range bookmarks {
+		post, ok := postById[bookmarks[i].PostId]
+		if !ok {
+			continue
+		}
+		bookmarks[i].Post = post
+		bookmarks[i].PostUuId = post.UuId
+		bookmarks[i].Thread = threadById[post.ThreadId]
+	}
+	return
+}
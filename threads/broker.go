@@ -0,0 +1,66 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"sync"
+)
+
+const subscriberBuffer = 16
+
+// eventBroker fans out thread events to streams in this process.
+// missed events are not kept here, streams replay them from db.
+type eventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[uint]map[chan common.ThreadEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[uint]map[chan common.ThreadEvent]struct{}),
+	}
+}
+
+func (b *eventBroker) subscribe(threadId uint) chan common.ThreadEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	ch := make(chan common.ThreadEvent, subscriberBuffer)
+	subs, ok := b.subscribers[threadId]
+	if !ok {
+		subs = make(map[chan common.ThreadEvent]struct{})
+		b.subscribers[threadId] = subs
+	}
+	subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(threadId uint, ch chan common.ThreadEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subs, ok := b.subscribers[threadId]
+	if !ok {
+		return
+	}
+	if _, ok = subs[ch]; ok {
+		delete(subs, ch)
+		close(ch)
+	}
+	if len(subs) == 0 {
+		delete(b.subscribers, threadId)
+	}
+}
+
+// never blocks. too slow subscriber is dropped,
+// its stream ends and client comes back with Last-Event-ID.
+func (b *eventBroker) publish(event *common.ThreadEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subs := b.subscribers[event.ThreadId]
+	for ch := range subs {
+		select {
+		case ch <- *event:
+		default:
+			delete(subs, ch)
+			close(ch)
+		}
+	}
+}
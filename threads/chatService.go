@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	chatRoomsTable    = "chat_rooms"
+	chatMessagesTable = "chat_messages"
+	// backlog sent on join
+	defaultBacklog = 50
+	maxBacklog     = 200
+	// router checks this too, here is the last line
+	maxChatMessageRunes = 500
+)
+
+// room names go into urls as they are
+var roomNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func createRoom(ctx *gin.Context) {
+	var room common.ChatRoom
+	err := createRoomInternal(ctx, &room)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &room)
+}
+
+func createRoomInternal(ctx *gin.Context, room *common.ChatRoom) (err error) {
+	err = bindInternal(ctx, room)
+	if err != nil {
+		return
+	}
+	if !roomNamePattern.MatchString(room.Name) {
+		err = common.NewError(
+			common.CodeInvalid,
+			"room name must be 1 to 32 of a-z, 0-9, _ and -",
+			nil,
+		)
+		return
+	}
+	room.UuId = common.NewUuIdString()
+	room.CreatedAt = time.Now()
+	err = createRoomSQLInternal(room)
+	return
+}
+
+func readRooms(ctx *gin.Context) {
+	rooms, err := readRoomsSQLInternal()
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, rooms)
+}
+
+// by name
+func readRoom(ctx *gin.Context) {
+	var room common.ChatRoom
+	err := bindInternal(ctx, &room)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	err = readRoomSQLInternal(&room)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &room)
+}
+
+func createMessage(ctx *gin.Context) {
+	var msg common.ChatMessage
+	err := createMessageInternal(ctx, &msg)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &msg)
+}
+
+func createMessageInternal(ctx *gin.Context, msg *common.ChatMessage) (err error) {
+	err = bindInternal(ctx, msg)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(msg.Body, msg.Contributor) || msg.RoomId == 0 {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	if len([]rune(msg.Body)) > maxChatMessageRunes {
+		err = common.NewError(common.CodeInvalid, "message is too long", nil)
+		return
+	}
+	msg.UuId = common.NewUuIdString()
+	msg.CreatedAt = time.Now()
+	err = createMessageSQLInternal(msg)
+	return
+}
+
+// latest messages of the room in posted order, ?limit= up to maxBacklog
+func readMessages(ctx *gin.Context) {
+	var room common.ChatRoom
+	err := bindInternal(ctx, &room)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultBacklog)))
+	if err != nil || limit < 1 || limit > maxBacklog {
+		handleErrorInternal(common.NewError(common.CodeInvalid, "invalid limit", err), ctx)
+		return
+	}
+	msgs, err := readMessagesSQLInternal(room.Id, limit)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, msgs)
+}
+
+func createRoomSQLInternal(room *common.ChatRoom) (err error) {
+	affected, err := dbEngine.
+		Table(chatRoomsTable).
+		InsertOne(room)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+func readRoomsSQLInternal() (rooms []common.ChatRoom, err error) {
+	err = dbEngine.
+		Table(chatRoomsTable).
+		Asc("name").
+		Find(&rooms)
+	return
+}
+
+func readRoomSQLInternal(room *common.ChatRoom) (err error) {
+	ok, err := dbEngine.
+		Table(chatRoomsTable).
+		Get(room)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such room", nil)
+	}
+	return
+}
+
+func createMessageSQLInternal(msg *common.ChatMessage) (err error) {
+	affected, err := dbEngine.
+		Table(chatMessagesTable).
+		InsertOne(msg)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+func readMessagesSQLInternal(roomId uint, limit int) (msgs []common.ChatMessage, err error) {
+	err = dbEngine.
+		Table(chatMessagesTable).
+		Where("room_id = ?", roomId).
+		Desc("id").
+		Limit(limit).
+		Find(&msgs)
+	// newest ones were taken, give them back oldest first
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const draftsTable = "drafts"
+
+// saving again replaces the draft, an empty one removes it
+func saveDraft(ctx *gin.Context) {
+	var draft common.Draft
+	err := saveDraftInternal(ctx, &draft)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &draft)
+}
+
+func saveDraftInternal(ctx *gin.Context, draft *common.Draft) (err error) {
+	err = bindInternal(ctx, draft)
+	if err != nil {
+		return
+	}
+	if draft.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+		return
+	}
+	if !common.NormalizeDraft(draft) {
+		err = common.NewError(common.CodeInvalid, "draft is too long", nil)
+		return
+	}
+	if draft.ThreadId != 0 {
+		thre := common.Thread{Id: draft.ThreadId}
+		err = readAThreadSQLInternal(&thre)
+		if err != nil {
+			return
+		}
+	}
+	draft.UpdatedAt = time.Now()
+	if draft.IsEmpty() {
+		draft.Id = 0
+		err = deleteDraftSQLInternal(dbEngine, draft.UserId, draft.ThreadId)
+		return
+	}
+	err = saveDraftSQLInternal(draft)
+	return
+}
+
+// not found when nothing is kept for the form
+func readDraft(ctx *gin.Context) {
+	var draft common.Draft
+	err := bindInternal(ctx, &draft)
+	if err == nil && draft.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err == nil {
+		err = readDraftSQLInternal(&draft)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &draft)
+}
+
+func deleteDraft(ctx *gin.Context) {
+	var draft common.Draft
+	err := bindInternal(ctx, &draft)
+	if err == nil && draft.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err == nil {
+		err = deleteDraftSQLInternal(dbEngine, draft.UserId, draft.ThreadId)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"deleted": "ok",
+	})
+}
+
+func saveDraftSQLInternal(draft *common.Draft) (err error) {
+	_, err = dbEngine.Exec(
+		`INSERT INTO drafts (user_id, thread_id, topic, board, tags, body, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (user_id, thread_id) DO UPDATE
+SET topic = EXCLUDED.topic, board = EXCLUDED.board, tags = EXCLUDED.tags,
+  body = EXCLUDED.body, updated_at = EXCLUDED.updated_at`,
+		draft.UserId,
+		draft.ThreadId,
+		draft.Topic,
+		draft.Board,
+		draft.Tags,
+		draft.Body,
+		draft.UpdatedAt,
+	)
+	if err != nil {
+		return
+	}
+	err = readDraftSQLInternal(draft)
+	return
+}
+
+func readDraftSQLInternal(draft *common.Draft) (err error) {
+	ok, err := dbEngine.
+		Table(draftsTable).
+		Where("user_id = ? AND thread_id = ?", draft.UserId, draft.ThreadId).
+		Get(draft)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no draft", nil)
+	}
+	return
+}
+
+// called in the transaction of a post or thread too,
+// a sent form has nothing left to keep
+func deleteDraftSQLInternal(db xorm.Interface, userId uint, threadId uint) (err error) {
+	_, err = db.
+		Table(draftsTable).
+		Where("user_id = ? AND thread_id = ?", userId, threadId).
+		Delete(&common.Draft{})
+	return
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	eventsTable       = "thread_events"
+	outboxTable       = "threads_outbox"
+	heartbeatInterval = time.Second * 15
+	// replay reads this many at once until it is caught up
+	replayPageEvents = 500
+)
+
+var broker = newEventBroker()
+
+// streams get post events through the bus, so with postgres bus
+// every threads process sees posts made on the others
+func relayPostEvent(ctx context.Context, event *common.Event) error {
+	var threEvent common.ThreadEvent
+	err := event.Decode(&threEvent)
+	if err != nil {
+		// delivering again does not fix it
+		common.LogError(logger).Printf("broken event %d: %s\n", event.Id, err.Error())
+		return nil
+	}
+	broker.publish(&threEvent)
+	return nil
+}
+
+// streams events of a thread as server-sent events.
+// events after Last-Event-ID (or ?after=) are replayed from db first.
+func streamEvents(ctx *gin.Context) {
+	threadId, after, err := streamEventsParamsInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+
+	// subscribe before replay not to lose events in between
+	ch := broker.subscribe(threadId)
+	defer broker.unsubscribe(threadId, ch)
+	missed, err := readEventsSQLInternal(threadId, after)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Status(http.StatusOK)
+	lastSent := after
+	for {
+		for i := range missed {
+			renderEventInternal(ctx, &missed[i])
+			lastSent = missed[i].Id
+		}
+		ctx.Writer.Flush()
+		if len(missed) < replayPageEvents {
+			break
+		}
+		missed, err = readEventsSQLInternal(threadId, lastSent)
+		if err != nil {
+			// client comes back with Last-Event-ID and gets the rest
+			common.LogError(logger).Println(err.Error())
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			// already sent in replay
+			if event.Id <= lastSent {
+				continue
+			}
+			renderEventInternal(ctx, &event)
+			lastSent = event.Id
+		case <-heartbeat.C:
+			fmt.Fprint(ctx.Writer, ":\n\n")
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+func streamEventsParamsInternal(ctx *gin.Context) (threadId uint, after uint, err error) {
+	id, err := strconv.ParseUint(ctx.Query("thread_id"), 10, 64)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "need thread id", err)
+		return
+	}
+	threadId = uint(id)
+	lastEventId := ctx.GetHeader("Last-Event-ID")
+	if len(lastEventId) == 0 {
+		lastEventId = ctx.DefaultQuery("after", "0")
+	}
+	id, err = strconv.ParseUint(lastEventId, 10, 64)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "invalid last event id", err)
+		return
+	}
+	after = uint(id)
+	return
+}
+
+func renderEventInternal(ctx *gin.Context, event *common.ThreadEvent) {
+	ctx.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(uint64(event.Id), 10),
+		Event: event.Kind,
+		Data:  event,
+	})
+}
+
+func readLastEvent(ctx *gin.Context) {
+	var thre common.Thread
+	err := bindInternal(ctx, &thre)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	event, err := readLastEventSQLInternal(thre.Id)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, event)
+}
+
+// stores event in the same transaction with the post change,
+// with its copy in the outbox for the bus
+func createEventSQLInternal(
+	session *xorm.Session,
+	kind string,
+	post *common.Post,
+) (err error) {
+	event := &common.ThreadEvent{
+		ThreadId:  post.ThreadId,
+		Kind:      kind,
+		Post:      *post,
+		CreatedAt: time.Now(),
+	}
+	affected, err := session.
+		Table(eventsTable).
+		InsertOne(event)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	if err != nil {
+		return
+	}
+	// subscribers in other services can not read threads table
+	thre := common.Thread{Id: post.ThreadId}
+	_, err = session.
+		Table(threadsTable).
+		Get(&thre)
+	if err != nil {
+		return
+	}
+	event.Thread = &thre
+	busEvent, err := common.NewEvent(kind, event)
+	if err != nil {
+		return
+	}
+	err = common.WriteOutbox(session, outboxTable, busEvent)
+	return
+}
+
+// events are replayed to anyone, so earlier ones of the post must not
+// keep text it does not have any more. deleted keeps only its ids.
+func forgetPostEventsSQLInternal(session *xorm.Session, post *common.Post, deleted bool) (err error) {
+	var events []common.ThreadEvent
+	err = session.
+		Table(eventsTable).
+		Where("thread_id = ? AND payload::jsonb->>'uuid' = ?", post.ThreadId, post.UuId).
+		Find(&events)
+	if err != nil {
+		return
+	}
+	for i := range events {
+		if deleted {
+			events[i].Post = post.Removed()
+		} else {
+			events[i].Post.Body = ""
+			events[i].Post.RenderedBody = ""
+			events[i].Post.Mentions = nil
+		}
+		_, err = session.
+			Table(eventsTable).
+			ID(events[i].Id).
+			Cols("payload").
+			Update(&events[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func readEventsSQLInternal(threadId uint, after uint) (events []common.ThreadEvent, err error) {
+	err = dbEngine.
+		Table(eventsTable).
+		Where("thread_id = ? AND id > ?", threadId, after).
+		Asc("id").
+		Limit(replayPageEvents).
+		Find(&events)
+	return
+}
+
+// Id is 0 when thread has no event yet
+func readLastEventSQLInternal(threadId uint) (event *common.ThreadEvent, err error) {
+	event = &common.ThreadEvent{}
+	_, err = dbEngine.
+		Table(eventsTable).
+		Where("thread_id = ?", threadId).
+		Desc("id").
+		Get(event)
+	return
+}
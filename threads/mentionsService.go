@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"time"
+
+	"xorm.io/xorm"
+)
+
+const mentionsTable = "mentions"
+
+// @names are checked by users service, it knows who exists
+// and who blocked the writer. post goes without mentions
+// while users service is down.
+func resolveMentionsInternal(ctx context.Context, post *common.Post) (mentions []common.Mention, err error) {
+	names := common.ParseMentions(post.Body)
+	if len(names) == 0 {
+		return
+	}
+	users, err := usersClient.ResolveMentions(ctx, post.UserId, names)
+	if common.IsUnavailable(err) {
+		common.LogWarning(logger).Printf("mentions dropped, users service unavailable %s\n", err.Error())
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	for _, user := range users {
+		if user.Id == post.UserId {
+			continue
+		}
+		mentions = append(mentions, common.Mention{
+			UserId:   user.Id,
+			UserName: user.Name,
+		})
+	}
+	return
+}
+
+// in the same transaction as the post
+func createMentionsSQLInternal(session *xorm.Session, post *common.Post) (err error) {
+	for i := range post.Mentions {
+		post.Mentions[i].PostId = post.Id
+		post.Mentions[i].CreatedAt = time.Now()
+		_, err = session.
+			Table(mentionsTable).
+			InsertOne(&post.Mentions[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// fills Mentions of the posts with one query
+func attachMentionsSQLInternal(posts []common.Post) (err error) {
+	if len(posts) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(posts))
+	for i := range posts {
+		ids = append(ids, posts[i].Id)
+	}
+	var mentions []common.Mention
+	err = dbEngine.
+		Table(mentionsTable).
+		In("post_id", ids).
+		Asc("id").
+		Find(&mentions)
+	if err != nil {
+		return
+	}
+	byPost := make(map[uint][]common.Mention)
+	for _, mention := range mentions {
+		byPost[mention.PostId] = append(byPost[mention.PostId], mention)
+	}
+	for i := range posts {
+		posts[i].Mentions = byPost[posts[i].Id]
+	}
+	return
+}
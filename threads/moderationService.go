@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+// router checked the moderator role already,
+// here the action is done and published for notifications
+func moderate(ctx *gin.Context) {
+	var action common.ModerationAction
+	err := moderateInternal(ctx, &action)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &action)
+}
+
+func moderateInternal(ctx *gin.Context, action *common.ModerationAction) (err error) {
+	err = bindInternal(ctx, action)
+	if err != nil {
+		return
+	}
+	if action.ModeratorId == 0 || common.IsEmpty(action.ModeratorName) {
+		err = common.NewError(common.CodeInvalid, "need moderator", nil)
+		return
+	}
+	action.CreatedAt = time.Now()
+	switch action.Action {
+	case common.ModerationPostDeleted:
+		err = moderatePostInternal(action)
+	case common.ModerationThreadLocked, common.ModerationThreadUnlocked:
+		err = moderateThreadInternal(action)
+	default:
+		err = common.NewError(common.CodeInvalid, "unknown action", nil)
+	}
+	if err != nil {
+		return
+	}
+	outbox.Wake()
+	return
+}
+
+func moderatePostInternal(action *common.ModerationAction) (err error) {
+	if common.IsEmpty(action.PostUuId) {
+		err = common.NewError(common.CodeInvalid, "need post", nil)
+		return
+	}
+	post := common.Post{UuId: action.PostUuId}
+	err = readPostSQLInternal(&post)
+	if err != nil {
+		return
+	}
+	thre := common.Thread{Id: post.ThreadId}
+	err = readAThreadSQLInternal(&thre)
+	if err != nil {
+		return
+	}
+	action.TargetUserId = post.UserId
+	action.ThreadUuId = thre.UuId
+	action.ThreadTopic = thre.Topic
+	err = deletePostSQLInternal(&post, action)
+	return
+}
+
+func moderateThreadInternal(action *common.ModerationAction) (err error) {
+	if common.IsEmpty(action.ThreadUuId) {
+		err = common.NewError(common.CodeInvalid, "need thread", nil)
+		return
+	}
+	thre := common.Thread{UuId: action.ThreadUuId}
+	err = readAThreadSQLInternal(&thre)
+	if err != nil {
+		return
+	}
+	action.TargetUserId = thre.UserId
+	action.ThreadTopic = thre.Topic
+	thre.Locked = action.Action == common.ModerationThreadLocked
+	err = lockThreadSQLInternal(&thre, action)
+	return
+}
+
+func lockThreadSQLInternal(thread *common.Thread, action *common.ModerationAction) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.
+		Table(threadsTable).
+		Where("id = ?", thread.Id).
+		Cols("locked").
+		Update(thread)
+	if err != nil {
+		return
+	}
+	err = createModerationEventSQLInternal(session, action)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+func createModerationEventSQLInternal(session *xorm.Session, action *common.ModerationAction) (err error) {
+	event, err := common.NewEvent(common.TopicModeration, action)
+	if err != nil {
+		return
+	}
+	err = common.WriteOutbox(session, outboxTable, event)
+	if err != nil {
+		err = fmt.Errorf("moderation event: %w", err)
+	}
+	return
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	pollsTable       = "polls"
+	pollOptionsTable = "poll_options"
+	pollVotesTable   = "poll_votes"
+)
+
+// nil is a thread without poll
+func normalizePollInternal(poll *common.Poll) (err error) {
+	if poll == nil {
+		return
+	}
+	if !common.NormalizePoll(poll, time.Now()) {
+		err = common.NewError(common.CodeInvalid, "invalid poll", nil)
+	}
+	return
+}
+
+// counts, and choices of query.UserId
+func readPoll(ctx *gin.Context) {
+	var query common.PollQuery
+	err := bindInternal(ctx, &query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	poll := common.Poll{ThreadId: query.ThreadId}
+	err = readPollSQLInternal(dbEngine, &poll, query.UserId)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &poll)
+}
+
+// answers the poll after the vote
+func vote(ctx *gin.Context) {
+	var poll common.Poll
+	err := voteInternal(ctx, &poll)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &poll)
+}
+
+// one ballot per user, router checked the user is not banned
+func voteInternal(ctx *gin.Context, poll *common.Poll) (err error) {
+	var ballot common.PollBallot
+	err = bindInternal(ctx, &ballot)
+	if err != nil {
+		return
+	}
+	if ballot.PollId == 0 || ballot.UserId == 0 || common.IsEmpty(ballot.UserName) {
+		err = common.NewError(common.CodeInvalid, "need poll and user for voting", nil)
+		return
+	}
+	*poll = common.Poll{Id: ballot.PollId}
+	err = voteSQLInternal(poll, &ballot)
+	return
+}
+
+// in the same transaction as the thread
+func createPollSQLInternal(session *xorm.Session, thre *common.Thread) (err error) {
+	if thre.Poll == nil {
+		return
+	}
+	poll := thre.Poll
+	poll.Id = 0
+	poll.ThreadId = thre.Id
+	poll.CreatedAt = thre.CreatedAt
+	_, err = session.
+		Table(pollsTable).
+		InsertOne(poll)
+	if err != nil {
+		return
+	}
+	for i := range poll.Options {
+		poll.Options[i].Id = 0
+		poll.Options[i].PollId = poll.Id
+		_, err = session.
+			Table(pollOptionsTable).
+			InsertOne(&poll.Options[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// poll row is locked till commit,
+// so two tabs of the same user can not both vote
+func voteSQLInternal(poll *common.Poll, ballot *common.PollBallot) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.Exec("SELECT id FROM polls WHERE id = ? FOR UPDATE", poll.Id)
+	if err != nil {
+		return
+	}
+	err = readPollSQLInternal(session, poll, ballot.UserId)
+	if err != nil {
+		return
+	}
+	if poll.IsClosed(time.Now()) {
+		err = common.NewError(common.CodeForbidden, "poll is closed", nil)
+		return
+	}
+	if poll.HasVoted() {
+		err = common.NewError(common.CodeConflict, "already voted", nil)
+		return
+	}
+	if !poll.IsValidBallot(ballot.OptionIds) {
+		err = common.NewError(common.CodeInvalid, "invalid choices", nil)
+		return
+	}
+	userName := ballot.UserName
+	if poll.Anonymous {
+		userName = ""
+	}
+	for _, optionId := range ballot.OptionIds {
+		_, err = session.
+			Table(pollVotesTable).
+			InsertOne(&common.PollVote{
+				PollId:    poll.Id,
+				OptionId:  optionId,
+				UserId:    ballot.UserId,
+				UserName:  userName,
+				CreatedAt: time.Now(),
+			})
+		if err != nil {
+			return
+		}
+	}
+	err = readPollSQLInternal(session, poll, ballot.UserId)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// by Id or ThreadId, with options, counts and choices of userId
+func readPollSQLInternal(db xorm.Interface, poll *common.Poll, userId uint) (err error) {
+	if poll.Id == 0 && poll.ThreadId == 0 {
+		err = common.NewError(common.CodeInvalid, "need poll or thread", nil)
+		return
+	}
+	// only the key is a condition
+	*poll = common.Poll{Id: poll.Id, ThreadId: poll.ThreadId}
+	ok, err := db.
+		Table(pollsTable).
+		Get(poll)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such poll", nil)
+	}
+	if err != nil {
+		return
+	}
+	err = db.
+		Table(pollOptionsTable).
+		Where("poll_id = ?", poll.Id).
+		Asc("position").
+		Find(&poll.Options)
+	if err != nil {
+		return
+	}
+	var votes []common.PollVote
+	err = db.
+		Table(pollVotesTable).
+		Where("poll_id = ?", poll.Id).
+		Asc("id").
+		Find(&votes)
+	if err != nil {
+		return
+	}
+	common.CountPollVotes(poll, votes, userId)
+	return
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const reactionsTable = "reactions"
+
+// answers the counts of the post after the toggle
+func toggleReaction(ctx *gin.Context) {
+	var reaction common.Reaction
+	counts, err := toggleReactionInternal(ctx, &reaction)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &counts)
+}
+
+// router checked the user is not banned
+func toggleReactionInternal(ctx *gin.Context, reaction *common.Reaction) (counts []common.ReactionCount, err error) {
+	err = bindInternal(ctx, reaction)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(reaction.PostUuId) || reaction.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need post and user for reaction", nil)
+		return
+	}
+	if !common.IsValidReactionKind(reaction.Kind) {
+		err = common.NewError(common.CodeInvalid, "unknown reaction", nil)
+		return
+	}
+	post := common.Post{UuId: reaction.PostUuId}
+	err = readPostSQLInternal(&post)
+	if err != nil {
+		return
+	}
+	reaction.PostId = post.Id
+	reaction.CreatedAt = time.Now()
+	err = toggleReactionSQLInternal(reaction)
+	if err != nil {
+		return
+	}
+	posts := []common.Post{post}
+	err = attachReactionsSQLInternal(posts)
+	if err != nil {
+		return
+	}
+	mine, err := readUserReactionsSQLInternal("post_id = ?", post.Id, reaction.UserId)
+	if err != nil {
+		return
+	}
+	common.MarkMyReactions(posts, mine)
+	counts = posts[0].Reactions
+	return
+}
+
+// what the user gave in a thread, for marking buttons
+func readUserReactions(ctx *gin.Context) {
+	var query common.ReactionQuery
+	err := bindInternal(ctx, &query)
+	if err == nil && (query.ThreadId == 0 || query.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	reactions, err := readUserReactionsSQLInternal(
+		"post_id IN (SELECT id FROM posts WHERE thread_id = ?)",
+		query.ThreadId,
+		query.UserId,
+	)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &reactions)
+}
+
+// taken back when it is there, given when it is not
+func toggleReactionSQLInternal(reaction *common.Reaction) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
+		Table(reactionsTable).
+		Where("post_id = ? AND user_id = ? AND kind = ?", reaction.PostId, reaction.UserId, reaction.Kind).
+		Delete(&common.Reaction{})
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		_, err = session.
+			Table(reactionsTable).
+			InsertOne(reaction)
+		if err != nil {
+			return
+		}
+	}
+	err = session.Commit()
+	return
+}
+
+// fills Reactions of the posts with one query
+func attachReactionsSQLInternal(posts []common.Post) (err error) {
+	if len(posts) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(posts))
+	for i := range posts {
+		ids = append(ids, posts[i].Id)
+	}
+	var counts []common.ReactionCount
+	err = dbEngine.
+		Table(reactionsTable).
+		Select("post_id, kind, COUNT(*) AS count").
+		In("post_id", ids).
+		GroupBy("post_id, kind").
+		Find(&counts)
+	if err != nil {
+		return
+	}
+	byPost := make(map[uint][]common.ReactionCount)
+	for _, count := range counts {
+		byPost[count.PostId] = append(byPost[count.PostId], count)
+	}
+	for i := range posts {
+		posts[i].Reactions = common.SortReactionCounts(byPost[posts[i].Id])
+	}
+	return
+}
+
+func readUserReactionsSQLInternal(condition string, id uint, userId uint) (reactions []common.Reaction, err error) {
+	reactions = []common.Reaction{}
+	err = dbEngine.
+		Table(reactionsTable).
+		Where("user_id = ?", userId).
+		And(condition, id).
+		Find(&reactions)
+	return
+}
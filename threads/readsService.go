@@ -0,0 +1,171 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	threadReadsTable  = "thread_reads"
+	readHorizonsTable = "read_horizons"
+)
+
+// answers what was read before, so the page can go to the first new post
+func markRead(ctx *gin.Context) {
+	var read common.ThreadRead
+	previous, err := markReadInternal(ctx, &read)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, previous)
+}
+
+func markReadInternal(ctx *gin.Context, read *common.ThreadRead) (previous *common.ThreadRead, err error) {
+	err = bindInternal(ctx, read)
+	if err != nil {
+		return
+	}
+	if read.ThreadId == 0 || read.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+		return
+	}
+	previous = &common.ThreadRead{ThreadId: read.ThreadId, UserId: read.UserId}
+	err = readThreadReadSQLInternal(previous)
+	if err != nil {
+		return
+	}
+	err = markReadSQLInternal(dbEngine, read.ThreadId, read.UserId, read.LastNumber, time.Now())
+	return
+}
+
+// threads without unread posts are left out
+func countUnread(ctx *gin.Context) {
+	var query common.UnreadQuery
+	err := bindInternal(ctx, &query)
+	if err == nil && query.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	counts, err := countUnreadSQLInternal(&query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &counts)
+}
+
+// one horizon row replaces every per thread row of the user
+func markAllRead(ctx *gin.Context) {
+	var horizon common.ReadHorizon
+	err := bindInternal(ctx, &horizon)
+	if err == nil && horizon.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	horizon.ReadAllAt = time.Now()
+	err = markAllReadSQLInternal(&horizon)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &horizon)
+}
+
+// zero values when the user never read the thread
+func readThreadReadSQLInternal(read *common.ThreadRead) (err error) {
+	_, err = dbEngine.
+		Table(threadReadsTable).
+		Where("user_id = ? AND thread_id = ?", read.UserId, read.ThreadId).
+		Get(read)
+	if err != nil {
+		return
+	}
+	horizon := common.ReadHorizon{UserId: read.UserId}
+	_, err = dbEngine.
+		Table(readHorizonsTable).
+		Get(&horizon)
+	read.ReadAllAt = horizon.ReadAllAt
+	return
+}
+
+// only moves forward, an old tab does not make posts unread again.
+// called in the transaction of a post too.
+func markReadSQLInternal(db xorm.Interface, threadId uint, userId uint, number uint, now time.Time) (err error) {
+	_, err = db.Exec(
+		`INSERT INTO thread_reads (user_id, thread_id, last_number, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (user_id, thread_id) DO UPDATE
+SET last_number = GREATEST(thread_reads.last_number, EXCLUDED.last_number),
+  updated_at = EXCLUDED.updated_at`,
+		userId,
+		threadId,
+		number,
+		now,
+	)
+	return
+}
+
+// posts of others after both the thread row and the horizon
+func countUnreadSQLInternal(query *common.UnreadQuery) (counts []common.UnreadCount, err error) {
+	counts = []common.UnreadCount{}
+	if len(query.ThreadIds) == 0 {
+		return
+	}
+	horizon := common.ReadHorizon{UserId: query.UserId}
+	_, err = dbEngine.
+		Table(readHorizonsTable).
+		Get(&horizon)
+	if err != nil {
+		return
+	}
+	err = dbEngine.
+		Table(postsTable).Alias("p").
+		Select("p.thread_id, COUNT(*) AS unread").
+		Join("LEFT", []string{threadReadsTable, "r"}, "r.thread_id = p.thread_id AND r.user_id = ?", query.UserId).
+		Where("p.deleted_at IS NULL AND p.user_id <> ?", query.UserId).
+		And("p.number > COALESCE(r.last_number, 0) AND p.created_at > ?", horizon.ReadAllAt).
+		In("p.thread_id", query.ThreadIds).
+		GroupBy("p.thread_id").
+		Find(&counts)
+	return
+}
+
+func markAllReadSQLInternal(horizon *common.ReadHorizon) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.Exec(
+		`INSERT INTO read_horizons (user_id, read_all_at) VALUES (?, ?)
+ON CONFLICT (user_id) DO UPDATE SET read_all_at = EXCLUDED.read_all_at`,
+		horizon.UserId,
+		horizon.ReadAllAt,
+	)
+	if err != nil {
+		return
+	}
+	// every post they tell about is before the horizon now
+	_, err = session.Exec(
+		"DELETE FROM thread_reads WHERE user_id = ? AND updated_at <= ?",
+		horizon.UserId,
+		horizon.ReadAllAt,
+	)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
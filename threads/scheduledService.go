@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scheduledTable = "scheduled_posts"
+	// posts go out at most this late
+	schedulerInterval = 30 * time.Second
+	// due ones published in one round, the rest wait for the next
+	schedulerBatch = 100
+)
+
+// only what can be told now, everything is
+// checked again at publish time
+func createScheduled(ctx *gin.Context) {
+	var scheduled common.ScheduledPost
+	err := createScheduledInternal(ctx, &scheduled)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &scheduled)
+}
+
+func createScheduledInternal(ctx *gin.Context, scheduled *common.ScheduledPost) (err error) {
+	err = bindInternal(ctx, scheduled)
+	if err != nil {
+		return
+	}
+	if scheduled.UserId == 0 || common.IsEmpty(scheduled.UserName) {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+		return
+	}
+	now := time.Now()
+	if !common.CheckPublishAt(scheduled.PublishAt, now) {
+		err = common.NewError(common.CodeInvalid, "publish time is not in the coming year", nil)
+		return
+	}
+	if scheduled.IsThread() {
+		err = checkScheduledThreadInternal(scheduled)
+	} else {
+		err = checkScheduledReplyInternal(scheduled)
+	}
+	if err != nil {
+		return
+	}
+	pending, err := dbEngine.
+		Table(scheduledTable).
+		Where("user_id = ? AND state = ?", scheduled.UserId, common.ScheduledPending).
+		Count(&common.ScheduledPost{})
+	if err != nil {
+		return
+	}
+	if pending >= common.MaxScheduledPerUser {
+		err = common.NewError(common.CodeInvalid, "too many scheduled posts", nil)
+		return
+	}
+	scheduled.Id = 0
+	scheduled.State = common.ScheduledPending
+	scheduled.Reason = ""
+	scheduled.PublishedUuId = ""
+	scheduled.CreatedAt = now
+	err = createScheduledSQLInternal(scheduled)
+	return
+}
+
+func checkScheduledThreadInternal(scheduled *common.ScheduledPost) (err error) {
+	if common.IsEmpty(scheduled.Topic) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	scheduled.Tags, err = normalizeTagsInternal(scheduled.Tags)
+	if err != nil {
+		return
+	}
+	board, err := readThreadBoardInternal(scheduled.BoardId)
+	if err != nil {
+		return
+	}
+	scheduled.BoardId = board.Id
+	return
+}
+
+// lock is checked again at publish time
+func checkScheduledReplyInternal(scheduled *common.ScheduledPost) (err error) {
+	if common.IsEmpty(scheduled.Body) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	thre := common.Thread{Id: scheduled.ThreadId}
+	err = readAThreadSQLInternal(&thre)
+	if err != nil {
+		return
+	}
+	if thre.Locked {
+		err = common.NewError(common.CodeForbidden, "thread is locked", nil)
+		return
+	}
+	scheduled.Topic = ""
+	scheduled.BoardId = 0
+	scheduled.Tags = nil
+	return
+}
+
+// newest publish time first, replies with their thread
+func readScheduledPage(ctx *gin.Context) {
+	var scheduled common.ScheduledPost
+	err := bindInternal(ctx, &scheduled)
+	if err == nil && scheduled.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readScheduledPageSQLInternal(scheduled.UserId, offset, limit)
+	if err == nil {
+		err = attachScheduledThreadsSQLInternal(page.Scheduled)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// only pending ones of the owner, conflict once it went out
+func cancelScheduled(ctx *gin.Context) {
+	var scheduled common.ScheduledPost
+	err := bindInternal(ctx, &scheduled)
+	if err == nil && (scheduled.Id == 0 || scheduled.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need id and user", nil)
+	}
+	if err == nil {
+		err = cancelScheduledSQLInternal(&scheduled)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &scheduled)
+}
+
+// draft of the form is done with, like when posting
+func createScheduledSQLInternal(scheduled *common.ScheduledPost) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.
+		Table(scheduledTable).
+		InsertOne(scheduled)
+	if err != nil {
+		return
+	}
+	err = deleteDraftSQLInternal(session, scheduled.UserId, scheduled.ThreadId)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+func readScheduledPageSQLInternal(
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.ScheduledPage, err error) {
+	page = &common.ScheduledPage{}
+	page.Total, err = dbEngine.
+		Table(scheduledTable).
+		Where("user_id = ?", userId).
+		Desc("publish_at").
+		Limit(limit, offset).
+		FindAndCount(&page.Scheduled)
+	return
+}
+
+// removed threads stay nil
+func attachScheduledThreadsSQLInternal(scheduled []common.ScheduledPost) (err error) {
+	threadIds := make([]uint, 0, len(scheduled))
+	for i := range scheduled {
+		if !scheduled[i].IsThread() {
+			threadIds = append(threadIds, scheduled[i].ThreadId)
+		}
+	}
+	if len(threadIds) == 0 {
+		return
+	}
+	var threads []common.Thread
+	err = dbEngine.
+		Table(threadsTable).
+		In("id", threadIds).
+		Find(&threads)
+	if err != nil {
+		return
+	}
+	threadById := make(map[uint]*common.Thread)
+	for i := range threads {
+		threadById[threads[i].Id] = &threads[i]
+	}
+	for i := range scheduled {
+		scheduled[i].Thread = threadById[scheduled[i].ThreadId]
+	}
+	return
+}
+
+func cancelScheduledSQLInternal(scheduled *common.ScheduledPost) (err error) {
+	affected, err := dbEngine.
+		Table(scheduledTable).
+		Where("id = ? AND user_id = ? AND state = ?", scheduled.Id, scheduled.UserId, common.ScheduledPending).
+		Cols("state").
+		Update(&common.ScheduledPost{State: common.ScheduledCancelled})
+	if err != nil {
+		return
+	}
+	ok, err := dbEngine.
+		Table(scheduledTable).
+		Where("id = ? AND user_id = ?", scheduled.Id, scheduled.UserId).
+		Get(scheduled)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such scheduled post", nil)
+	}
+	if err == nil && affected == 0 {
+		err = common.NewError(common.CodeConflict, "already "+scheduled.State, nil)
+	}
+	return
+}
+
+// schedulerWorker publishes due posts. each one is locked while
+// being done, so several threads processes can run it at once.
+type schedulerWorker struct{}
+
+func (w *schedulerWorker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.publishDueInternal(ctx, time.Now())
+	}
+}
+
+// one failing post does not stop the others
+func (w *schedulerWorker) publishDueInternal(ctx context.Context, now time.Time) {
+	var ids []uint
+	err := dbEngine.
+		SQL(`SELECT id FROM scheduled_posts WHERE state = ? AND publish_at <= ?
+  ORDER BY publish_at LIMIT ?`, common.ScheduledPending, now, schedulerBatch).
+		Find(&ids)
+	if err != nil {
+		common.LogError(logger).Printf("scheduler: %s\n", err.Error())
+		return
+	}
+	for _, id := range ids {
+		err = w.publishOneInternal(ctx, id)
+		if err != nil {
+			common.LogError(logger).Printf("scheduled post %d: %s\n", id, err.Error())
+		}
+	}
+}
+
+// post may go out twice when commit fails after publishing, never lost.
+// locked threads, bans and such are checked now, not when scheduled,
+// and fail the post for good. anything else is tried next round.
+func (w *schedulerWorker) publishOneInternal(ctx context.Context, id uint) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	var scheduled common.ScheduledPost
+	ok, err := session.
+		SQL("SELECT * FROM scheduled_posts WHERE id = ? AND state = ? FOR UPDATE SKIP LOCKED",
+			id, common.ScheduledPending).
+		Get(&scheduled)
+	// cancelled in between, or another process has it
+	if err != nil || !ok {
+		return
+	}
+	threUuId, err := publishScheduledInternal(ctx, &scheduled)
+	if reason, final := common.IsFinalPublishError(err); final {
+		common.LogWarning(logger).Printf("scheduled post %d failed: %s\n", id, err.Error())
+		scheduled.State = common.ScheduledFailed
+		scheduled.Reason = reason
+	} else if err != nil {
+		return
+	} else {
+		scheduled.State = common.ScheduledPublished
+		scheduled.PublishedUuId = threUuId
+	}
+	_, err = session.
+		Table(scheduledTable).
+		ID(scheduled.Id).
+		Cols("state", "reason", "published_uu_id").
+		Update(&scheduled)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// goes the same way as posts from router, with the user as they are now
+func publishScheduledInternal(ctx context.Context, scheduled *common.ScheduledPost) (threUuId string, err error) {
+	user, err := usersClient.ReadUser(ctx, scheduled.UserId)
+	if err != nil {
+		return
+	}
+	if user.IsBanned(time.Now()) {
+		err = common.NewError(common.CodeForbidden, "user is banned", nil)
+		return
+	}
+	if !scheduled.IsThread() {
+		threUuId, err = publishScheduledReplyInternal(ctx, scheduled, user)
+		return
+	}
+	thre := common.Thread{
+		Topic:   scheduled.Topic,
+		Owner:   user.Name,
+		UserId:  user.Id,
+		BoardId: scheduled.BoardId,
+		Tags:    scheduled.Tags,
+	}
+	err = publishThreadInternal(ctx, &thre)
+	if err != nil {
+		return
+	}
+	threUuId = thre.UuId
+	if common.IsEmpty(scheduled.Body) {
+		return
+	}
+	// the thread is out, publishing again would make a second one
+	reply := *scheduled
+	reply.ThreadId = thre.Id
+	_, err = publishScheduledReplyInternal(ctx, &reply, user)
+	if err != nil {
+		common.LogError(logger).Printf("first post of scheduled thread %d: %s\n", scheduled.Id, err.Error())
+		scheduled.Reason = "published without its first post"
+		err = nil
+	}
+	return
+}
+
+func publishScheduledReplyInternal(
+	ctx context.Context,
+	scheduled *common.ScheduledPost,
+	user *common.User,
+) (threUuId string, err error) {
+	post := common.Post{
+		Body:        scheduled.Body,
+		Contributor: user.Name,
+		UserId:      user.Id,
+		ThreadId:    scheduled.ThreadId,
+	}
+	err = publishPostInternal(ctx, &post)
+	if err != nil {
+		return
+	}
+	// the post is out, nothing after it may fail it now.
+	// router counts replies of its own posts the same way.
+	_, bumpErr := dbEngine.Exec(
+		"UPDATE threads SET num_replies = num_replies + 1, last_update = ? WHERE id = ?",
+		post.CreatedAt,
+		post.ThreadId,
+	)
+	if bumpErr != nil {
+		common.LogError(logger).Printf("replies of thread %d: %s\n", post.ThreadId, bumpErr.Error())
+	}
+	thre := common.Thread{Id: post.ThreadId}
+	if readErr := readAThreadSQLInternal(&thre); readErr != nil {
+		common.LogError(logger).Printf("thread %d: %s\n", post.ThreadId, readErr.Error())
+	}
+	threUuId = thre.UuId
+	return
+}
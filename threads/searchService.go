@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+// thread topic is shorter than a post, so a hit weighs more
+const topicRankWeight = 2
+
+func search(ctx *gin.Context) {
+	var query common.SearchQuery
+	err := bindInternal(ctx, &query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := searchInternal(&query, offset, limit)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func searchInternal(query *common.SearchQuery, offset, limit int) (page *common.SearchPage, err error) {
+	tsquery := common.SearchTSQuery(query.Query)
+	if common.IsEmpty(tsquery) {
+		err = common.NewError(common.CodeInvalid, "nothing to search", nil)
+		return
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && query.To.Before(query.From) {
+		err = common.NewError(common.CodeInvalid, "date range is reversed", nil)
+		return
+	}
+	page, err = searchSQLInternal(query, tsquery, offset, limit)
+	if err != nil {
+		return
+	}
+	terms := common.SearchTerms(query.Query)
+	for i := range page.Results {
+		page.Results[i].Snippet = common.Snippet(page.Results[i].Text, terms)
+	}
+	return
+}
+
+// filters of one side of the union, author and created_at
+// columns are given as they differ between threads and posts
+func searchFiltersInternal(
+	query *common.SearchQuery,
+	author string,
+	createdAt string,
+) (where string, args []interface{}) {
+	var conds []string
+	if !common.IsEmpty(query.Author) {
+		conds = append(conds, author+" = ?")
+		args = append(args, query.Author)
+	}
+	if !common.IsEmpty(query.Thread) {
+		conds = append(conds, "t.uu_id = ?")
+		args = append(args, query.Thread)
+	}
+	if !query.From.IsZero() {
+		conds = append(conds, createdAt+" >= ?")
+		args = append(args, query.From)
+	}
+	if !query.To.IsZero() {
+		conds = append(conds, createdAt+" < ?")
+		args = append(args, query.To)
+	}
+	for _, cond := range conds {
+		where += " AND " + cond
+	}
+	return
+}
+
+// threads and posts in one ranked list, total comes with every row
+func searchSQLInternal(
+	query *common.SearchQuery,
+	tsquery string,
+	offset int,
+	limit int,
+) (page *common.SearchPage, err error) {
+	threadsWhere, threadsArgs := searchFiltersInternal(query, "t.owner", "t.created_at")
+	postsWhere, postsArgs := searchFiltersInternal(query, "p.contributor", "p.created_at")
+	sql := fmt.Sprintf(`SELECT r.*, COUNT(*) OVER () AS total FROM (
+  SELECT '%s' AS kind, t.uu_id AS thread_uu_id, t.topic, 0 AS number,
+    t.owner AS author, t.topic AS text, t.created_at,
+    ts_rank(t.search_vector, q) * %d AS rank
+  FROM threads t, CAST(? AS tsquery) q
+  WHERE t.search_vector @@ q%s
+  UNION ALL
+  SELECT '%s', t.uu_id, t.topic, p.number,
+    p.contributor, p.body, p.created_at,
+    ts_rank(p.search_vector, q)
+  FROM posts p JOIN threads t ON t.id = p.thread_id, CAST(? AS tsquery) q
+  WHERE p.deleted_at IS NULL AND p.search_vector @@ q%s
+) r
+ORDER BY r.rank DESC, r.created_at DESC
+LIMIT ? OFFSET ?`,
+		common.SearchKindThread, topicRankWeight, threadsWhere,
+		common.SearchKindPost, postsWhere,
+	)
+	args := []interface{}{tsquery}
+	args = append(args, threadsArgs...)
+	args = append(args, tsquery)
+	args = append(args, postsArgs...)
+	args = append(args, limit, offset)
+
+	page = &common.SearchPage{Results: []common.SearchResult{}}
+	err = dbEngine.SQL(sql, args...).Find(&page.Results)
+	if err != nil {
+		return
+	}
+	// no rows past the last page, total stays 0 then
+	if len(page.Results) > 0 {
+		page.Total = page.Results[0].Total
+	}
+	return
+}
+
+// search_vector is kept by hand in the same transaction,
+// tokens are cut by common.SearchDocument not by postgres
+func indexSQLInternal(db xorm.Interface, table string, id uint, text string) (err error) {
+	_, err = db.Exec(
+		fmt.Sprintf("UPDATE %s SET search_vector = CAST(? AS tsvector) WHERE id = ?", table),
+		common.SearchDocument(text),
+		id,
+	)
+	return
+}
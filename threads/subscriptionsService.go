@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	subscriptionsTable = "subscriptions"
+	// first key of pg_try_advisory_xact_lock, the second is the user
+	digestLockKey = 45
+)
+
+// watching again is fine, the first one is kept
+func subscribe(ctx *gin.Context) {
+	var sub common.Subscription
+	err := subscribeInternal(ctx, &sub)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &sub)
+}
+
+func subscribeInternal(ctx *gin.Context, sub *common.Subscription) (err error) {
+	err = bindInternal(ctx, sub)
+	if err != nil {
+		return
+	}
+	if sub.ThreadId == 0 || sub.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+		return
+	}
+	thre := common.Thread{Id: sub.ThreadId}
+	err = readAThreadSQLInternal(&thre)
+	if err != nil {
+		return
+	}
+	err = subscribeSQLInternal(dbEngine, sub.ThreadId, sub.UserId, time.Now())
+	if err != nil {
+		return
+	}
+	err = readSubscriptionSQLInternal(sub)
+	return
+}
+
+func unsubscribe(ctx *gin.Context) {
+	var sub common.Subscription
+	err := bindInternal(ctx, &sub)
+	if err == nil && (sub.ThreadId == 0 || sub.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+	}
+	if err == nil {
+		err = unsubscribeSQLInternal(&sub)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"deleted": "ok",
+	})
+}
+
+// not found when the user does not watch the thread
+func readSubscription(ctx *gin.Context) {
+	var sub common.Subscription
+	err := bindInternal(ctx, &sub)
+	if err == nil && (sub.ThreadId == 0 || sub.UserId == 0) {
+		err = common.NewError(common.CodeInvalid, "need thread and user", nil)
+	}
+	if err == nil {
+		err = readSubscriptionSQLInternal(&sub)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &sub)
+}
+
+// threads the user watches, latest activity first
+func readWatchedThreadsPage(ctx *gin.Context) {
+	var sub common.Subscription
+	err := bindInternal(ctx, &sub)
+	if err == nil && sub.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readWatchedThreadsPageSQLInternal(sub.UserId, offset, limit)
+	if err == nil {
+		err = attachTagsSQLInternal(page.Threads)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// own posts before since are not news, digests start from there.
+// called in the transaction of a post too.
+func subscribeSQLInternal(db xorm.Interface, threadId uint, userId uint, since time.Time) (err error) {
+	_, err = db.Exec(
+		`INSERT INTO subscriptions (thread_id, user_id, created_at, digested_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (thread_id, user_id) DO NOTHING`,
+		threadId,
+		userId,
+		since,
+		since,
+	)
+	return
+}
+
+func unsubscribeSQLInternal(sub *common.Subscription) (err error) {
+	_, err = dbEngine.
+		Table(subscriptionsTable).
+		Where("thread_id = ? AND user_id = ?", sub.ThreadId, sub.UserId).
+		Delete(&common.Subscription{})
+	return
+}
+
+func readSubscriptionSQLInternal(sub *common.Subscription) (err error) {
+	ok, err := dbEngine.
+		Table(subscriptionsTable).
+		Where("thread_id = ? AND user_id = ?", sub.ThreadId, sub.UserId).
+		Get(sub)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "not subscribed", nil)
+	}
+	return
+}
+
+func readWatchedThreadsPageSQLInternal(
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	page.Total, err = dbEngine.
+		Table(threadsTable).
+		Where("id IN (SELECT thread_id FROM subscriptions WHERE user_id = ?)", userId).
+		Desc(descendingUpdate).
+		Limit(limit, offset).
+		FindAndCount(&page.Threads)
+	return
+}
+
+// digestWorker mails every user the posts that came to watched
+// threads since the last digest. a user is locked while being
+// done, so several threads processes can run it at once.
+type digestWorker struct {
+	mailer  common.Mailer
+	baseURL string
+}
+
+func (w *digestWorker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.sendDigestsInternal(ctx, time.Now())
+	}
+}
+
+// one failing user does not stop the others, they are tried next time
+func (w *digestWorker) sendDigestsInternal(ctx context.Context, until time.Time) {
+	userIds, err := readDigestUsersSQLInternal(until)
+	if err != nil {
+		common.LogError(logger).Printf("digests: %s\n", err.Error())
+		return
+	}
+	for _, userId := range userIds {
+		err = w.sendDigestInternal(ctx, userId, until)
+		if err != nil {
+			common.LogError(logger).Printf("digest for user %d: %s\n", userId, err.Error())
+		}
+	}
+}
+
+// mail may go out twice when commit fails after sending, never lost
+func (w *digestWorker) sendDigestInternal(ctx context.Context, userId uint, until time.Time) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	var locked bool
+	_, err = session.
+		SQL("SELECT pg_try_advisory_xact_lock(?, ?)", digestLockKey, userId).
+		Get(&locked)
+	if err != nil || !locked {
+		return
+	}
+	threads, err := readDigestSQLInternal(session, userId, until)
+	if err != nil || len(threads) == 0 {
+		return
+	}
+	user, err := usersClient.ReadUser(ctx, userId)
+	// gone users get nothing, their posts are skipped for good
+	if common.ErrorCodeOf(err) == common.CodeNotFound {
+		err = nil
+	} else if err != nil {
+		return
+	} else if mail := common.FormatDigest(user, w.baseURL, threads); mail != nil {
+		err = w.mailer.Send(ctx, mail)
+		// sending again does not fix a broken address
+		if common.ErrorCodeOf(err) == common.CodeInvalid {
+			common.LogWarning(logger).Printf("digest for user %d: %s\n", userId, err.Error())
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	_, err = session.Exec(
+		"UPDATE subscriptions SET digested_at = ? WHERE user_id = ? AND digested_at < ?",
+		until,
+		userId,
+		until,
+	)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// who has news from others in a watched thread
+func readDigestUsersSQLInternal(until time.Time) (userIds []uint, err error) {
+	err = dbEngine.
+		SQL(`SELECT DISTINCT s.user_id FROM subscriptions s
+  JOIN posts p ON p.thread_id = s.thread_id
+  WHERE p.deleted_at IS NULL AND p.user_id <> s.user_id
+    AND p.created_at > s.digested_at AND p.created_at <= ?`, until).
+		Find(&userIds)
+	return
+}
+
+// new posts per watched thread, the first few of each with the count
+func readDigestSQLInternal(
+	session *xorm.Session,
+	userId uint,
+	until time.Time,
+) (threads []common.DigestThread, err error) {
+	var counts []struct {
+		ThreadId uint  `xorm:"'thread_id'"`
+		NumNew   int64 `xorm:"'num_new'"`
+	}
+	err = session.
+		SQL(`SELECT p.thread_id, COUNT(*) AS num_new FROM posts p
+  JOIN subscriptions s ON s.thread_id = p.thread_id AND s.user_id = ?
+  WHERE p.deleted_at IS NULL AND p.user_id <> s.user_id
+    AND p.created_at > s.digested_at AND p.created_at <= ?
+  GROUP BY p.thread_id`, userId, until).
+		Find(&counts)
+	if err != nil || len(counts) == 0 {
+		return
+	}
+	var posts []common.Post
+	err = session.
+		SQL(`SELECT * FROM (
+  SELECT p.*, ROW_NUMBER() OVER (PARTITION BY p.thread_id ORDER BY p.id) AS nth FROM posts p
+  JOIN subscriptions s ON s.thread_id = p.thread_id AND s.user_id = ?
+  WHERE p.deleted_at IS NULL AND p.user_id <> s.user_id
+    AND p.created_at > s.digested_at AND p.created_at <= ?
+) AS news WHERE nth <= ? ORDER BY id`, userId, until, common.DigestPostsPerThread).
+		Find(&posts)
+	if err != nil {
+		return
+	}
+	ids := make([]uint, 0, len(counts))
+	for _, count := range counts {
+		ids = append(ids, count.ThreadId)
+	}
+	var thres []common.Thread
+	err = session.
+		Table(threadsTable).
+		In("id", ids).
+		Desc(descendingUpdate).
+		Find(&thres)
+	if err != nil {
+		return
+	}
+	numNew := make(map[uint]int64)
+	for _, count := range counts {
+		numNew[count.ThreadId] = count.NumNew
+	}
+	for _, thre := range thres {
+		digest := common.DigestThread{Thread: thre, NumNew: numNew[thre.Id]}
+		for _, post := range posts {
+			if post.ThreadId == thre.Id {
+				digest.Posts = append(digest.Posts, post)
+			}
+		}
+		threads = append(threads, digest)
+	}
+	return
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	threadTagsTable = "thread_tags"
+	defaultTagLimit = 50
+	maxTagLimit     = 200
+	// threads having the tag
+	taggedCondition = "id IN (SELECT thread_id FROM thread_tags WHERE tag = ?)"
+)
+
+// router checked the moderator role already
+func updateTags(ctx *gin.Context) {
+	var thre common.Thread
+	err := updateTagsInternal(ctx, &thre)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &thre)
+}
+
+// tags are replaced as a whole
+func updateTagsInternal(ctx *gin.Context, thre *common.Thread) (err error) {
+	err = bindInternal(ctx, thre)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(thre.UuId) {
+		err = common.NewError(common.CodeInvalid, "need uuid for finding thread", nil)
+		return
+	}
+	tags, err := normalizeTagsInternal(thre.Tags)
+	if err != nil {
+		return
+	}
+	*thre = common.Thread{UuId: thre.UuId}
+	err = readAThreadSQLInternal(thre)
+	if err != nil {
+		return
+	}
+	thre.Tags = tags
+	err = replaceTagsSQLInternal(thre)
+	return
+}
+
+// most used first
+func readTags(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultTagLimit)))
+	if err != nil || limit <= 0 || limit > maxTagLimit {
+		handleErrorInternal(common.NewError(common.CodeInvalid, "invalid limit", err), ctx)
+		return
+	}
+	tags, err := readTagsSQLInternal(limit)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &tags)
+}
+
+func normalizeTagsInternal(tags []string) (normalized []string, err error) {
+	normalized, ok := common.NormalizeTags(tags)
+	if !ok {
+		err = common.NewError(common.CodeInvalid, "invalid tags", nil)
+	}
+	return
+}
+
+// ?tag= of index queries, empty when not given
+func tagQueryInternal(ctx *gin.Context) (tag string, err error) {
+	raw, ok := ctx.GetQuery("tag")
+	if !ok {
+		return
+	}
+	tag = common.NormalizeTag(raw)
+	if len(tag) == 0 {
+		err = common.NewError(common.CodeInvalid, "invalid tag", nil)
+	}
+	return
+}
+
+// in the same transaction as the thread
+func createTagsSQLInternal(session *xorm.Session, thre *common.Thread) (err error) {
+	for _, tag := range thre.Tags {
+		_, err = session.
+			Table(threadTagsTable).
+			InsertOne(&common.ThreadTag{ThreadId: thre.Id, Tag: tag})
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func replaceTagsSQLInternal(thre *common.Thread) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.
+		Table(threadTagsTable).
+		Where("thread_id = ?", thre.Id).
+		Delete(&common.ThreadTag{})
+	if err != nil {
+		return
+	}
+	err = createTagsSQLInternal(session, thre)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// fills Tags of the threads with one query
+func attachTagsSQLInternal(threads []common.Thread) (err error) {
+	if len(threads) == 0 {
+		return
+	}
+	ids := make([]uint, 0, len(threads))
+	for i := range threads {
+		ids = append(ids, threads[i].Id)
+	}
+	var tags []common.ThreadTag
+	err = dbEngine.
+		Table(threadTagsTable).
+		In("thread_id", ids).
+		Asc("id").
+		Find(&tags)
+	if err != nil {
+		return
+	}
+	byThread := make(map[uint][]string)
+	for _, tag := range tags {
+		byThread[tag.ThreadId] = append(byThread[tag.ThreadId], tag.Tag)
+	}
+	for i := range threads {
+		threads[i].Tags = byThread[threads[i].Id]
+	}
+	return
+}
+
+func readTagsSQLInternal(limit int) (tags []common.TagCount, err error) {
+	tags = []common.TagCount{}
+	err = dbEngine.
+		SQL(`SELECT tag, COUNT(*) AS count FROM thread_tags
+GROUP BY tag ORDER BY count DESC, tag LIMIT ?`, limit).
+		Find(&tags)
+	return
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"learning-web-chatboard2/common"
+	"learning-web-chatboard2/usersclient"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"xorm.io/xorm"
 )
 
 var dbEngine *xorm.Engine
+var eventBus common.EventBus
+var outbox *common.OutboxDispatcher
 var config *common.Configuration
 var logger *log.Logger
 
+// for @mentions, users service owns who exists
+var usersClient usersclient.Client
+
 func main() {
 	var err error
 	// config
@@ -36,6 +44,37 @@ func main() {
 	if err != nil {
 		common.LogError(logger).Fatalln(err.Error())
 	}
+	//events
+	eventBus, outbox, err = common.StartEventBus(
+		context.Background(),
+		config,
+		dbEngine,
+		outboxTable,
+		logger,
+	)
+	if err != nil {
+		common.LogError(logger).Fatalln(err.Error())
+	}
+	eventBus.Subscribe("post.*", relayPostEvent)
+	eventBus.Subscribe("*", enqueueWebhooks)
+	go webhookWorker.run(
+		context.Background(),
+		common.NewWebhookClient(config.WebhookAllowPrivate),
+	)
+	usersClient = usersclient.NewHTTPClient(config.AddressUsers, config.UsersClient)
+	scheduler := &schedulerWorker{}
+	go scheduler.run(context.Background(), schedulerInterval)
+	if config.Mailer.DigestIntervalMinutes > 0 {
+		mailer, err := common.NewMailer(config.Mailer)
+		if err != nil {
+			common.LogError(logger).Fatalln(err.Error())
+		}
+		digests := &digestWorker{mailer: mailer, baseURL: config.Mailer.BaseURL}
+		go digests.run(
+			context.Background(),
+			time.Duration(config.Mailer.DigestIntervalMinutes)*time.Minute,
+		)
+	}
 	//router
 	routeEngine := gin.Default()
 	routeEngine.POST("/create", createThread)
@@ -43,7 +82,54 @@ func main() {
 	routeEngine.POST("/read", readAThread)
 	routeEngine.POST("/read-posts", readPostsInThread)
 	routeEngine.GET("/read-index", readThreads)
+	routeEngine.GET("/read-index-page", readThreadsPage)
+	routeEngine.POST("/read-posts-page", readPostsPageInThread)
 	routeEngine.POST("/update", updateThread)
+	routeEngine.POST("/update-tags", updateTags)
+	routeEngine.GET("/read-tags", readTags)
+	routeEngine.POST("/update-post", updatePost)
+	routeEngine.POST("/delete-post", deletePost)
+	routeEngine.POST("/toggle-reaction", toggleReaction)
+	routeEngine.POST("/read-user-reactions", readUserReactions)
+	routeEngine.POST("/read-poll", readPoll)
+	routeEngine.POST("/vote", vote)
+	routeEngine.POST("/subscribe", subscribe)
+	routeEngine.POST("/unsubscribe", unsubscribe)
+	routeEngine.POST("/read-subscription", readSubscription)
+	routeEngine.POST("/read-watched-threads-page", readWatchedThreadsPage)
+	routeEngine.POST("/mark-read", markRead)
+	routeEngine.POST("/count-unread", countUnread)
+	routeEngine.POST("/mark-all-read", markAllRead)
+	routeEngine.POST("/create-bookmark", createBookmark)
+	routeEngine.POST("/delete-bookmark", deleteBookmark)
+	routeEngine.POST("/read-bookmarks-page", readBookmarksPage)
+	routeEngine.POST("/read-user-bookmarks", readUserBookmarks)
+	routeEngine.POST("/save-draft", saveDraft)
+	routeEngine.POST("/read-draft", readDraft)
+	routeEngine.POST("/delete-draft", deleteDraft)
+	routeEngine.POST("/create-scheduled", createScheduled)
+	routeEngine.POST("/read-scheduled-page", readScheduledPage)
+	routeEngine.POST("/cancel-scheduled", cancelScheduled)
+	routeEngine.POST("/moderate", moderate)
+	routeEngine.POST("/search", search)
+	routeEngine.POST("/create-board", createBoard)
+	routeEngine.POST("/update-board", updateBoard)
+	routeEngine.POST("/delete-board", deleteBoard)
+	routeEngine.GET("/read-boards", readBoards)
+	routeEngine.POST("/read-board", readBoard)
+	routeEngine.POST("/read-board-threads-page", readBoardThreadsPage)
+	routeEngine.GET("/stream-events", streamEvents)
+	routeEngine.POST("/read-last-event", readLastEvent)
+	routeEngine.POST("/create-room", createRoom)
+	routeEngine.GET("/read-rooms", readRooms)
+	routeEngine.POST("/read-room", readRoom)
+	routeEngine.POST("/create-message", createMessage)
+	routeEngine.POST("/read-messages", readMessages)
+	routeEngine.POST("/create-webhook", createWebhook)
+	routeEngine.POST("/read-webhooks", readWebhooks)
+	routeEngine.POST("/read-webhook", readWebhook)
+	routeEngine.POST("/delete-webhook", deleteWebhook)
+	routeEngine.POST("/read-webhook-deliveries", readWebhookDeliveries)
 
 	routeEngine.Run(config.AddressThreads)
 }
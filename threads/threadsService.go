@@ -1,53 +1,96 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"learning-web-chatboard2/common"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
 )
 
 const (
 	threadsTable     = "threads"
 	postsTable       = "posts"
 	descendingUpdate = "last_update"
+	ascendingPost    = "id"
 )
 
-func handleErrorInternal(
-	loggerErrorMsg string,
-	ctx *gin.Context,
-) {
-	common.LogError(logger).Println(loggerErrorMsg)
-	ctx.JSON(http.StatusBadRequest, gin.H{"status": "error"})
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// replies error envelope with the status for its code.
+// cause is logged here and never sent.
+func handleErrorInternal(err error, ctx *gin.Context) {
+	replyErr := common.AsError(err)
+	common.LogError(logger).Println(replyErr.Error())
+	ctx.JSON(replyErr.HTTPStatus(), &common.ErrorEnvelope{Error: replyErr})
+}
+
+func bindInternal(ctx *gin.Context, obj interface{}) (err error) {
+	err = ctx.ShouldBind(obj)
+	if err != nil {
+		err = common.NewError(common.CodeBadRequest, "invalid body", err)
+	}
+	return
 }
 
 func createThread(ctx *gin.Context) {
 	var newThre common.Thread
 	err := createThreadInternal(ctx, &newThre)
 	if err != nil {
-		handleErrorInternal("failed to create thread", ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &newThre)
 }
 
 func createThreadInternal(ctx *gin.Context, newThre *common.Thread) (err error) {
-	err = ctx.Bind(newThre)
+	err = bindInternal(ctx, newThre)
 	if err != nil {
 		return
 	}
+	err = publishThreadInternal(ctx.Request.Context(), newThre)
+	return
+}
+
+// shared with the scheduler
+func publishThreadInternal(ctx context.Context, newThre *common.Thread) (err error) {
 	if common.IsEmpty(newThre.Topic, newThre.Owner) {
-		err = errors.New("contains empty string")
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	newThre.Tags, err = normalizeTagsInternal(newThre.Tags)
+	if err != nil {
 		return
 	}
+	err = normalizePollInternal(newThre.Poll)
+	if err != nil {
+		return
+	}
+	board, err := readThreadBoardInternal(newThre.BoardId)
+	if err != nil {
+		return
+	}
+	err = checkBoardRoleInternal(ctx, board.ThreadRole, newThre.UserId)
+	if err != nil {
+		return
+	}
+	newThre.BoardId = board.Id
 	now := time.Now()
 	newThre.UuId = common.NewUuIdString()
 	newThre.LastUpdate = now
 	newThre.CreatedAt = now
 	err = createThreadSQLInternal(newThre)
+	if err != nil {
+		return
+	}
+	outbox.Wake()
 	return
 }
 
@@ -55,27 +98,149 @@ func createPost(ctx *gin.Context) {
 	var post common.Post
 	err := createPostInternal(ctx, &post)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &post)
 }
 
 func createPostInternal(ctx *gin.Context, post *common.Post) (err error) {
-	err = ctx.Bind(post)
+	err = bindInternal(ctx, post)
 	if err != nil {
 		return
 	}
-	if common.IsEmpty(
-		post.Body,
-		post.Contributor,
-	) {
-		err = errors.New("contains empty string")
+	err = publishPostInternal(ctx.Request.Context(), post)
+	return
+}
+
+// shared with the scheduler
+func publishPostInternal(ctx context.Context, post *common.Post) (err error) {
+	// a post of only files is fine
+	if common.IsEmpty(post.Contributor) ||
+		(common.IsEmpty(post.Body) && len(post.Attachments) == 0) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	err = checkAttachmentsInternal(post)
+	if err != nil {
+		return
+	}
+	thre := common.Thread{Id: post.ThreadId}
+	err = readAThreadSQLInternal(&thre)
+	if err != nil {
+		return
+	}
+	if thre.Locked {
+		err = common.NewError(common.CodeForbidden, "thread is locked", nil)
+		return
+	}
+	board, err := readThreadBoardInternal(thre.BoardId)
+	if err != nil {
+		return
+	}
+	err = checkBoardRoleInternal(ctx, board.PostRole, post.UserId)
+	if err != nil {
 		return
 	}
+	post.Mentions, err = resolveMentionsInternal(ctx, post)
+	if err != nil {
+		return
+	}
+	post.RenderedBody = common.RenderBody(post.Body, post.Mentions)
 	post.UuId = common.NewUuIdString()
 	post.CreatedAt = time.Now()
 	err = createPostSQLInternal(post)
+	if err != nil {
+		return
+	}
+	outbox.Wake()
+	return
+}
+
+func updatePost(ctx *gin.Context) {
+	var post common.Post
+	err := updatePostInternal(ctx, &post)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &post)
+}
+
+// only body can be changed, and only by who wrote it
+func updatePostInternal(ctx *gin.Context, post *common.Post) (err error) {
+	err = bindInternal(ctx, post)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(post.UuId, post.Body) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	stored, err := readOwnPostInternal(post)
+	if err != nil {
+		return
+	}
+	stored.Body = post.Body
+	stored.RenderedBody = common.RenderBody(stored.Body, stored.Mentions)
+	stored.UpdatedAt = time.Now()
+	err = updatePostSQLInternal(stored)
+	if err != nil {
+		return
+	}
+	*post = *stored
+	outbox.Wake()
+	return
+}
+
+func deletePost(ctx *gin.Context) {
+	var post common.Post
+	err := deletePostInternal(ctx, &post)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"deleted": "ok",
+	})
+}
+
+func deletePostInternal(ctx *gin.Context, post *common.Post) (err error) {
+	err = bindInternal(ctx, post)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(post.UuId) {
+		err = common.NewError(common.CodeInvalid, "need uuid for deleting post", nil)
+		return
+	}
+	stored, err := readOwnPostInternal(post)
+	if err != nil {
+		return
+	}
+	err = deletePostSQLInternal(stored, nil)
+	if err != nil {
+		return
+	}
+	outbox.Wake()
+	return
+}
+
+// finds post by uuid and checks it belongs to post.UserId
+func readOwnPostInternal(post *common.Post) (stored *common.Post, err error) {
+	stored = &common.Post{UuId: post.UuId}
+	err = readPostSQLInternal(stored)
+	if err != nil {
+		return
+	}
+	if post.UserId == 0 || stored.UserId != post.UserId {
+		err = common.NewError(common.CodeForbidden, "not your post", nil)
+		return
+	}
+	// events carry them, pages link them
+	posts := []common.Post{*stored}
+	err = attachPostDetailsSQLInternal(posts)
+	*stored = posts[0]
 	return
 }
 
@@ -83,22 +248,28 @@ func readAThread(ctx *gin.Context) {
 	var thre common.Thread
 	err := readAThreadInternal(ctx, &thre)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &thre)
 }
 
 func readAThreadInternal(ctx *gin.Context, thre *common.Thread) (err error) {
-	err = ctx.Bind(thre)
+	err = bindInternal(ctx, thre)
 	if err != nil {
 		return
 	}
 	if common.IsEmpty(thre.UuId) {
-		err = errors.New("need uuid for finding thread")
+		err = common.NewError(common.CodeInvalid, "need uuid for finding thread", nil)
 		return
 	}
 	err = readAThreadSQLInternal(thre)
+	if err != nil {
+		return
+	}
+	threads := []common.Thread{*thre}
+	err = attachTagsSQLInternal(threads)
+	thre.Tags = threads[0].Tags
 	return
 }
 
@@ -106,14 +277,14 @@ func updateThread(ctx *gin.Context) {
 	var thre common.Thread
 	err := updateThreadInternal(ctx, &thre)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &thre)
 }
 
 func updateThreadInternal(ctx *gin.Context, thre *common.Thread) (err error) {
-	err = ctx.Bind(thre)
+	err = bindInternal(ctx, thre)
 	if err != nil {
 		return
 	}
@@ -122,7 +293,7 @@ func updateThreadInternal(ctx *gin.Context, thre *common.Thread) (err error) {
 		thre.Topic,
 		thre.Owner,
 	) {
-		err = errors.New("contains empty string")
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
 		return
 	}
 	thre.LastUpdate = time.Now()
@@ -132,45 +303,163 @@ func updateThreadInternal(ctx *gin.Context, thre *common.Thread) (err error) {
 
 func readPostsInThread(ctx *gin.Context) {
 	var thre common.Thread
-	err := ctx.Bind(&thre)
+	err := bindInternal(ctx, &thre)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	// is there a way to check valid id before?
 	posts, err := readPostsInThreadSQLInternal(&thre)
+	if err == nil {
+		err = attachPostDetailsSQLInternal(posts)
+	}
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &posts)
 }
 
+// ?tag= narrows to threads with the tag
 func readThreads(ctx *gin.Context) {
-	thres, err := readThreadsSQLInternal()
+	tag, err := tagQueryInternal(ctx)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
+		return
+	}
+	thres, err := readThreadsSQLInternal(tag)
+	if err == nil {
+		err = attachTagsSQLInternal(thres)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
 		return
 	} else {
 		ctx.JSON(http.StatusOK, &thres)
 	}
 }
 
+func readThreadsPage(ctx *gin.Context) {
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	tag, err := tagQueryInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readThreadsPageSQLInternal(tag, offset, limit)
+	if err == nil {
+		err = attachTagsSQLInternal(page.Threads)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func readPostsPageInThread(ctx *gin.Context) {
+	var thre common.Thread
+	err := bindInternal(ctx, &thre)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	offset, limit, err := pagingInternal(ctx)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	page, err := readPostsPageInThreadSQLInternal(&thre, offset, limit)
+	if err == nil {
+		err = attachPostDetailsSQLInternal(page.Posts)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// offset and limit from query, limit is defaultPageLimit if not given
+func pagingInternal(ctx *gin.Context) (offset int, limit int, err error) {
+	offset, err = strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		err = common.NewError(common.CodeInvalid, "invalid offset", err)
+		return
+	}
+	limit, err = strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 || limit > maxPageLimit {
+		err = common.NewError(common.CodeInvalid, "invalid limit", err)
+		return
+	}
+	return
+}
+
 func createThreadSQLInternal(newThre *common.Thread) (err error) {
-	affected, err := dbEngine.
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
 		Table(threadsTable).
-		InsertOne(&newThre)
+		InsertOne(newThre)
 	if err == nil && affected != 1 {
 		err = fmt.Errorf(
 			"something wrong. returned value was %d",
 			affected,
 		)
 	}
+	if err != nil {
+		return
+	}
+	err = indexSQLInternal(session, threadsTable, newThre.Id, newThre.Topic)
+	if err != nil {
+		return
+	}
+	err = createTagsSQLInternal(session, newThre)
+	if err != nil {
+		return
+	}
+	err = createPollSQLInternal(session, newThre)
+	if err != nil {
+		return
+	}
+	if newThre.ClearDraft {
+		err = deleteDraftSQLInternal(session, newThre.UserId, 0)
+		if err != nil {
+			return
+		}
+	}
+	event, err := common.NewEvent(common.TopicThreadCreated, newThre)
+	if err != nil {
+		return
+	}
+	err = common.WriteOutbox(session, outboxTable, event)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
 	return
 }
 
 func createPostSQLInternal(newPost *common.Post) (err error) {
-	affected, err := dbEngine.
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	err = nextPostNumberSQLInternal(session, newPost)
+	if err != nil {
+		return
+	}
+	affected, err := session.
 		Table(postsTable).
 		InsertOne(newPost)
 	if err == nil && affected != 1 {
@@ -179,12 +468,165 @@ func createPostSQLInternal(newPost *common.Post) (err error) {
 			affected,
 		)
 	}
+	if err != nil {
+		return
+	}
+	err = indexSQLInternal(session, postsTable, newPost.Id, newPost.Body)
+	if err != nil {
+		return
+	}
+	err = createMentionsSQLInternal(session, newPost)
+	if err != nil {
+		return
+	}
+	err = createAttachmentsSQLInternal(session, newPost)
+	if err != nil {
+		return
+	}
+	// writers follow the thread from their post on
+	err = subscribeSQLInternal(session, newPost.ThreadId, newPost.UserId, newPost.CreatedAt)
+	if err != nil {
+		return
+	}
+	err = markReadSQLInternal(session, newPost.ThreadId, newPost.UserId, newPost.Number, newPost.CreatedAt)
+	if err != nil {
+		return
+	}
+	if newPost.ClearDraft {
+		err = deleteDraftSQLInternal(session, newPost.UserId, newPost.ThreadId)
+		if err != nil {
+			return
+		}
+	}
+	err = createEventSQLInternal(session, common.EventPostCreated, newPost)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
 	return
 }
 
-func readThreadsSQLInternal() (threads []common.Thread, err error) {
-	err = dbEngine.
-		Table(threadsTable).
+// thread row is locked till commit, so two posts never get the same number.
+// deleted posts keep theirs, raw sql sees them.
+func nextPostNumberSQLInternal(session *xorm.Session, post *common.Post) (err error) {
+	_, err = session.Exec("SELECT id FROM threads WHERE id = ? FOR UPDATE", post.ThreadId)
+	if err != nil {
+		return
+	}
+	_, err = session.
+		SQL("SELECT COALESCE(MAX(number), 0) + 1 FROM posts WHERE thread_id = ?", post.ThreadId).
+		Get(&post.Number)
+	return
+}
+
+// mentions, attachments and reactions, one query each
+func attachPostDetailsSQLInternal(posts []common.Post) (err error) {
+	err = attachMentionsSQLInternal(posts)
+	if err != nil {
+		return
+	}
+	err = attachAttachmentsSQLInternal(posts)
+	if err != nil {
+		return
+	}
+	err = attachReactionsSQLInternal(posts)
+	return
+}
+
+func readPostSQLInternal(post *common.Post) (err error) {
+	ok, err := dbEngine.
+		Table(postsTable).
+		Get(post)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such post", nil)
+	}
+	return
+}
+
+func updatePostSQLInternal(post *common.Post) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
+		Table(postsTable).
+		Where("id = ?", post.Id).
+		Cols("body", "rendered_body", "updated_at").
+		Update(post)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	if err != nil {
+		return
+	}
+	err = indexSQLInternal(session, postsTable, post.Id, post.Body)
+	if err != nil {
+		return
+	}
+	err = forgetPostEventsSQLInternal(session, post, false)
+	if err != nil {
+		return
+	}
+	err = createEventSQLInternal(session, common.EventPostUpdated, post)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// soft delete, deleted_at is set.
+// action is given when a moderator did it
+func deletePostSQLInternal(post *common.Post, action *common.ModerationAction) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
+		Table(postsTable).
+		Where("id = ?", post.Id).
+		Delete(&common.Post{})
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	if err != nil {
+		return
+	}
+	err = forgetPostEventsSQLInternal(session, post, true)
+	if err != nil {
+		return
+	}
+	removed := post.Removed()
+	err = createEventSQLInternal(session, common.EventPostDeleted, &removed)
+	if err != nil {
+		return
+	}
+	if action != nil {
+		err = createModerationEventSQLInternal(session, action)
+		if err != nil {
+			return
+		}
+	}
+	err = session.Commit()
+	return
+}
+
+func readThreadsSQLInternal(tag string) (threads []common.Thread, err error) {
+	session := dbEngine.Table(threadsTable)
+	if len(tag) > 0 {
+		session = session.Where(taggedCondition, tag)
+	}
+	err = session.
 		Desc(descendingUpdate).
 		Find(&threads)
 	return
@@ -195,7 +637,7 @@ func readAThreadSQLInternal(thread *common.Thread) (err error) {
 		Table(threadsTable).
 		Get(thread)
 	if err == nil && !ok {
-		err = errors.New("no such thread")
+		err = common.NewError(common.CodeNotFound, "no such thread", nil)
 	}
 	return
 }
@@ -211,6 +653,10 @@ func updateThreadSQLInternal(thread *common.Thread) (err error) {
 			affected,
 		)
 	}
+	if err != nil {
+		return
+	}
+	err = indexSQLInternal(dbEngine, threadsTable, thread.Id, thread.Topic)
 	return
 }
 
@@ -218,6 +664,35 @@ func readPostsInThreadSQLInternal(thread *common.Thread) (posts []common.Post, e
 	err = dbEngine.
 		Table(postsTable).
 		Where("thread_id = ?", thread.Id).
+		Asc(ascendingPost).
 		Find(&posts)
 	return
 }
+
+func readThreadsPageSQLInternal(tag string, offset, limit int) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	session := dbEngine.Table(threadsTable)
+	if len(tag) > 0 {
+		session = session.Where(taggedCondition, tag)
+	}
+	page.Total, err = session.
+		Desc(descendingUpdate).
+		Limit(limit, offset).
+		FindAndCount(&page.Threads)
+	return
+}
+
+func readPostsPageInThreadSQLInternal(
+	thread *common.Thread,
+	offset int,
+	limit int,
+) (page *common.PostPage, err error) {
+	page = &common.PostPage{}
+	page.Total, err = dbEngine.
+		Table(postsTable).
+		Where("thread_id = ?", thread.Id).
+		Asc(ascendingPost).
+		Limit(limit, offset).
+		FindAndCount(&page.Posts)
+	return
+}
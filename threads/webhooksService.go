@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webhooksTable        = "webhooks"
+	webhookDeliveryTable = "webhook_deliveries"
+	webhookPollInterval  = time.Second * 2
+	webhookBatch         = 20
+	// a claimed delivery is retried by someone else after this
+	webhookClaim         = time.Minute
+	maxDeliveryLog       = 100
+	maxWebhooksPerThread = 20
+)
+
+// what webhooks can ask for
+var webhookTopics = []string{
+	common.TopicThreadCreated,
+	common.TopicPostCreated,
+	common.TopicPostUpdated,
+	common.TopicPostDeleted,
+}
+
+var webhookWorker = newDeliveryWorker()
+
+// router decides who may register,
+// here only the hook itself is checked
+func createWebhook(ctx *gin.Context) {
+	var hook common.Webhook
+	err := createWebhookInternal(ctx, &hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &hook)
+}
+
+func createWebhookInternal(ctx *gin.Context, hook *common.Webhook) (err error) {
+	err = bindInternal(ctx, hook)
+	if err != nil {
+		return
+	}
+	if hook.UserId == 0 || common.IsEmpty(hook.URL, hook.Events) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	err = common.ValidateWebhookURL(hook.URL)
+	if err != nil {
+		return
+	}
+	hook.Events, err = normalizeWebhookEventsInternal(hook.Events)
+	if err != nil {
+		return
+	}
+	count, err := countWebhooksSQLInternal(hook.ThreadId)
+	if err != nil {
+		return
+	}
+	if count >= maxWebhooksPerThread {
+		err = common.NewError(common.CodeConflict, "too many webhooks", nil)
+		return
+	}
+	hook.Secret, err = newWebhookSecretInternal()
+	if err != nil {
+		return
+	}
+	hook.UuId = common.NewUuIdString()
+	hook.CreatedAt = time.Now()
+	err = createWebhookSQLInternal(hook)
+	return
+}
+
+// every pattern must match at least one topic we send
+func normalizeWebhookEventsInternal(events string) (normalized string, err error) {
+	hook := common.Webhook{Events: events}
+	patterns := hook.EventList()
+	for _, pattern := range patterns {
+		matched := false
+		for _, topic := range webhookTopics {
+			if common.MatchTopic(pattern, topic) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			err = common.NewError(common.CodeInvalid, fmt.Sprintf("unknown event %s", pattern), nil)
+			return
+		}
+	}
+	if len(patterns) == 0 {
+		err = common.NewError(common.CodeInvalid, "no events", nil)
+		return
+	}
+	sort.Strings(patterns)
+	normalized = strings.Join(patterns, ",")
+	return
+}
+
+func newWebhookSecretInternal() (secret string, err error) {
+	bin := make([]byte, 24)
+	_, err = rand.Read(bin)
+	if err != nil {
+		return
+	}
+	secret = "whsec_" + hex.EncodeToString(bin)
+	return
+}
+
+// hooks of one thread, thread_id 0 for the global ones
+func readWebhooks(ctx *gin.Context) {
+	var hook common.Webhook
+	err := bindInternal(ctx, &hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	hooks, err := readWebhooksSQLInternal(hook.ThreadId)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, hooks)
+}
+
+func readWebhook(ctx *gin.Context) {
+	var hook common.Webhook
+	err := bindInternal(ctx, &hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	if common.IsEmpty(hook.UuId) {
+		handleErrorInternal(common.NewError(common.CodeInvalid, "need uuid", nil), ctx)
+		return
+	}
+	err = readWebhookSQLInternal(&hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &hook)
+}
+
+func deleteWebhook(ctx *gin.Context) {
+	var hook common.Webhook
+	err := bindInternal(ctx, &hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	if common.IsEmpty(hook.UuId) {
+		handleErrorInternal(common.NewError(common.CodeInvalid, "need uuid", nil), ctx)
+		return
+	}
+	err = deleteWebhookSQLInternal(&hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"deleted": "ok",
+	})
+}
+
+// latest deliveries of a hook, for the log page
+func readWebhookDeliveries(ctx *gin.Context) {
+	var hook common.Webhook
+	err := bindInternal(ctx, &hook)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	deliveries, err := readDeliveriesSQLInternal(hook.Id)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, deliveries)
+}
+
+// subscribed to the bus, turns events into pending deliveries.
+// same event twice makes no new rows, so redelivery from the bus is fine.
+func enqueueWebhooks(ctx context.Context, event *common.Event) (err error) {
+	payload, err := webhookPayloadInternal(ctx, event)
+	if err != nil || payload == nil {
+		return
+	}
+	hooks, err := readWebhooksForThreadSQLInternal(payload.Thread.Id)
+	if err != nil {
+		return
+	}
+	bin, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	queued := false
+	for i := range hooks {
+		if !hooks[i].Wants(event.Topic) {
+			continue
+		}
+		err = createDeliverySQLInternal(&common.WebhookDelivery{
+			UuId:          common.NewUuIdString(),
+			WebhookId:     hooks[i].Id,
+			EventUuId:     event.UuId,
+			Topic:         event.Topic,
+			Payload:       string(bin),
+			NextAttemptAt: time.Now(),
+			CreatedAt:     time.Now(),
+		})
+		if err != nil {
+			return
+		}
+		queued = true
+	}
+	if queued {
+		webhookWorker.wake()
+	}
+	return
+}
+
+// nil payload for topics webhooks do not care about
+func webhookPayloadInternal(
+	ctx context.Context,
+	event *common.Event,
+) (payload *common.WebhookPayload, err error) {
+	payload = &common.WebhookPayload{
+		Id:        event.UuId,
+		Event:     event.Topic,
+		CreatedAt: event.CreatedAt,
+	}
+	switch event.Topic {
+	case common.TopicThreadCreated:
+		payload.Thread = &common.Thread{}
+		err = event.Decode(payload.Thread)
+	case common.TopicPostCreated, common.TopicPostUpdated, common.TopicPostDeleted:
+		var threEvent common.ThreadEvent
+		err = event.Decode(&threEvent)
+		if err != nil {
+			return
+		}
+		payload.Post = &threEvent.Post
+		payload.Thread = &common.Thread{Id: threEvent.ThreadId}
+		err = readAThreadSQLInternal(payload.Thread)
+	default:
+		payload = nil
+	}
+	return
+}
+
+// deliveryWorker sends pending deliveries until they succeed
+// or run out of attempts. rows are claimed, so several
+// threads processes can run it at once.
+type deliveryWorker struct {
+	client *http.Client
+	wakeCh chan struct{}
+}
+
+func newDeliveryWorker() *deliveryWorker {
+	return &deliveryWorker{
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+func (w *deliveryWorker) wake() {
+	select {
+	case w.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *deliveryWorker) run(ctx context.Context, client *http.Client) {
+	w.client = client
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wakeCh:
+		}
+		for {
+			sent, err := w.deliverBatchInternal(ctx)
+			if err != nil {
+				common.LogError(logger).Printf("webhook deliveries: %s\n", err.Error())
+			}
+			if err != nil || sent < webhookBatch {
+				break
+			}
+		}
+	}
+}
+
+func (w *deliveryWorker) deliverBatchInternal(ctx context.Context) (count int, err error) {
+	deliveries, err := claimDeliveriesSQLInternal()
+	if err != nil {
+		return
+	}
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		hook := common.Webhook{Id: delivery.WebhookId}
+		err = readWebhookSQLInternal(&hook)
+		// removed meanwhile, its deliveries are gone too
+		if common.ErrorCodeOf(err) == common.CodeNotFound {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		status, serr := common.SendWebhook(ctx, w.client, &hook, delivery)
+		markDeliveryInternal(delivery, status, serr)
+		err = updateDeliverySQLInternal(delivery)
+		if err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// success, retry later, or give up
+func markDeliveryInternal(delivery *common.WebhookDelivery, status int, sendErr error) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.StatusCode = status
+	if sendErr == nil {
+		delivery.LastError = ""
+		delivery.DeliveredAt = now
+		return
+	}
+	delivery.LastError = sendErr.Error()
+	if delivery.Attempts >= common.MaxWebhookAttempts {
+		delivery.FailedAt = now
+		return
+	}
+	delivery.NextAttemptAt = now.Add(common.WebhookBackoff(delivery.Attempts))
+}
+
+func createWebhookSQLInternal(hook *common.Webhook) (err error) {
+	affected, err := dbEngine.
+		Table(webhooksTable).
+		InsertOne(hook)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+func countWebhooksSQLInternal(threadId uint) (count int64, err error) {
+	count, err = dbEngine.
+		Table(webhooksTable).
+		Where("thread_id = ?", threadId).
+		Count(&common.Webhook{})
+	return
+}
+
+func readWebhooksSQLInternal(threadId uint) (hooks []common.Webhook, err error) {
+	err = dbEngine.
+		Table(webhooksTable).
+		Where("thread_id = ?", threadId).
+		Asc("id").
+		Find(&hooks)
+	return
+}
+
+// hooks of the thread and the global ones
+func readWebhooksForThreadSQLInternal(threadId uint) (hooks []common.Webhook, err error) {
+	err = dbEngine.
+		Table(webhooksTable).
+		Where("thread_id = ? OR thread_id = 0", threadId).
+		Find(&hooks)
+	return
+}
+
+func readWebhookSQLInternal(hook *common.Webhook) (err error) {
+	ok, err := dbEngine.
+		Table(webhooksTable).
+		Get(hook)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such webhook", nil)
+	}
+	return
+}
+
+// deliveries go with it
+func deleteWebhookSQLInternal(hook *common.Webhook) (err error) {
+	affected, err := dbEngine.
+		Table(webhooksTable).
+		Where("uu_id = ?", hook.UuId).
+		Delete(&common.Webhook{})
+	if err == nil && affected != 1 {
+		err = common.NewError(common.CodeNotFound, "no such webhook", nil)
+	}
+	return
+}
+
+func createDeliverySQLInternal(delivery *common.WebhookDelivery) (err error) {
+	_, err = dbEngine.Exec(
+		`INSERT INTO webhook_deliveries
+  (uu_id, webhook_id, event_uu_id, topic, payload, next_attempt_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (webhook_id, event_uu_id) DO NOTHING`,
+		delivery.UuId,
+		delivery.WebhookId,
+		delivery.EventUuId,
+		delivery.Topic,
+		delivery.Payload,
+		delivery.NextAttemptAt,
+		delivery.CreatedAt,
+	)
+	return
+}
+
+func claimDeliveriesSQLInternal() (deliveries []common.WebhookDelivery, err error) {
+	now := time.Now()
+	err = dbEngine.
+		SQL(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (
+  SELECT id FROM webhook_deliveries
+  WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+  ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED
+) RETURNING *`, now.Add(webhookClaim), now, webhookBatch).
+		Find(&deliveries)
+	return
+}
+
+func updateDeliverySQLInternal(delivery *common.WebhookDelivery) (err error) {
+	cols := []string{"attempts", "status_code", "last_error", "next_attempt_at"}
+	if !delivery.DeliveredAt.IsZero() {
+		cols = append(cols, "delivered_at")
+	}
+	if !delivery.FailedAt.IsZero() {
+		cols = append(cols, "failed_at")
+	}
+	_, err = dbEngine.
+		Table(webhookDeliveryTable).
+		Where("id = ?", delivery.Id).
+		Cols(cols...).
+		Update(delivery)
+	return
+}
+
+func readDeliveriesSQLInternal(webhookId uint) (deliveries []common.WebhookDelivery, err error) {
+	err = dbEngine.
+		Table(webhookDeliveryTable).
+		Where("webhook_id = ?", webhookId).
+		Desc("id").
+		Limit(maxDeliveryLog).
+		Find(&deliveries)
+	return
+}
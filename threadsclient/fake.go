@@ -0,0 +1,1674 @@
+package threadsclient
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"learning-web-chatboard2/usersclient"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is in-memory threads service for tests.
+// it behaves like the real one as far as router cares.
+type Fake struct {
+	mutex sync.Mutex
+	// set true to make every call fail like the service is down
+	Down     bool
+	lastId   uint
+	Threads  map[string]*common.Thread
+	Posts    []common.Post
+	Events   []common.ThreadEvent
+	Rooms    map[string]*common.ChatRoom
+	Messages []common.ChatMessage
+	Webhooks []common.Webhook
+	// tests put deliveries here, fake never sends
+	Deliveries []common.WebhookDelivery
+	Actions    []common.ModerationAction
+	// default board is there from the start
+	Boards    []common.Board
+	Reactions []common.Reaction
+	Polls     []common.Poll
+	PollVotes []common.PollVote
+	// posting subscribes like the real service
+	Subscriptions []common.Subscription
+	ThreadReads   []common.ThreadRead
+	// user id -> posts till then are read
+	ReadHorizons map[uint]time.Time
+	Bookmarks    []common.Bookmark
+	// sending a form clears its draft like the real service
+	Drafts []common.Draft
+	// fake never publishes, tests change State themselves
+	Scheduled []common.ScheduledPost
+	// resolves @mentions like the real service when set
+	Users   usersclient.Client
+	streams map[chan common.ThreadEvent]uint
+	// thread id -> last post number, deleted posts keep theirs
+	lastNumbers map[uint]uint
+}
+
+func NewFake() *Fake {
+	f := &Fake{
+		Threads:      make(map[string]*common.Thread),
+		Rooms:        make(map[string]*common.ChatRoom),
+		streams:      make(map[chan common.ThreadEvent]uint),
+		lastNumbers:  make(map[uint]uint),
+		ReadHorizons: make(map[uint]time.Time),
+	}
+	f.Boards = append(f.Boards, common.Board{
+		Id:        f.nextId(),
+		Slug:      common.DefaultBoardSlug,
+		Title:     "General",
+		CreatedAt: time.Now(),
+	})
+	return f
+}
+
+func fakeError(code common.ErrorCode, msg string) error {
+	status := common.HTTPStatusOf(code)
+	return &common.StatusError{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Code:       code,
+		Message:    msg,
+	}
+}
+
+func (f *Fake) Available() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return !f.Down
+}
+
+// same as sql offset and limit on slice
+func pageBounds(length, offset, limit int) (from, to int) {
+	from = offset
+	if from > length {
+		from = length
+	}
+	to = offset + limit
+	if to > length {
+		to = length
+	}
+	return
+}
+
+func (f *Fake) nextId() uint {
+	f.lastId++
+	return f.lastId
+}
+
+func (f *Fake) ListThreads(ctx context.Context) ([]common.Thread, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	threads := make([]common.Thread, 0, len(f.Threads))
+	for _, thre := range f.Threads {
+		threads = append(threads, *thre)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].LastUpdate.After(threads[j].LastUpdate)
+	})
+	return threads, nil
+}
+
+func (f *Fake) ListThreadsPage(ctx context.Context, offset, limit int) (*common.ThreadPage, error) {
+	threads, err := f.ListThreads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from, to := pageBounds(len(threads), offset, limit)
+	return &common.ThreadPage{
+		Threads: threads[from:to],
+		Total:   int64(len(threads)),
+	}, nil
+}
+
+func (f *Fake) ReadThread(ctx context.Context, uuid string) (*common.Thread, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	thre, ok := f.Threads[uuid]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such thread")
+	}
+	copied := *thre
+	return &copied, nil
+}
+
+func (f *Fake) ReadPosts(ctx context.Context, thread *common.Thread) ([]common.Post, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var posts []common.Post
+	for _, post := range f.Posts {
+		if post.ThreadId == thread.Id {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+func (f *Fake) ReadPostsPage(
+	ctx context.Context,
+	thread *common.Thread,
+	offset int,
+	limit int,
+) (*common.PostPage, error) {
+	posts, err := f.ReadPosts(ctx, thread)
+	if err != nil {
+		return nil, err
+	}
+	from, to := pageBounds(len(posts), offset, limit)
+	return &common.PostPage{
+		Posts: posts[from:to],
+		Total: int64(len(posts)),
+	}, nil
+}
+
+func (f *Fake) CreateThread(ctx context.Context, thread *common.Thread) (*common.Thread, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(thread.Topic, thread.Owner) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	tags, ok := common.NormalizeTags(thread.Tags)
+	if !ok {
+		return nil, fakeError(common.CodeInvalid, "invalid tags")
+	}
+	board, err := f.threadBoardInternal(thread.BoardId)
+	if err != nil {
+		return nil, err
+	}
+	err = f.checkBoardRoleInternal(ctx, board.ThreadRole, thread.UserId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	created := *thread
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.BoardId = board.Id
+	created.Tags = tags
+	created.LastUpdate = now
+	created.CreatedAt = now
+	created.Poll = nil
+	if thread.Poll != nil {
+		poll := *thread.Poll
+		if !common.NormalizePoll(&poll, now) {
+			return nil, fakeError(common.CodeInvalid, "invalid poll")
+		}
+		poll.Id = f.nextId()
+		poll.ThreadId = created.Id
+		poll.CreatedAt = now
+		for i := range poll.Options {
+			poll.Options[i].Id = f.nextId()
+			poll.Options[i].PollId = poll.Id
+		}
+		f.Polls = append(f.Polls, poll)
+		created.Poll = &poll
+	}
+	stored := created
+	stored.Poll = nil
+	f.Threads[created.UuId] = &stored
+	if created.ClearDraft {
+		f.deleteDraftInternal(created.UserId, 0)
+	}
+	return &created, nil
+}
+
+func (f *Fake) UpdateThread(ctx context.Context, thread *common.Thread) (*common.Thread, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(thread.UuId, thread.Topic, thread.Owner) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if _, ok := f.Threads[thread.UuId]; !ok {
+		return nil, fakeError(common.CodeNotFound, "no such thread")
+	}
+	updated := *thread
+	updated.LastUpdate = time.Now()
+	f.Threads[thread.UuId] = &updated
+	copied := updated
+	return &copied, nil
+}
+
+func (f *Fake) CreatePost(ctx context.Context, post *common.Post) (*common.Post, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(post.Contributor) ||
+		(common.IsEmpty(post.Body) && len(post.Attachments) == 0) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if len(post.Attachments) > common.MaxPostAttachments {
+		return nil, fakeError(common.CodeInvalid, "too many attachments")
+	}
+	for _, thre := range f.Threads {
+		if thre.Id != post.ThreadId {
+			continue
+		}
+		if thre.Locked {
+			return nil, fakeError(common.CodeForbidden, "thread is locked")
+		}
+		board, err := f.threadBoardInternal(thre.BoardId)
+		if err != nil {
+			return nil, err
+		}
+		err = f.checkBoardRoleInternal(ctx, board.PostRole, post.UserId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	created := *post
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	f.lastNumbers[post.ThreadId]++
+	created.Number = f.lastNumbers[post.ThreadId]
+	created.Mentions = nil
+	if names := common.ParseMentions(post.Body); f.Users != nil && len(names) > 0 {
+		// posted without mentions while users are down
+		users, err := f.Users.ResolveMentions(ctx, post.UserId, names)
+		if err != nil && !common.IsUnavailable(err) {
+			return nil, err
+		}
+		for _, user := range users {
+			created.Mentions = append(created.Mentions, common.Mention{
+				PostId:   created.Id,
+				UserId:   user.Id,
+				UserName: user.Name,
+			})
+		}
+	}
+	created.Attachments = nil
+	for _, attachment := range post.Attachments {
+		attachment.Id = f.nextId()
+		attachment.PostId = created.Id
+		attachment.CreatedAt = created.CreatedAt
+		created.Attachments = append(created.Attachments, attachment)
+	}
+	created.RenderedBody = common.RenderBody(created.Body, created.Mentions)
+	f.Posts = append(f.Posts, created)
+	f.subscribeInternal(created.ThreadId, created.UserId, created.CreatedAt)
+	f.markReadInternal(created.ThreadId, created.UserId, created.Number, created.CreatedAt)
+	if created.ClearDraft {
+		f.deleteDraftInternal(created.UserId, created.ThreadId)
+	}
+	f.emitInternal(common.EventPostCreated, &created)
+	return &created, nil
+}
+
+func (f *Fake) UpdatePost(ctx context.Context, post *common.Post) (*common.Post, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	i, err := f.ownPostIndexInternal(post)
+	if err != nil {
+		return nil, err
+	}
+	f.Posts[i].Body = post.Body
+	f.Posts[i].RenderedBody = common.RenderBody(post.Body, f.Posts[i].Mentions)
+	f.Posts[i].UpdatedAt = time.Now()
+	updated := f.Posts[i]
+	f.forgetPostEventsInternal(&updated, false)
+	f.emitInternal(common.EventPostUpdated, &updated)
+	return &updated, nil
+}
+
+func (f *Fake) DeletePost(ctx context.Context, post *common.Post) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	i, err := f.ownPostIndexInternal(post)
+	if err != nil {
+		return err
+	}
+	deleted := f.Posts[i]
+	f.Posts = append(f.Posts[:i], f.Posts[i+1:]...)
+	f.forgetPostEventsInternal(&deleted, true)
+	removed := deleted.Removed()
+	f.emitInternal(common.EventPostDeleted, &removed)
+	return nil
+}
+
+// no bus here, Actions keeps what was done
+func (f *Fake) Moderate(ctx context.Context, action *common.ModerationAction) (*common.ModerationAction, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if action.ModeratorId == 0 || common.IsEmpty(action.ModeratorName) {
+		return nil, fakeError(common.CodeInvalid, "need moderator")
+	}
+	done := *action
+	done.CreatedAt = time.Now()
+	switch action.Action {
+	case common.ModerationPostDeleted:
+		found := false
+		for i := range f.Posts {
+			if f.Posts[i].UuId != action.PostUuId {
+				continue
+			}
+			deleted := f.Posts[i]
+			done.TargetUserId = deleted.UserId
+			f.Posts = append(f.Posts[:i], f.Posts[i+1:]...)
+			f.forgetPostEventsInternal(&deleted, true)
+			removed := deleted.Removed()
+			f.emitInternal(common.EventPostDeleted, &removed)
+			found = true
+			break
+		}
+		if !found {
+			return nil, fakeError(common.CodeNotFound, "no such post")
+		}
+	case common.ModerationThreadLocked, common.ModerationThreadUnlocked:
+		thre, ok := f.Threads[action.ThreadUuId]
+		if !ok {
+			return nil, fakeError(common.CodeNotFound, "no such thread")
+		}
+		thre.Locked = action.Action == common.ModerationThreadLocked
+		done.TargetUserId = thre.UserId
+		done.ThreadTopic = thre.Topic
+	default:
+		return nil, fakeError(common.CodeInvalid, "unknown action")
+	}
+	f.Actions = append(f.Actions, done)
+	return &done, nil
+}
+
+// every term as substring, newest first. no real ranking here
+func (f *Fake) Search(
+	ctx context.Context,
+	query *common.SearchQuery,
+	offset int,
+	limit int,
+) (*common.SearchPage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	terms := common.SearchTerms(query.Query)
+	if len(terms) == 0 {
+		return nil, fakeError(common.CodeInvalid, "nothing to search")
+	}
+	matches := func(text, author string, thre *common.Thread, createdAt time.Time) bool {
+		if !common.IsEmpty(query.Author) && author != query.Author ||
+			!common.IsEmpty(query.Thread) && thre.UuId != query.Thread ||
+			!query.From.IsZero() && createdAt.Before(query.From) ||
+			!query.To.IsZero() && !createdAt.Before(query.To) {
+			return false
+		}
+		lower := strings.ToLower(text)
+		for _, term := range terms {
+			if !strings.Contains(lower, term) {
+				return false
+			}
+		}
+		return true
+	}
+	var results []common.SearchResult
+	byId := make(map[uint]*common.Thread, len(f.Threads))
+	for _, thre := range f.Threads {
+		byId[thre.Id] = thre
+		if matches(thre.Topic, thre.Owner, thre, thre.CreatedAt) {
+			results = append(results, common.SearchResult{
+				Kind:       common.SearchKindThread,
+				ThreadUuId: thre.UuId,
+				Topic:      thre.Topic,
+				Author:     thre.Owner,
+				Text:       thre.Topic,
+				CreatedAt:  thre.CreatedAt,
+			})
+		}
+	}
+	for _, post := range f.Posts {
+		thre, ok := byId[post.ThreadId]
+		if ok && matches(post.Body, post.Contributor, thre, post.CreatedAt) {
+			results = append(results, common.SearchResult{
+				Kind:       common.SearchKindPost,
+				ThreadUuId: thre.UuId,
+				Topic:      thre.Topic,
+				Number:     post.Number,
+				Author:     post.Contributor,
+				Text:       post.Body,
+				CreatedAt:  post.CreatedAt,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	from, to := pageBounds(len(results), offset, limit)
+	page := &common.SearchPage{
+		Results: results[from:to],
+		Total:   int64(len(results)),
+	}
+	for i := range page.Results {
+		page.Results[i].Snippet = common.Snippet(page.Results[i].Text, terms)
+	}
+	return page, nil
+}
+
+func (f *Fake) ListTaggedThreadsPage(
+	ctx context.Context,
+	tag string,
+	offset int,
+	limit int,
+) (*common.ThreadPage, error) {
+	normalized := common.NormalizeTag(tag)
+	if len(normalized) == 0 {
+		return nil, fakeError(common.CodeInvalid, "invalid tag")
+	}
+	all, err := f.ListThreads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var threads []common.Thread
+	for _, thre := range all {
+		for _, tag := range thre.Tags {
+			if tag == normalized {
+				threads = append(threads, thre)
+				break
+			}
+		}
+	}
+	from, to := pageBounds(len(threads), offset, limit)
+	return &common.ThreadPage{
+		Threads: threads[from:to],
+		Total:   int64(len(threads)),
+	}, nil
+}
+
+func (f *Fake) ListTags(ctx context.Context, limit int) ([]common.TagCount, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	counts := make(map[string]int64)
+	for _, thre := range f.Threads {
+		for _, tag := range thre.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]common.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, common.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags, nil
+}
+
+func (f *Fake) UpdateTags(ctx context.Context, thread *common.Thread) (*common.Thread, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	tags, ok := common.NormalizeTags(thread.Tags)
+	if !ok {
+		return nil, fakeError(common.CodeInvalid, "invalid tags")
+	}
+	thre, ok := f.Threads[thread.UuId]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such thread")
+	}
+	thre.Tags = tags
+	updated := *thre
+	return &updated, nil
+}
+
+func (f *Fake) ToggleReaction(ctx context.Context, reaction *common.Reaction) ([]common.ReactionCount, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if reaction.UserId == 0 || !common.IsValidReactionKind(reaction.Kind) {
+		return nil, fakeError(common.CodeInvalid, "invalid reaction")
+	}
+	index := -1
+	for i := range f.Posts {
+		if f.Posts[i].UuId == reaction.PostUuId {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fakeError(common.CodeNotFound, "no such post")
+	}
+	post := &f.Posts[index]
+	removed := false
+	for i, given := range f.Reactions {
+		if given.PostId == post.Id && given.UserId == reaction.UserId && given.Kind == reaction.Kind {
+			f.Reactions = append(f.Reactions[:i], f.Reactions[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		created := *reaction
+		created.Id = f.nextId()
+		created.PostId = post.Id
+		created.CreatedAt = time.Now()
+		f.Reactions = append(f.Reactions, created)
+	}
+	counts := make(map[string]int64)
+	var mine []common.Reaction
+	for _, given := range f.Reactions {
+		if given.PostId != post.Id {
+			continue
+		}
+		counts[given.Kind]++
+		if given.UserId == reaction.UserId {
+			mine = append(mine, given)
+		}
+	}
+	var reactions []common.ReactionCount
+	for kind, count := range counts {
+		reactions = append(reactions, common.ReactionCount{PostId: post.Id, Kind: kind, Count: count})
+	}
+	post.Reactions = common.SortReactionCounts(reactions)
+	posts := []common.Post{*post}
+	posts[0].Reactions = append([]common.ReactionCount(nil), post.Reactions...)
+	common.MarkMyReactions(posts, mine)
+	return posts[0].Reactions, nil
+}
+
+func (f *Fake) ReadUserReactions(ctx context.Context, query *common.ReactionQuery) ([]common.Reaction, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	inThread := make(map[uint]bool)
+	for _, post := range f.Posts {
+		if post.ThreadId == query.ThreadId {
+			inThread[post.Id] = true
+		}
+	}
+	reactions := []common.Reaction{}
+	for _, given := range f.Reactions {
+		if given.UserId == query.UserId && inThread[given.PostId] {
+			reactions = append(reactions, given)
+		}
+	}
+	return reactions, nil
+}
+
+func (f *Fake) ReadPoll(ctx context.Context, query *common.PollQuery) (*common.Poll, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for i := range f.Polls {
+		if f.Polls[i].ThreadId == query.ThreadId {
+			return f.countVotesInternal(&f.Polls[i], query.UserId), nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "no such poll")
+}
+
+func (f *Fake) Vote(ctx context.Context, ballot *common.PollBallot) (*common.Poll, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if ballot.UserId == 0 || common.IsEmpty(ballot.UserName) {
+		return nil, fakeError(common.CodeInvalid, "need poll and user for voting")
+	}
+	for i := range f.Polls {
+		if f.Polls[i].Id != ballot.PollId {
+			continue
+		}
+		poll := f.countVotesInternal(&f.Polls[i], ballot.UserId)
+		if poll.IsClosed(time.Now()) {
+			return nil, fakeError(common.CodeForbidden, "poll is closed")
+		}
+		if poll.HasVoted() {
+			return nil, fakeError(common.CodeConflict, "already voted")
+		}
+		if !poll.IsValidBallot(ballot.OptionIds) {
+			return nil, fakeError(common.CodeInvalid, "invalid choices")
+		}
+		userName := ballot.UserName
+		if poll.Anonymous {
+			userName = ""
+		}
+		for _, optionId := range ballot.OptionIds {
+			f.PollVotes = append(f.PollVotes, common.PollVote{
+				Id:        f.nextId(),
+				PollId:    poll.Id,
+				OptionId:  optionId,
+				UserId:    ballot.UserId,
+				UserName:  userName,
+				CreatedAt: time.Now(),
+			})
+		}
+		return f.countVotesInternal(&f.Polls[i], ballot.UserId), nil
+	}
+	return nil, fakeError(common.CodeNotFound, "no such poll")
+}
+
+// a copy, stored options stay without counts
+func (f *Fake) countVotesInternal(stored *common.Poll, userId uint) *common.Poll {
+	poll := *stored
+	poll.Options = append([]common.PollOption(nil), stored.Options...)
+	var votes []common.PollVote
+	for _, given := range f.PollVotes {
+		if given.PollId == poll.Id {
+			votes = append(votes, given)
+		}
+	}
+	common.CountPollVotes(&poll, votes, userId)
+	return &poll
+}
+
+func (f *Fake) Subscribe(ctx context.Context, sub *common.Subscription) (*common.Subscription, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if sub.ThreadId == 0 || sub.UserId == 0 {
+		return nil, fakeError(common.CodeInvalid, "need thread and user")
+	}
+	found := false
+	for _, thre := range f.Threads {
+		found = found || thre.Id == sub.ThreadId
+	}
+	if !found {
+		return nil, fakeError(common.CodeNotFound, "no such thread")
+	}
+	f.subscribeInternal(sub.ThreadId, sub.UserId, time.Now())
+	return f.readSubscriptionInternal(sub)
+}
+
+// the first one is kept, like the real one
+func (f *Fake) subscribeInternal(threadId uint, userId uint, since time.Time) {
+	for _, stored := range f.Subscriptions {
+		if stored.ThreadId == threadId && stored.UserId == userId {
+			return
+		}
+	}
+	f.Subscriptions = append(f.Subscriptions, common.Subscription{
+		Id:         f.nextId(),
+		ThreadId:   threadId,
+		UserId:     userId,
+		CreatedAt:  since,
+		DigestedAt: since,
+	})
+}
+
+func (f *Fake) Unsubscribe(ctx context.Context, sub *common.Subscription) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	kept := f.Subscriptions[:0]
+	for _, stored := range f.Subscriptions {
+		if stored.ThreadId != sub.ThreadId || stored.UserId != sub.UserId {
+			kept = append(kept, stored)
+		}
+	}
+	f.Subscriptions = kept
+	return nil
+}
+
+func (f *Fake) ReadSubscription(ctx context.Context, sub *common.Subscription) (*common.Subscription, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	return f.readSubscriptionInternal(sub)
+}
+
+func (f *Fake) readSubscriptionInternal(sub *common.Subscription) (*common.Subscription, error) {
+	for _, stored := range f.Subscriptions {
+		if stored.ThreadId == sub.ThreadId && stored.UserId == sub.UserId {
+			found := stored
+			return &found, nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "not subscribed")
+}
+
+func (f *Fake) ListWatchedThreadsPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (*common.ThreadPage, error) {
+	all, err := f.ListThreads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f.mutex.Lock()
+	watched := make(map[uint]bool)
+	for _, stored := range f.Subscriptions {
+		if stored.UserId == userId {
+			watched[stored.ThreadId] = true
+		}
+	}
+	f.mutex.Unlock()
+	var threads []common.Thread
+	for _, thre := range all {
+		if watched[thre.Id] {
+			threads = append(threads, thre)
+		}
+	}
+	from, to := pageBounds(len(threads), offset, limit)
+	return &common.ThreadPage{
+		Threads: threads[from:to],
+		Total:   int64(len(threads)),
+	}, nil
+}
+
+func (f *Fake) MarkRead(ctx context.Context, read *common.ThreadRead) (*common.ThreadRead, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if read.ThreadId == 0 || read.UserId == 0 {
+		return nil, fakeError(common.CodeInvalid, "need thread and user")
+	}
+	previous := common.ThreadRead{ThreadId: read.ThreadId, UserId: read.UserId}
+	for _, stored := range f.ThreadReads {
+		if stored.ThreadId == read.ThreadId && stored.UserId == read.UserId {
+			previous = stored
+		}
+	}
+	previous.ReadAllAt = f.ReadHorizons[read.UserId]
+	f.markReadInternal(read.ThreadId, read.UserId, read.LastNumber, time.Now())
+	return &previous, nil
+}
+
+// only forward, like the real one
+func (f *Fake) markReadInternal(threadId uint, userId uint, number uint, now time.Time) {
+	for i := range f.ThreadReads {
+		stored := &f.ThreadReads[i]
+		if stored.ThreadId == threadId && stored.UserId == userId {
+			if number > stored.LastNumber {
+				stored.LastNumber = number
+			}
+			stored.UpdatedAt = now
+			return
+		}
+	}
+	f.ThreadReads = append(f.ThreadReads, common.ThreadRead{
+		Id:         f.nextId(),
+		ThreadId:   threadId,
+		UserId:     userId,
+		LastNumber: number,
+		UpdatedAt:  now,
+	})
+}
+
+func (f *Fake) CountUnread(ctx context.Context, query *common.UnreadQuery) ([]common.UnreadCount, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if query.UserId == 0 {
+		return nil, fakeError(common.CodeInvalid, "need user")
+	}
+	counts := []common.UnreadCount{}
+	for _, threadId := range query.ThreadIds {
+		read := common.ThreadRead{UserId: query.UserId, ReadAllAt: f.ReadHorizons[query.UserId]}
+		for _, stored := range f.ThreadReads {
+			if stored.ThreadId == threadId && stored.UserId == query.UserId {
+				read.LastNumber = stored.LastNumber
+			}
+		}
+		count := common.UnreadCount{ThreadId: threadId}
+		for i := range f.Posts {
+			if f.Posts[i].ThreadId == threadId && !read.IsRead(&f.Posts[i]) {
+				count.Unread++
+			}
+		}
+		if count.Unread > 0 {
+			counts = append(counts, count)
+		}
+	}
+	return counts, nil
+}
+
+func (f *Fake) MarkAllRead(ctx context.Context, userId uint) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	if userId == 0 {
+		return fakeError(common.CodeInvalid, "need user")
+	}
+	f.ReadHorizons[userId] = time.Now()
+	kept := f.ThreadReads[:0]
+	for _, stored := range f.ThreadReads {
+		if stored.UserId != userId {
+			kept = append(kept, stored)
+		}
+	}
+	f.ThreadReads = kept
+	return nil
+}
+
+func (f *Fake) CreateBookmark(ctx context.Context, bookmark *common.Bookmark) (*common.Bookmark, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if bookmark.UserId == 0 || common.IsEmpty(bookmark.PostUuId) {
+		return nil, fakeError(common.CodeInvalid, "need post and user")
+	}
+	kept := *bookmark
+	if !common.NormalizeBookmark(&kept) {
+		return nil, fakeError(common.CodeInvalid, "note is too long")
+	}
+	post := f.findPostInternal(kept.PostUuId)
+	if post == nil {
+		return nil, fakeError(common.CodeNotFound, "no such post")
+	}
+	now := time.Now()
+	for i := range f.Bookmarks {
+		stored := &f.Bookmarks[i]
+		if stored.UserId == kept.UserId && stored.PostId == post.Id {
+			stored.Note = kept.Note
+			stored.UpdatedAt = now
+			updated := *stored
+			return &updated, nil
+		}
+	}
+	kept.Id = f.nextId()
+	kept.PostId = post.Id
+	kept.Post = nil
+	kept.Thread = nil
+	kept.UpdatedAt = now
+	kept.CreatedAt = now
+	f.Bookmarks = append(f.Bookmarks, kept)
+	return &kept, nil
+}
+
+func (f *Fake) findPostInternal(uuid string) *common.Post {
+	for i := range f.Posts {
+		if f.Posts[i].UuId == uuid {
+			return &f.Posts[i]
+		}
+	}
+	return nil
+}
+
+func (f *Fake) DeleteBookmark(ctx context.Context, bookmark *common.Bookmark) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	for i, stored := range f.Bookmarks {
+		if stored.Id == bookmark.Id && stored.UserId == bookmark.UserId {
+			f.Bookmarks = append(f.Bookmarks[:i], f.Bookmarks[i+1:]...)
+			return nil
+		}
+	}
+	return fakeError(common.CodeNotFound, "no such bookmark")
+}
+
+func (f *Fake) ListBookmarksPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (*common.BookmarkPage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var bookmarks []common.Bookmark
+	// newest first
+	for i := len(f.Bookmarks) - 1; i >= 0; i-- {
+		if f.Bookmarks[i].UserId != userId {
+			continue
+		}
+		bookmark := f.Bookmarks[i]
+		for j := range f.Posts {
+			if f.Posts[j].Id != bookmark.PostId {
+				continue
+			}
+			post := f.Posts[j]
+			bookmark.Post = &post
+			bookmark.PostUuId = post.UuId
+			for _, thre := range f.Threads {
+				if thre.Id == post.ThreadId {
+					found := *thre
+					bookmark.Thread = &found
+				}
+			}
+		}
+		bookmarks = append(bookmarks, bookmark)
+	}
+	from, to := pageBounds(len(bookmarks), offset, limit)
+	return &common.BookmarkPage{
+		Bookmarks: bookmarks[from:to],
+		Total:     int64(len(bookmarks)),
+	}, nil
+}
+
+func (f *Fake) ReadUserBookmarks(ctx context.Context, query *common.BookmarkQuery) ([]common.Bookmark, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	inThread := make(map[uint]bool)
+	for _, post := range f.Posts {
+		if post.ThreadId == query.ThreadId {
+			inThread[post.Id] = true
+		}
+	}
+	bookmarks := []common.Bookmark{}
+	for _, stored := range f.Bookmarks {
+		if stored.UserId == query.UserId && inThread[stored.PostId] {
+			bookmarks = append(bookmarks, stored)
+		}
+	}
+	return bookmarks, nil
+}
+
+func (f *Fake) SaveDraft(ctx context.Context, draft *common.Draft) (*common.Draft, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if draft.UserId == 0 {
+		return nil, fakeError(common.CodeInvalid, "need user")
+	}
+	saved := *draft
+	if !common.NormalizeDraft(&saved) {
+		return nil, fakeError(common.CodeInvalid, "draft is too long")
+	}
+	if saved.ThreadId != 0 {
+		found := false
+		for _, thre := range f.Threads {
+			found = found || thre.Id == saved.ThreadId
+		}
+		if !found {
+			return nil, fakeError(common.CodeNotFound, "no such thread")
+		}
+	}
+	saved.UpdatedAt = time.Now()
+	if saved.IsEmpty() {
+		saved.Id = 0
+		f.deleteDraftInternal(saved.UserId, saved.ThreadId)
+		return &saved, nil
+	}
+	for i := range f.Drafts {
+		stored := &f.Drafts[i]
+		if stored.UserId == saved.UserId && stored.ThreadId == saved.ThreadId {
+			saved.Id = stored.Id
+			*stored = saved
+			return &saved, nil
+		}
+	}
+	saved.Id = f.nextId()
+	f.Drafts = append(f.Drafts, saved)
+	return &saved, nil
+}
+
+func (f *Fake) ReadDraft(ctx context.Context, userId uint, threadId uint) (*common.Draft, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for _, stored := range f.Drafts {
+		if stored.UserId == userId && stored.ThreadId == threadId {
+			draft := stored
+			return &draft, nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "no draft")
+}
+
+func (f *Fake) DeleteDraft(ctx context.Context, userId uint, threadId uint) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	f.deleteDraftInternal(userId, threadId)
+	return nil
+}
+
+func (f *Fake) deleteDraftInternal(userId uint, threadId uint) {
+	for i, stored := range f.Drafts {
+		if stored.UserId == userId && stored.ThreadId == threadId {
+			f.Drafts = append(f.Drafts[:i], f.Drafts[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *Fake) CreateScheduled(ctx context.Context, scheduled *common.ScheduledPost) (*common.ScheduledPost, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if scheduled.UserId == 0 || common.IsEmpty(scheduled.UserName) {
+		return nil, fakeError(common.CodeInvalid, "need user")
+	}
+	now := time.Now()
+	if !common.CheckPublishAt(scheduled.PublishAt, now) {
+		return nil, fakeError(common.CodeInvalid, "publish time is not in the coming year")
+	}
+	created := *scheduled
+	if created.IsThread() {
+		if common.IsEmpty(created.Topic) {
+			return nil, fakeError(common.CodeInvalid, "contains empty string")
+		}
+		tags, ok := common.NormalizeTags(created.Tags)
+		if !ok {
+			return nil, fakeError(common.CodeInvalid, "invalid tags")
+		}
+		board, err := f.threadBoardInternal(created.BoardId)
+		if err != nil {
+			return nil, err
+		}
+		created.Tags = tags
+		created.BoardId = board.Id
+	} else {
+		if common.IsEmpty(created.Body) {
+			return nil, fakeError(common.CodeInvalid, "contains empty string")
+		}
+		var thre *common.Thread
+		for _, stored := range f.Threads {
+			if stored.Id == created.ThreadId {
+				thre = stored
+			}
+		}
+		if thre == nil {
+			return nil, fakeError(common.CodeNotFound, "no such thread")
+		}
+		if thre.Locked {
+			return nil, fakeError(common.CodeForbidden, "thread is locked")
+		}
+		created.Topic = ""
+		created.BoardId = 0
+		created.Tags = nil
+	}
+	pending := 0
+	for _, stored := range f.Scheduled {
+		if stored.UserId == created.UserId && stored.IsPending() {
+			pending++
+		}
+	}
+	if pending >= common.MaxScheduledPerUser {
+		return nil, fakeError(common.CodeInvalid, "too many scheduled posts")
+	}
+	created.Id = f.nextId()
+	created.State = common.ScheduledPending
+	created.Reason = ""
+	created.PublishedUuId = ""
+	created.CreatedAt = now
+	f.Scheduled = append(f.Scheduled, created)
+	f.deleteDraftInternal(created.UserId, created.ThreadId)
+	return &created, nil
+}
+
+func (f *Fake) ListScheduledPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (*common.ScheduledPage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var scheduled []common.ScheduledPost
+	for _, stored := range f.Scheduled {
+		if stored.UserId != userId {
+			continue
+		}
+		for _, thre := range f.Threads {
+			if !stored.IsThread() && thre.Id == stored.ThreadId {
+				found := *thre
+				stored.Thread = &found
+			}
+		}
+		scheduled = append(scheduled, stored)
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].PublishAt.After(scheduled[j].PublishAt)
+	})
+	from, to := pageBounds(len(scheduled), offset, limit)
+	return &common.ScheduledPage{
+		Scheduled: scheduled[from:to],
+		Total:     int64(len(scheduled)),
+	}, nil
+}
+
+func (f *Fake) CancelScheduled(ctx context.Context, scheduled *common.ScheduledPost) (*common.ScheduledPost, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for i := range f.Scheduled {
+		stored := &f.Scheduled[i]
+		if stored.Id != scheduled.Id || stored.UserId != scheduled.UserId {
+			continue
+		}
+		if !stored.IsPending() {
+			return nil, fakeError(common.CodeConflict, "already "+stored.State)
+		}
+		stored.State = common.ScheduledCancelled
+		cancelled := *stored
+		return &cancelled, nil
+	}
+	return nil, fakeError(common.CodeNotFound, "no such scheduled post")
+}
+
+func (f *Fake) ListBoards(ctx context.Context) ([]common.Board, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	boards := append([]common.Board(nil), f.Boards...)
+	sort.SliceStable(boards, func(i, j int) bool {
+		return boards[i].SortOrder < boards[j].SortOrder
+	})
+	return boards, nil
+}
+
+func (f *Fake) ReadBoard(ctx context.Context, board *common.Board) (*common.Board, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	i, err := f.boardIndexInternal(board)
+	if err != nil {
+		return nil, err
+	}
+	read := f.Boards[i]
+	return &read, nil
+}
+
+func (f *Fake) ListBoardThreadsPage(
+	ctx context.Context,
+	board *common.Board,
+	offset int,
+	limit int,
+) (*common.ThreadPage, error) {
+	read, err := f.ReadBoard(ctx, board)
+	if err != nil {
+		return nil, err
+	}
+	all, err := f.ListThreads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var threads []common.Thread
+	for _, thre := range all {
+		if thre.BoardId == read.Id {
+			threads = append(threads, thre)
+		}
+	}
+	from, to := pageBounds(len(threads), offset, limit)
+	return &common.ThreadPage{
+		Threads: threads[from:to],
+		Total:   int64(len(threads)),
+	}, nil
+}
+
+func (f *Fake) CreateBoard(ctx context.Context, board *common.Board) (*common.Board, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if !common.IsValidBoardSlug(board.Slug) {
+		return nil, fakeError(common.CodeInvalid, "invalid slug")
+	}
+	if err := fakeValidateBoard(board); err != nil {
+		return nil, err
+	}
+	for _, stored := range f.Boards {
+		if stored.Slug == board.Slug {
+			return nil, fakeError(common.CodeConflict, "slug is taken")
+		}
+	}
+	created := *board
+	created.Id = f.nextId()
+	created.CreatedAt = time.Now()
+	f.Boards = append(f.Boards, created)
+	return &created, nil
+}
+
+func (f *Fake) UpdateBoard(ctx context.Context, board *common.Board) (*common.Board, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if err := fakeValidateBoard(board); err != nil {
+		return nil, err
+	}
+	if common.IsEmpty(board.Slug) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	i, err := f.boardIndexInternal(&common.Board{Slug: board.Slug})
+	if err != nil {
+		return nil, err
+	}
+	stored := &f.Boards[i]
+	stored.Title = board.Title
+	stored.Description = board.Description
+	stored.SortOrder = board.SortOrder
+	stored.ThreadRole = board.ThreadRole
+	stored.PostRole = board.PostRole
+	updated := *stored
+	return &updated, nil
+}
+
+func (f *Fake) DeleteBoard(ctx context.Context, board *common.Board) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	if common.IsEmpty(board.Slug) {
+		return fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if board.Slug == common.DefaultBoardSlug {
+		return fakeError(common.CodeForbidden, "default board can not be deleted")
+	}
+	i, err := f.boardIndexInternal(&common.Board{Slug: board.Slug})
+	if err != nil {
+		return err
+	}
+	for _, thre := range f.Threads {
+		if thre.BoardId == f.Boards[i].Id {
+			return fakeError(common.CodeConflict, "board still has threads")
+		}
+	}
+	f.Boards = append(f.Boards[:i], f.Boards[i+1:]...)
+	return nil
+}
+
+func fakeValidateBoard(board *common.Board) error {
+	if common.IsEmpty(board.Title) {
+		return fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if !common.IsValidRole(board.ThreadRole) || !common.IsValidRole(board.PostRole) {
+		return fakeError(common.CodeInvalid, "unknown role")
+	}
+	return nil
+}
+
+// by id or slug
+func (f *Fake) boardIndexInternal(board *common.Board) (int, error) {
+	if board.Id == 0 && common.IsEmpty(board.Slug) {
+		return 0, fakeError(common.CodeInvalid, "need board")
+	}
+	for i := range f.Boards {
+		if (board.Id == 0 || f.Boards[i].Id == board.Id) &&
+			(board.Slug == "" || f.Boards[i].Slug == board.Slug) {
+			return i, nil
+		}
+	}
+	return 0, fakeError(common.CodeNotFound, "no such board")
+}
+
+// zero id is the default board
+func (f *Fake) threadBoardInternal(boardId uint) (*common.Board, error) {
+	query := common.Board{Id: boardId}
+	if boardId == 0 {
+		query.Slug = common.DefaultBoardSlug
+	}
+	i, err := f.boardIndexInternal(&query)
+	if err != nil {
+		return nil, err
+	}
+	return &f.Boards[i], nil
+}
+
+// roles are checked only when Users is set
+func (f *Fake) checkBoardRoleInternal(ctx context.Context, need string, userId uint) error {
+	if common.RoleAtLeast(common.RoleMember, need) || f.Users == nil {
+		return nil
+	}
+	user, err := f.Users.ReadUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !user.HasRole(need) {
+		return fakeError(common.CodeForbidden, "not allowed in this board")
+	}
+	return nil
+}
+
+func (f *Fake) ownPostIndexInternal(post *common.Post) (int, error) {
+	for i := range f.Posts {
+		if f.Posts[i].UuId != post.UuId {
+			continue
+		}
+		if post.UserId == 0 || f.Posts[i].UserId != post.UserId {
+			return 0, fakeError(common.CodeForbidden, "not your post")
+		}
+		return i, nil
+	}
+	return 0, fakeError(common.CodeNotFound, "no such post")
+}
+
+func (f *Fake) ReadLastEvent(ctx context.Context, thread *common.Thread) (*common.ThreadEvent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for i := len(f.Events) - 1; i >= 0; i-- {
+		if f.Events[i].ThreadId == thread.Id {
+			copied := f.Events[i]
+			return &copied, nil
+		}
+	}
+	return &common.ThreadEvent{}, nil
+}
+
+// replays stored events after the id, then follows new ones until ctx is done
+func (f *Fake) StreamEvents(
+	ctx context.Context,
+	threadId uint,
+	after uint,
+) (<-chan common.ThreadEvent, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	live := make(chan common.ThreadEvent, len(f.Events)+64)
+	for _, event := range f.Events {
+		if event.ThreadId == threadId && event.Id > after {
+			live <- event
+		}
+	}
+	f.streams[live] = threadId
+	out := make(chan common.ThreadEvent)
+	go func() {
+		defer close(out)
+		defer func() {
+			f.mutex.Lock()
+			delete(f.streams, live)
+			f.mutex.Unlock()
+		}()
+		for {
+			select {
+			case event := <-live:
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// like the real service, earlier events lose the text.
+// must be called with mutex held
+func (f *Fake) forgetPostEventsInternal(post *common.Post, deleted bool) {
+	for i := range f.Events {
+		if f.Events[i].Post.UuId != post.UuId {
+			continue
+		}
+		if deleted {
+			f.Events[i].Post = post.Removed()
+		} else {
+			f.Events[i].Post.Body = ""
+			f.Events[i].Post.RenderedBody = ""
+			f.Events[i].Post.Mentions = nil
+		}
+	}
+}
+
+// must be called with mutex held
+func (f *Fake) emitInternal(kind string, post *common.Post) {
+	event := common.ThreadEvent{
+		Id:        uint(len(f.Events) + 1),
+		ThreadId:  post.ThreadId,
+		Kind:      kind,
+		Post:      *post,
+		CreatedAt: time.Now(),
+	}
+	f.Events = append(f.Events, event)
+	for ch, threadId := range f.streams {
+		if threadId != event.ThreadId {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (f *Fake) ListRooms(ctx context.Context) ([]common.ChatRoom, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	rooms := make([]common.ChatRoom, 0, len(f.Rooms))
+	for _, room := range f.Rooms {
+		rooms = append(rooms, *room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms, nil
+}
+
+func (f *Fake) ReadRoom(ctx context.Context, name string) (*common.ChatRoom, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	room, ok := f.Rooms[name]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such room")
+	}
+	copied := *room
+	return &copied, nil
+}
+
+func (f *Fake) CreateRoom(ctx context.Context, room *common.ChatRoom) (*common.ChatRoom, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(room.Name) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if _, ok := f.Rooms[room.Name]; ok {
+		return nil, fakeError(common.CodeConflict, "already exists")
+	}
+	created := *room
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	f.Rooms[created.Name] = &created
+	copied := created
+	return &copied, nil
+}
+
+func (f *Fake) CreateMessage(ctx context.Context, msg *common.ChatMessage) (*common.ChatMessage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(msg.Body, msg.Contributor) || msg.RoomId == 0 {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	created := *msg
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	f.Messages = append(f.Messages, created)
+	return &created, nil
+}
+
+func (f *Fake) ReadMessages(
+	ctx context.Context,
+	room *common.ChatRoom,
+	limit int,
+) ([]common.ChatMessage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var msgs []common.ChatMessage
+	for _, msg := range f.Messages {
+		if msg.RoomId == room.Id {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) > limit {
+		msgs = msgs[len(msgs)-limit:]
+	}
+	return msgs, nil
+}
+
+func (f *Fake) CreateWebhook(ctx context.Context, hook *common.Webhook) (*common.Webhook, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if hook.UserId == 0 || common.IsEmpty(hook.URL, hook.Events) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if err := common.ValidateWebhookURL(hook.URL); err != nil {
+		return nil, fakeError(common.CodeInvalid, err.Error())
+	}
+	created := *hook
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.Secret = "whsec_" + common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	f.Webhooks = append(f.Webhooks, created)
+	return &created, nil
+}
+
+func (f *Fake) ReadWebhooks(ctx context.Context, threadId uint) ([]common.Webhook, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var hooks []common.Webhook
+	for _, hook := range f.Webhooks {
+		if hook.ThreadId == threadId {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (f *Fake) ReadWebhook(ctx context.Context, uuid string) (*common.Webhook, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for _, hook := range f.Webhooks {
+		if hook.UuId == uuid {
+			copied := hook
+			return &copied, nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "no such webhook")
+}
+
+func (f *Fake) DeleteWebhook(ctx context.Context, uuid string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	for i, hook := range f.Webhooks {
+		if hook.UuId == uuid {
+			f.Webhooks = append(f.Webhooks[:i], f.Webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return fakeError(common.CodeNotFound, "no such webhook")
+}
+
+func (f *Fake) ReadWebhookDeliveries(
+	ctx context.Context,
+	hook *common.Webhook,
+) ([]common.WebhookDelivery, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var deliveries []common.WebhookDelivery
+	for _, delivery := range f.Deliveries {
+		if delivery.WebhookId == hook.Id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,65 @@
+package threadsclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"learning-web-chatboard2/common"
+	"strings"
+)
+
+// StreamEvents follows the events of a thread after the given event id.
+// the channel is closed when ctx is done or the stream is broken,
+// so caller reconnects with the last id it got.
+func (c *HTTPClient) StreamEvents(
+	ctx context.Context,
+	threadId uint,
+	after uint,
+) (<-chan common.ThreadEvent, error) {
+	path := fmt.Sprintf("/stream-events?thread_id=%d&after=%d", threadId, after)
+	body, err := c.service.OpenStream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan common.ThreadEvent)
+	go func() {
+		defer close(ch)
+		defer body.Close()
+		readEventStream(ctx, body, ch)
+	}()
+	return ch, nil
+}
+
+// only "data:" lines matter, id and kind are in the json too.
+// comments (heartbeats) and unknown fields are skipped.
+func readEventStream(ctx context.Context, body io.Reader, ch chan<- common.ThreadEvent) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) > 0 {
+			if strings.HasPrefix(line, "data:") {
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			}
+			continue
+		}
+		// blank line ends one event
+		if data.Len() == 0 {
+			continue
+		}
+		var event common.ThreadEvent
+		err := json.Unmarshal([]byte(data.String()), &event)
+		data.Reset()
+		if err != nil {
+			return
+		}
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package threadsclient
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_StreamEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("after") != "3" {
+			t.Errorf("after is %s", r.URL.Query().Get("after"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ":\n\n")
+		fmt.Fprint(w, "id:4\nevent:post.created\ndata:{\"id\":4,\"thread_id\":1,\"kind\":\"post.created\",\"post\":{\"body\":\"hello\"}}\n\n")
+		fmt.Fprint(w, "id:5\nevent:post.deleted\ndata:{\"id\":5,\"thread_id\":1,\"kind\":\"post.deleted\",\"post\":{\"body\":\"hello\"}}\n\n")
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(
+		strings.TrimPrefix(server.URL, common.HttpPrefix),
+		common.ClientConfiguration{},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	events, err := client.StreamEvents(ctx, 1, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var got []common.ThreadEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d events", len(got))
+	}
+	if got[0].Id != 4 || got[0].Kind != common.EventPostCreated || got[0].Post.Body != "hello" {
+		t.Fatalf("wrong first event %v", got[0])
+	}
+	if got[1].Kind != common.EventPostDeleted {
+		t.Fatalf("wrong second event %v", got[1])
+	}
+}
@@ -0,0 +1,456 @@
+package threadsclient
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/url"
+)
+
+// Client is the threads service seen from other services.
+// HTTPClient talks to the real service, Fake keeps everything in memory.
+type Client interface {
+	ListThreads(ctx context.Context) ([]common.Thread, error)
+	ListThreadsPage(ctx context.Context, offset, limit int) (*common.ThreadPage, error)
+	ReadThread(ctx context.Context, uuid string) (*common.Thread, error)
+	ReadPosts(ctx context.Context, thread *common.Thread) ([]common.Post, error)
+	ReadPostsPage(ctx context.Context, thread *common.Thread, offset, limit int) (*common.PostPage, error)
+	CreateThread(ctx context.Context, thread *common.Thread) (*common.Thread, error)
+	UpdateThread(ctx context.Context, thread *common.Thread) (*common.Thread, error)
+	CreatePost(ctx context.Context, post *common.Post) (*common.Post, error)
+	UpdatePost(ctx context.Context, post *common.Post) (*common.Post, error)
+	DeletePost(ctx context.Context, post *common.Post) error
+	Moderate(ctx context.Context, action *common.ModerationAction) (*common.ModerationAction, error)
+	Search(ctx context.Context, query *common.SearchQuery, offset, limit int) (*common.SearchPage, error)
+	ListTaggedThreadsPage(ctx context.Context, tag string, offset, limit int) (*common.ThreadPage, error)
+	ListTags(ctx context.Context, limit int) ([]common.TagCount, error)
+	UpdateTags(ctx context.Context, thread *common.Thread) (*common.Thread, error)
+	ToggleReaction(ctx context.Context, reaction *common.Reaction) ([]common.ReactionCount, error)
+	ReadUserReactions(ctx context.Context, query *common.ReactionQuery) ([]common.Reaction, error)
+	ReadPoll(ctx context.Context, query *common.PollQuery) (*common.Poll, error)
+	Vote(ctx context.Context, ballot *common.PollBallot) (*common.Poll, error)
+	Subscribe(ctx context.Context, sub *common.Subscription) (*common.Subscription, error)
+	Unsubscribe(ctx context.Context, sub *common.Subscription) error
+	ReadSubscription(ctx context.Context, sub *common.Subscription) (*common.Subscription, error)
+	ListWatchedThreadsPage(ctx context.Context, userId uint, offset, limit int) (*common.ThreadPage, error)
+	MarkRead(ctx context.Context, read *common.ThreadRead) (*common.ThreadRead, error)
+	CountUnread(ctx context.Context, query *common.UnreadQuery) ([]common.UnreadCount, error)
+	MarkAllRead(ctx context.Context, userId uint) error
+	CreateBookmark(ctx context.Context, bookmark *common.Bookmark) (*common.Bookmark, error)
+	DeleteBookmark(ctx context.Context, bookmark *common.Bookmark) error
+	ListBookmarksPage(ctx context.Context, userId uint, offset, limit int) (*common.BookmarkPage, error)
+	ReadUserBookmarks(ctx context.Context, query *common.BookmarkQuery) ([]common.Bookmark, error)
+	SaveDraft(ctx context.Context, draft *common.Draft) (*common.Draft, error)
+	ReadDraft(ctx context.Context, userId uint, threadId uint) (*common.Draft, error)
+	DeleteDraft(ctx context.Context, userId uint, threadId uint) error
+	CreateScheduled(ctx context.Context, scheduled *common.ScheduledPost) (*common.ScheduledPost, error)
+	ListScheduledPage(ctx context.Context, userId uint, offset, limit int) (*common.ScheduledPage, error)
+	CancelScheduled(ctx context.Context, scheduled *common.ScheduledPost) (*common.ScheduledPost, error)
+	ListBoards(ctx context.Context) ([]common.Board, error)
+	ReadBoard(ctx context.Context, board *common.Board) (*common.Board, error)
+	ListBoardThreadsPage(ctx context.Context, board *common.Board, offset, limit int) (*common.ThreadPage, error)
+	CreateBoard(ctx context.Context, board *common.Board) (*common.Board, error)
+	UpdateBoard(ctx context.Context, board *common.Board) (*common.Board, error)
+	DeleteBoard(ctx context.Context, board *common.Board) error
+	ReadLastEvent(ctx context.Context, thread *common.Thread) (*common.ThreadEvent, error)
+	StreamEvents(ctx context.Context, threadId uint, after uint) (<-chan common.ThreadEvent, error)
+	ListRooms(ctx context.Context) ([]common.ChatRoom, error)
+	ReadRoom(ctx context.Context, name string) (*common.ChatRoom, error)
+	CreateRoom(ctx context.Context, room *common.ChatRoom) (*common.ChatRoom, error)
+	CreateMessage(ctx context.Context, msg *common.ChatMessage) (*common.ChatMessage, error)
+	ReadMessages(ctx context.Context, room *common.ChatRoom, limit int) ([]common.ChatMessage, error)
+	CreateWebhook(ctx context.Context, hook *common.Webhook) (*common.Webhook, error)
+	ReadWebhooks(ctx context.Context, threadId uint) ([]common.Webhook, error)
+	ReadWebhook(ctx context.Context, uuid string) (*common.Webhook, error)
+	DeleteWebhook(ctx context.Context, uuid string) error
+	ReadWebhookDeliveries(ctx context.Context, hook *common.Webhook) ([]common.WebhookDelivery, error)
+	Available() bool
+}
+
+type HTTPClient struct {
+	service *common.ServiceClient
+}
+
+func NewHTTPClient(address string, conf common.ClientConfiguration) *HTTPClient {
+	return &HTTPClient{
+		service: common.NewServiceClient(address, conf),
+	}
+}
+
+// Available is false while the circuit to the service is open.
+func (c *HTTPClient) Available() bool {
+	return !c.service.Breaker().IsOpen()
+}
+
+func pagingPath(path string, offset, limit int) string {
+	return fmt.Sprintf("%s?offset=%d&limit=%d", path, offset, limit)
+}
+
+// reads and overwrites are idempotent, creates are not
+func (c *HTTPClient) do(
+	ctx context.Context,
+	method string,
+	path string,
+	in interface{},
+	out interface{},
+	idempotent bool,
+) error {
+	return c.service.DoJSON(ctx, method, path, in, out, idempotent)
+}
+
+func (c *HTTPClient) ListThreads(ctx context.Context) (threads []common.Thread, err error) {
+	err = c.do(ctx, http.MethodGet, "/read-index", nil, &threads, true)
+	return
+}
+
+func (c *HTTPClient) ListThreadsPage(ctx context.Context, offset, limit int) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	err = c.do(ctx, http.MethodGet, pagingPath("/read-index-page", offset, limit), nil, page, true)
+	return
+}
+
+func (c *HTTPClient) ReadThread(ctx context.Context, uuid string) (thread *common.Thread, err error) {
+	thread = &common.Thread{}
+	err = c.do(ctx, http.MethodPost, "/read", &common.Thread{UuId: uuid}, thread, true)
+	return
+}
+
+func (c *HTTPClient) ReadPosts(ctx context.Context, thread *common.Thread) (posts []common.Post, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-posts", thread, &posts, true)
+	return
+}
+
+func (c *HTTPClient) ReadPostsPage(
+	ctx context.Context,
+	thread *common.Thread,
+	offset int,
+	limit int,
+) (page *common.PostPage, err error) {
+	page = &common.PostPage{}
+	err = c.do(ctx, http.MethodPost, pagingPath("/read-posts-page", offset, limit), thread, page, true)
+	return
+}
+
+func (c *HTTPClient) CreateThread(ctx context.Context, thread *common.Thread) (created *common.Thread, err error) {
+	created = &common.Thread{}
+	err = c.do(ctx, http.MethodPost, "/create", thread, created, false)
+	return
+}
+
+func (c *HTTPClient) UpdateThread(ctx context.Context, thread *common.Thread) (updated *common.Thread, err error) {
+	updated = &common.Thread{}
+	err = c.do(ctx, http.MethodPost, "/update", thread, updated, true)
+	return
+}
+
+func (c *HTTPClient) CreatePost(ctx context.Context, post *common.Post) (created *common.Post, err error) {
+	created = &common.Post{}
+	err = c.do(ctx, http.MethodPost, "/create-post", post, created, false)
+	return
+}
+
+func (c *HTTPClient) UpdatePost(ctx context.Context, post *common.Post) (updated *common.Post, err error) {
+	updated = &common.Post{}
+	err = c.do(ctx, http.MethodPost, "/update-post", post, updated, true)
+	return
+}
+
+func (c *HTTPClient) DeletePost(ctx context.Context, post *common.Post) error {
+	return c.do(ctx, http.MethodPost, "/delete-post", post, nil, false)
+}
+
+func (c *HTTPClient) Moderate(ctx context.Context, action *common.ModerationAction) (done *common.ModerationAction, err error) {
+	done = &common.ModerationAction{}
+	err = c.do(ctx, http.MethodPost, "/moderate", action, done, false)
+	return
+}
+
+func (c *HTTPClient) Search(
+	ctx context.Context,
+	query *common.SearchQuery,
+	offset int,
+	limit int,
+) (page *common.SearchPage, err error) {
+	page = &common.SearchPage{}
+	err = c.do(ctx, http.MethodPost, pagingPath("/search", offset, limit), query, page, true)
+	return
+}
+
+func (c *HTTPClient) ListTaggedThreadsPage(
+	ctx context.Context,
+	tag string,
+	offset int,
+	limit int,
+) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	path := fmt.Sprintf("%s&tag=%s", pagingPath("/read-index-page", offset, limit), url.QueryEscape(tag))
+	err = c.do(ctx, http.MethodGet, path, nil, page, true)
+	return
+}
+
+func (c *HTTPClient) ListTags(ctx context.Context, limit int) (tags []common.TagCount, err error) {
+	err = c.do(ctx, http.MethodGet, fmt.Sprintf("/read-tags?limit=%d", limit), nil, &tags, true)
+	return
+}
+
+// tags of the thread are replaced
+func (c *HTTPClient) UpdateTags(ctx context.Context, thread *common.Thread) (updated *common.Thread, err error) {
+	updated = &common.Thread{}
+	err = c.do(ctx, http.MethodPost, "/update-tags", thread, updated, true)
+	return
+}
+
+// not retried, a second try would take it back
+func (c *HTTPClient) ToggleReaction(ctx context.Context, reaction *common.Reaction) (counts []common.ReactionCount, err error) {
+	err = c.do(ctx, http.MethodPost, "/toggle-reaction", reaction, &counts, false)
+	return
+}
+
+func (c *HTTPClient) ReadUserReactions(ctx context.Context, query *common.ReactionQuery) (reactions []common.Reaction, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-user-reactions", query, &reactions, true)
+	return
+}
+
+// not found when the thread has no poll
+func (c *HTTPClient) ReadPoll(ctx context.Context, query *common.PollQuery) (poll *common.Poll, err error) {
+	poll = &common.Poll{}
+	err = c.do(ctx, http.MethodPost, "/read-poll", query, poll, true)
+	return
+}
+
+// a retry would be told the user already voted
+func (c *HTTPClient) Vote(ctx context.Context, ballot *common.PollBallot) (poll *common.Poll, err error) {
+	poll = &common.Poll{}
+	err = c.do(ctx, http.MethodPost, "/vote", ballot, poll, false)
+	return
+}
+
+// subscribing twice keeps the first one, so retries are fine
+func (c *HTTPClient) Subscribe(ctx context.Context, sub *common.Subscription) (created *common.Subscription, err error) {
+	created = &common.Subscription{}
+	err = c.do(ctx, http.MethodPost, "/subscribe", sub, created, true)
+	return
+}
+
+func (c *HTTPClient) Unsubscribe(ctx context.Context, sub *common.Subscription) error {
+	return c.do(ctx, http.MethodPost, "/unsubscribe", sub, nil, true)
+}
+
+// not found when the user does not watch the thread
+func (c *HTTPClient) ReadSubscription(ctx context.Context, sub *common.Subscription) (found *common.Subscription, err error) {
+	found = &common.Subscription{}
+	err = c.do(ctx, http.MethodPost, "/read-subscription", sub, found, true)
+	return
+}
+
+func (c *HTTPClient) ListWatchedThreadsPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	path := pagingPath("/read-watched-threads-page", offset, limit)
+	err = c.do(ctx, http.MethodPost, path, &common.Subscription{UserId: userId}, page, true)
+	return
+}
+
+// answers what was read before this, marks only move forward
+func (c *HTTPClient) MarkRead(ctx context.Context, read *common.ThreadRead) (previous *common.ThreadRead, err error) {
+	previous = &common.ThreadRead{}
+	err = c.do(ctx, http.MethodPost, "/mark-read", read, previous, true)
+	return
+}
+
+func (c *HTTPClient) CountUnread(ctx context.Context, query *common.UnreadQuery) (counts []common.UnreadCount, err error) {
+	err = c.do(ctx, http.MethodPost, "/count-unread", query, &counts, true)
+	return
+}
+
+func (c *HTTPClient) MarkAllRead(ctx context.Context, userId uint) error {
+	return c.do(ctx, http.MethodPost, "/mark-all-read", &common.ReadHorizon{UserId: userId}, nil, true)
+}
+
+// keeping a post again only changes the note, so retries are fine
+func (c *HTTPClient) CreateBookmark(ctx context.Context, bookmark *common.Bookmark) (created *common.Bookmark, err error) {
+	created = &common.Bookmark{}
+	err = c.do(ctx, http.MethodPost, "/create-bookmark", bookmark, created, true)
+	return
+}
+
+func (c *HTTPClient) DeleteBookmark(ctx context.Context, bookmark *common.Bookmark) error {
+	return c.do(ctx, http.MethodPost, "/delete-bookmark", bookmark, nil, true)
+}
+
+func (c *HTTPClient) ListBookmarksPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.BookmarkPage, err error) {
+	page = &common.BookmarkPage{}
+	path := pagingPath("/read-bookmarks-page", offset, limit)
+	err = c.do(ctx, http.MethodPost, path, &common.Bookmark{UserId: userId}, page, true)
+	return
+}
+
+func (c *HTTPClient) ReadUserBookmarks(ctx context.Context, query *common.BookmarkQuery) (bookmarks []common.Bookmark, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-user-bookmarks", query, &bookmarks, true)
+	return
+}
+
+// saving replaces the whole draft, so retries are fine
+func (c *HTTPClient) SaveDraft(ctx context.Context, draft *common.Draft) (saved *common.Draft, err error) {
+	saved = &common.Draft{}
+	err = c.do(ctx, http.MethodPost, "/save-draft", draft, saved, true)
+	return
+}
+
+// threadId 0 is the new thread form
+func (c *HTTPClient) ReadDraft(ctx context.Context, userId uint, threadId uint) (draft *common.Draft, err error) {
+	draft = &common.Draft{}
+	query := &common.Draft{UserId: userId, ThreadId: threadId}
+	err = c.do(ctx, http.MethodPost, "/read-draft", query, draft, true)
+	return
+}
+
+func (c *HTTPClient) DeleteDraft(ctx context.Context, userId uint, threadId uint) error {
+	query := &common.Draft{UserId: userId, ThreadId: threadId}
+	return c.do(ctx, http.MethodPost, "/delete-draft", query, nil, true)
+}
+
+// sending twice would publish twice
+func (c *HTTPClient) CreateScheduled(ctx context.Context, scheduled *common.ScheduledPost) (created *common.ScheduledPost, err error) {
+	created = &common.ScheduledPost{}
+	err = c.do(ctx, http.MethodPost, "/create-scheduled", scheduled, created, false)
+	return
+}
+
+func (c *HTTPClient) ListScheduledPage(
+	ctx context.Context,
+	userId uint,
+	offset int,
+	limit int,
+) (page *common.ScheduledPage, err error) {
+	page = &common.ScheduledPage{}
+	path := pagingPath("/read-scheduled-page", offset, limit)
+	err = c.do(ctx, http.MethodPost, path, &common.ScheduledPost{UserId: userId}, page, true)
+	return
+}
+
+// by Id, only of UserId
+func (c *HTTPClient) CancelScheduled(ctx context.Context, scheduled *common.ScheduledPost) (cancelled *common.ScheduledPost, err error) {
+	cancelled = &common.ScheduledPost{}
+	err = c.do(ctx, http.MethodPost, "/cancel-scheduled", scheduled, cancelled, true)
+	return
+}
+
+func (c *HTTPClient) ListBoards(ctx context.Context) (boards []common.Board, err error) {
+	err = c.do(ctx, http.MethodGet, "/read-boards", nil, &boards, true)
+	return
+}
+
+// by id or slug
+func (c *HTTPClient) ReadBoard(ctx context.Context, board *common.Board) (read *common.Board, err error) {
+	read = &common.Board{}
+	err = c.do(ctx, http.MethodPost, "/read-board", board, read, true)
+	return
+}
+
+func (c *HTTPClient) ListBoardThreadsPage(
+	ctx context.Context,
+	board *common.Board,
+	offset int,
+	limit int,
+) (page *common.ThreadPage, err error) {
+	page = &common.ThreadPage{}
+	err = c.do(ctx, http.MethodPost, pagingPath("/read-board-threads-page", offset, limit), board, page, true)
+	return
+}
+
+func (c *HTTPClient) CreateBoard(ctx context.Context, board *common.Board) (created *common.Board, err error) {
+	created = &common.Board{}
+	err = c.do(ctx, http.MethodPost, "/create-board", board, created, false)
+	return
+}
+
+func (c *HTTPClient) UpdateBoard(ctx context.Context, board *common.Board) (updated *common.Board, err error) {
+	updated = &common.Board{}
+	err = c.do(ctx, http.MethodPost, "/update-board", board, updated, true)
+	return
+}
+
+func (c *HTTPClient) DeleteBoard(ctx context.Context, board *common.Board) error {
+	return c.do(ctx, http.MethodPost, "/delete-board", board, nil, true)
+}
+
+func (c *HTTPClient) ReadLastEvent(ctx context.Context, thread *common.Thread) (event *common.ThreadEvent, err error) {
+	event = &common.ThreadEvent{}
+	err = c.do(ctx, http.MethodPost, "/read-last-event", thread, event, true)
+	return
+}
+
+func (c *HTTPClient) ListRooms(ctx context.Context) (rooms []common.ChatRoom, err error) {
+	err = c.do(ctx, http.MethodGet, "/read-rooms", nil, &rooms, true)
+	return
+}
+
+func (c *HTTPClient) ReadRoom(ctx context.Context, name string) (room *common.ChatRoom, err error) {
+	room = &common.ChatRoom{}
+	err = c.do(ctx, http.MethodPost, "/read-room", &common.ChatRoom{Name: name}, room, true)
+	return
+}
+
+func (c *HTTPClient) CreateRoom(ctx context.Context, room *common.ChatRoom) (created *common.ChatRoom, err error) {
+	created = &common.ChatRoom{}
+	err = c.do(ctx, http.MethodPost, "/create-room", room, created, false)
+	return
+}
+
+func (c *HTTPClient) CreateMessage(ctx context.Context, msg *common.ChatMessage) (created *common.ChatMessage, err error) {
+	created = &common.ChatMessage{}
+	err = c.do(ctx, http.MethodPost, "/create-message", msg, created, false)
+	return
+}
+
+// latest ones up to limit, oldest first
+func (c *HTTPClient) ReadMessages(
+	ctx context.Context,
+	room *common.ChatRoom,
+	limit int,
+) (msgs []common.ChatMessage, err error) {
+	path := fmt.Sprintf("/read-messages?limit=%d", limit)
+	err = c.do(ctx, http.MethodPost, path, room, &msgs, true)
+	return
+}
+
+func (c *HTTPClient) CreateWebhook(ctx context.Context, hook *common.Webhook) (created *common.Webhook, err error) {
+	created = &common.Webhook{}
+	err = c.do(ctx, http.MethodPost, "/create-webhook", hook, created, false)
+	return
+}
+
+// threadId 0 for the global ones
+func (c *HTTPClient) ReadWebhooks(ctx context.Context, threadId uint) (hooks []common.Webhook, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-webhooks", &common.Webhook{ThreadId: threadId}, &hooks, true)
+	return
+}
+
+func (c *HTTPClient) ReadWebhook(ctx context.Context, uuid string) (hook *common.Webhook, err error) {
+	hook = &common.Webhook{}
+	err = c.do(ctx, http.MethodPost, "/read-webhook", &common.Webhook{UuId: uuid}, hook, true)
+	return
+}
+
+func (c *HTTPClient) DeleteWebhook(ctx context.Context, uuid string) error {
+	return c.do(ctx, http.MethodPost, "/delete-webhook", &common.Webhook{UuId: uuid}, nil, true)
+}
+
+func (c *HTTPClient) ReadWebhookDeliveries(
+	ctx context.Context,
+	hook *common.Webhook,
+) (deliveries []common.WebhookDelivery, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-webhook-deliveries", hook, &deliveries, true)
+	return
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	conversationTable       = "conversations"
+	conversationMemberTable = "conversation_members"
+	directMessageTable      = "direct_messages"
+	// latest ones in the inbox
+	conversationLimit = 100
+	// latest ones of a conversation
+	directMessageLimit = 200
+)
+
+// a one to one is found again instead of made twice.
+// nobody who blocked the starter, or was blocked by them, can be added.
+func createConversation(ctx *gin.Context) {
+	var req common.ConversationRequest
+	conv, err := createConversationInternal(ctx, &req)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, conv)
+}
+
+func createConversationInternal(
+	ctx *gin.Context,
+	req *common.ConversationRequest,
+) (conv *common.Conversation, err error) {
+	err = bindInternal(ctx, req)
+	if err != nil {
+		return
+	}
+	if req.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+		return
+	}
+	if !common.NormalizeConversationRequest(req) {
+		err = common.NewError(common.CodeInvalid, "need a few names and a short title", nil)
+		return
+	}
+	starter := common.User{Id: req.UserId}
+	err = readUserSQLInternal(&starter)
+	if err != nil {
+		return
+	}
+	found, err := readUsersByNameSQLInternal(req.Names)
+	if err != nil {
+		return
+	}
+	if len(found) != len(req.Names) {
+		err = common.NewError(common.CodeNotFound, "no such users", nil)
+		return
+	}
+	now := time.Now()
+	members := []common.ConversationMember{{
+		UserId:   starter.Id,
+		UserName: starter.Name,
+		JoinedAt: now,
+	}}
+	for i := range found {
+		if found[i].Id == starter.Id {
+			err = common.NewError(common.CodeInvalid, "can not talk to yourself", nil)
+			return
+		}
+		err = checkNotBlockedInternal(starter.Id, found[i].Id)
+		if err != nil {
+			return
+		}
+		members = append(members, common.ConversationMember{
+			UserId:   found[i].Id,
+			UserName: found[i].Name,
+			JoinedAt: now,
+		})
+	}
+	conv = &common.Conversation{
+		UuId:          common.NewUuIdString(),
+		Title:         req.Title,
+		LastMessageAt: now,
+		CreatedAt:     now,
+	}
+	if len(members) == 2 && len(req.Title) == 0 {
+		conv.PairKey = common.ConversationPairKey(members[0].UserId, members[1].UserId)
+	}
+	err = createConversationSQLInternal(conv, members)
+	if err != nil {
+		return
+	}
+	err = attachMembersSQLInternal(conv)
+	return
+}
+
+// either way round, for one to ones and for adding to groups
+func checkNotBlockedInternal(userId uint, otherId uint) (err error) {
+	blocked, err := isBlockedSQLInternal(userId, otherId)
+	if err == nil && !blocked {
+		blocked, err = isBlockedSQLInternal(otherId, userId)
+	}
+	if err == nil && blocked {
+		err = common.NewError(common.CodeForbidden, "blocked", nil)
+	}
+	return
+}
+
+// latest first, with unread counts and last message of each
+func readConversations(ctx *gin.Context) {
+	var query common.ConversationQuery
+	err := bindInternal(ctx, &query)
+	if err == nil && query.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	convs, err := readConversationsSQLInternal(query.UserId)
+	if err == nil {
+		err = attachInboxSQLInternal(query.UserId, convs)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, convs)
+}
+
+// not found for anyone but members
+func readConversation(ctx *gin.Context) {
+	var query common.ConversationQuery
+	conv, err := readConversationInternal(ctx, &query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, conv)
+}
+
+func readConversationInternal(
+	ctx *gin.Context,
+	query *common.ConversationQuery,
+) (conv *common.Conversation, err error) {
+	err = bindInternal(ctx, query)
+	if err != nil {
+		return
+	}
+	if query.UserId == 0 || len(query.UuId) == 0 {
+		err = common.NewError(common.CodeInvalid, "need uuid and user", nil)
+		return
+	}
+	conv, err = readMemberConversationSQLInternal(query.UuId, query.UserId)
+	if err == nil {
+		err = attachMembersSQLInternal(conv)
+	}
+	return
+}
+
+// oldest first, without what blocked users wrote
+func readDirectMessages(ctx *gin.Context) {
+	var query common.ConversationQuery
+	conv, err := readConversationInternal(ctx, &query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	msgs, err := readDirectMessagesSQLInternal(conv, query.UserId)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, msgs)
+}
+
+// talks of two stop once either blocks the other, titled or not. in groups blocked
+// ones still write, who blocked them just does not see it.
+func createDirectMessage(ctx *gin.Context) {
+	var msg common.DirectMessage
+	err := createDirectMessageInternal(ctx, &msg)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &msg)
+}
+
+func createDirectMessageInternal(ctx *gin.Context, msg *common.DirectMessage) (err error) {
+	err = bindInternal(ctx, msg)
+	if err != nil {
+		return
+	}
+	if msg.UserId == 0 || len(msg.ConversationUuId) == 0 {
+		err = common.NewError(common.CodeInvalid, "need conversation and user", nil)
+		return
+	}
+	if !common.NormalizeDirectMessage(msg) {
+		err = common.NewError(common.CodeInvalid, "message is empty or too long", nil)
+		return
+	}
+	conv, err := readMemberConversationSQLInternal(msg.ConversationUuId, msg.UserId)
+	if err != nil {
+		return
+	}
+	err = attachMembersSQLInternal(conv)
+	if err != nil {
+		return
+	}
+	if !conv.IsGroup() {
+		for _, member := range conv.Members {
+			if member.UserId == msg.UserId {
+				continue
+			}
+			err = checkNotBlockedInternal(msg.UserId, member.UserId)
+			if err != nil {
+				return
+			}
+		}
+	}
+	msg.Id = 0
+	msg.ConversationId = conv.Id
+	msg.UserName = conv.Member(msg.UserId).UserName
+	msg.CreatedAt = time.Now()
+	err = createDirectMessageSQLInternal(msg)
+	return
+}
+
+// everything in it up to now
+func markConversationRead(ctx *gin.Context) {
+	var query common.ConversationQuery
+	err := bindInternal(ctx, &query)
+	if err == nil && (query.UserId == 0 || len(query.UuId) == 0) {
+		err = common.NewError(common.CodeInvalid, "need uuid and user", nil)
+	}
+	if err == nil {
+		err = markConversationReadSQLInternal(query.UuId, query.UserId)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func countUnreadMessages(ctx *gin.Context) {
+	var count common.MessageCount
+	err := bindInternal(ctx, &count)
+	if err == nil && count.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err == nil {
+		count.Unread, err = countUnreadMessagesSQLInternal(count.UserId)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &count)
+}
+
+// one to ones racing each other meet on pair key, the later one
+// waits for the first to commit and reads it then
+func createConversationSQLInternal(
+	conv *common.Conversation,
+	members []common.ConversationMember,
+) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.Exec(
+		`INSERT INTO conversations (uu_id, title, pair_key, last_message_at, created_at)
+VALUES (?, ?, NULLIF(?, ''), ?, ?)
+ON CONFLICT (pair_key) DO NOTHING`,
+		conv.UuId,
+		conv.Title,
+		conv.PairKey,
+		conv.LastMessageAt,
+		conv.CreatedAt,
+	)
+	if err != nil {
+		return
+	}
+	var ok bool
+	if len(conv.PairKey) == 0 {
+		ok, err = session.Table(conversationTable).Where("uu_id = ?", conv.UuId).Get(conv)
+	} else {
+		ok, err = session.Table(conversationTable).Where("pair_key = ?", conv.PairKey).Get(conv)
+	}
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = common.NewError(common.CodeInternal, "conversation went missing", nil)
+		return
+	}
+	err = createMembersSQLInternal(session, conv.Id, members)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+// members already there stay as they are
+func createMembersSQLInternal(db xorm.Interface, convId uint, members []common.ConversationMember) (err error) {
+	for i := range members {
+		_, err = db.Exec(
+			`INSERT INTO conversation_members (conversation_id, user_id, user_name, last_read_id, joined_at)
+VALUES (?, ?, ?, 0, ?)
+ON CONFLICT (conversation_id, user_id) DO NOTHING`,
+			convId,
+			members[i].UserId,
+			members[i].UserName,
+			members[i].JoinedAt,
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func readConversationsSQLInternal(userId uint) (convs []common.Conversation, err error) {
+	convs = []common.Conversation{}
+	err = dbEngine.
+		Table(conversationTable).
+		Where("id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)", userId).
+		Desc("last_message_at").
+		Limit(conversationLimit).
+		Find(&convs)
+	return
+}
+
+func readMemberConversationSQLInternal(uuid string, userId uint) (conv *common.Conversation, err error) {
+	conv = &common.Conversation{}
+	ok, err := dbEngine.
+		Table(conversationTable).
+		Where("uu_id = ?", uuid).
+		And("id IN (SELECT conversation_id FROM conversation_members WHERE user_id = ?)", userId).
+		Get(conv)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such conversation", nil)
+	}
+	return
+}
+
+func attachMembersSQLInternal(conv *common.Conversation) (err error) {
+	conv.Members = []common.ConversationMember{}
+	err = dbEngine.
+		Table(conversationMemberTable).
+		Where("conversation_id = ?", conv.Id).
+		Asc("id").
+		Find(&conv.Members)
+	return
+}
+
+// members, unread counts and last messages in three queries
+func attachInboxSQLInternal(userId uint, convs []common.Conversation) (err error) {
+	if len(convs) == 0 {
+		return
+	}
+	convById := make(map[uint]*common.Conversation)
+	convIds := make([]uint, 0, len(convs))
+	for i := range convs {
+		convs[i].Members = []common.ConversationMember{}
+		convById[convs[i].Id] = &convs[i]
+		convIds = append(convIds, convs[i].Id)
+	}
+	var members []common.ConversationMember
+	err = dbEngine.
+		Table(conversationMemberTable).
+		In("conversation_id", convIds).
+		Asc("id").
+		Find(&members)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		conv := convById[member.ConversationId]
+		conv.Members = append(conv.Members, member)
+	}
+	var counts []struct {
+		ConversationId uint  `xorm:"'conversation_id'"`
+		Unread         int64 `xorm:"'unread'"`
+	}
+	err = dbEngine.SQL(`SELECT m.conversation_id, COUNT(*) AS unread FROM direct_messages m
+  JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?
+  WHERE m.id > cm.last_read_id AND m.user_id <> ?
+  AND m.user_id NOT IN (SELECT blocked_id FROM user_blocks WHERE user_id = ?)
+  GROUP BY m.conversation_id`, userId, userId, userId).
+		Find(&counts)
+	if err != nil {
+		return
+	}
+	for _, count := range counts {
+		if conv, ok := convById[count.ConversationId]; ok {
+			conv.Unread = count.Unread
+		}
+	}
+	var lasts []common.DirectMessage
+	err = dbEngine.
+		Table(directMessageTable).
+		In("conversation_id", convIds).
+		And(`id IN (SELECT MAX(d.id) FROM direct_messages d
+  JOIN conversation_members cm ON cm.conversation_id = d.conversation_id AND cm.user_id = ?
+  WHERE d.user_id NOT IN (SELECT blocked_id FROM user_blocks WHERE user_id = ?)
+  GROUP BY d.conversation_id)`, userId, userId).
+		Find(&lasts)
+	if err != nil {
+		return
+	}
+	for i := range lasts {
+		conv := convById[lasts[i].ConversationId]
+		lasts[i].ConversationUuId = conv.UuId
+		conv.LastMessage = &lasts[i]
+	}
+	return
+}
+
+func readDirectMessagesSQLInternal(conv *common.Conversation, userId uint) (msgs []common.DirectMessage, err error) {
+	msgs = []common.DirectMessage{}
+	err = dbEngine.
+		Table(directMessageTable).
+		Where("conversation_id = ?", conv.Id).
+		And("user_id NOT IN (SELECT blocked_id FROM user_blocks WHERE user_id = ?)", userId).
+		Desc("id").
+		Limit(directMessageLimit).
+		Find(&msgs)
+	if err != nil {
+		return
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	for i := range msgs {
+		msgs[i].ConversationUuId = conv.UuId
+	}
+	return
+}
+
+// own message counts as read for who wrote it
+func createDirectMessageSQLInternal(msg *common.DirectMessage) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	_, err = session.
+		Table(directMessageTable).
+		InsertOne(msg)
+	if err != nil {
+		return
+	}
+	_, err = session.Exec(
+		"UPDATE conversations SET last_message_at = ? WHERE id = ?",
+		msg.CreatedAt,
+		msg.ConversationId,
+	)
+	if err != nil {
+		return
+	}
+	_, err = session.Exec(
+		"UPDATE conversation_members SET last_read_id = ? WHERE conversation_id = ? AND user_id = ?",
+		msg.Id,
+		msg.ConversationId,
+		msg.UserId,
+	)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+func markConversationReadSQLInternal(uuid string, userId uint) (err error) {
+	res, err := dbEngine.Exec(
+		`UPDATE conversation_members cm
+SET last_read_id = (SELECT COALESCE(MAX(id), 0) FROM direct_messages WHERE conversation_id = cm.conversation_id)
+WHERE cm.user_id = ? AND cm.conversation_id = (SELECT id FROM conversations WHERE uu_id = ?)`,
+		userId,
+		uuid,
+	)
+	if err != nil {
+		return
+	}
+	affected, err := res.RowsAffected()
+	if err == nil && affected == 0 {
+		err = common.NewError(common.CodeNotFound, "no such conversation", nil)
+	}
+	return
+}
+
+func countUnreadMessagesSQLInternal(userId uint) (unread int64, err error) {
+	_, err = dbEngine.SQL(`SELECT COUNT(*) FROM direct_messages m
+  JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?
+  WHERE m.id > cm.last_read_id AND m.user_id <> ?
+  AND m.user_id NOT IN (SELECT blocked_id FROM user_blocks WHERE user_id = ?)`, userId, userId, userId).
+		Get(&unread)
+	return
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+const (
+	notificationTable = "notifications"
+	notificationLimit = 50
+	excerptRunes      = 140
+)
+
+func readNotifications(ctx *gin.Context) {
+	var search common.Notification
+	notifications, err := readNotificationsInternal(ctx, &search)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, notifications)
+}
+
+func readNotificationsInternal(
+	ctx *gin.Context,
+	search *common.Notification,
+) (notifications []common.Notification, err error) {
+	err = bindInternal(ctx, search)
+	if err != nil {
+		return
+	}
+	if search.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+		return
+	}
+	notifications, err = readNotificationsSQLInternal(search.UserId)
+	return
+}
+
+func countUnreadNotifications(ctx *gin.Context) {
+	var count common.NotificationCount
+	err := countUnreadNotificationsInternal(ctx, &count)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &count)
+}
+
+func countUnreadNotificationsInternal(ctx *gin.Context, count *common.NotificationCount) (err error) {
+	err = bindInternal(ctx, count)
+	if err != nil {
+		return
+	}
+	if count.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+		return
+	}
+	count.Unread, err = countUnreadSQLInternal(count.UserId)
+	return
+}
+
+// empty uuid marks all of them
+func markNotificationsRead(ctx *gin.Context) {
+	var search common.Notification
+	err := bindInternal(ctx, &search)
+	if err == nil && search.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err == nil {
+		err = markReadSQLInternal(&search)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func banUser(ctx *gin.Context) {
+	var action common.ModerationAction
+	user, err := banUserInternal(ctx, &action)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
+// router checked the moderator role already
+func banUserInternal(ctx *gin.Context, action *common.ModerationAction) (user *common.User, err error) {
+	err = bindInternal(ctx, action)
+	if err != nil {
+		return
+	}
+	if action.TargetUserId == 0 || action.ModeratorId == 0 {
+		err = common.NewError(common.CodeInvalid, "need target and moderator", nil)
+		return
+	}
+	if !action.Until.After(time.Now()) {
+		err = common.NewError(common.CodeInvalid, "ban must end in the future", nil)
+		return
+	}
+	user = &common.User{Id: action.TargetUserId}
+	err = readUserSQLInternal(user)
+	if err != nil {
+		return
+	}
+	if user.IsModerator() {
+		err = common.NewError(common.CodeForbidden, "moderators can not be banned", nil)
+		return
+	}
+	action.Action = common.ModerationUserBanned
+	action.CreatedAt = time.Now()
+	user.BannedUntil = action.Until
+	err = banUserSQLInternal(user, action)
+	if err != nil {
+		return
+	}
+	outbox.Wake()
+	user.Password = ""
+	return
+}
+
+// subscribed to the bus. threads events come here only
+// with the postgres bus, the memory one is per process.
+func notifyFromEvent(ctx context.Context, event *common.Event) (err error) {
+	var notifications []common.Notification
+	switch event.Topic {
+	case common.TopicPostCreated:
+		notifications, err = notificationsForPostInternal(event)
+	case common.TopicModeration:
+		notifications, err = notificationsForModerationInternal(event)
+	default:
+		return
+	}
+	if common.ErrorCodeOf(err) == common.CodeInvalid {
+		// delivering again does not fix it
+		common.LogError(logger).Printf("broken event %d: %s\n", event.Id, err.Error())
+		return nil
+	} else if err != nil {
+		return
+	}
+	for i := range notifications {
+		err = createNotificationSQLInternal(&notifications[i])
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func notificationsForPostInternal(event *common.Event) (notifications []common.Notification, err error) {
+	var threEvent common.ThreadEvent
+	err = event.Decode(&threEvent)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "broken post event", err)
+		return
+	}
+	thre := threEvent.Thread
+	post := &threEvent.Post
+	if thre == nil {
+		err = common.NewError(common.CodeInvalid, "post event without thread", nil)
+		return
+	}
+	base := common.Notification{
+		EventUuId:  event.UuId,
+		ActorName:  post.Contributor,
+		ThreadUuId: thre.UuId,
+		Topic:      thre.Topic,
+		Excerpt:    excerptInternal(post.Body),
+	}
+	blocked, err := isBlockedSQLInternal(thre.UserId, post.UserId)
+	if err != nil {
+		return
+	}
+	if thre.UserId != post.UserId && !blocked {
+		reply := base
+		reply.UserId = thre.UserId
+		reply.Kind = common.NotifyReply
+		notifications = append(notifications, reply)
+	}
+	// threads service resolved them, blocks included
+	for _, mentioned := range post.Mentions {
+		// owner knows from the reply already
+		if mentioned.UserId == post.UserId || mentioned.UserId == thre.UserId {
+			continue
+		}
+		mention := base
+		mention.UserId = mentioned.UserId
+		mention.Kind = common.NotifyMention
+		notifications = append(notifications, mention)
+	}
+	return
+}
+
+func notificationsForModerationInternal(event *common.Event) (notifications []common.Notification, err error) {
+	var action common.ModerationAction
+	err = event.Decode(&action)
+	if err != nil {
+		err = common.NewError(common.CodeInvalid, "broken moderation event", err)
+		return
+	}
+	if action.TargetUserId == 0 || action.TargetUserId == action.ModeratorId {
+		return
+	}
+	notifications = append(notifications, common.Notification{
+		UserId:     action.TargetUserId,
+		Kind:       common.NotifyModeration,
+		EventUuId:  event.UuId,
+		ActorName:  action.ModeratorName,
+		ThreadUuId: action.ThreadUuId,
+		Topic:      action.ThreadTopic,
+		Excerpt:    moderationTextInternal(&action),
+	})
+	return
+}
+
+func moderationTextInternal(action *common.ModerationAction) (text string) {
+	switch action.Action {
+	case common.ModerationPostDeleted:
+		text = "deleted your post"
+	case common.ModerationThreadLocked:
+		text = "locked your thread"
+	case common.ModerationThreadUnlocked:
+		text = "unlocked your thread"
+	case common.ModerationUserBanned:
+		text = "banned you until " + action.Until.Format("2006/Jan/2 at 3:04pm")
+	default:
+		text = action.Action
+	}
+	if !common.IsEmpty(action.Reason) {
+		text += ": " + excerptInternal(action.Reason)
+	}
+	return
+}
+
+func excerptInternal(body string) string {
+	runes := []rune(body)
+	if len(runes) <= excerptRunes {
+		return body
+	}
+	return string(runes[:excerptRunes]) + "…"
+}
+
+// the same event delivered twice makes one row
+func createNotificationSQLInternal(notification *common.Notification) (err error) {
+	_, err = dbEngine.Exec(
+		`INSERT INTO notifications
+  (uu_id, user_id, kind, event_uu_id, actor_name, thread_uu_id, topic, excerpt, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (user_id, event_uu_id, kind) DO NOTHING`,
+		common.NewUuIdString(),
+		notification.UserId,
+		notification.Kind,
+		notification.EventUuId,
+		notification.ActorName,
+		notification.ThreadUuId,
+		notification.Topic,
+		notification.Excerpt,
+		time.Now(),
+	)
+	return
+}
+
+func readNotificationsSQLInternal(userId uint) (notifications []common.Notification, err error) {
+	err = dbEngine.
+		Table(notificationTable).
+		Where("user_id = ?", userId).
+		Desc("id").
+		Limit(notificationLimit).
+		Find(&notifications)
+	return
+}
+
+func countUnreadSQLInternal(userId uint) (count int64, err error) {
+	count, err = dbEngine.
+		Table(notificationTable).
+		Where("user_id = ? AND read_at IS NULL", userId).
+		Count()
+	return
+}
+
+func markReadSQLInternal(search *common.Notification) (err error) {
+	session := dbEngine.
+		Table(notificationTable).
+		Where("user_id = ? AND read_at IS NULL", search.UserId)
+	if !common.IsEmpty(search.UuId) {
+		session = session.And("uu_id = ?", search.UuId)
+	}
+	_, err = session.
+		Cols("read_at").
+		Update(&common.Notification{ReadAt: time.Now()})
+	return
+}
+
+func banUserSQLInternal(user *common.User, action *common.ModerationAction) (err error) {
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
+		Table(userTable).
+		ID(user.Id).
+		Cols("banned_until").
+		Update(user)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	if err != nil {
+		return
+	}
+	err = writeModerationEventInternal(session, action)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
+	return
+}
+
+func writeModerationEventInternal(session *xorm.Session, action *common.ModerationAction) (err error) {
+	event, err := common.NewEvent(common.TopicModeration, action)
+	if err != nil {
+		return
+	}
+	err = common.WriteOutbox(session, outboxTable, event)
+	return
+}
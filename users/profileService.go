@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const blockTable = "user_blocks"
+
+// public part of a user, by name
+func readProfile(ctx *gin.Context) {
+	var user common.User
+	err := readProfileInternal(ctx, &user)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &user)
+}
+
+func readProfileInternal(ctx *gin.Context, user *common.User) (err error) {
+	err = bindInternal(ctx, user)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(user.Name) {
+		err = common.NewError(common.CodeInvalid, "need name", nil)
+		return
+	}
+	// only name is a condition
+	*user = common.User{Name: user.Name}
+	err = readUserSQLInternal(user)
+	if err != nil {
+		return
+	}
+	user.Email = ""
+	user.Password = ""
+	return
+}
+
+// names that exist and did not block the author, author excluded
+func resolveMentions(ctx *gin.Context) {
+	var query common.MentionQuery
+	users, err := resolveMentionsInternal(ctx, &query)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, users)
+}
+
+func resolveMentionsInternal(
+	ctx *gin.Context,
+	query *common.MentionQuery,
+) (users []common.User, err error) {
+	err = bindInternal(ctx, query)
+	if err != nil {
+		return
+	}
+	if query.AuthorId == 0 {
+		err = common.NewError(common.CodeInvalid, "need author", nil)
+		return
+	}
+	users = []common.User{}
+	if len(query.Names) == 0 {
+		return
+	}
+	found, err := readUsersByNameSQLInternal(query.Names)
+	if err != nil {
+		return
+	}
+	for i := range found {
+		if found[i].Id == query.AuthorId {
+			continue
+		}
+		var blocked bool
+		blocked, err = isBlockedSQLInternal(found[i].Id, query.AuthorId)
+		if err != nil {
+			return
+		}
+		if blocked {
+			continue
+		}
+		users = append(users, common.User{
+			Id:   found[i].Id,
+			UuId: found[i].UuId,
+			Name: found[i].Name,
+		})
+	}
+	return
+}
+
+func blockUser(ctx *gin.Context) {
+	var block common.UserBlock
+	err := blockUserInternal(ctx, &block)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &block)
+}
+
+func blockUserInternal(ctx *gin.Context, block *common.UserBlock) (err error) {
+	err = bindInternal(ctx, block)
+	if err != nil {
+		return
+	}
+	if block.UserId == 0 || block.BlockedId == 0 {
+		err = common.NewError(common.CodeInvalid, "need users", nil)
+		return
+	}
+	if block.UserId == block.BlockedId {
+		err = common.NewError(common.CodeInvalid, "can not block yourself", nil)
+		return
+	}
+	blocked := common.User{Id: block.BlockedId}
+	err = readUserSQLInternal(&blocked)
+	if err != nil {
+		return
+	}
+	block.BlockedName = blocked.Name
+	block.CreatedAt = time.Now()
+	err = createBlockSQLInternal(block)
+	return
+}
+
+func unblockUser(ctx *gin.Context) {
+	var block common.UserBlock
+	err := bindInternal(ctx, &block)
+	if err == nil && (block.UserId == 0 || block.BlockedId == 0) {
+		err = common.NewError(common.CodeInvalid, "need users", nil)
+	}
+	if err == nil {
+		err = deleteBlockSQLInternal(&block)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.Status(http.StatusOK)
+}
+
+func readBlocks(ctx *gin.Context) {
+	var search common.UserBlock
+	err := bindInternal(ctx, &search)
+	if err == nil && search.UserId == 0 {
+		err = common.NewError(common.CodeInvalid, "need user", nil)
+	}
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	blocks, err := readBlocksSQLInternal(search.UserId)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, blocks)
+}
+
+func readUsersByNameSQLInternal(names []string) (users []common.User, err error) {
+	err = dbEngine.
+		Table(userTable).
+		In("name", names).
+		Find(&users)
+	return
+}
+
+// blocking twice is fine
+func createBlockSQLInternal(block *common.UserBlock) (err error) {
+	_, err = dbEngine.Exec(
+		`INSERT INTO user_blocks (user_id, blocked_id, blocked_name, created_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT (user_id, blocked_id) DO NOTHING`,
+		block.UserId,
+		block.BlockedId,
+		block.BlockedName,
+		block.CreatedAt,
+	)
+	return
+}
+
+func deleteBlockSQLInternal(block *common.UserBlock) (err error) {
+	_, err = dbEngine.
+		Table(blockTable).
+		Where("user_id = ? AND blocked_id = ?", block.UserId, block.BlockedId).
+		Delete(&common.UserBlock{})
+	return
+}
+
+func readBlocksSQLInternal(userId uint) (blocks []common.UserBlock, err error) {
+	blocks = []common.UserBlock{}
+	err = dbEngine.
+		Table(blockTable).
+		Where("user_id = ?", userId).
+		Asc("blocked_name").
+		Find(&blocks)
+	return
+}
+
+func isBlockedSQLInternal(userId uint, blockedId uint) (blocked bool, err error) {
+	blocked, err = dbEngine.
+		Table(blockTable).
+		Where("user_id = ? AND blocked_id = ?", userId, blockedId).
+		Exist()
+	if err != nil {
+		err = fmt.Errorf("block check: %w", err)
+	}
+	return
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	tokenTable       = "api_tokens"
+	tokenPrefix      = "kjb_"
+	tokenSecretBytes = 30
+	defaultRateLimit = 60
+	maxRateLimit     = 600
+	// don't write last_used_at on every single api call
+	lastUsedInterval = time.Minute
+)
+
+func createToken(ctx *gin.Context) {
+	var token common.APIToken
+	newToken, err := createTokenInternal(ctx, &token)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, newToken)
+}
+
+func createTokenInternal(ctx *gin.Context, token *common.APIToken) (newToken *common.NewAPIToken, err error) {
+	err = bindInternal(ctx, token)
+	if err != nil {
+		return
+	}
+	if token.UserId == 0 || common.IsEmpty(token.Name, token.UserName) {
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
+		return
+	}
+	if !common.IsValidTokenScope(token.Scope) {
+		err = common.NewError(common.CodeInvalid, "unknown scope", nil)
+		return
+	}
+	if token.Scope == common.TokenScopeAdmin {
+		owner := common.User{Id: token.UserId}
+		err = readUserSQLInternal(&owner)
+		if err != nil {
+			return
+		}
+		if !owner.IsAdmin() {
+			err = common.NewError(common.CodeForbidden, "admin scope is for admins", nil)
+			return
+		}
+	}
+	if token.RateLimit <= 0 {
+		token.RateLimit = defaultRateLimit
+	} else if token.RateLimit > maxRateLimit {
+		token.RateLimit = maxRateLimit
+	}
+	secret, err := generateTokenSecretInternal()
+	if err != nil {
+		return
+	}
+	token.UuId = common.NewUuIdString()
+	token.TokenHash = hashTokenSecretInternal(secret)
+	token.Revoked = false
+	token.LastUsedAt = time.Time{}
+	token.CreatedAt = time.Now()
+	err = createTokenSQLInternal(token)
+	if err != nil {
+		return
+	}
+	newToken = &common.NewAPIToken{
+		Token:  *token,
+		Secret: secret,
+	}
+	return
+}
+
+func readTokens(ctx *gin.Context) {
+	var search common.APIToken
+	err := bindInternal(ctx, &search)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	if search.UserId == 0 {
+		handleErrorInternal(common.NewError(common.CodeInvalid, "need user id", nil), ctx)
+		return
+	}
+	tokens, err := readTokensSQLInternal(search.UserId)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &tokens)
+}
+
+func revokeToken(ctx *gin.Context) {
+	var token common.APIToken
+	err := revokeTokenInternal(ctx, &token)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, &token)
+}
+
+// only the owner can revoke, so user id is required with uuid
+func revokeTokenInternal(ctx *gin.Context, token *common.APIToken) (err error) {
+	err = bindInternal(ctx, token)
+	if err != nil {
+		return
+	}
+	if token.UserId == 0 || common.IsEmpty(token.UuId) {
+		err = common.NewError(common.CodeInvalid, "need uuid and user id", nil)
+		return
+	}
+	err = readTokenSQLInternal(token)
+	if err != nil {
+		return
+	}
+	token.Revoked = true
+	err = updateTokenSQLInternal(token, "revoked")
+	return
+}
+
+func checkToken(ctx *gin.Context) {
+	var newToken common.NewAPIToken
+	token, err := checkTokenInternal(ctx, &newToken)
+	if err != nil {
+		handleErrorInternal(err, ctx)
+		return
+	}
+	ctx.JSON(http.StatusOK, token)
+}
+
+func checkTokenInternal(ctx *gin.Context, newToken *common.NewAPIToken) (token *common.APIToken, err error) {
+	err = bindInternal(ctx, newToken)
+	if err != nil {
+		return
+	}
+	if common.IsEmpty(newToken.Secret) {
+		err = common.NewError(common.CodeInvalid, "need secret", nil)
+		return
+	}
+	token = &common.APIToken{TokenHash: hashTokenSecretInternal(newToken.Secret)}
+	err = readTokenSQLInternal(token)
+	if common.ErrorCodeOf(err) == common.CodeNotFound {
+		err = common.NewError(common.CodeUnauthorized, "invalid token", err)
+		return
+	} else if err != nil {
+		return
+	}
+	if token.Revoked {
+		err = common.NewError(common.CodeUnauthorized, "revoked token", nil)
+		return
+	}
+	now := time.Now()
+	if now.Sub(token.LastUsedAt) > lastUsedInterval {
+		token.LastUsedAt = now
+		err = updateTokenSQLInternal(token, "last_used_at")
+	}
+	return
+}
+
+func generateTokenSecretInternal() (secret string, err error) {
+	raw := make([]byte, tokenSecretBytes)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return
+	}
+	secret = fmt.Sprint(tokenPrefix, base64.RawURLEncoding.EncodeToString(raw))
+	return
+}
+
+// secrets are random enough, plain sha256 is fine
+func hashTokenSecretInternal(secret string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(secret)))
+}
+
+func createTokenSQLInternal(token *common.APIToken) (err error) {
+	affected, err := dbEngine.
+		Table(tokenTable).
+		InsertOne(token)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
+
+func readTokenSQLInternal(token *common.APIToken) (err error) {
+	ok, err := dbEngine.
+		Table(tokenTable).
+		Get(token)
+	if err == nil && !ok {
+		err = common.NewError(common.CodeNotFound, "no such token", nil)
+	}
+	return
+}
+
+func readTokensSQLInternal(userId uint) (tokens []common.APIToken, err error) {
+	err = dbEngine.
+		Table(tokenTable).
+		Where("user_id = ?", userId).
+		Desc("created_at").
+		Find(&tokens)
+	return
+}
+
+func updateTokenSQLInternal(token *common.APIToken, cols ...string) (err error) {
+	affected, err := dbEngine.
+		Table(tokenTable).
+		ID(token.Id).
+		Cols(cols...).
+		Update(token)
+	if err == nil && affected != 1 {
+		err = fmt.Errorf(
+			"something wrong. returned value was %d",
+			affected,
+		)
+	}
+	return
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"learning-web-chatboard2/common"
 	"log"
 
@@ -9,6 +10,8 @@ import (
 )
 
 var dbEngine *xorm.Engine
+var eventBus common.EventBus
+var outbox *common.OutboxDispatcher
 var config *common.Configuration
 var logger *log.Logger
 
@@ -36,17 +39,51 @@ func main() {
 	if err != nil {
 		common.LogError(logger).Fatalln(err.Error())
 	}
+	//events
+	eventBus, outbox, err = common.StartEventBus(
+		context.Background(),
+		config,
+		dbEngine,
+		outboxTable,
+		logger,
+	)
+	if err != nil {
+		common.LogError(logger).Fatalln(err.Error())
+	}
+	eventBus.Subscribe(common.TopicPostCreated, notifyFromEvent)
+	eventBus.Subscribe(common.TopicModeration, notifyFromEvent)
 	//router
 	routeEngine := gin.Default()
 	routeEngine.GET("/create-visit", createVisit)
 	routeEngine.POST("/signup-account", createUser)
 	routeEngine.POST("/create-session", createSession)
 	routeEngine.POST("/authenticate", readUser)
+	routeEngine.POST("/read-user", readUser)
 	routeEngine.POST("/check-session", readSession)
 	routeEngine.POST("/check-visit", readVisit)
 	routeEngine.POST("/update-session", updateSession)
 	routeEngine.POST("/update-visit", updateVisit)
 	routeEngine.POST("/delete-session", deleteSession)
+	routeEngine.POST("/create-token", createToken)
+	routeEngine.POST("/read-tokens", readTokens)
+	routeEngine.POST("/revoke-token", revokeToken)
+	routeEngine.POST("/check-token", checkToken)
+	routeEngine.POST("/read-notifications", readNotifications)
+	routeEngine.POST("/count-unread-notifications", countUnreadNotifications)
+	routeEngine.POST("/mark-notifications-read", markNotificationsRead)
+	routeEngine.POST("/ban-user", banUser)
+	routeEngine.POST("/read-profile", readProfile)
+	routeEngine.POST("/resolve-mentions", resolveMentions)
+	routeEngine.POST("/block-user", blockUser)
+	routeEngine.POST("/unblock-user", unblockUser)
+	routeEngine.POST("/read-blocks", readBlocks)
+	routeEngine.POST("/create-conversation", createConversation)
+	routeEngine.POST("/read-conversations", readConversations)
+	routeEngine.POST("/read-conversation", readConversation)
+	routeEngine.POST("/read-direct-messages", readDirectMessages)
+	routeEngine.POST("/create-direct-message", createDirectMessage)
+	routeEngine.POST("/mark-conversation-read", markConversationRead)
+	routeEngine.POST("/count-unread-messages", countUnreadMessages)
 
 	routeEngine.Run(config.AddressUsers)
 }
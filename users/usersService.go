@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"learning-web-chatboard2/common"
 	"net/http"
@@ -14,14 +13,23 @@ const (
 	userTable    = "users"
 	sessionTable = "sessions"
 	visitTable   = "visits"
+	outboxTable  = "users_outbox"
 )
 
-func handleErrorInternal(
-	loggerErrorMsg string,
-	ctx *gin.Context,
-) {
-	common.LogError(logger).Println(loggerErrorMsg)
-	ctx.JSON(http.StatusBadRequest, gin.H{"status": "error"})
+// replies error envelope with the status for its code.
+// cause is logged here and never sent.
+func handleErrorInternal(err error, ctx *gin.Context) {
+	replyErr := common.AsError(err)
+	common.LogError(logger).Println(replyErr.Error())
+	ctx.JSON(replyErr.HTTPStatus(), &common.ErrorEnvelope{Error: replyErr})
+}
+
+func bindInternal(ctx *gin.Context, obj interface{}) (err error) {
+	err = ctx.ShouldBind(obj)
+	if err != nil {
+		err = common.NewError(common.CodeBadRequest, "invalid body", err)
+	}
+	return
 }
 
 // better way to send user data??
@@ -29,14 +37,14 @@ func createUser(ctx *gin.Context) {
 	var newUser common.User
 	err := createUserInternal(ctx, &newUser)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &newUser)
 }
 
 func createUserInternal(ctx *gin.Context, newUser *common.User) (err error) {
-	err = ctx.Bind(newUser)
+	err = bindInternal(ctx, newUser)
 	if err != nil {
 		return
 	}
@@ -45,12 +53,18 @@ func createUserInternal(ctx *gin.Context, newUser *common.User) (err error) {
 		newUser.Email,
 		newUser.Password,
 	) {
-		err = errors.New("contains empty string")
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
 		return
 	}
 	newUser.UuId = common.NewUuIdString()
+	// roles are never taken from request
+	newUser.Role = common.RoleMember
 	newUser.CreatedAt = time.Now()
 	err = createUserSQLInternal(newUser)
+	if err != nil {
+		return
+	}
+	outbox.Wake()
 	return
 }
 
@@ -58,19 +72,19 @@ func createSession(ctx *gin.Context) {
 	var sessUser common.User
 	sess, err := createSessionInternal(ctx, &sessUser)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, sess)
 }
 
 func createSessionInternal(ctx *gin.Context, sessUser *common.User) (sess *common.Session, err error) {
-	err = ctx.Bind(sessUser)
+	err = bindInternal(ctx, sessUser)
 	if err != nil {
 		return
 	}
 	if common.IsEmpty(sessUser.Name, sessUser.Email) {
-		err = errors.New("contains empty string")
+		err = common.NewError(common.CodeInvalid, "contains empty string", nil)
 		return
 	}
 	now := time.Now()
@@ -89,7 +103,7 @@ func createVisit(ctx *gin.Context) {
 	var newVis common.Visit
 	err := createVisitInternal(ctx, &newVis)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &newVis)
@@ -108,19 +122,21 @@ func readUser(ctx *gin.Context) {
 	var searchUser common.User
 	err := readUserInternal(ctx, &searchUser)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &searchUser)
 }
 
 func readUserInternal(ctx *gin.Context, searchUser *common.User) (err error) {
-	err = ctx.Bind(searchUser)
+	err = bindInternal(ctx, searchUser)
 	if err != nil {
 		return
 	}
-	if common.IsEmpty(searchUser.Email) && common.IsEmpty(searchUser.UuId) {
-		err = errors.New("need email or uuid for finding user")
+	if searchUser.Id == 0 &&
+		common.IsEmpty(searchUser.Email) &&
+		common.IsEmpty(searchUser.UuId) {
+		err = common.NewError(common.CodeInvalid, "need id, email or uuid for finding user", nil)
 		return
 	}
 	err = readUserSQLInternal(searchUser)
@@ -131,19 +147,19 @@ func readSession(ctx *gin.Context) {
 	var searchSess common.Session
 	err := readSessionInternal(ctx, &searchSess)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &searchSess)
 }
 
 func readSessionInternal(ctx *gin.Context, searchSess *common.Session) (err error) {
-	err = ctx.Bind(searchSess)
+	err = bindInternal(ctx, searchSess)
 	if err != nil {
 		return
 	}
 	if common.IsEmpty(searchSess.UuId) {
-		err = errors.New("need uuid for finding session")
+		err = common.NewError(common.CodeInvalid, "need uuid for finding session", nil)
 		return
 	}
 	err = readSessionSQLInternal(searchSess)
@@ -154,19 +170,19 @@ func readVisit(ctx *gin.Context) {
 	var searchVis common.Visit
 	err := readVisitInternal(ctx, &searchVis)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &searchVis)
 }
 
 func readVisitInternal(ctx *gin.Context, searchVis *common.Visit) (err error) {
-	err = ctx.Bind(searchVis)
+	err = bindInternal(ctx, searchVis)
 	if err != nil {
 		return
 	}
 	if common.IsEmpty(searchVis.UuId) {
-		err = errors.New("need uuid for finding visit")
+		err = common.NewError(common.CodeInvalid, "need uuid for finding visit", nil)
 		return
 	}
 	err = readVisitSQLInternal(searchVis)
@@ -177,14 +193,14 @@ func updateSession(ctx *gin.Context) {
 	var sess common.Session
 	err := updateSessionInternal(ctx, &sess)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &sess)
 }
 
 func updateSessionInternal(ctx *gin.Context, sess *common.Session) (err error) {
-	err = ctx.Bind(sess)
+	err = bindInternal(ctx, sess)
 	if err != nil {
 		return
 	}
@@ -192,7 +208,11 @@ func updateSessionInternal(ctx *gin.Context, sess *common.Session) (err error) {
 		sess.UuId,
 		sess.UserName,
 	) {
-		err = fmt.Errorf("contains empty string %s %s", sess.UuId, sess.UserName)
+		err = common.NewError(
+			common.CodeInvalid,
+			fmt.Sprintf("contains empty string %s %s", sess.UuId, sess.UserName),
+			nil,
+		)
 		return
 	}
 	sess.LastUpdate = time.Now()
@@ -204,21 +224,25 @@ func updateVisit(ctx *gin.Context) {
 	var vis common.Visit
 	err := updateVisitInternal(ctx, &vis)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
 		return
 	}
 	ctx.JSON(http.StatusOK, &vis)
 }
 
 func updateVisitInternal(ctx *gin.Context, vis *common.Visit) (err error) {
-	err = ctx.Bind(vis)
+	err = bindInternal(ctx, vis)
 	if err != nil {
 		return
 	}
 	if common.IsEmpty(
 		vis.UuId,
 	) {
-		err = fmt.Errorf("contains empty string %s %s", vis.UuId, vis.State)
+		err = common.NewError(
+			common.CodeInvalid,
+			fmt.Sprintf("contains empty string %s %s", vis.UuId, vis.State),
+			nil,
+		)
 		return
 	}
 	err = updateVisitSQLInternal(vis)
@@ -229,7 +253,8 @@ func deleteSession(ctx *gin.Context) {
 	var delSess common.Session
 	err := deleteSessionInternal(ctx, &delSess)
 	if err != nil {
-		handleErrorInternal(err.Error(), ctx)
+		handleErrorInternal(err, ctx)
+		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"deleted": "ok",
@@ -237,7 +262,7 @@ func deleteSession(ctx *gin.Context) {
 }
 
 func deleteSessionInternal(ctx *gin.Context, delSess *common.Session) (err error) {
-	err = ctx.Bind(delSess)
+	err = bindInternal(ctx, delSess)
 	if err != nil {
 		return
 	}
@@ -260,7 +285,13 @@ func createVisitSQLInternal(newVis *common.Visit) (err error) {
 }
 
 func createUserSQLInternal(newUser *common.User) (err error) {
-	affected, err := dbEngine.
+	session := dbEngine.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err != nil {
+		return
+	}
+	affected, err := session.
 		Table(userTable).
 		InsertOne(newUser)
 	if err == nil && affected != 1 {
@@ -269,6 +300,24 @@ func createUserSQLInternal(newUser *common.User) (err error) {
 			affected,
 		)
 	}
+	if err != nil {
+		return
+	}
+	// no email nor password on the bus
+	event, err := common.NewEvent(common.TopicUserSignedUp, &common.User{
+		Id:        newUser.Id,
+		UuId:      newUser.UuId,
+		Name:      newUser.Name,
+		CreatedAt: newUser.CreatedAt,
+	})
+	if err != nil {
+		return
+	}
+	err = common.WriteOutbox(session, outboxTable, event)
+	if err != nil {
+		return
+	}
+	err = session.Commit()
 	return
 }
 
@@ -291,7 +340,7 @@ func readUserSQLInternal(searchUser *common.User) (err error) {
 		Table(userTable).
 		Get(searchUser)
 	if err == nil && !ok {
-		err = errors.New("no such users")
+		err = common.NewError(common.CodeNotFound, "no such users", nil)
 	}
 	return
 }
@@ -302,7 +351,7 @@ func readVisitSQLInternal(searchVis *common.Visit) (err error) {
 		Table(visitTable).
 		Get(searchVis)
 	if err == nil && !ok {
-		err = errors.New("no such viz")
+		err = common.NewError(common.CodeNotFound, "no such viz", nil)
 	}
 	return
 }
@@ -313,7 +362,7 @@ func readSessionSQLInternal(searchSess *common.Session) (err error) {
 		Table(sessionTable).
 		Get(searchSess)
 	if err == nil && !ok {
-		err = errors.New("no such session")
+		err = common.NewError(common.CodeNotFound, "no such session", nil)
 	}
 	return
 }
@@ -0,0 +1,708 @@
+package usersclient
+
+import (
+	"context"
+	"fmt"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is in-memory users service for tests.
+// it behaves like the real one as far as router cares.
+type Fake struct {
+	mutex sync.Mutex
+	// set true to make every call fail like the service is down
+	Down     bool
+	lastId   uint
+	Users    map[string]*common.User
+	Sessions map[string]*common.Session
+	Visits   map[string]*common.Visit
+	// keyed by secret, fake does not hash
+	Tokens map[string]*common.APIToken
+	// no bus here, tests put notifications in by hand
+	Notifications []common.Notification
+	Blocks        []common.UserBlock
+	// members kept inside, messages in the order written
+	Conversations  []common.Conversation
+	DirectMessages []common.DirectMessage
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Users:    make(map[string]*common.User),
+		Sessions: make(map[string]*common.Session),
+		Visits:   make(map[string]*common.Visit),
+		Tokens:   make(map[string]*common.APIToken),
+	}
+}
+
+func fakeError(code common.ErrorCode, msg string) error {
+	status := common.HTTPStatusOf(code)
+	return &common.StatusError{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Code:       code,
+		Message:    msg,
+	}
+}
+
+func (f *Fake) Available() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return !f.Down
+}
+
+func (f *Fake) nextId() uint {
+	f.lastId++
+	return f.lastId
+}
+
+func (f *Fake) CreateVisit(ctx context.Context) (*common.Visit, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	vis := &common.Visit{
+		Id:        f.nextId(),
+		UuId:      common.NewUuIdString(),
+		CreatedAt: time.Now(),
+	}
+	f.Visits[vis.UuId] = vis
+	copied := *vis
+	return &copied, nil
+}
+
+func (f *Fake) CheckVisit(ctx context.Context, uuid string) (*common.Visit, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	vis, ok := f.Visits[uuid]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such viz")
+	}
+	copied := *vis
+	return &copied, nil
+}
+
+func (f *Fake) UpdateVisit(ctx context.Context, vis *common.Visit) (*common.Visit, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if _, ok := f.Visits[vis.UuId]; !ok {
+		return nil, fakeError(common.CodeNotFound, "no such viz")
+	}
+	copied := *vis
+	f.Visits[vis.UuId] = &copied
+	return vis, nil
+}
+
+func (f *Fake) SignupAccount(ctx context.Context, user *common.User) (*common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(user.Name, user.Email, user.Password) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if _, ok := f.Users[user.Email]; ok {
+		return nil, fakeError(common.CodeConflict, "duplicated email")
+	}
+	created := *user
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	// tests may sign up admins directly
+	if len(created.Role) == 0 {
+		created.Role = common.RoleMember
+	}
+	f.Users[created.Email] = &created
+	copied := created
+	return &copied, nil
+}
+
+func (f *Fake) Authenticate(ctx context.Context, email string) (*common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	user, ok := f.Users[email]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such users")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (f *Fake) ReadUser(ctx context.Context, id uint) (*common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for _, user := range f.Users {
+		if user.Id == id {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "no such users")
+}
+
+func (f *Fake) CreateSession(ctx context.Context, user *common.User) (*common.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if common.IsEmpty(user.Name, user.Email) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	now := time.Now()
+	sess := &common.Session{
+		Id:         f.nextId(),
+		UuId:       common.NewUuIdString(),
+		UserName:   user.Name,
+		UserId:     user.Id,
+		LastUpdate: now,
+		CreatedAt:  now,
+	}
+	f.Sessions[sess.UuId] = sess
+	copied := *sess
+	return &copied, nil
+}
+
+func (f *Fake) CheckSession(ctx context.Context, uuid string) (*common.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	sess, ok := f.Sessions[uuid]
+	if !ok {
+		return nil, fakeError(common.CodeNotFound, "no such session")
+	}
+	copied := *sess
+	return &copied, nil
+}
+
+func (f *Fake) UpdateSession(ctx context.Context, sess *common.Session) (*common.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if _, ok := f.Sessions[sess.UuId]; !ok {
+		return nil, fakeError(common.CodeNotFound, "no such session")
+	}
+	copied := *sess
+	copied.LastUpdate = time.Now()
+	f.Sessions[sess.UuId] = &copied
+	updated := copied
+	return &updated, nil
+}
+
+// same as sql delete, all non-zero fields are conditions
+func (f *Fake) DeleteSession(ctx context.Context, sess *common.Session) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	if common.IsEmpty(sess.UuId) && sess.UserId == 0 {
+		return fakeError(common.CodeBadRequest, "no condition for deleting session")
+	}
+	for uuid, stored := range f.Sessions {
+		if !common.IsEmpty(sess.UuId) && stored.UuId != sess.UuId {
+			continue
+		}
+		if sess.UserId != 0 && stored.UserId != sess.UserId {
+			continue
+		}
+		delete(f.Sessions, uuid)
+	}
+	return nil
+}
+
+func (f *Fake) CreateToken(ctx context.Context, token *common.APIToken) (*common.NewAPIToken, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if token.UserId == 0 || common.IsEmpty(token.Name, token.UserName) {
+		return nil, fakeError(common.CodeInvalid, "contains empty string")
+	}
+	if !common.IsValidTokenScope(token.Scope) {
+		return nil, fakeError(common.CodeInvalid, "unknown scope")
+	}
+	if token.Scope == common.TokenScopeAdmin {
+		owner := f.userByIdInternal(token.UserId)
+		if owner == nil || !owner.IsAdmin() {
+			return nil, fakeError(common.CodeForbidden, "admin scope is for admins")
+		}
+	}
+	created := *token
+	created.Id = f.nextId()
+	created.UuId = common.NewUuIdString()
+	created.CreatedAt = time.Now()
+	if created.RateLimit <= 0 {
+		created.RateLimit = 60
+	}
+	secret := "kjb_" + common.NewUuIdString()
+	f.Tokens[secret] = &created
+	return &common.NewAPIToken{Token: created, Secret: secret}, nil
+}
+
+func (f *Fake) ReadTokens(ctx context.Context, userId uint) ([]common.APIToken, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var tokens []common.APIToken
+	for _, token := range f.Tokens {
+		if token.UserId == userId {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (f *Fake) RevokeToken(ctx context.Context, token *common.APIToken) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	for _, stored := range f.Tokens {
+		if stored.UuId == token.UuId && stored.UserId == token.UserId {
+			stored.Revoked = true
+			return nil
+		}
+	}
+	return fakeError(common.CodeNotFound, "no such token")
+}
+
+func (f *Fake) CheckToken(ctx context.Context, secret string) (*common.APIToken, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	token, ok := f.Tokens[secret]
+	if !ok || token.Revoked {
+		return nil, fakeError(common.CodeUnauthorized, "invalid token")
+	}
+	token.LastUsedAt = time.Now()
+	copied := *token
+	return &copied, nil
+}
+
+func (f *Fake) ReadNotifications(ctx context.Context, userId uint) ([]common.Notification, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	var notifications []common.Notification
+	// newest first like the service
+	for i := len(f.Notifications) - 1; i >= 0; i-- {
+		if f.Notifications[i].UserId == userId {
+			notifications = append(notifications, f.Notifications[i])
+		}
+	}
+	return notifications, nil
+}
+
+func (f *Fake) CountUnreadNotifications(ctx context.Context, userId uint) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return 0, common.ErrCircuitOpen
+	}
+	var unread int64
+	for i := range f.Notifications {
+		if f.Notifications[i].UserId == userId && !f.Notifications[i].IsRead() {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+func (f *Fake) MarkNotificationsRead(ctx context.Context, userId uint, uuid string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	for i := range f.Notifications {
+		stored := &f.Notifications[i]
+		if stored.UserId != userId || stored.IsRead() {
+			continue
+		}
+		if !common.IsEmpty(uuid) && stored.UuId != uuid {
+			continue
+		}
+		stored.ReadAt = time.Now()
+	}
+	return nil
+}
+
+func (f *Fake) BanUser(ctx context.Context, action *common.ModerationAction) (*common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if !action.Until.After(time.Now()) {
+		return nil, fakeError(common.CodeInvalid, "ban must end in the future")
+	}
+	for _, user := range f.Users {
+		if user.Id != action.TargetUserId {
+			continue
+		}
+		if user.IsModerator() {
+			return nil, fakeError(common.CodeForbidden, "moderators can not be banned")
+		}
+		user.BannedUntil = action.Until
+		copied := *user
+		return &copied, nil
+	}
+	return nil, fakeError(common.CodeNotFound, "no such users")
+}
+
+func (f *Fake) ReadProfile(ctx context.Context, name string) (*common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	for _, user := range f.Users {
+		if user.Name == name {
+			copied := *user
+			copied.Email = ""
+			copied.Password = ""
+			return &copied, nil
+		}
+	}
+	return nil, fakeError(common.CodeNotFound, "no such users")
+}
+
+func (f *Fake) ResolveMentions(ctx context.Context, authorId uint, names []string) ([]common.User, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	users := []common.User{}
+	for _, name := range names {
+		for _, user := range f.Users {
+			if user.Name != name || user.Id == authorId || f.blockedInternal(user.Id, authorId) {
+				continue
+			}
+			users = append(users, common.User{Id: user.Id, UuId: user.UuId, Name: user.Name})
+		}
+	}
+	return users, nil
+}
+
+func (f *Fake) BlockUser(ctx context.Context, block *common.UserBlock) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	if block.UserId == block.BlockedId {
+		return fakeError(common.CodeInvalid, "can not block yourself")
+	}
+	if f.blockedInternal(block.UserId, block.BlockedId) {
+		return nil
+	}
+	for _, user := range f.Users {
+		if user.Id == block.BlockedId {
+			created := *block
+			created.Id = f.nextId()
+			created.BlockedName = user.Name
+			created.CreatedAt = time.Now()
+			f.Blocks = append(f.Blocks, created)
+			return nil
+		}
+	}
+	return fakeError(common.CodeNotFound, "no such users")
+}
+
+func (f *Fake) UnblockUser(ctx context.Context, block *common.UserBlock) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	for i := range f.Blocks {
+		if f.Blocks[i].UserId == block.UserId && f.Blocks[i].BlockedId == block.BlockedId {
+			f.Blocks = append(f.Blocks[:i], f.Blocks[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *Fake) ReadBlocks(ctx context.Context, userId uint) ([]common.UserBlock, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	blocks := []common.UserBlock{}
+	for _, block := range f.Blocks {
+		if block.UserId == userId {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, nil
+}
+
+func (f *Fake) blockedInternal(userId uint, blockedId uint) bool {
+	for _, block := range f.Blocks {
+		if block.UserId == userId && block.BlockedId == blockedId {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fake) CreateConversation(
+	ctx context.Context,
+	req *common.ConversationRequest,
+) (*common.Conversation, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	normalized := *req
+	if !common.NormalizeConversationRequest(&normalized) {
+		return nil, fakeError(common.CodeInvalid, "need a few names and a short title")
+	}
+	starter := f.userByIdInternal(normalized.UserId)
+	if starter == nil {
+		return nil, fakeError(common.CodeNotFound, "no such users")
+	}
+	now := time.Now()
+	members := []common.ConversationMember{{UserId: starter.Id, UserName: starter.Name, JoinedAt: now}}
+	for _, name := range normalized.Names {
+		user := f.userByNameInternal(name)
+		if user == nil {
+			return nil, fakeError(common.CodeNotFound, "no such users")
+		}
+		if user.Id == starter.Id {
+			return nil, fakeError(common.CodeInvalid, "can not talk to yourself")
+		}
+		if f.blockedInternal(starter.Id, user.Id) || f.blockedInternal(user.Id, starter.Id) {
+			return nil, fakeError(common.CodeForbidden, "blocked")
+		}
+		members = append(members, common.ConversationMember{UserId: user.Id, UserName: user.Name, JoinedAt: now})
+	}
+	conv := common.Conversation{
+		Id:            f.nextId(),
+		UuId:          common.NewUuIdString(),
+		Title:         normalized.Title,
+		LastMessageAt: now,
+		CreatedAt:     now,
+		Members:       members,
+	}
+	if len(members) == 2 && len(normalized.Title) == 0 {
+		conv.PairKey = common.ConversationPairKey(members[0].UserId, members[1].UserId)
+		for i := range f.Conversations {
+			if f.Conversations[i].PairKey == conv.PairKey {
+				return f.copyConversationInternal(&f.Conversations[i]), nil
+			}
+		}
+	}
+	for i := range conv.Members {
+		conv.Members[i].Id = f.nextId()
+		conv.Members[i].ConversationId = conv.Id
+	}
+	f.Conversations = append(f.Conversations, conv)
+	return f.copyConversationInternal(&conv), nil
+}
+
+func (f *Fake) ReadConversations(ctx context.Context, userId uint) ([]common.Conversation, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	convs := []common.Conversation{}
+	for i := range f.Conversations {
+		member := f.Conversations[i].Member(userId)
+		if member == nil {
+			continue
+		}
+		conv := f.copyConversationInternal(&f.Conversations[i])
+		for _, msg := range f.visibleMessagesInternal(conv, userId) {
+			if msg.Id > member.LastReadId && msg.UserId != userId {
+				conv.Unread++
+			}
+			last := msg
+			conv.LastMessage = &last
+		}
+		convs = append(convs, *conv)
+	}
+	// latest first like the service
+	sort.SliceStable(convs, func(i, j int) bool {
+		return convs[i].LastMessageAt.After(convs[j].LastMessageAt)
+	})
+	return convs, nil
+}
+
+func (f *Fake) ReadConversation(ctx context.Context, userId uint, uuid string) (*common.Conversation, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	conv := f.memberConversationInternal(userId, uuid)
+	if conv == nil {
+		return nil, fakeError(common.CodeNotFound, "no such conversation")
+	}
+	return f.copyConversationInternal(conv), nil
+}
+
+func (f *Fake) ReadDirectMessages(ctx context.Context, userId uint, uuid string) ([]common.DirectMessage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	conv := f.memberConversationInternal(userId, uuid)
+	if conv == nil {
+		return nil, fakeError(common.CodeNotFound, "no such conversation")
+	}
+	return f.visibleMessagesInternal(conv, userId), nil
+}
+
+func (f *Fake) CreateDirectMessage(ctx context.Context, msg *common.DirectMessage) (*common.DirectMessage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return nil, common.ErrCircuitOpen
+	}
+	if !common.NormalizeDirectMessage(msg) {
+		return nil, fakeError(common.CodeInvalid, "message is empty or too long")
+	}
+	conv := f.memberConversationInternal(msg.UserId, msg.ConversationUuId)
+	if conv == nil {
+		return nil, fakeError(common.CodeNotFound, "no such conversation")
+	}
+	if !conv.IsGroup() {
+		for _, member := range conv.Members {
+			if f.blockedInternal(member.UserId, msg.UserId) || f.blockedInternal(msg.UserId, member.UserId) {
+				return nil, fakeError(common.CodeForbidden, "blocked")
+			}
+		}
+	}
+	created := *msg
+	created.Id = f.nextId()
+	created.ConversationId = conv.Id
+	created.UserName = conv.Member(msg.UserId).UserName
+	created.CreatedAt = time.Now()
+	f.DirectMessages = append(f.DirectMessages, created)
+	conv.LastMessageAt = created.CreatedAt
+	conv.Member(msg.UserId).LastReadId = created.Id
+	return &created, nil
+}
+
+func (f *Fake) MarkConversationRead(ctx context.Context, userId uint, uuid string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return common.ErrCircuitOpen
+	}
+	conv := f.memberConversationInternal(userId, uuid)
+	if conv == nil {
+		return fakeError(common.CodeNotFound, "no such conversation")
+	}
+	member := conv.Member(userId)
+	for _, msg := range f.DirectMessages {
+		if msg.ConversationId == conv.Id && msg.Id > member.LastReadId {
+			member.LastReadId = msg.Id
+		}
+	}
+	return nil
+}
+
+func (f *Fake) CountUnreadMessages(ctx context.Context, userId uint) (int64, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.Down {
+		return 0, common.ErrCircuitOpen
+	}
+	var unread int64
+	for i := range f.Conversations {
+		member := f.Conversations[i].Member(userId)
+		if member == nil {
+			continue
+		}
+		for _, msg := range f.visibleMessagesInternal(&f.Conversations[i], userId) {
+			if msg.Id > member.LastReadId && msg.UserId != userId {
+				unread++
+			}
+		}
+	}
+	return unread, nil
+}
+
+func (f *Fake) userByIdInternal(id uint) *common.User {
+	for _, user := range f.Users {
+		if user.Id == id {
+			return user
+		}
+	}
+	return nil
+}
+
+func (f *Fake) userByNameInternal(name string) *common.User {
+	for _, user := range f.Users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+func (f *Fake) memberConversationInternal(userId uint, uuid string) *common.Conversation {
+	for i := range f.Conversations {
+		if f.Conversations[i].UuId == uuid && f.Conversations[i].Member(userId) != nil {
+			return &f.Conversations[i]
+		}
+	}
+	return nil
+}
+
+// without what blocked users wrote, oldest first
+func (f *Fake) visibleMessagesInternal(conv *common.Conversation, userId uint) []common.DirectMessage {
+	msgs := []common.DirectMessage{}
+	for _, msg := range f.DirectMessages {
+		if msg.ConversationId == conv.Id && !f.blockedInternal(userId, msg.UserId) {
+			msg.ConversationUuId = conv.UuId
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func (f *Fake) copyConversationInternal(conv *common.Conversation) *common.Conversation {
+	copied := *conv
+	copied.Members = append([]common.ConversationMember{}, conv.Members...)
+	return &copied
+}
@@ -0,0 +1,248 @@
+package usersclient
+
+import (
+	"context"
+	"learning-web-chatboard2/common"
+	"net/http"
+)
+
+// Client is the users service seen from other services.
+// HTTPClient talks to the real service, Fake keeps everything in memory.
+type Client interface {
+	CreateVisit(ctx context.Context) (*common.Visit, error)
+	CheckVisit(ctx context.Context, uuid string) (*common.Visit, error)
+	UpdateVisit(ctx context.Context, vis *common.Visit) (*common.Visit, error)
+	SignupAccount(ctx context.Context, user *common.User) (*common.User, error)
+	Authenticate(ctx context.Context, email string) (*common.User, error)
+	ReadUser(ctx context.Context, id uint) (*common.User, error)
+	CreateSession(ctx context.Context, user *common.User) (*common.Session, error)
+	CheckSession(ctx context.Context, uuid string) (*common.Session, error)
+	UpdateSession(ctx context.Context, sess *common.Session) (*common.Session, error)
+	DeleteSession(ctx context.Context, sess *common.Session) error
+	CreateToken(ctx context.Context, token *common.APIToken) (*common.NewAPIToken, error)
+	ReadTokens(ctx context.Context, userId uint) ([]common.APIToken, error)
+	RevokeToken(ctx context.Context, token *common.APIToken) error
+	CheckToken(ctx context.Context, secret string) (*common.APIToken, error)
+	ReadNotifications(ctx context.Context, userId uint) ([]common.Notification, error)
+	CountUnreadNotifications(ctx context.Context, userId uint) (int64, error)
+	MarkNotificationsRead(ctx context.Context, userId uint, uuid string) error
+	BanUser(ctx context.Context, action *common.ModerationAction) (*common.User, error)
+	ReadProfile(ctx context.Context, name string) (*common.User, error)
+	ResolveMentions(ctx context.Context, authorId uint, names []string) ([]common.User, error)
+	BlockUser(ctx context.Context, block *common.UserBlock) error
+	UnblockUser(ctx context.Context, block *common.UserBlock) error
+	ReadBlocks(ctx context.Context, userId uint) ([]common.UserBlock, error)
+	CreateConversation(ctx context.Context, req *common.ConversationRequest) (*common.Conversation, error)
+	ReadConversations(ctx context.Context, userId uint) ([]common.Conversation, error)
+	ReadConversation(ctx context.Context, userId uint, uuid string) (*common.Conversation, error)
+	ReadDirectMessages(ctx context.Context, userId uint, uuid string) ([]common.DirectMessage, error)
+	CreateDirectMessage(ctx context.Context, msg *common.DirectMessage) (*common.DirectMessage, error)
+	MarkConversationRead(ctx context.Context, userId uint, uuid string) error
+	CountUnreadMessages(ctx context.Context, userId uint) (int64, error)
+	Available() bool
+}
+
+type HTTPClient struct {
+	service *common.ServiceClient
+}
+
+func NewHTTPClient(address string, conf common.ClientConfiguration) *HTTPClient {
+	return &HTTPClient{
+		service: common.NewServiceClient(address, conf),
+	}
+}
+
+// Available is false while the circuit to the service is open.
+func (c *HTTPClient) Available() bool {
+	return !c.service.Breaker().IsOpen()
+}
+
+// reads and overwrites are idempotent, creates are not
+func (c *HTTPClient) do(
+	ctx context.Context,
+	method string,
+	path string,
+	in interface{},
+	out interface{},
+	idempotent bool,
+) error {
+	return c.service.DoJSON(ctx, method, path, in, out, idempotent)
+}
+
+func (c *HTTPClient) CreateVisit(ctx context.Context) (vis *common.Visit, err error) {
+	vis = &common.Visit{}
+	err = c.do(ctx, http.MethodGet, "/create-visit", nil, vis, false)
+	return
+}
+
+func (c *HTTPClient) CheckVisit(ctx context.Context, uuid string) (vis *common.Visit, err error) {
+	vis = &common.Visit{}
+	err = c.do(ctx, http.MethodPost, "/check-visit", &common.Visit{UuId: uuid}, vis, true)
+	return
+}
+
+func (c *HTTPClient) UpdateVisit(ctx context.Context, vis *common.Visit) (updated *common.Visit, err error) {
+	updated = &common.Visit{}
+	err = c.do(ctx, http.MethodPost, "/update-visit", vis, updated, true)
+	return
+}
+
+func (c *HTTPClient) SignupAccount(ctx context.Context, user *common.User) (created *common.User, err error) {
+	created = &common.User{}
+	err = c.do(ctx, http.MethodPost, "/signup-account", user, created, false)
+	return
+}
+
+func (c *HTTPClient) Authenticate(ctx context.Context, email string) (user *common.User, err error) {
+	user = &common.User{}
+	err = c.do(ctx, http.MethodPost, "/authenticate", &common.User{Email: email}, user, true)
+	return
+}
+
+func (c *HTTPClient) ReadUser(ctx context.Context, id uint) (user *common.User, err error) {
+	user = &common.User{}
+	err = c.do(ctx, http.MethodPost, "/read-user", &common.User{Id: id}, user, true)
+	return
+}
+
+func (c *HTTPClient) CreateSession(ctx context.Context, user *common.User) (sess *common.Session, err error) {
+	sess = &common.Session{}
+	err = c.do(ctx, http.MethodPost, "/create-session", user, sess, false)
+	return
+}
+
+func (c *HTTPClient) CheckSession(ctx context.Context, uuid string) (sess *common.Session, err error) {
+	sess = &common.Session{}
+	err = c.do(ctx, http.MethodPost, "/check-session", &common.Session{UuId: uuid}, sess, true)
+	return
+}
+
+func (c *HTTPClient) UpdateSession(ctx context.Context, sess *common.Session) (updated *common.Session, err error) {
+	updated = &common.Session{}
+	err = c.do(ctx, http.MethodPost, "/update-session", sess, updated, true)
+	return
+}
+
+func (c *HTTPClient) DeleteSession(ctx context.Context, sess *common.Session) error {
+	return c.do(ctx, http.MethodPost, "/delete-session", sess, nil, true)
+}
+
+func (c *HTTPClient) CreateToken(ctx context.Context, token *common.APIToken) (newToken *common.NewAPIToken, err error) {
+	newToken = &common.NewAPIToken{}
+	err = c.do(ctx, http.MethodPost, "/create-token", token, newToken, false)
+	return
+}
+
+func (c *HTTPClient) ReadTokens(ctx context.Context, userId uint) (tokens []common.APIToken, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-tokens", &common.APIToken{UserId: userId}, &tokens, true)
+	return
+}
+
+func (c *HTTPClient) RevokeToken(ctx context.Context, token *common.APIToken) error {
+	return c.do(ctx, http.MethodPost, "/revoke-token", token, nil, true)
+}
+
+func (c *HTTPClient) CheckToken(ctx context.Context, secret string) (token *common.APIToken, err error) {
+	token = &common.APIToken{}
+	err = c.do(ctx, http.MethodPost, "/check-token", &common.NewAPIToken{Secret: secret}, token, true)
+	return
+}
+
+func (c *HTTPClient) ReadNotifications(ctx context.Context, userId uint) (notifications []common.Notification, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-notifications", &common.Notification{UserId: userId}, &notifications, true)
+	return
+}
+
+func (c *HTTPClient) CountUnreadNotifications(ctx context.Context, userId uint) (unread int64, err error) {
+	var count common.NotificationCount
+	err = c.do(ctx, http.MethodPost, "/count-unread-notifications", &common.NotificationCount{UserId: userId}, &count, true)
+	unread = count.Unread
+	return
+}
+
+// empty uuid marks all of them
+func (c *HTTPClient) MarkNotificationsRead(ctx context.Context, userId uint, uuid string) error {
+	return c.do(ctx, http.MethodPost, "/mark-notifications-read", &common.Notification{UserId: userId, UuId: uuid}, nil, true)
+}
+
+func (c *HTTPClient) BanUser(ctx context.Context, action *common.ModerationAction) (user *common.User, err error) {
+	user = &common.User{}
+	err = c.do(ctx, http.MethodPost, "/ban-user", action, user, true)
+	return
+}
+
+// no email nor password in it
+func (c *HTTPClient) ReadProfile(ctx context.Context, name string) (user *common.User, err error) {
+	user = &common.User{}
+	err = c.do(ctx, http.MethodPost, "/read-profile", &common.User{Name: name}, user, true)
+	return
+}
+
+func (c *HTTPClient) ResolveMentions(ctx context.Context, authorId uint, names []string) (users []common.User, err error) {
+	query := common.MentionQuery{AuthorId: authorId, Names: names}
+	err = c.do(ctx, http.MethodPost, "/resolve-mentions", &query, &users, true)
+	return
+}
+
+func (c *HTTPClient) BlockUser(ctx context.Context, block *common.UserBlock) error {
+	return c.do(ctx, http.MethodPost, "/block-user", block, nil, true)
+}
+
+func (c *HTTPClient) UnblockUser(ctx context.Context, block *common.UserBlock) error {
+	return c.do(ctx, http.MethodPost, "/unblock-user", block, nil, true)
+}
+
+func (c *HTTPClient) ReadBlocks(ctx context.Context, userId uint) (blocks []common.UserBlock, err error) {
+	err = c.do(ctx, http.MethodPost, "/read-blocks", &common.UserBlock{UserId: userId}, &blocks, true)
+	return
+}
+
+// groups are made again on retry, so not retried
+func (c *HTTPClient) CreateConversation(
+	ctx context.Context,
+	req *common.ConversationRequest,
+) (conv *common.Conversation, err error) {
+	conv = &common.Conversation{}
+	err = c.do(ctx, http.MethodPost, "/create-conversation", req, conv, false)
+	return
+}
+
+func (c *HTTPClient) ReadConversations(ctx context.Context, userId uint) (convs []common.Conversation, err error) {
+	query := common.ConversationQuery{UserId: userId}
+	err = c.do(ctx, http.MethodPost, "/read-conversations", &query, &convs, true)
+	return
+}
+
+func (c *HTTPClient) ReadConversation(ctx context.Context, userId uint, uuid string) (conv *common.Conversation, err error) {
+	conv = &common.Conversation{}
+	query := common.ConversationQuery{UserId: userId, UuId: uuid}
+	err = c.do(ctx, http.MethodPost, "/read-conversation", &query, conv, true)
+	return
+}
+
+func (c *HTTPClient) ReadDirectMessages(ctx context.Context, userId uint, uuid string) (msgs []common.DirectMessage, err error) {
+	query := common.ConversationQuery{UserId: userId, UuId: uuid}
+	err = c.do(ctx, http.MethodPost, "/read-direct-messages", &query, &msgs, true)
+	return
+}
+
+func (c *HTTPClient) CreateDirectMessage(
+	ctx context.Context,
+	msg *common.DirectMessage,
+) (created *common.DirectMessage, err error) {
+	created = &common.DirectMessage{}
+	err = c.do(ctx, http.MethodPost, "/create-direct-message", msg, created, false)
+	return
+}
+
+func (c *HTTPClient) MarkConversationRead(ctx context.Context, userId uint, uuid string) error {
+	query := common.ConversationQuery{UserId: userId, UuId: uuid}
+	return c.do(ctx, http.MethodPost, "/mark-conversation-read", &query, nil, true)
+}
+
+func (c *HTTPClient) CountUnreadMessages(ctx context.Context, userId uint) (unread int64, err error) {
+	var count common.MessageCount
+	err = c.do(ctx, http.MethodPost, "/count-unread-messages", &common.MessageCount{UserId: userId}, &count, true)
+	unread = count.Unread
+	return
+}
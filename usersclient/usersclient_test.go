@@ -0,0 +1,51 @@
+package usersclient
+
+import (
+	"context"
+	"errors"
+	"learning-web-chatboard2/common"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Test_CheckSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/check-session", func(ctx *gin.Context) {
+		var sess common.Session
+		ctx.Bind(&sess)
+		if sess.UuId != "known" {
+			ctx.JSON(http.StatusBadRequest, gin.H{"status": "error"})
+			return
+		}
+		sess.UserName = "TestingTaro"
+		ctx.JSON(http.StatusOK, &sess)
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	client := NewHTTPClient(
+		strings.TrimPrefix(server.URL, common.HttpPrefix),
+		common.ClientConfiguration{TimeoutMillis: 1000},
+	)
+	sess, err := client.CheckSession(context.Background(), "known")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if sess.UserName != "TestingTaro" {
+		t.Fatalf("unexpected session %v", sess)
+	}
+
+	_, err = client.CheckSession(context.Background(), "unknown")
+	var statusErr *common.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", statusErr.StatusCode)
+	}
+}